@@ -1,14 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"log"
+	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/ecodeclub/ekit/spi"
 	"github.com/spf13/viper"
 
 	"github.com/meoying/dbproxy/internal/protocol/mysql"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/auth"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
 	"github.com/spf13/pflag"
 )
@@ -60,15 +73,48 @@ func main() {
 		}
 		plugins = append(plugins, ps[0])
 	}
-	server := mysql.NewServer(cfg.Server.Addr, plugins)
+	opts, err := cfg.Server.options()
+	if err != nil {
+		panic(fmt.Errorf("初始化服务器配置失败 %w", err))
+	}
+	server := mysql.NewServer(cfg.Server.Addr, plugins, opts...)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		shutdown(server, cfg.Server.ShutdownTimeout)
+	}()
 	log.Printf("服务开启。。。。端口：%s", cfg.Server.Addr)
+	for _, l := range cfg.Server.Listeners {
+		log.Printf("监听：%s %s 管理端口：%t", l.Network, l.Addr, l.Admin)
+	}
 	err = server.Start()
 	if err != nil {
-		// 可以是正常退出，也可能是异常退出，暂时还没区分并且解决
 		panic(err)
 	}
+	// Start 在开始退出的时候就返回了，要等连接处理完毕
+	<-shutdownDone
+}
+
+// shutdown 收到 SIGTERM 或者 SIGINT 之后优雅退出
+// 超过 timeout 还没有处理完的连接会被强制关闭，没有结束的事务会被回滚
+func shutdown(server *mysql.Server, timeout time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	log.Printf("开始退出，最多等待 %s", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("退出的时候还有连接没有处理完毕 %v", err)
+	}
 }
 
+// defaultShutdownTimeout 优雅退出的默认等待时间
+const defaultShutdownTimeout = 30 * time.Second
+
 type Config struct {
 	Server  Server  `yaml:"server"`
 	Plugins Plugins `yaml:"plugins"`
@@ -76,6 +122,160 @@ type Config struct {
 
 type Server struct {
 	Addr string `yaml:"addr"`
+	TLS  TLS    `yaml:"tls"`
+	// Listeners 在 Addr 之外监听的地址，例如 Unix domain socket 和只允许管理员登录的管理端口
+	Listeners []mysql.ListenerConfig `yaml:"listeners"`
+	// Users 允许登录 dbproxy 的用户，为空的时候不校验用户名和密码
+	Users []auth.User `yaml:"users"`
+	// RSAKeyFile PEM 格式的 RSA 私钥，客户端在非 TLS 连接上使用 caching_sha2_password 登录的时候会用到
+	// 不配置的时候启动时自动生成
+	RSAKeyFile string `yaml:"rsaKeyFile"`
+	// ConnectTimeout 握手和鉴权的最长时间，不配置的时候是 10s
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+	// CmdTimeout 每个命令的执行超时时间，例如 3s，不配置的时候不限制
+	CmdTimeout time.Duration `yaml:"cmdTimeout"`
+	// MaxAllowedPacket 客户端发送的单个 payload 的最大字节数，不配置的时候是 64MB
+	MaxAllowedPacket int `yaml:"maxAllowedPacket"`
+	// StrictMode 为 true 的时候，客户端发送了格式非法的报文会被断开连接
+	StrictMode bool `yaml:"strictMode"`
+	// ShutdownTimeout 退出的时候等待连接处理完毕的最长时间，不配置的时候是 30s
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// MaxConnections 连接总数的上限，不配置的时候不限制
+	MaxConnections int `yaml:"maxConnections"`
+	// MaxUserConnections 每个用户的连接数上限，不配置的时候不限制
+	MaxUserConnections int `yaml:"maxUserConnections"`
+	// MaxHostConnections 每个客户端地址的连接数上限，不配置的时候不限制
+	MaxHostConnections int `yaml:"maxHostConnections"`
+	// WaitTimeout 连接空闲的超时时间，例如 8h，不配置的时候不限制
+	WaitTimeout time.Duration `yaml:"waitTimeout"`
+	// IdleInTransactionTimeout 连接在事务中空闲的超时时间，不配置的时候使用 WaitTimeout
+	IdleInTransactionTimeout time.Duration `yaml:"idleInTransactionTimeout"`
+	// TrustedProxies 可以发送 PROXY protocol 头部的负载均衡的地址，例如 10.0.0.0/8，不配置的时候不解析头部
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+func (s Server) options() ([]mysql.ServerOption, error) {
+	var opts []mysql.ServerOption
+	if len(s.Listeners) > 0 {
+		opts = append(opts, mysql.ServerWithListeners(s.Listeners...))
+	}
+	if s.TLS.CertFile != "" || s.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.TLS.CertFile, s.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载 TLS 证书失败 %w", err)
+		}
+		opts = append(opts, mysql.ServerWithTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}))
+	}
+	if s.TLS.Required {
+		if s.TLS.CertFile == "" {
+			return nil, fmt.Errorf("要求客户端使用 TLS，但是没有配置证书")
+		}
+		opts = append(opts, mysql.ServerWithRequireSecureTransport(true))
+	}
+	if len(s.Users) > 0 {
+		opts = append(opts, mysql.ServerWithUserStore(auth.NewStaticStore(s.Users)))
+	}
+	if s.hasAdminListener() && !slices.ContainsFunc(s.Users, func(u auth.User) bool { return u.Admin }) {
+		return nil, fmt.Errorf("配置了管理端口，但是没有配置管理员")
+	}
+	if s.RSAKeyFile != "" {
+		key, err := loadRSAKey(s.RSAKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载 RSA 私钥失败 %w", err)
+		}
+		opts = append(opts, mysql.ServerWithRSAKey(key))
+	}
+	if s.ConnectTimeout > 0 {
+		opts = append(opts, mysql.ServerWithConnectTimeout(s.ConnectTimeout))
+	}
+	if s.CmdTimeout > 0 {
+		opts = append(opts, mysql.ServerWithCmdTimeout(s.CmdTimeout))
+	}
+	if s.MaxAllowedPacket > 0 {
+		opts = append(opts, mysql.ServerWithMaxAllowedPacket(s.MaxAllowedPacket))
+	}
+	if s.StrictMode {
+		opts = append(opts, mysql.ServerWithStrictMode(true))
+	}
+	if len(s.TrustedProxies) > 0 {
+		prefixes := make([]netip.Prefix, 0, len(s.TrustedProxies))
+		for _, p := range s.TrustedProxies {
+			prefix, err := parsePrefix(p)
+			if err != nil {
+				return nil, fmt.Errorf("非法的 trustedProxies %w", err)
+			}
+			prefixes = append(prefixes, prefix)
+		}
+		opts = append(opts, mysql.ServerWithProxyProtocol(prefixes...))
+	}
+	if s.MaxConnections > 0 {
+		opts = append(opts, mysql.ServerWithMaxConnections(s.MaxConnections))
+	}
+	if s.MaxUserConnections > 0 {
+		opts = append(opts, mysql.ServerWithMaxUserConnections(s.MaxUserConnections))
+	}
+	if s.MaxHostConnections > 0 {
+		opts = append(opts, mysql.ServerWithMaxHostConnections(s.MaxHostConnections))
+	}
+	if s.WaitTimeout > 0 {
+		opts = append(opts, mysql.ServerWithWaitTimeout(s.WaitTimeout))
+	}
+	if s.IdleInTransactionTimeout > 0 {
+		opts = append(opts, mysql.ServerWithIdleInTransactionTimeout(s.IdleInTransactionTimeout))
+	}
+	return opts, nil
+}
+
+// hasAdminListener 是否配置了只允许管理员登录的地址
+func (s Server) hasAdminListener() bool {
+	return slices.ContainsFunc(s.Listeners, func(l mysql.ListenerConfig) bool { return l.Admin })
+}
+
+// parsePrefix 解析 CIDR，单个 IP 当成只包含它自己的网段
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	return netip.ParsePrefix(s)
+}
+
+// loadRSAKey 加载 PKCS#1 或者 PKCS#8 格式的 RSA 私钥
+func loadRSAKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s 不是 PEM 格式", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s 不是 RSA 私钥", path)
+	}
+	return rsaKey, nil
+}
+
+// TLS 客户端连接 dbproxy 时使用的 TLS 配置
+type TLS struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// Required 为 true 的时候，拒绝没有使用 TLS 的客户端
+	Required bool `yaml:"required"`
 }
 
 type Plugins struct {
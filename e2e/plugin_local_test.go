@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/meoying/dbproxy/e2e/testsuite"
 	"github.com/meoying/dbproxy/internal/protocol/mysql"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/configbuilder"
@@ -115,6 +116,28 @@ func (s *localForwardTestSuite) TestPing() {
 	s.NoError(s.newProxyClientDB().PingContext(ctx))
 }
 
+// TestUseSchema USE 之后语句仍然要发到配置的库上，不认识的库直接拒绝
+func (s *localForwardTestSuite) TestUseSchema() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	db := s.newProxyClientDB()
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	s.NoError(err)
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "USE dbproxy")
+	s.NoError(err)
+	var cnt int
+	s.NoError(conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM `order`").Scan(&cnt))
+
+	var mysqlErr *mysqldriver.MySQLError
+	_, err = conn.ExecContext(ctx, "USE other_db")
+	s.ErrorAs(err, &mysqlErr)
+	s.Equal(uint16(1049), mysqlErr.Number)
+	s.NoError(conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM `order`").Scan(&cnt))
+}
+
 func (s *localForwardTestSuite) TestDataTypeSuite() {
 	var dataTypeSuite testsuite.DataTypeTestSuite
 	dataTypeSuite.SetProxyDBAndMySQLDB(s.newProxyClientDB(), s.newMySQLDB())
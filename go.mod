@@ -8,6 +8,7 @@ require (
 	github.com/ecodeclub/ekit v0.0.9-0.20240604015119-6fdf3ad42c4b
 	github.com/go-sql-driver/mysql v1.8.1
 	github.com/hashicorp/go-multierror v1.1.1
+	github.com/klauspost/compress v1.18.0
 	github.com/mattn/go-sqlite3 v1.14.15
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/pflag v1.0.5
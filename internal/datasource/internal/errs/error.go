@@ -3,6 +3,7 @@ package errs
 import (
 	"fmt"
 
+	proxyerrs "github.com/meoying/dbproxy/internal/errs"
 	"github.com/pkg/errors"
 )
 
@@ -11,20 +12,20 @@ func NewErrNotCompleteFinder(name string) error {
 }
 
 func NewErrNotFoundTargetDataSource(name string) error {
-	return fmt.Errorf("eorm: 未发现目标 data dource %s", name)
+	return proxyerrs.NewRouteError(fmt.Sprintf("eorm: 未发现目标 data dource %s", name))
 }
 
-var ErrSlaveNotFound = errors.New(" slave不存在")
+var ErrSlaveNotFound = proxyerrs.NewRouteError(" slave不存在")
 
 func NewInvalidDSNError(dsn string) error {
 	return fmt.Errorf("不正确的 DSN %s", dsn)
 }
 func NewErrNotFoundTargetDB(name string) error {
-	return fmt.Errorf(" 未发现目标 DB %s", name)
+	return proxyerrs.NewRouteError(fmt.Sprintf(" 未发现目标 DB %s", name))
 }
 
 func NewErrDBNotEqual(oldDB, tgtDB string) error {
-	return fmt.Errorf("禁止跨库操作： %s 不等于 %s ", oldDB, tgtDB)
+	return proxyerrs.NewRouteError(fmt.Sprintf("禁止跨库操作： %s 不等于 %s ", oldDB, tgtDB))
 }
 
 var ErrUnsupportedDistributedTransaction = errors.New(" 不支持的分布式事务类型")
@@ -67,8 +67,17 @@ func (m *MasterSlavesDB) Exec(ctx context.Context, query datasource.Query) (sql.
 	return m.master.ExecContext(ctx, query.SQL, query.Args...)
 }
 
+// BeginTx 开启事务，只读事务在有从库并且没有通过 UseMaster 指定主库的时候使用从库
 func (m *MasterSlavesDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (datasource.Tx, error) {
-	tx, err := m.master.BeginTx(ctx, opts)
+	db := m.master
+	if _, useMaster := ctx.Value(master).(bool); opts != nil && opts.ReadOnly && !useMaster && m.slaves != nil {
+		slave, err := m.slaves.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		db = slave.DB
+	}
+	tx, err := db.BeginTx(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
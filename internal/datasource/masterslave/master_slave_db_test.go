@@ -71,6 +71,63 @@ func TestMasterSlavesDB_BeginTx(t *testing.T) {
 	assert.NotNil(t, tx)
 }
 
+func TestMasterSlavesDB_BeginReadOnlyTx(t *testing.T) {
+	masterDB, masterMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = masterDB.Close() }()
+	slaveDB, slaveMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = slaveDB.Close() }()
+	sl, err := roundrobin.NewSlaves(slaveDB)
+	require.NoError(t, err)
+	db := NewMasterSlavesDB(masterDB, MasterSlavesWithSlaves(sl))
+
+	testCases := []struct {
+		name       string
+		ctx        context.Context
+		opts       *sql.TxOptions
+		wantMaster bool
+	}{
+		{
+			name:       "没有指定选项",
+			ctx:        context.Background(),
+			wantMaster: true,
+		},
+		{
+			name:       "读写事务",
+			ctx:        context.Background(),
+			opts:       &sql.TxOptions{Isolation: sql.LevelReadCommitted},
+			wantMaster: true,
+		},
+		{
+			name: "只读事务",
+			ctx:  context.Background(),
+			opts: &sql.TxOptions{ReadOnly: true},
+		},
+		{
+			name:       "只读事务指定了主库",
+			ctx:        UseMaster(context.Background()),
+			opts:       &sql.TxOptions{ReadOnly: true},
+			wantMaster: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := slaveMock
+			if tc.wantMaster {
+				mock = masterMock
+			}
+			mock.ExpectBegin()
+			mock.ExpectCommit()
+			tx, err := db.BeginTx(tc.ctx, tc.opts)
+			require.NoError(t, err)
+			require.NoError(t, tx.Commit())
+			require.NoError(t, masterMock.ExpectationsWereMet())
+			require.NoError(t, slaveMock.ExpectationsWereMet())
+		})
+	}
+}
+
 func ExampleMasterSlavesDB_BeginTx() {
 	sqlite3db, _ := sql.Open("sqlite3", "file:test.db?cache=shared&mode=memory")
 	db := NewMasterSlavesDB(sqlite3db)
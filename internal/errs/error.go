@@ -8,7 +8,78 @@ import (
 var ErrInvalidConn = errors.New("异常连接")
 var ErrPktSync = errors.New("报文乱序")
 var ErrPktTooLarge = errors.New("报文过大")
+var ErrAccessDenied = errors.New("鉴权失败")
+
+// ErrTooManyConnections 连接数超过了限制，用 errors.Is 判断
+var ErrTooManyConnections = errors.New("连接数过多")
+
+// ErrAdminRequired 只有管理员可以执行的操作，例如通过管理端口登录，用 errors.Is 判断
+var ErrAdminRequired = errors.New("需要管理员权限")
+
+// ErrInvalidProxyHeader PROXY protocol 的头部格式非法，用 errors.Is 判断
+var ErrInvalidProxyHeader = errors.New("PROXY protocol 头部格式非法")
+
+// ErrMalformedPacket 客户端发送的报文格式非法，用 errors.Is 判断
+var ErrMalformedPacket = errors.New("报文格式非法")
+
+// ErrUnsupportedSQL dbproxy 尚未支持的 SQL，用 errors.Is 判断
+var ErrUnsupportedSQL = errors.New("dbproxy: 尚未支持的SQL")
+
+// ErrRouteFailed 无法将 SQL 路由到目标库、表或者数据源，用 errors.Is 判断
+var ErrRouteFailed = errors.New("dbproxy: 路由失败")
+
+// ErrWrongValueForVar 系统变量不能设置成这个值，用 errors.Is 判断
+var ErrWrongValueForVar = errors.New("系统变量的值非法")
+
+// ErrReadOnlyVar 系统变量是只读的，用 errors.Is 判断
+var ErrReadOnlyVar = errors.New("系统变量是只读的")
+
+// ErrLongDataTooLarge 通过 COM_STMT_SEND_LONG_DATA 发送的参数超过了 max_allowed_packet，用 errors.Is 判断
+var ErrLongDataTooLarge = errors.New("预处理语句的参数超过了 max_allowed_packet")
+
+// ErrUnknownDatabase 客户端选择的逻辑库不存在，用 errors.Is 判断
+var ErrUnknownDatabase = errors.New("逻辑库不存在")
 
 func NewErrScanWrongDestinationArguments(expect int, actual int) error {
 	return fmt.Errorf("dbproxy: Scan 方法收到过多或者过少的参数，预期 %d，实际 %d", expect, actual)
 }
+
+// NewUnsupportedSQLError 创建一个尚未支持的 SQL 的错误，错误信息保持为 msg
+func NewUnsupportedSQLError(msg string) error {
+	return kindError{msg: msg, kind: ErrUnsupportedSQL}
+}
+
+// NewRouteError 创建一个路由失败的错误，错误信息保持为 msg
+func NewRouteError(msg string) error {
+	return kindError{msg: msg, kind: ErrRouteFailed}
+}
+
+// NewWrongValueForVarError 创建一个系统变量的值非法的错误，错误信息和 MySQL 一致
+func NewWrongValueForVarError(name, value string) error {
+	return kindError{msg: fmt.Sprintf("Variable '%s' can't be set to the value of '%s'", name, value), kind: ErrWrongValueForVar}
+}
+
+// NewReadOnlyVarError 创建一个修改只读系统变量的错误，错误信息和 MySQL 一致
+func NewReadOnlyVarError(name string) error {
+	return kindError{msg: fmt.Sprintf("Variable '%s' is a read only variable", name), kind: ErrReadOnlyVar}
+}
+
+// NewUnknownDatabaseError 创建一个逻辑库不存在的错误，错误信息和 MySQL 一致
+func NewUnknownDatabaseError(db string) error {
+	return kindError{msg: fmt.Sprintf("Unknown database '%s'", db), kind: ErrUnknownDatabase}
+}
+
+// kindError 保留原本的错误信息，同时可以通过 errors.Is 判断错误的类别
+// 返回给客户端的时候会根据类别使用不同的错误码
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e kindError) Error() string {
+	return e.msg
+}
+
+func (e kindError) Unwrap() error {
+	return e.kind
+}
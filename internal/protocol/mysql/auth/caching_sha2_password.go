@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sync"
+)
+
+// CachingSha2PasswordPluginName caching_sha2_password 鉴权插件的名字，也是 MySQL 8 默认的鉴权插件
+const CachingSha2PasswordPluginName = "caching_sha2_password"
+
+// caching_sha2_password 在 AuthMoreData 里面使用的状态，以及客户端请求公钥的标记
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_caching_sha2_authentication_exchanges.html
+const (
+	CachingSha2RequestPublicKey          byte = 0x02
+	CachingSha2FastAuthSuccess           byte = 0x03
+	CachingSha2PerformFullAuthentication byte = 0x04
+)
+
+// CachingSha2Digest 计算 SHA256(SHA256(password))，快速鉴权的时候用它来校验客户端的数据
+func CachingSha2Digest(password string) []byte {
+	stage1 := sha256.Sum256([]byte(password))
+	stage2 := sha256.Sum256(stage1[:])
+	return stage2[:]
+}
+
+// VerifyCachingSha2Password 快速鉴权，校验客户端发送过来的 caching_sha2_password 鉴权数据
+// 客户端发送的是 SHA256(password) XOR SHA256(SHA256(SHA256(password)) + scramble)
+func VerifyCachingSha2Password(scramble, authResponse, digest []byte) bool {
+	if len(digest) != sha256.Size || len(authResponse) != sha256.Size {
+		return false
+	}
+	crypt := sha256.New()
+	crypt.Write(digest)
+	crypt.Write(scramble)
+	stage1 := crypt.Sum(nil)
+	// 还原出 SHA256(password)
+	for i := range stage1 {
+		stage1[i] ^= authResponse[i]
+	}
+	candidate := sha256.Sum256(stage1)
+	return subtle.ConstantTimeCompare(candidate[:], digest) == 1
+}
+
+// VerifyPassword 完整鉴权，校验客户端发送过来的明文密码和 mysql_native_password 格式的密码哈希是否匹配
+func VerifyPassword(password, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(NativePasswordHash(password)), []byte(hash)) == 1
+}
+
+// DecryptPassword 解密客户端在非 TLS 连接上发送过来的密码
+// 客户端先将 password + "\x00" 和 scramble 循环异或，再用服务端的公钥以 RSA-OAEP 加密
+func DecryptPassword(key *rsa.PrivateKey, scramble, data []byte) (string, error) {
+	plain, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, key, data, nil)
+	if err != nil {
+		return "", fmt.Errorf("解密密码失败 %w", err)
+	}
+	if len(scramble) == 0 {
+		return "", fmt.Errorf("scramble 不能为空")
+	}
+	for i := range plain {
+		plain[i] ^= scramble[i%len(scramble)]
+	}
+	return string(bytes.TrimRight(plain, "\x00")), nil
+}
+
+// PublicKeyPEM 将公钥编码为 PEM 格式，客户端请求公钥的时候返回
+func PublicKeyPEM(key *rsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// SHA2Cache 缓存完整鉴权成功的用户的 SHA256(SHA256(password))
+// 和 MySQL 一样，命中缓存的用户可以走快速鉴权，不需要再传输密码
+type SHA2Cache struct {
+	mu      sync.RWMutex
+	entries map[string]sha2CacheEntry
+}
+
+type sha2CacheEntry struct {
+	// password 写入缓存的时候用户的密码哈希，密码修改之后缓存自然失效
+	password string
+	digest   []byte
+}
+
+func NewSHA2Cache() *SHA2Cache {
+	return &SHA2Cache{entries: make(map[string]sha2CacheEntry, 16)}
+}
+
+// Get 返回用户的 SHA256(SHA256(password))，第二个返回值表示是否命中
+func (c *SHA2Cache) Get(u User) ([]byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[u.Name]
+	if !ok || entry.password != u.Password {
+		return nil, false
+	}
+	return entry.digest, true
+}
+
+func (c *SHA2Cache) Put(u User, digest []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[u.Name] = sha2CacheEntry{password: u.Password, digest: digest}
+}
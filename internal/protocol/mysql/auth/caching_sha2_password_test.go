@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCachingSha2Password(t *testing.T) {
+	scramble := []byte("abcdefghijklmnopqrst")
+	tests := []struct {
+		name         string
+		authResponse []byte
+		digest       []byte
+		want         bool
+	}{
+		{
+			name:         "密码正确",
+			authResponse: scrambleCachingSha2Password(scramble, "root"),
+			digest:       CachingSha2Digest("root"),
+			want:         true,
+		},
+		{
+			name:         "密码错误",
+			authResponse: scrambleCachingSha2Password(scramble, "wrong"),
+			digest:       CachingSha2Digest("root"),
+			want:         false,
+		},
+		{
+			name:         "长度不对",
+			authResponse: []byte{0x01},
+			digest:       CachingSha2Digest("root"),
+			want:         false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, VerifyCachingSha2Password(scramble, tt.authResponse, tt.digest))
+		})
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	assert.True(t, VerifyPassword("root", NativePasswordHash("root")))
+	assert.False(t, VerifyPassword("wrong", NativePasswordHash("root")))
+	assert.True(t, VerifyPassword("", ""))
+}
+
+func TestDecryptPassword(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	scramble := []byte("abcdefghijklmnopqrst")
+
+	// 模拟客户端，从 PEM 中解析公钥再加密
+	data, err := PublicKeyPEM(key)
+	require.NoError(t, err)
+	block, _ := pem.Decode(data)
+	require.NotNil(t, block)
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	require.NoError(t, err)
+	plain := []byte("a-long-password-longer-than-scramble\x00")
+	for i := range plain {
+		plain[i] ^= scramble[i%len(scramble)]
+	}
+	enc, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub.(*rsa.PublicKey), plain, nil)
+	require.NoError(t, err)
+
+	password, err := DecryptPassword(key, scramble, enc)
+	require.NoError(t, err)
+	assert.Equal(t, "a-long-password-longer-than-scramble", password)
+
+	_, err = DecryptPassword(key, scramble, []byte("invalid"))
+	assert.Error(t, err)
+}
+
+func TestSHA2Cache(t *testing.T) {
+	c := NewSHA2Cache()
+	u := User{Name: "root", Password: NativePasswordHash("root")}
+	_, ok := c.Get(u)
+	assert.False(t, ok)
+
+	c.Put(u, CachingSha2Digest("root"))
+	digest, ok := c.Get(u)
+	assert.True(t, ok)
+	assert.Equal(t, CachingSha2Digest("root"), digest)
+
+	// 修改密码之后缓存失效
+	u.Password = NativePasswordHash("new")
+	_, ok = c.Get(u)
+	assert.False(t, ok)
+}
+
+// scrambleCachingSha2Password 模拟客户端的计算过程
+func scrambleCachingSha2Password(scramble []byte, password string) []byte {
+	stage1 := sha256.Sum256([]byte(password))
+	stage2 := sha256.Sum256(stage1[:])
+	crypt := sha256.New()
+	crypt.Write(stage2[:])
+	crypt.Write(scramble)
+	res := crypt.Sum(nil)
+	for i := range res {
+		res[i] ^= stage1[i]
+	}
+	return res
+}
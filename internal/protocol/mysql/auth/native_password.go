@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+// NativePasswordPluginName mysql_native_password 鉴权插件的名字
+const NativePasswordPluginName = "mysql_native_password"
+
+// NativePasswordHash 计算 mysql_native_password 格式的密码哈希
+// 结果为 "*" + HEX(SHA1(SHA1(password)))，空密码返回空字符串
+func NativePasswordHash(password string) string {
+	if password == "" {
+		return ""
+	}
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+	return "*" + strings.ToUpper(hex.EncodeToString(stage2[:]))
+}
+
+// VerifyNativePassword 校验客户端发送过来的 mysql_native_password 鉴权数据
+// 客户端发送的是 SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password)))
+// 因此服务端只需要保存 SHA1(SHA1(password)) 就可以完成校验
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_authentication_methods_native_password_authentication.html
+func VerifyNativePassword(scramble, authResponse []byte, hash string) bool {
+	if hash == "" {
+		return len(authResponse) == 0
+	}
+	stage2, err := hex.DecodeString(strings.TrimPrefix(hash, "*"))
+	if err != nil || len(stage2) != sha1.Size || len(authResponse) != sha1.Size {
+		return false
+	}
+	crypt := sha1.New()
+	crypt.Write(scramble)
+	crypt.Write(stage2)
+	stage1 := crypt.Sum(nil)
+	// 还原出 SHA1(password)
+	for i := range stage1 {
+		stage1[i] ^= authResponse[i]
+	}
+	candidate := sha1.Sum(stage1)
+	return bytes.Equal(candidate[:], stage2)
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNativePasswordHash(t *testing.T) {
+	// 和 MySQL 5.7 中 SELECT PASSWORD('root') 的结果一致
+	assert.Equal(t, "*81F5E21E35407D884A6CD4A731AEBFB6AF209E1B", NativePasswordHash("root"))
+	assert.Equal(t, "", NativePasswordHash(""))
+}
+
+func TestVerifyNativePassword(t *testing.T) {
+	scramble := []byte("abcdefghijklmnopqrst")
+	tests := []struct {
+		name         string
+		authResponse []byte
+		hash         string
+		want         bool
+	}{
+		{
+			name:         "密码正确",
+			authResponse: scrambleNativePassword(scramble, "root"),
+			hash:         NativePasswordHash("root"),
+			want:         true,
+		},
+		{
+			name:         "密码错误",
+			authResponse: scrambleNativePassword(scramble, "wrong"),
+			hash:         NativePasswordHash("root"),
+			want:         false,
+		},
+		{
+			name:         "空密码",
+			authResponse: nil,
+			hash:         "",
+			want:         true,
+		},
+		{
+			name:         "需要密码但是没有传",
+			authResponse: nil,
+			hash:         NativePasswordHash("root"),
+			want:         false,
+		},
+		{
+			name:         "不需要密码但是传了",
+			authResponse: scrambleNativePassword(scramble, "root"),
+			hash:         "",
+			want:         false,
+		},
+		{
+			name:         "哈希格式错误",
+			authResponse: scrambleNativePassword(scramble, "root"),
+			hash:         "*XYZ",
+			want:         false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, VerifyNativePassword(scramble, tt.authResponse, tt.hash))
+		})
+	}
+}
+
+// scrambleNativePassword 模拟客户端的计算过程
+func scrambleNativePassword(scramble []byte, password string) []byte {
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+	crypt := sha1.New()
+	crypt.Write(scramble)
+	crypt.Write(stage2[:])
+	res := crypt.Sum(nil)
+	for i := range res {
+		res[i] ^= stage1[i]
+	}
+	return res
+}
@@ -0,0 +1,21 @@
+package auth
+
+var _ Store = &StaticStore{}
+
+// StaticStore 在启动的时候就确定了全部用户，一般来自于配置文件
+type StaticStore struct {
+	users map[string]User
+}
+
+func NewStaticStore(users []User) *StaticStore {
+	m := make(map[string]User, len(users))
+	for _, u := range users {
+		m[u.Name] = u
+	}
+	return &StaticStore{users: m}
+}
+
+func (s *StaticStore) User(name string) (User, bool) {
+	u, ok := s.users[name]
+	return u, ok
+}
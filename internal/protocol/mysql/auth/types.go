@@ -0,0 +1,31 @@
+// Package auth 负责客户端连接 dbproxy 时的鉴权
+package auth
+
+import (
+	"slices"
+)
+
+// User 代表一个可以登录 dbproxy 的用户
+type User struct {
+	Name string `json:"name" yaml:"name"`
+	// Password 是 mysql_native_password 格式的密码哈希
+	// 也就是 "*" + HEX(SHA1(SHA1(password)))，和 MySQL 里面 mysql.user 表的 authentication_string 一致
+	// 可以用 NativePasswordHash 生成。为空的时候表示这个用户不需要密码
+	// mysql_native_password 和 caching_sha2_password 两种鉴权方式都使用它来校验
+	Password string `json:"password" yaml:"password"`
+	// Schemas 该用户可以访问的逻辑库，为空的时候表示可以访问全部逻辑库
+	Schemas []string `json:"schemas" yaml:"schemas"`
+	// Admin 管理员可以通过管理端口登录，参考 mysql.ListenerConfig
+	Admin bool `json:"admin" yaml:"admin"`
+}
+
+// CanAccess 用户是否可以访问 schema
+func (u User) CanAccess(schema string) bool {
+	return len(u.Schemas) == 0 || slices.Contains(u.Schemas, schema)
+}
+
+// Store 用户存储，可以替换成从配置中心、数据库等读取
+type Store interface {
+	// User 根据用户名查找用户，第二个返回值表示用户是否存在
+	User(name string) (User, bool)
+}
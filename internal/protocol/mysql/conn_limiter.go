@@ -0,0 +1,82 @@
+package mysql
+
+import "sync"
+
+// connLimiter 限制连接的数量，上限为 0 的时候不限制
+// 总数和每个客户端地址的数量在建立连接的时候就可以判断，每个用户的数量要等鉴权成功之后才能判断
+type connLimiter struct {
+	// maxConns 连接总数的上限
+	maxConns int
+	// maxUserConns 每个用户的连接数上限，和 MySQL 的 max_user_connections 一样
+	maxUserConns int
+	// maxHostConns 每个客户端地址的连接数上限
+	maxHostConns int
+
+	mu        sync.Mutex
+	conns     int
+	userConns map[string]int
+	hostConns map[string]int
+}
+
+// acquire 建立连接的时候调用，返回 false 表示超过了限制，此时不需要调用 release
+func (l *connLimiter) acquire(host string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxConns > 0 && l.conns >= l.maxConns {
+		return false
+	}
+	if l.maxHostConns > 0 && l.hostConns[host] >= l.maxHostConns {
+		return false
+	}
+	l.conns++
+	if l.maxHostConns > 0 {
+		if l.hostConns == nil {
+			l.hostConns = make(map[string]int, 16)
+		}
+		l.hostConns[host]++
+	}
+	return true
+}
+
+// release 关闭连接的时候调用
+func (l *connLimiter) release(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conns--
+	if l.maxHostConns > 0 {
+		l.hostConns[host]--
+		if l.hostConns[host] <= 0 {
+			delete(l.hostConns, host)
+		}
+	}
+}
+
+// acquireUser 鉴权成功之后调用，返回 false 表示超过了限制，此时不需要调用 releaseUser
+func (l *connLimiter) acquireUser(user string) bool {
+	if l.maxUserConns <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.userConns[user] >= l.maxUserConns {
+		return false
+	}
+	if l.userConns == nil {
+		l.userConns = make(map[string]int, 16)
+	}
+	l.userConns[user]++
+	return true
+}
+
+// releaseUser 关闭 acquireUser 成功的连接的时候调用
+func (l *connLimiter) releaseUser(user string) {
+	if l.maxUserConns <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.userConns[user]--
+	if l.userConns[user] <= 0 {
+		delete(l.userConns, user)
+	}
+}
@@ -0,0 +1,75 @@
+package mysql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter *connLimiter
+		// hosts 依次建立连接的客户端地址
+		hosts []string
+		// users 依次鉴权成功的用户
+		users     []string
+		wantHosts []bool
+		wantUsers []bool
+	}{
+		{
+			name:      "不限制",
+			limiter:   &connLimiter{},
+			hosts:     []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"},
+			users:     []string{"root", "root", "root"},
+			wantHosts: []bool{true, true, true},
+			wantUsers: []bool{true, true, true},
+		},
+		{
+			name:      "连接总数",
+			limiter:   &connLimiter{maxConns: 2},
+			hosts:     []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			wantHosts: []bool{true, true, false},
+		},
+		{
+			name:      "每个客户端地址的连接数",
+			limiter:   &connLimiter{maxHostConns: 1},
+			hosts:     []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"},
+			wantHosts: []bool{true, false, true},
+		},
+		{
+			name:      "每个用户的连接数",
+			limiter:   &connLimiter{maxUserConns: 2},
+			users:     []string{"root", "root", "root", "alice"},
+			wantUsers: []bool{true, true, false, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, host := range tt.hosts {
+				assert.Equal(t, tt.wantHosts[i], tt.limiter.acquire(host), "第 %d 个连接", i)
+			}
+			for i, user := range tt.users {
+				assert.Equal(t, tt.wantUsers[i], tt.limiter.acquireUser(user), "第 %d 个用户", i)
+			}
+			// 释放之后可以重新建立连接
+			for i, host := range tt.hosts {
+				if tt.wantHosts[i] {
+					tt.limiter.release(host)
+				}
+			}
+			for i, user := range tt.users {
+				if tt.wantUsers[i] {
+					tt.limiter.releaseUser(user)
+				}
+			}
+			assert.Zero(t, tt.limiter.conns)
+			assert.Empty(t, tt.limiter.hostConns)
+			assert.Empty(t, tt.limiter.userConns)
+			for i, host := range tt.hosts {
+				assert.True(t, tt.limiter.acquire(host), "第 %d 个连接", i)
+				tt.limiter.release(host)
+			}
+		})
+	}
+}
@@ -31,9 +31,11 @@ func (s *HintVisitor) Visit(tree antlr.ParseTree) any {
 }
 
 func (s *HintVisitor) VisitRoot(ctx *parser.RootContext) any {
-	sqlStmts := ctx.GetChildren()[0]
-	sqlStmt := sqlStmts.GetChildren()[0]
-	return s.VisitSqlStatement(sqlStmt.(*parser.SqlStatementContext))
+	sqlStmt, ok := FirstStatement(ctx)
+	if !ok {
+		return Hints{}
+	}
+	return s.VisitSqlStatement(sqlStmt)
 }
 
 func (s *HintVisitor) VisitSqlStatement(ctx *parser.SqlStatementContext) any {
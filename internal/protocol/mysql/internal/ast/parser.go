@@ -1,15 +1,31 @@
 package ast
 
 import (
+	"fmt"
+
 	"github.com/antlr4-go/antlr/v4"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast/parser"
 )
 
+// Parse 解析 query，有语法错误的时候 Err 不为 nil，这时候不解析 Hints
+// dbproxy 的语法不认识的语句不一定是错误的，交给后端执行的时候由后端返回真正的错误
 func Parse(query string) ProxyCtx {
+	errListener := &syntaxErrorListener{DefaultErrorListener: antlr.NewDefaultErrorListener()}
 	lexer := parser.NewMySqlLexer(antlr.NewInputStream(query))
+	lexer.RemoveErrorListeners()
+	lexer.AddErrorListener(errListener)
 	tokens := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
 	paser := parser.NewMySqlParser(tokens)
+	paser.RemoveErrorListeners()
+	paser.AddErrorListener(errListener)
 	root := paser.Root()
+	if errListener.err != nil {
+		return ProxyCtx{
+			Hints: Hints{},
+			Root:  root,
+			Err:   errListener.err,
+		}
+	}
 	hints := NewHintVisitor().Visit(root).(Hints)
 	return ProxyCtx{
 		Hints: hints,
@@ -20,4 +36,31 @@ func Parse(query string) ProxyCtx {
 type ProxyCtx struct {
 	Root  parser.IRootContext
 	Hints Hints
+	// Err 第一个语法错误
+	Err error
+}
+
+// FirstStatement 第一个语句，空语句的时候返回 false
+func FirstStatement(root parser.IRootContext) (*parser.SqlStatementContext, bool) {
+	if root == nil || root.SqlStatements() == nil {
+		return nil, false
+	}
+	stmts := root.SqlStatements().AllSqlStatement()
+	if len(stmts) == 0 {
+		return nil, false
+	}
+	stmt, ok := stmts[0].(*parser.SqlStatementContext)
+	return stmt, ok
+}
+
+// syntaxErrorListener 记录第一个语法错误，替换掉默认输出到标准输出的 listener
+type syntaxErrorListener struct {
+	*antlr.DefaultErrorListener
+	err error
+}
+
+func (l *syntaxErrorListener) SyntaxError(_ antlr.Recognizer, _ any, line, column int, msg string, _ antlr.RecognitionException) {
+	if l.err == nil {
+		l.err = fmt.Errorf("第 %d 行第 %d 列有语法错误 %s", line, column, msg)
+	}
 }
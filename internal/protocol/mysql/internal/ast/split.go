@@ -0,0 +1,34 @@
+package ast
+
+import (
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast/parser"
+)
+
+// SplitStatements 将用 ; 连接起来的多个语句拆分成单个的语句，不包含结尾的 ;
+// 无法识别出语句的时候，例如语法错误，会原样返回 query，交给后续流程处理
+func SplitStatements(query string) []string {
+	lexer := parser.NewMySqlLexer(antlr.NewInputStream(query))
+	tokens := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
+	p := parser.NewMySqlParser(tokens)
+	p.RemoveErrorListeners()
+	root := p.Root()
+	if root.SqlStatements() == nil {
+		return []string{query}
+	}
+	// antlr 中的下标是按照字符来计算的
+	runes := []rune(query)
+	stmts := root.SqlStatements().AllSqlStatement()
+	res := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		start, stop := stmt.GetStart(), stmt.GetStop()
+		if start == nil || stop == nil || start.GetStart() < 0 || stop.GetStop() >= len(runes) || start.GetStart() > stop.GetStop() {
+			return []string{query}
+		}
+		res = append(res, string(runes[start.GetStart():stop.GetStop()+1]))
+	}
+	if len(res) == 0 {
+		return []string{query}
+	}
+	return res
+}
@@ -0,0 +1,48 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	testcases := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{
+			name:  "单个语句",
+			query: "SELECT * FROM users WHERE id = 1",
+			want:  []string{"SELECT * FROM users WHERE id = 1"},
+		},
+		{
+			name:  "单个语句带分号",
+			query: "SELECT * FROM users WHERE id = 1;",
+			want:  []string{"SELECT * FROM users WHERE id = 1"},
+		},
+		{
+			name:  "多个语句",
+			query: "INSERT INTO users (id) VALUES (1); UPDATE users SET name = 'a;b' WHERE id = 1;SELECT * FROM users",
+			want: []string{
+				"INSERT INTO users (id) VALUES (1)",
+				"UPDATE users SET name = 'a;b' WHERE id = 1",
+				"SELECT * FROM users",
+			},
+		},
+		{
+			name:  "包含hint和中文",
+			query: "SELECT /* @proxy useMaster=true */ * FROM users WHERE name = '张三';; DELETE FROM users WHERE id = 1;",
+			want: []string{
+				"SELECT /* @proxy useMaster=true */ * FROM users WHERE name = '张三'",
+				"DELETE FROM users WHERE id = 1",
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, SplitStatements(tc.query))
+		})
+	}
+}
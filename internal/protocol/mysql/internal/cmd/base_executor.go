@@ -1,17 +1,43 @@
 package cmd
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
 
 	"github.com/ecodeclub/ekit/slice"
 	"github.com/ecodeclub/ekit/sqlx"
+	"github.com/meoying/dbproxy/internal/errs"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/visitor/vparser"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
 )
 
 type BaseExecutor struct {
+	// kill 终止其他连接正在执行的命令，为 nil 的时候不支持 KILL
+	kill KillFunc
+	// hasSchema 校验客户端切换的逻辑库，为 nil 的时候不校验
+	hasSchema HasSchemaFunc
+	// systemVariablesTx 插件会在事务的连接上设置客户端修改过的系统变量，参考 plugin.SystemVariablesApplier
+	systemVariablesTx bool
+	// logger 和 Server 使用同一个日志，为 nil 的时候使用 slog.Default()
+	logger *slog.Logger
+}
+
+func NewBaseExecutor(logger *slog.Logger, kill KillFunc, hasSchema HasSchemaFunc, systemVariablesTx bool) *BaseExecutor {
+	return &BaseExecutor{logger: logger, kill: kill, hasSchema: hasSchema, systemVariablesTx: systemVariablesTx}
+}
+
+func (e *BaseExecutor) getLogger() *slog.Logger {
+	if e.logger == nil {
+		return slog.Default()
+	}
+	return e.logger
 }
 
 func (e *BaseExecutor) parseQuery(payload []byte) string {
@@ -19,14 +45,120 @@ func (e *BaseExecutor) parseQuery(payload []byte) string {
 	return string(payload[1:])
 }
 
+// withTimeout 语句通过 hint 指定了超时时间的时候，在命令的 ctx 的基础上加上超时时间
+// 命令本身剩余的时间更短的时候，以命令的为准
+func (e *BaseExecutor) withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 func (e *BaseExecutor) getServerStatus(conn *connection.Conn) flags.SeverStatus {
-	status := flags.ServerStatusAutoCommit
+	var status flags.SeverStatus
+	if conn.AutoCommit() {
+		status |= flags.ServerStatusAutoCommit
+	}
 	if conn.InTransaction() {
 		status |= flags.SeverStatusInTrans
 	}
 	return status
 }
 
+// NewPluginContext 交给插件的上下文，只填充了和连接相关的字段，例如 ConnID、User、Session
+// 所有命令和连接的建立、断开都通过它创建上下文，再填充各自的字段
+func NewPluginContext(ctx context.Context, conn *connection.Conn) *pcontext.Context {
+	return &pcontext.Context{
+		Context:    ctx,
+		ConnID:     conn.ID(),
+		User:       conn.User(),
+		ClientAddr: conn.RemoteAddr(),
+		Schema:     conn.Schema(),
+		ConnAttrs:  conn.ConnAttrs(),
+		Charset:    conn.Charset(),
+		Session:    conn.Session(),
+	}
+}
+
+// execTxStmt 通过插件执行 dbproxy 自己构造的事务语句，例如隐式开启事务，不会给客户端返回响应
+// opts 是开启事务时使用的事务特性，执行成功之后更新连接的事务状态
+func (e *BaseExecutor) execTxStmt(ctx context.Context, hdl plugin.Handler, conn *connection.Conn, que string, opts *sql.TxOptions) error {
+	pctx := NewPluginContext(ctx, conn)
+	pctx.Query = que
+	pctx.ParsedQuery = pcontext.NewParsedQuery(que)
+	pctx.SystemVariables = conn.BackendSystemVariables()
+	pctx.TxOptions = opts
+	result, err := hdl.Handle(pctx)
+	if err != nil {
+		return err
+	}
+	conn.SetInTransaction(result.InTransactionState)
+	return nil
+}
+
+// beginImplicitTx 客户端关闭了 autocommit 并且不在事务中的时候，在执行语句之前隐式地开启事务
+// 和 MySQL 一样，这个事务直到客户端 COMMIT 或者 ROLLBACK 的时候才结束
+func (e *BaseExecutor) beginImplicitTx(ctx context.Context, hdl plugin.Handler, conn *connection.Conn) error {
+	if conn.AutoCommit() || conn.InTransaction() {
+		return nil
+	}
+	return e.execTxStmt(ctx, hdl, conn, "START TRANSACTION", conn.TakeTxOptions())
+}
+
+// needSystemVariablesTx autocommit 的时候客户端通过 SET 修改过的变量只记录在连接上，
+// 后端的连接是共用的，要让这些变量对语句生效，只能把语句放在只包含它自己的事务中执行，参考 execInSystemVariablesTx。
+// 只有插件会在事务的连接上设置这些变量的时候才需要这样做。
+// 只有增删改查语句会放在这样的事务中，DDL 这种会隐式提交事务的语句和事务控制语句都不行。
+// sqlType 返回语句的类型，只有在需要判断的时候才会调用
+func (e *BaseExecutor) needSystemVariablesTx(conn *connection.Conn, ctx *pcontext.Context, sqlType func() string) bool {
+	if !e.systemVariablesTx || !conn.AutoCommit() || conn.InTransaction() || len(ctx.SystemVariables) == 0 {
+		return false
+	}
+	switch sqlType() {
+	case vparser.SelectStmt, vparser.InsertStmt, vparser.UpdateStmt, vparser.DeleteStmt:
+		return true
+	}
+	return false
+}
+
+// execInSystemVariablesTx 在只包含这个语句的事务中执行它
+// 插件开启事务的时候把变量设置到事务独占的后端连接上，结束事务之前恢复成原本的值。
+// 对客户端来说语句依旧是在 autocommit 下执行的，所以响应中不会带上 SERVER_STATUS_IN_TRANS。
+// 没有结果集的语句在提交成功之后才返回响应，有结果集的语句在写回结果集之后提交
+func (e *BaseExecutor) execInSystemVariablesTx(ctx context.Context, hdl plugin.Handler, conn *connection.Conn, pctx *pcontext.Context,
+	handleSQLRowsFunc handleSQLRowsFunc, status flags.SeverStatus) (bool, error) {
+	if err := e.execTxStmt(ctx, hdl, conn, "START TRANSACTION", nil); err != nil {
+		return false, e.writeErrRespPacket(conn, err)
+	}
+	// 语句超时或者被 KILL 的时候也要结束事务
+	endCtx := context.WithoutCancel(ctx)
+	result, err := hdl.Handle(pctx)
+	if err == nil && result.Rows == nil {
+		err = e.execTxStmt(endCtx, hdl, conn, "COMMIT", nil)
+		result.InTransactionState = false
+	}
+	if err != nil {
+		if conn.InTransaction() {
+			_ = e.execTxStmt(endCtx, hdl, conn, "ROLLBACK", nil)
+			conn.SetInTransaction(false)
+		}
+		return false, e.writeErrRespPacket(conn, err)
+	}
+	if result.Rows == nil {
+		return e.handlePluginResultWithStatus(result, conn, handleSQLRowsFunc, status)
+	}
+	result.InTransactionState = false
+	completed, err := e.handlePluginResultWithStatus(result, conn, handleSQLRowsFunc, status)
+	end := "COMMIT"
+	if !completed {
+		end = "ROLLBACK"
+	}
+	// 结果集已经写回给客户端了，没有办法再返回结束事务的错误
+	_ = e.execTxStmt(endCtx, hdl, conn, end, nil)
+	conn.SetInTransaction(false)
+	return completed, err
+}
+
 func (e *BaseExecutor) writeOKRespPacket(conn *connection.Conn, status flags.SeverStatus, rowsAffected, lastInsertID uint64) error {
 	b := builder.NewOKPacket(conn.ClientCapabilityFlags(), status)
 	b.AffectedRows = rowsAffected
@@ -34,8 +166,58 @@ func (e *BaseExecutor) writeOKRespPacket(conn *connection.Conn, status flags.Sev
 	return conn.WritePacket(b.Build())
 }
 
+// writeErrRespPacket 回写错误响应
+// 严格模式下，客户端的报文格式非法的时候，回写错误响应之后返回错误，连接会被关闭
 func (e *BaseExecutor) writeErrRespPacket(conn *connection.Conn, err error) error {
-	return conn.WritePacket(builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.NewInternalError(err)).Build())
+	if err1 := conn.WritePacket(builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.NewError(err)).Build()); err1 != nil {
+		return err1
+	}
+	return e.checkMalformedPacket(conn, err)
+}
+
+// checkMalformedPacket 严格模式下，客户端的报文格式非法的时候返回 err，其余情况返回 nil
+// COM_STMT_CLOSE 这种没有响应的命令直接使用它
+func (e *BaseExecutor) checkMalformedPacket(conn *connection.Conn, err error) error {
+	if conn.StrictMode() && errors.Is(err, errs.ErrMalformedPacket) {
+		return err
+	}
+	return nil
+}
+
+// useSchema 切换连接使用的逻辑库，COM_INIT_DB 和 USE 语句共用
+// extraStatus 会合并到 OK_Packet 的服务器状态中，返回的 bool 表示是否切换成功
+func (e *BaseExecutor) useSchema(conn *connection.Conn, schema string, extraStatus flags.SeverStatus) (bool, error) {
+	if schema == "" {
+		return false, conn.WritePacket(builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.ER_NO_DB_ERROR).Build())
+	}
+	if !conn.CanAccess(schema) {
+		b := builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.NewErrDBAccessDenied(conn.User(), conn.RemoteHost(), schema))
+		return false, conn.WritePacket(b.Build())
+	}
+	if e.hasSchema != nil && !e.hasSchema(schema) {
+		return false, e.writeErrRespPacket(conn, errs.NewUnknownDatabaseError(schema))
+	}
+	conn.SetSchema(schema)
+	return true, e.writeOKRespPacket(conn, e.getServerStatus(conn)|extraStatus, 0, 0)
+}
+
+// killConn 终止连接 connID 正在执行的命令，query 为 false 的时候同时关闭连接
+// KILL 语句和 COM_PROCESS_KILL 命令共用，返回的 bool 表示是否成功
+func (e *BaseExecutor) killConn(conn *connection.Conn, connID uint32, query bool, extraStatus flags.SeverStatus) (bool, error) {
+	err := ErrUnknownConn
+	if e.kill != nil {
+		err = e.kill(conn.User(), connID, query)
+	}
+	switch {
+	case err == nil:
+		return true, e.writeOKRespPacket(conn, e.getServerStatus(conn)|extraStatus, 0, 0)
+	case errors.Is(err, ErrUnknownConn):
+		return false, conn.WritePacket(builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.NewErrNoSuchThread(connID)).Build())
+	case errors.Is(err, ErrKillDenied):
+		return false, conn.WritePacket(builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.NewErrKillDenied(connID)).Build())
+	default:
+		return false, e.writeErrRespPacket(conn, err)
+	}
 }
 
 func (e *BaseExecutor) writeRespPackets(conn *connection.Conn, packets [][]byte) error {
@@ -49,82 +231,190 @@ func (e *BaseExecutor) writeRespPackets(conn *connection.Conn, packets [][]byte)
 }
 
 // handleQuerySQLRows 处理使用非prepare语句获取到的结果集
-func (e *BaseExecutor) handleQuerySQLRows(rows sqlx.Rows, conn *connection.Conn, status flags.SeverStatus) error {
-	return e.handleRows(rows, conn, status, func(cols []builder.ColumnType, rows [][]any, serverStatus flags.SeverStatus, charset uint32) ([][]byte, error) {
-		textResultset := builder.NewTextResultsetPacket(conn.ClientCapabilityFlags(), cols, rows, serverStatus, charset)
-		return textResultset.Build(), nil
+func (e *BaseExecutor) handleQuerySQLRows(rows sqlx.Rows, conn *connection.Conn, status flags.SeverStatus) (bool, error) {
+	return e.handleRows(rows, conn, status, func(cols []builder.ColumnType, serverStatus flags.SeverStatus, charset uint32) resultsetBuilder {
+		return builder.NewTextResultsetPacket(conn.ClientCapabilityFlags(), cols, nil, serverStatus, charset)
 	})
 }
 
 // handlePrepareSQLRows 处理使用prepare语句获取到的结果集
-func (e *BaseExecutor) handlePrepareSQLRows(rows sqlx.Rows, conn *connection.Conn, status flags.SeverStatus) error {
-	return e.handleRows(rows, conn, status, func(cols []builder.ColumnType, rows [][]any, serverStatus flags.SeverStatus, charset uint32) ([][]byte, error) {
-		binaryResultset := builder.NewBinaryResultsetPacket(conn.ClientCapabilityFlags(), cols, rows, serverStatus, charset)
-		return binaryResultset.Build()
+func (e *BaseExecutor) handlePrepareSQLRows(rows sqlx.Rows, conn *connection.Conn, status flags.SeverStatus) (bool, error) {
+	return e.handleRows(rows, conn, status, func(cols []builder.ColumnType, serverStatus flags.SeverStatus, charset uint32) resultsetBuilder {
+		return builder.NewBinaryResultsetPacket(conn.ClientCapabilityFlags(), cols, nil, serverStatus, charset)
 	})
 }
 
-type buildResultsetRespPacketsFunc func(cols []builder.ColumnType, rows [][]any, serverStatus flags.SeverStatus, charset uint32) ([][]byte, error)
+// resultsetBuilder 按照字段定义、行数据、结束包的顺序构造结果集
+// 这样可以读取一行就发送一行，不需要将整个结果集缓存在内存中
+type resultsetBuilder interface {
+	BuildColumns() [][]byte
+	BuildRow(row []any) ([]byte, error)
+	BuildEnd(err error) []byte
+}
+
+type newResultsetBuilderFunc func(cols []builder.ColumnType, serverStatus flags.SeverStatus, charset uint32) resultsetBuilder
+
+// handleRows 以流的形式将结果集写回给客户端
+// 先写字段定义，而后每从 rows 中读取一行就编码并写回一行。
+// 写回是同步的，客户端读得慢的时候会阻塞在写入上，也就不会继续从 rows 中读取数据
+// 返回的 bool 表示结果集是否完整写回，false 表示给客户端返回的是错误
+func (e *BaseExecutor) handleRows(rows sqlx.Rows, conn *connection.Conn, status flags.SeverStatus, newBuilderFunc newResultsetBuilderFunc) (bool, error) {
+	completed, err := e.writeRows(rows, conn, status, newBuilderFunc)
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	return completed, err
+}
 
-func (e *BaseExecutor) handleRows(rows sqlx.Rows, conn *connection.Conn, status flags.SeverStatus, buildPacketsFunc buildResultsetRespPacketsFunc) error {
+// writeRows 依次写回 rows 中的全部结果集，例如存储过程可能返回多个结果集，
+// 除了最后一个结果集，其余结果集的结束包都要带上 SERVER_MORE_RESULTS_EXISTS。
+// 语句没有返回结果集的时候，例如通过 Query 转发的 DDL，写回 OK_Packet
+func (e *BaseExecutor) writeRows(rows sqlx.Rows, conn *connection.Conn, status flags.SeverStatus, newBuilderFunc newResultsetBuilderFunc) (bool, error) {
 	cols, err := rows.ColumnTypes()
 	if err != nil {
-		return e.writeErrRespPacket(conn, err)
+		return false, e.writeErrRespPacket(conn, err)
 	}
-	var data [][]any
-	for rows.Next() {
-		row := make([]any, len(cols))
-		// 这里需要用到指针给Scan，不然会报错
-		for i := range row {
-			var v []byte
-			row[i] = &v
+	if len(cols) == 0 {
+		return true, e.writeOKRespPacket(conn, status, 0, 0)
+	}
+	for {
+		more, completed, err := e.writeResultset(rows, cols, conn, status, newBuilderFunc)
+		if err != nil || !completed || !more {
+			return completed, err
 		}
-		err = rows.Scan(row...)
+		cols, err = rows.ColumnTypes()
 		if err != nil {
-			return e.writeErrRespPacket(conn, err)
+			// 上一个结果集已经告诉客户端还有结果了，ERR_Packet 也是一个合法的结果
+			return false, e.writeErrRespPacket(conn, err)
 		}
-
-		data = append(data, row)
 	}
+}
 
+// writeResultset 写回当前的结果集，返回是否还有下一个结果集以及当前结果集是否完整写回
+func (e *BaseExecutor) writeResultset(rows sqlx.Rows, cols []*sql.ColumnType, conn *connection.Conn,
+	status flags.SeverStatus, newBuilderFunc newResultsetBuilderFunc) (bool, bool, error) {
 	columnTypes := slice.Map(cols, func(idx int, src *sql.ColumnType) builder.ColumnType {
 		return src
 	})
-
-	packets, err := buildPacketsFunc(columnTypes, data, status, conn.CharacterSet())
+	b := newBuilderFunc(columnTypes, status, conn.CharacterSet())
+	err := e.writeRespPackets(conn, b.BuildColumns())
 	if err != nil {
-		return e.writeErrRespPacket(conn, err)
+		return false, false, err
 	}
 
-	err = e.writeRespPackets(conn, packets)
-	if err != nil {
-		return err
+	// 每一行都复用同一批接收数据的变量，Scan 的时候会复制数据
+	row := make([]any, len(cols))
+	// 这里需要用到指针给Scan，不然会报错
+	for i := range row {
+		var v []byte
+		row[i] = &v
+	}
+	for rows.Next() {
+		err = rows.Scan(row...)
+		if err != nil {
+			// 字段定义已经发送出去了，只能用 ERR_Packet 来结束结果集
+			return false, false, conn.WritePacket(b.BuildEnd(err))
+		}
+		pkt, err := b.BuildRow(row)
+		if err != nil {
+			return false, false, conn.WritePacket(b.BuildEnd(err))
+		}
+		err = conn.WritePacket(pkt)
+		if err != nil {
+			// 客户端已经不可用了
+			return false, false, err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return false, false, conn.WritePacket(b.BuildEnd(err))
+	}
+	// 读完当前结果集之后才知道后面还有没有结果集
+	if !rows.NextResultSet() {
+		if err = rows.Err(); err != nil {
+			return false, false, conn.WritePacket(b.BuildEnd(err))
+		}
+		return false, true, conn.WritePacket(b.BuildEnd(nil))
 	}
-	return rows.Close()
+	end := newBuilderFunc(columnTypes, status|flags.ServerMoreResultsExists, conn.CharacterSet()).BuildEnd(nil)
+	return true, true, conn.WritePacket(end)
 }
 
 // handleSQLRowsFunc 对 handleQuerySQLRows 和 handlePrepareSQLRows 方法的抽象
-type handleSQLRowsFunc func(rows sqlx.Rows, conn *connection.Conn, status flags.SeverStatus) error
+type handleSQLRowsFunc func(rows sqlx.Rows, conn *connection.Conn, status flags.SeverStatus) (bool, error)
 
 // handlePluginResult 同一处理插件执行结果
 func (e *BaseExecutor) handlePluginResult(result *plugin.Result, conn *connection.Conn, handleSQLRowsFunc handleSQLRowsFunc) error {
+	_, err := e.handlePluginResultWithStatus(result, conn, handleSQLRowsFunc, 0)
+	return err
+}
+
+// handlePluginResultWithStatus 统一处理插件执行结果，extraStatus 会合并到响应的服务器状态中
+// 例如多语句的时候，除了最后一个语句，其余语句的响应都要带上 SERVER_MORE_RESULTS_EXISTS
+// 返回的 bool 表示是否成功写回了结果，false 表示给客户端返回的是错误
+func (e *BaseExecutor) handlePluginResultWithStatus(result *plugin.Result, conn *connection.Conn,
+	handleSQLRowsFunc handleSQLRowsFunc, extraStatus flags.SeverStatus) (bool, error) {
 	// 重置conn的事务状态
 	conn.SetInTransaction(result.InTransactionState)
 
-	status := flags.ServerStatusAutoCommit
-	if result.InTransactionState {
-		status |= flags.SeverStatusInTrans
-	}
+	status := e.getServerStatus(conn) | extraStatus
 
 	if result.Rows != nil {
+		if result.Summary != nil {
+			// 结果集关闭之后才能拿到完整的统计信息
+			return handleSQLRowsFunc(&summaryRows{Rows: result.Rows, summary: result.Summary}, conn, status)
+		}
 		return handleSQLRowsFunc(result.Rows, conn, status)
 	}
 
+	if result.Summary != nil {
+		defer result.Summary.Complete()
+	}
+
 	if result.Result != nil {
-		return e.handleSQLResult(result.Result, conn, status)
+		if result.Summary != nil {
+			result.Summary.RowsAffected, _ = result.Result.RowsAffected()
+			result.Summary.LastInsertID, _ = result.Result.LastInsertId()
+		}
+		return true, e.handleSQLResult(result.Result, conn, status)
 	}
 
-	return e.writeOKRespPacket(conn, status, 0, 0)
+	return true, e.writeOKRespPacket(conn, status, 0, 0)
+}
+
+// summaryRows 统计写回客户端的行数，关闭的时候调用 Summary 的回调
+type summaryRows struct {
+	sqlx.Rows
+	summary *plugin.Summary
+	closed  bool
+}
+
+func (r *summaryRows) Next() bool {
+	if !r.Rows.Next() {
+		return false
+	}
+	r.summary.RowsReturned++
+	return true
+}
+
+func (r *summaryRows) Scan(dest ...any) error {
+	err := r.Rows.Scan(dest...)
+	if err != nil && r.summary.Err == nil {
+		r.summary.Err = err
+	}
+	return err
+}
+
+func (r *summaryRows) Close() error {
+	if r.closed {
+		return r.Rows.Close()
+	}
+	r.closed = true
+	if r.summary.Err == nil {
+		r.summary.Err = r.Rows.Err()
+	}
+	err := r.Rows.Close()
+	r.summary.Complete()
+	return err
 }
 
 func (e *BaseExecutor) handleSQLResult(result sql.Result, conn *connection.Conn, status flags.SeverStatus) error {
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseExecutor_handleQuerySQLRows(t *testing.T) {
+	tests := []struct {
+		name          string
+		mock          func(mock sqlmock.Sqlmock)
+		wantErr       error
+		wantCompleted bool
+		// 客户端收到的报文的第一个字节
+		wantHeaders []byte
+	}{
+		{
+			name:          "逐行写回",
+			wantCompleted: true,
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT .*").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "name"}).
+						AddRow("1", "Tom").
+						AddRow("2", "Jerry"))
+			},
+			wantHeaders: []byte{
+				0x02,       // column_count
+				0x03, 0x03, // column definitions
+				0xfe,       // EOF
+				0x01, 0x01, // rows
+				0xfe, // EOF
+			},
+		},
+		{
+			name: "读取数据的过程中出错",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT .*").WillReturnRows(
+					sqlmock.NewRows([]string{"id"}).
+						AddRow("1").
+						AddRow("2").
+						RowError(1, errors.New("mock error")))
+			},
+			wantHeaders: []byte{
+				0x01, // column_count
+				0x03, // column definition
+				0xfe, // EOF
+				0x01, // 第一行
+				0xff, // ERR
+			},
+		},
+		{
+			name:          "没有数据",
+			wantCompleted: true,
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			wantHeaders: []byte{
+				0x01, // column_count
+				0x03, // column definition
+				0xfe, // EOF
+				0xfe, // EOF
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+			tt.mock(mock)
+			rows, err := db.Query("SELECT * FROM users")
+			require.NoError(t, err)
+
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := connection.NewConn(1, server, nil)
+			defer conn.Close()
+
+			headers := make(chan []byte, 1)
+			go func() {
+				headers <- readPacketHeaders(client, len(tt.wantHeaders))
+			}()
+			e := &BaseExecutor{}
+			completed, err := e.handleQuerySQLRows(rows, conn, flags.ServerStatusAutoCommit)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantCompleted, completed)
+			assert.Equal(t, tt.wantHeaders, <-headers)
+		})
+	}
+}
+
+func TestBaseExecutor_handlePluginResultSummary(t *testing.T) {
+	tests := []struct {
+		name   string
+		result func(db *sql.DB, mock sqlmock.Sqlmock) *plugin.Result
+		// 客户端需要读取的报文数量
+		packets     int
+		wantSummary plugin.Summary
+	}{
+		{
+			name: "结果集",
+			result: func(db *sql.DB, mock sqlmock.Sqlmock) *plugin.Result {
+				mock.ExpectQuery("SELECT .*").WillReturnRows(
+					sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2"))
+				rows, err := db.Query("SELECT * FROM users")
+				require.NoError(t, err)
+				return &plugin.Result{Rows: rows}
+			},
+			packets:     6,
+			wantSummary: plugin.Summary{RowsReturned: 2},
+		},
+		{
+			name: "读取数据的过程中出错",
+			result: func(db *sql.DB, mock sqlmock.Sqlmock) *plugin.Result {
+				mock.ExpectQuery("SELECT .*").WillReturnRows(
+					sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2").
+						RowError(1, errors.New("mock error")))
+				rows, err := db.Query("SELECT * FROM users")
+				require.NoError(t, err)
+				return &plugin.Result{Rows: rows}
+			},
+			packets:     5,
+			wantSummary: plugin.Summary{RowsReturned: 1, Err: errors.New("mock error")},
+		},
+		{
+			name: "影响的行数",
+			result: func(db *sql.DB, mock sqlmock.Sqlmock) *plugin.Result {
+				return &plugin.Result{Result: sqlmock.NewResult(3, 2)}
+			},
+			packets:     1,
+			wantSummary: plugin.Summary{RowsAffected: 2, LastInsertID: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+			result := tt.result(db, mock)
+			var summaries []plugin.Summary
+			result.OnComplete(func(s *plugin.Summary) {
+				summaries = append(summaries, *s)
+			})
+
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := connection.NewConn(1, server, nil)
+			defer conn.Close()
+
+			go func() {
+				readPacketHeaders(client, tt.packets)
+			}()
+			e := &BaseExecutor{}
+			_, err = e.handlePluginResultWithStatus(result, conn, e.handleQuerySQLRows, 0)
+			require.NoError(t, err)
+			// 回调只调用一次，并且可以读到最终的统计信息
+			require.Len(t, summaries, 1)
+			assert.Equal(t, tt.wantSummary.RowsReturned, summaries[0].RowsReturned)
+			assert.Equal(t, tt.wantSummary.RowsAffected, summaries[0].RowsAffected)
+			assert.Equal(t, tt.wantSummary.LastInsertID, summaries[0].LastInsertID)
+			assert.Equal(t, tt.wantSummary.Err, summaries[0].Err)
+		})
+	}
+}
+
+// readPacketHeaders 模拟客户端读取 n 个报文，返回每个报文 payload 的第一个字节
+func readPacketHeaders(conn net.Conn, n int) []byte {
+	res := make([]byte, 0, n)
+	for i := 0; i < n; i++ {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return res
+		}
+		body := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return res
+		}
+		res = append(res, body[0])
+	}
+	return res
+}
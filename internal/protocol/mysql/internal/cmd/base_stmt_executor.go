@@ -1,19 +1,35 @@
 package cmd
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/ecodeclub/ekit/syncx"
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/visitor/vparser"
+	"go.uber.org/multierr"
 )
 
 type BaseStmtExecutor struct {
 	*BaseExecutor
 	stmtIDGenerator  atomic.Uint32
 	stmtID2NumParams syncx.Map[uint32, uint64]
+	// stmtID2Cursor 预处理语句打开的游标
+	stmtID2Cursor syncx.Map[uint32, *cursor]
+	// stmtID2LongData 预处理语句通过 COM_STMT_SEND_LONG_DATA 发送的参数
+	// 同一个预处理语句只会在一个连接上使用，所以里面的数据不需要考虑并发安全
+	stmtID2LongData syncx.Map[uint32, *longData]
+	// stmtID2Timeout 预处理语句通过 hint 指定的超时时间，每次执行都会使用
+	stmtID2Timeout syncx.Map[uint32, time.Duration]
+	// stmtID2Query 预处理语句本身，同一个预处理语句只会在一个连接上使用，所以里面的数据不需要考虑并发安全
+	stmtID2Query syncx.Map[uint32, *stmtQuery]
+	// connID2StmtIDs 连接上还没有关闭的预处理语句，连接断开的时候要释放它们的状态
+	// 同一个连接上的命令是串行执行的，所以里面的 map 不需要考虑并发安全
+	connID2StmtIDs syncx.Map[uint32, map[uint32]struct{}]
 }
 
 func NewBaseStmtExecutor(base *BaseExecutor) *BaseStmtExecutor {
@@ -21,14 +37,12 @@ func NewBaseStmtExecutor(base *BaseExecutor) *BaseStmtExecutor {
 }
 
 // parseStmtID 获取对应prepare stmt id
-func (e *BaseStmtExecutor) parseStmtID(payload []byte) uint32 {
-	var stmtId uint32
-	// 第一个字节是 cmd
-	reader := bytes.NewReader(payload[1:5])
-	if err := binary.Read(reader, binary.LittleEndian, &stmtId); err != nil {
-		return 0
+func (e *BaseStmtExecutor) parseStmtID(payload []byte) (uint32, error) {
+	// 第一个字节是 cmd，接着是 int<4> 的 statement_id
+	if len(payload) < 5 {
+		return 0, fmt.Errorf("%w，长度 %d 的报文中没有 statement_id", errs.ErrMalformedPacket, len(payload))
 	}
-	return stmtId
+	return binary.LittleEndian.Uint32(payload[1:5]), nil
 }
 
 // generateExecuteStmtSQL 获取执行prepare的sql语句
@@ -41,8 +55,38 @@ func (e *BaseStmtExecutor) generateDeallocatePrepareStmtSQL(stmtId uint32) strin
 	return fmt.Sprintf("DEALLOCATE PREPARE stmt%d", stmtId)
 }
 
-func (e *BaseStmtExecutor) generateStmtID() uint32 {
-	return e.stmtIDGenerator.Add(1)
+// generateStmtID 生成预处理语句的 ID，并且记录在连接上
+func (e *BaseStmtExecutor) generateStmtID(connID uint32) uint32 {
+	stmtID := e.stmtIDGenerator.Add(1)
+	stmtIDs, _ := e.connID2StmtIDs.LoadOrStore(connID, make(map[uint32]struct{}, 1))
+	stmtIDs[stmtID] = struct{}{}
+	return stmtID
+}
+
+// releaseStmt 释放预处理语句的状态，包括打开的游标，COM_STMT_CLOSE 和连接断开共用
+func (e *BaseStmtExecutor) releaseStmt(connID, stmtID uint32) error {
+	if stmtIDs, ok := e.connID2StmtIDs.Load(connID); ok {
+		delete(stmtIDs, stmtID)
+	}
+	e.stmtID2NumParams.Delete(stmtID)
+	e.resetLongData(stmtID)
+	e.deleteTimeout(stmtID)
+	e.stmtID2Query.Delete(stmtID)
+	return e.closeCursor(stmtID)
+}
+
+// ReleaseConn 连接断开之后释放连接上所有没有关闭的预处理语句的状态
+// 打开的游标会占用后端的连接，所以一定要关闭
+func (e *BaseStmtExecutor) ReleaseConn(connID uint32) error {
+	stmtIDs, ok := e.connID2StmtIDs.LoadAndDelete(connID)
+	if !ok {
+		return nil
+	}
+	var err error
+	for stmtID := range stmtIDs {
+		err = multierr.Append(err, e.releaseStmt(connID, stmtID))
+	}
+	return err
 }
 
 func (e *BaseStmtExecutor) storeNumParams(stmtID uint32, query string) uint64 {
@@ -55,3 +99,92 @@ func (e *BaseStmtExecutor) storeNumParams(stmtID uint32, query string) uint64 {
 func (e *BaseStmtExecutor) loadNumParams(stmtID uint32) (uint64, bool) {
 	return e.stmtID2NumParams.Load(stmtID)
 }
+
+// storeTimeout 解析预处理语句通过 hint 指定的超时时间
+func (e *BaseStmtExecutor) storeTimeout(stmtID uint32, query string) {
+	if !strings.Contains(query, "@proxy") {
+		// 绝大多数语句都没有 hint，没必要再解析一遍
+		return
+	}
+	// 和插件一样，占位符要替换成字符串之后才能解析
+	parsedQuery := pcontext.NewParsedQuery(strings.ReplaceAll(query, "?", "'?'"))
+	if timeout := parsedQuery.Timeout(); timeout > 0 {
+		e.stmtID2Timeout.Store(stmtID, timeout)
+	}
+}
+
+// loadTimeout 预处理语句的超时时间，没有指定的时候返回 0
+func (e *BaseStmtExecutor) loadTimeout(stmtID uint32) time.Duration {
+	timeout, _ := e.stmtID2Timeout.Load(stmtID)
+	return timeout
+}
+
+// deleteTimeout 删除预处理语句的超时时间
+func (e *BaseStmtExecutor) deleteTimeout(stmtID uint32) {
+	e.stmtID2Timeout.Delete(stmtID)
+}
+
+// stmtQuery 预处理语句本身和它的类型
+type stmtQuery struct {
+	query string
+	// sqlType 语句的类型，例如 vparser.SelectStmt，第一次使用的时候才解析
+	sqlType string
+}
+
+func (e *BaseStmtExecutor) storeQuery(stmtID uint32, query string) {
+	e.stmtID2Query.Store(stmtID, &stmtQuery{query: query})
+}
+
+// loadSQLType 预处理语句的类型，例如 vparser.SelectStmt
+func (e *BaseStmtExecutor) loadSQLType(stmtID uint32) string {
+	q, ok := e.stmtID2Query.Load(stmtID)
+	if !ok {
+		return vparser.UnKnownSQLStmt
+	}
+	if q.sqlType == "" {
+		// 和插件一样，占位符要替换成字符串之后才能解析
+		parsedQuery := pcontext.NewParsedQuery(strings.ReplaceAll(q.query, "?", "'?'"))
+		q.sqlType = parsedQuery.Type()
+	}
+	return q.sqlType
+}
+
+// longData 预处理语句通过 COM_STMT_SEND_LONG_DATA 发送的参数
+type longData struct {
+	// params key 是参数的下标
+	params map[uint16][]byte
+	// err COM_STMT_SEND_LONG_DATA 没有响应，出错的时候只能在执行语句的时候返回
+	err error
+}
+
+// appendLongData 缓存 COM_STMT_SEND_LONG_DATA 发送的参数，同一个参数可以分多次发送
+// 和 MySQL 一样，单个参数拼接之后的长度不能超过 maxLen，也就是 max_allowed_packet
+func (e *BaseStmtExecutor) appendLongData(stmtID uint32, paramID uint16, data []byte, maxLen int) {
+	ld, _ := e.stmtID2LongData.LoadOrStore(stmtID, &longData{params: make(map[uint16][]byte, 1)})
+	if ld.err != nil {
+		return
+	}
+	if len(ld.params[paramID])+len(data) > maxLen {
+		ld.err = errs.ErrLongDataTooLarge
+		// 语句已经不能执行了，缓存的数据也就没用了
+		ld.params = nil
+		return
+	}
+	// data 引用的是读取报文的缓冲区，所以要复制一份
+	ld.params[paramID] = append(ld.params[paramID], data...)
+}
+
+// takeLongData 取出并清空预处理语句缓存的参数，执行语句之后客户端需要重新发送
+// 发送参数的过程中出错的时候返回 error
+func (e *BaseStmtExecutor) takeLongData(stmtID uint32) (map[uint16][]byte, error) {
+	ld, ok := e.stmtID2LongData.LoadAndDelete(stmtID)
+	if !ok {
+		return nil, nil
+	}
+	return ld.params, ld.err
+}
+
+// resetLongData 清空预处理语句缓存的参数
+func (e *BaseStmtExecutor) resetLongData(stmtID uint32) {
+	e.stmtID2LongData.Delete(stmtID)
+}
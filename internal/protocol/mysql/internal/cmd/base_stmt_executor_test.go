@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseStmtExecutor_ReleaseConn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(
+		sqlmock.NewRowsWithColumnDefinition(sqlmock.NewColumn("id").OfType("VARCHAR", "")).
+			AddRow("1").AddRow("2")).
+		RowsWillBeClosed()
+	completed := false
+	hdl := plugin.HandleFunc(func(ctx *pcontext.Context) (*plugin.Result, error) {
+		rows, err := db.Query("SELECT id FROM users")
+		require.NoError(t, err)
+		res := &plugin.Result{Rows: rows}
+		res.OnComplete(func(s *plugin.Summary) {
+			completed = true
+		})
+		return res, nil
+	})
+
+	base := NewBaseStmtExecutor(&BaseExecutor{})
+	stmtID := base.generateStmtID(1)
+	base.storeNumParams(stmtID, "SELECT id FROM users WHERE name = ?")
+	base.storeTimeout(stmtID, "SELECT /* @proxy timeout=1s */ id FROM users WHERE name = ?")
+	base.appendLongData(stmtID, 0, []byte("Tom"), connection.DefaultMaxAllowedPacket)
+	// 其它连接上的预处理语句不受影响
+	otherStmtID := base.generateStmtID(2)
+	base.storeNumParams(otherStmtID, "SELECT id FROM users")
+
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := connection.NewConn(1, server, nil)
+	defer conn.Close()
+	go func() {
+		// 打开游标的时候只返回字段定义
+		readPacketHeaders(client, 3)
+	}()
+	err = NewStmtExecuteExecutor(hdl, base).Exec(context.Background(), conn, []byte{
+		CmdStmtExecute.Byte(),
+		byte(stmtID), 0x00, 0x00, 0x00, // statement_id
+		0x01,                   // flags CURSOR_TYPE_READ_ONLY
+		0x01, 0x00, 0x00, 0x00, // iteration_count
+		0x00,       // null_bitmap
+		0x01,       // new_params_bind_flag
+		0xfe, 0x00, // MYSQL_TYPE_STRING
+	})
+	require.NoError(t, err)
+	_, ok := base.loadCursor(1, stmtID)
+	require.True(t, ok)
+	// 客户端断开连接，游标还没有读完
+	base.appendLongData(stmtID, 0, []byte("Jerry"), connection.DefaultMaxAllowedPacket)
+	require.NoError(t, base.ReleaseConn(1))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.True(t, completed)
+	_, ok = base.loadCursor(1, stmtID)
+	assert.False(t, ok)
+	_, ok = base.loadNumParams(stmtID)
+	assert.False(t, ok)
+	longData, err := base.takeLongData(stmtID)
+	require.NoError(t, err)
+	assert.Nil(t, longData)
+	assert.Zero(t, base.loadTimeout(stmtID))
+	_, ok = base.loadNumParams(otherStmtID)
+	assert.True(t, ok)
+	// 重复释放什么也不做
+	require.NoError(t, base.ReleaseConn(1))
+}
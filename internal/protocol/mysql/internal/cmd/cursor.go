@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/ecodeclub/ekit/slice"
+	"github.com/ecodeclub/ekit/sqlx"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+)
+
+// cursor 服务端游标
+// 执行预处理语句的时候客户端要求打开游标，那么只会返回字段定义，
+// 数据保留在 rows 中，由客户端通过 COM_STMT_FETCH 分批获取。
+// rows 是插件返回的结果集，分库分表的时候就是合并之后的结果集
+type cursor struct {
+	connID uint32
+	rows   sqlx.Rows
+	// cancel 关闭游标的时候取消执行语句的 ctx
+	cancel context.CancelFunc
+	cols   []builder.ColumnType
+	// row 每次读取数据都复用的接收数据的变量
+	row []any
+}
+
+// cursorContext 打开游标的时候执行语句使用的 ctx
+// 命令执行期间和命令的 ctx 一起取消，例如超时、KILL QUERY 或者客户端断开连接；
+// 命令结束之后就只能在关闭游标的时候通过 cancel 取消，这样结果集才能在后续的 COM_STMT_FETCH 中继续读取
+type cursorContext struct {
+	// Context 只用来获取 Value
+	context.Context
+	done chan struct{}
+	once sync.Once
+	err  error
+	// stop 停止跟随命令的 ctx
+	stop func() bool
+}
+
+func newCursorContext(parent context.Context) *cursorContext {
+	c := &cursorContext{
+		Context: context.WithoutCancel(parent),
+		done:    make(chan struct{}),
+	}
+	c.stop = context.AfterFunc(parent, func() {
+		c.cancelWithErr(parent.Err())
+	})
+	return c
+}
+
+func (c *cursorContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *cursorContext) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// detach 命令结束的时候调用，之后命令的 ctx 取消不会再影响游标
+func (c *cursorContext) detach() {
+	c.stop()
+}
+
+// cancel 关闭游标的时候调用
+func (c *cursorContext) cancel() {
+	c.cancelWithErr(context.Canceled)
+}
+
+func (c *cursorContext) cancelWithErr(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+	})
+}
+
+// fetch 读取最多 n 行数据并写回给客户端
+// 返回 true 表示游标已经不可用了，可能是数据读完了，也可能是读取数据出错，调用者需要关闭游标
+func (c *cursor) fetch(conn *connection.Conn, n uint32, status flags.SeverStatus) (bool, error) {
+	b := builder.NewBinaryResultsetPacket(conn.ClientCapabilityFlags(), c.cols, nil, status, conn.CharacterSet())
+	exhausted := false
+	for i := uint32(0); i < n; i++ {
+		if !c.rows.Next() {
+			exhausted = true
+			break
+		}
+		err := c.rows.Scan(c.row...)
+		if err != nil {
+			return true, conn.WritePacket(b.BuildEnd(err))
+		}
+		pkt, err := b.BuildRow(c.row)
+		if err != nil {
+			return true, conn.WritePacket(b.BuildEnd(err))
+		}
+		err = conn.WritePacket(pkt)
+		if err != nil {
+			return true, err
+		}
+	}
+	if exhausted {
+		if err := c.rows.Err(); err != nil {
+			return true, conn.WritePacket(b.BuildEnd(err))
+		}
+		// 告诉客户端已经没有数据了
+		status |= flags.ServerStatusLastRowSent
+	}
+	return exhausted, conn.WritePacket(builder.NewEOFPacket(conn.ClientCapabilityFlags(), status).Build())
+}
+
+// openCursor 打开游标，只写回字段定义
+func (e *BaseStmtExecutor) openCursor(stmtID uint32, rows sqlx.Rows, cancel context.CancelFunc, conn *connection.Conn, status flags.SeverStatus) (bool, error) {
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		_ = rows.Close()
+		return false, e.writeErrRespPacket(conn, err)
+	}
+	columnTypes := slice.Map(cols, func(idx int, src *sql.ColumnType) builder.ColumnType {
+		return src
+	})
+	c := &cursor{
+		connID: conn.ID(),
+		rows:   rows,
+		cancel: cancel,
+		cols:   columnTypes,
+		row:    make([]any, len(cols)),
+	}
+	for i := range c.row {
+		var v []byte
+		c.row[i] = &v
+	}
+	e.stmtID2Cursor.Store(stmtID, c)
+	b := builder.NewBinaryResultsetPacket(conn.ClientCapabilityFlags(), columnTypes, nil,
+		status|flags.ServerStatusCursorExists, conn.CharacterSet())
+	return true, e.writeRespPackets(conn, b.BuildColumns())
+}
+
+// loadCursor 获取连接上预处理语句打开的游标
+func (e *BaseStmtExecutor) loadCursor(connID, stmtID uint32) (*cursor, bool) {
+	c, ok := e.stmtID2Cursor.Load(stmtID)
+	if !ok || c.connID != connID {
+		return nil, false
+	}
+	return c, true
+}
+
+// closeCursor 关闭预处理语句打开的游标，没有打开游标的时候什么也不做
+func (e *BaseStmtExecutor) closeCursor(stmtID uint32) error {
+	c, ok := e.stmtID2Cursor.LoadAndDelete(stmtID)
+	if !ok {
+		return nil
+	}
+	defer c.cancel()
+	return c.rows.Close()
+}
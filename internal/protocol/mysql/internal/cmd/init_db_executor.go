@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+)
+
+var _ Executor = &InitDBExecutor{}
+
+// HasSchemaFunc 判断能否切换到逻辑库 schema
+type HasSchemaFunc func(schema string) bool
+
+// InitDBExecutor 负责处理 COM_INIT_DB 命令，切换当前连接使用的逻辑库
+type InitDBExecutor struct {
+	*BaseExecutor
+}
+
+func NewInitDBExecutor(executor *BaseExecutor) *InitDBExecutor {
+	return &InitDBExecutor{
+		BaseExecutor: executor,
+	}
+}
+
+// Exec
+// COM_INIT_DB 命令的 payload 格式在
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_init_db.html
+func (e *InitDBExecutor) Exec(
+	ctx context.Context,
+	conn *connection.Conn,
+	payload []byte) error {
+	// 第一个字节是 cmd，后面都是库名
+	_, err := e.useSchema(conn, string(payload[1:]), 0)
+	return err
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitDBExecutor_Exec(t *testing.T) {
+	tests := []struct {
+		name     string
+		executor Executor
+		payload  []byte
+
+		wantHeader byte
+		wantSchema string
+	}{
+		{
+			name:       "COM_INIT_DB",
+			executor:   NewInitDBExecutor(&BaseExecutor{}),
+			payload:    append([]byte{CmdInitDB.Byte()}, "order_db"...),
+			wantHeader: 0x00,
+			wantSchema: "order_db",
+		},
+		{
+			name:       "COM_INIT_DB_没有库名",
+			executor:   NewInitDBExecutor(&BaseExecutor{}),
+			payload:    []byte{CmdInitDB.Byte()},
+			wantHeader: 0xff,
+		},
+		{
+			name: "COM_INIT_DB_逻辑库不存在",
+			executor: NewInitDBExecutor(NewBaseExecutor(nil, nil, func(schema string) bool {
+				return schema == "order_db"
+			}, false)),
+			payload:    append([]byte{CmdInitDB.Byte()}, "other_db"...),
+			wantHeader: 0xff,
+		},
+		{
+			name:       "USE语句",
+			executor:   NewQueryExecutor(nil, &BaseExecutor{}),
+			payload:    append([]byte{CmdQuery.Byte()}, "USE `order_db`"...),
+			wantHeader: 0x00,
+			wantSchema: "order_db",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := connection.NewConn(1, server, nil)
+			defer conn.Close()
+
+			headers := make(chan []byte, 1)
+			go func() {
+				headers <- readPacketHeaders(client, 1)
+			}()
+			err := tt.executor.Exec(context.Background(), conn, tt.payload)
+			require.NoError(t, err)
+			assert.Equal(t, []byte{tt.wantHeader}, <-headers)
+			assert.Equal(t, tt.wantSchema, conn.Schema())
+		})
+	}
+}
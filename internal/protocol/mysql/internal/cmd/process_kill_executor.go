@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+)
+
+var (
+	// ErrUnknownConn 要终止的连接不存在
+	ErrUnknownConn = errors.New("连接不存在")
+	// ErrKillDenied 只能终止同一个用户的连接
+	ErrKillDenied = errors.New("无权终止其他用户的连接")
+)
+
+// KillFunc 终止连接 connID 正在执行的命令，query 为 false 的时候同时关闭连接
+// user 是发起 KILL 的用户
+type KillFunc func(user string, connID uint32, query bool) error
+
+var _ Executor = &ProcessKillExecutor{}
+
+// ProcessKillExecutor 负责处理 COM_PROCESS_KILL 命令，效果等同于 KILL CONNECTION 语句
+type ProcessKillExecutor struct {
+	*BaseExecutor
+}
+
+func NewProcessKillExecutor(executor *BaseExecutor) *ProcessKillExecutor {
+	return &ProcessKillExecutor{
+		BaseExecutor: executor,
+	}
+}
+
+// Exec
+// COM_PROCESS_KILL 命令的 payload 格式在
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_process_kill.html
+func (e *ProcessKillExecutor) Exec(
+	ctx context.Context,
+	conn *connection.Conn,
+	payload []byte) error {
+	if len(payload) < 5 {
+		return conn.WritePacket(builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.ER_UNKNOWN_COM_ERROR).Build())
+	}
+	// int<4>	connection_id
+	_, err := e.killConn(conn, binary.LittleEndian.Uint32(payload[1:5]), false, 0)
+	return err
+}
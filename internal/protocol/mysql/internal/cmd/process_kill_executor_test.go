@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessKillExecutor_Exec(t *testing.T) {
+	type killArgs struct {
+		connID uint32
+		query  bool
+	}
+	kill := func(killed *killArgs) KillFunc {
+		return func(user string, connID uint32, query bool) error {
+			switch connID {
+			case 2:
+				*killed = killArgs{connID: connID, query: query}
+				return nil
+			case 3:
+				return ErrKillDenied
+			default:
+				return ErrUnknownConn
+			}
+		}
+	}
+	tests := []struct {
+		name     string
+		executor func(kill KillFunc) Executor
+		payload  []byte
+
+		wantHeader byte
+		wantKilled killArgs
+	}{
+		{
+			name: "COM_PROCESS_KILL",
+			executor: func(kill KillFunc) Executor {
+				return NewProcessKillExecutor(NewBaseExecutor(nil, kill, nil, false))
+			},
+			payload:    []byte{CmdProcessKill.Byte(), 0x02, 0x00, 0x00, 0x00},
+			wantHeader: 0x00,
+			wantKilled: killArgs{connID: 2},
+		},
+		{
+			name: "COM_PROCESS_KILL_连接不存在",
+			executor: func(kill KillFunc) Executor {
+				return NewProcessKillExecutor(NewBaseExecutor(nil, kill, nil, false))
+			},
+			payload:    []byte{CmdProcessKill.Byte(), 0x04, 0x00, 0x00, 0x00},
+			wantHeader: 0xff,
+		},
+		{
+			name: "COM_PROCESS_KILL_缺少连接ID",
+			executor: func(kill KillFunc) Executor {
+				return NewProcessKillExecutor(NewBaseExecutor(nil, kill, nil, false))
+			},
+			payload:    []byte{CmdProcessKill.Byte()},
+			wantHeader: 0xff,
+		},
+		{
+			name: "KILL QUERY语句",
+			executor: func(kill KillFunc) Executor {
+				return NewQueryExecutor(nil, NewBaseExecutor(nil, kill, nil, false))
+			},
+			payload:    append([]byte{CmdQuery.Byte()}, "KILL QUERY 2"...),
+			wantHeader: 0x00,
+			wantKilled: killArgs{connID: 2, query: true},
+		},
+		{
+			name: "KILL语句_其他用户的连接",
+			executor: func(kill KillFunc) Executor {
+				return NewQueryExecutor(nil, NewBaseExecutor(nil, kill, nil, false))
+			},
+			payload:    append([]byte{CmdQuery.Byte()}, "KILL 3"...),
+			wantHeader: 0xff,
+		},
+		{
+			name: "不支持KILL",
+			executor: func(kill KillFunc) Executor {
+				return NewQueryExecutor(nil, &BaseExecutor{})
+			},
+			payload:    append([]byte{CmdQuery.Byte()}, "KILL 2"...),
+			wantHeader: 0xff,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := connection.NewConn(1, server, nil)
+			defer conn.Close()
+
+			var killed killArgs
+			headers := make(chan []byte, 1)
+			go func() {
+				headers <- readPacketHeaders(client, 1)
+			}()
+			err := tt.executor(kill(&killed)).Exec(context.Background(), conn, tt.payload)
+			require.NoError(t, err)
+			assert.Equal(t, []byte{tt.wantHeader}, <-headers)
+			assert.Equal(t, tt.wantKilled, killed)
+		})
+	}
+}
@@ -2,9 +2,18 @@ package cmd
 
 import (
 	"context"
+	"database/sql"
+	"strconv"
 
+	"github.com/ecodeclub/ekit/sqlx"
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/parser"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/visitor/vparser"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
 )
 
@@ -25,16 +34,80 @@ func NewQueryExecutor(hdl plugin.Handler, executor *BaseExecutor) *QueryExecutor
 // Exec
 // Query 命令的 payload 格式在
 // https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_query.html
+// 客户端开启了 CLIENT_MULTI_STATEMENTS 的时候，一个 COM_QUERY 里面可以有多个语句，
+// 每个语句依次执行并返回各自的结果，除了最后一个结果，其余结果都要带上 SERVER_MORE_RESULTS_EXISTS。
+// 和 MySQL 一样，某个语句执行出错之后就不再执行后面的语句
 func (e *QueryExecutor) Exec(
 	ctx context.Context,
 	conn *connection.Conn,
 	payload []byte) error {
-	que := e.parseQuery(payload)
-	pctx := &pcontext.Context{
-		Context:     ctx,
-		Query:       que,
-		ParsedQuery: pcontext.NewParsedQuery(que),
-		ConnID:      conn.ID(),
+	// 协商了 CLIENT_QUERY_ATTRIBUTES 的时候，查询语句前面是查询属性
+	p := parser.NewQueryPacket(conn.ClientCapabilityFlags())
+	if err := p.Parse(payload); err != nil {
+		return e.writeErrRespPacket(conn, err)
+	}
+	que := p.Query()
+	queries := []string{que}
+	if conn.MultiStatements() {
+		queries = ast.SplitStatements(que)
+	}
+	for i, q := range queries {
+		var status flags.SeverStatus
+		if i < len(queries)-1 {
+			status = flags.ServerMoreResultsExists
+		}
+		completed, err := e.exec(ctx, conn, q, status)
+		if err != nil || !completed {
+			return err
+		}
+	}
+	return nil
+}
+
+// exec 执行单个语句并且写回响应，返回的 bool 表示语句是否执行成功
+func (e *QueryExecutor) exec(ctx context.Context, conn *connection.Conn, que string, status flags.SeverStatus) (bool, error) {
+	parsedQuery := pcontext.NewParsedQuery(que)
+	ctx, cancel := e.withTimeout(ctx, parsedQuery.Timeout())
+	// 结果集在返回之前已经全部写回给客户端了
+	defer cancel()
+	pctx := NewPluginContext(ctx, conn)
+	pctx.Query = que
+	pctx.ParsedQuery = parsedQuery
+	// 开启事务的时候插件需要在事务的连接上设置这些变量
+	pctx.SystemVariables = conn.BackendSystemVariables()
+
+	switch pctx.ParsedQuery.Type() {
+	case vparser.UseStmt:
+		// 逻辑库由 dbproxy 自己维护，不需要交给插件处理
+		return e.handleUseStmt(conn, pctx, status)
+	case vparser.KillStmt:
+		// 客户端连接的是 dbproxy，连接 ID 也是 dbproxy 的
+		return e.handleKillStmt(conn, pctx, status)
+	case vparser.SetStmt:
+		return e.handleSetStmt(conn, pctx, status)
+	case vparser.PrepareStmt, vparser.ExecutePrepareStmt, vparser.DeallocatePrepareStmt:
+		// SQL 层面的预处理语句保存在后端的连接上，而后端的连接是多个客户端共用的，
+		// 客户端应该使用 COM_STMT_PREPARE 这种二进制协议的预处理语句
+		return false, e.writeErrRespPacket(conn, errs.NewUnsupportedSQLError("dbproxy 不支持 SQL 层面的预处理语句 "+que))
+	case vparser.StartTransactionStmt:
+		pctx.TxOptions = e.startTransactionOptions(conn, pctx)
+	case vparser.SelectStmt:
+		// 驱动建立连接之后读取的系统变量，不需要交给插件处理
+		if cols, row, ok := e.localSystemVariables(conn, pctx); ok {
+			return true, e.writeRespPackets(conn, builder.NewTextResultsetPacket(conn.ClientCapabilityFlags(),
+				cols, [][]any{row}, e.getServerStatus(conn)|status, conn.CharacterSet()).Build())
+		}
+	}
+
+	switch pctx.ParsedQuery.Type() {
+	case vparser.SelectStmt, vparser.InsertStmt, vparser.UpdateStmt, vparser.DeleteStmt:
+		if err := e.beginImplicitTx(ctx, e.hdl, conn); err != nil {
+			return false, e.writeErrRespPacket(conn, err)
+		}
+	}
+
+	if e.needSystemVariablesTx(conn, pctx, pctx.ParsedQuery.Type) {
+		return e.execInSystemVariablesTx(ctx, e.hdl, conn, pctx, e.handleRowsFunc(pctx), status)
 	}
 
 	// 在这里执行 que，并且写回响应
@@ -42,7 +115,158 @@ func (e *QueryExecutor) Exec(
 	if err != nil {
 		// 回写错误响应
 		// 先返回系统错误
-		return e.writeErrRespPacket(conn, err)
+		return false, e.writeErrRespPacket(conn, err)
+	}
+	return e.handlePluginResultWithStatus(result, conn, e.handleRowsFunc(pctx), status)
+}
+
+func (e *QueryExecutor) handleRowsFunc(ctx *pcontext.Context) handleSQLRowsFunc {
+	if ctx.ParsedQuery.Type() == vparser.CallStmt {
+		return e.handleCallSQLRows
+	}
+	return e.handleQuerySQLRows
+}
+
+// handleCallSQLRows 和 MySQL 一样，存储过程返回的结果集之后还有一个表示 CALL 语句本身执行结果的 OK_Packet
+// 存储过程没有返回结果集的时候只有这个 OK_Packet
+func (e *QueryExecutor) handleCallSQLRows(rows sqlx.Rows, conn *connection.Conn, status flags.SeverStatus) (bool, error) {
+	cols, err := rows.Columns()
+	if err != nil || len(cols) == 0 {
+		return e.handleQuerySQLRows(rows, conn, status)
+	}
+	completed, err := e.handleQuerySQLRows(rows, conn, status|flags.ServerMoreResultsExists)
+	if err != nil || !completed {
+		return completed, err
+	}
+	return true, e.writeOKRespPacket(conn, status, 0, 0)
+}
+
+// startTransactionOptions 开启事务时使用的事务特性，START TRANSACTION READ ONLY 这种写法优先
+func (e *QueryExecutor) startTransactionOptions(conn *connection.Conn, ctx *pcontext.Context) *sql.TxOptions {
+	opts := conn.TakeTxOptions()
+	res := vparser.NewStartTransactionVisitor().Parse(ctx.ParsedQuery.Root()).(vparser.BaseVal)
+	if res.Err != nil {
+		return opts
+	}
+	val := res.Data.(vparser.StartTransactionVal)
+	if val.ReadOnly || val.ReadWrite {
+		if opts == nil {
+			opts = &sql.TxOptions{}
+		}
+		opts.ReadOnly = val.ReadOnly
+	}
+	return opts
+}
+
+func (e *QueryExecutor) handleUseStmt(conn *connection.Conn, ctx *pcontext.Context, status flags.SeverStatus) (bool, error) {
+	res := vparser.NewUseVisitor().Parse(ctx.ParsedQuery.Root()).(vparser.BaseVal)
+	if res.Err != nil {
+		return false, e.writeErrRespPacket(conn, res.Err)
+	}
+	return e.useSchema(conn, res.Data.(string), status)
+}
+
+func (e *QueryExecutor) handleKillStmt(conn *connection.Conn, ctx *pcontext.Context, status flags.SeverStatus) (bool, error) {
+	res := vparser.NewKillVisitor().Parse(ctx.ParsedQuery.Root()).(vparser.BaseVal)
+	if res.Err != nil {
+		return false, e.writeErrRespPacket(conn, res.Err)
+	}
+	val := res.Data.(vparser.KillVal)
+	return e.killConn(conn, val.ConnID, val.Query, status)
+}
+
+// handleSetStmt 修改连接上的系统变量
+// 后端的连接是多个客户端共用的，所以只在连接上记录，开启事务的时候由插件设置到事务的连接上。
+// autocommit 的时候，之后的语句会在只包含它自己的事务中执行，参考 needSystemVariablesTx。
+// 已经处于事务中的时候，需要交给插件立刻设置到事务的连接上。
+// 和 MySQL 一样，autocommit 从关闭变成开启的时候会提交正在进行的事务
+func (e *QueryExecutor) handleSetStmt(conn *connection.Conn, ctx *pcontext.Context, status flags.SeverStatus) (bool, error) {
+	res := vparser.NewSetVisitor().Parse(ctx.ParsedQuery.Root()).(vparser.BaseVal)
+	if res.Err != nil {
+		return false, e.writeErrRespPacket(conn, errs.NewUnsupportedSQLError(res.Err.Error()))
+	}
+	vals := res.Data.([]vparser.SetVal)
+	vars := make([]connection.SystemVariable, 0, len(vals))
+	nextTx := false
+	for _, v := range vals {
+		switch v.Scope {
+		case vparser.ScopeGlobal:
+			return false, e.writeErrRespPacket(conn, errs.NewUnsupportedSQLError("dbproxy 不支持修改全局的系统变量 "+v.Name))
+		case vparser.ScopeUser:
+			return false, e.writeErrRespPacket(conn, errs.NewUnsupportedSQLError("dbproxy 不支持用户变量 @"+v.Name))
+		case vparser.ScopeNextTransaction:
+			nextTx = true
+		}
+		vars = append(vars, connection.SystemVariable{
+			Name:    v.Name,
+			Value:   sql.NullString{String: v.Value, Valid: !v.Null},
+			Default: v.Default,
+		})
+	}
+	if nextTx {
+		return e.setNextTransaction(conn, vars, status)
+	}
+	autoCommit := conn.AutoCommit()
+	var apply func(backend map[string]string) error
+	if conn.InTransaction() {
+		apply = func(backend map[string]string) error {
+			ctx.SystemVariables = backend
+			_, err := e.hdl.Handle(ctx)
+			return err
+		}
+	}
+	tracked, err := conn.SetSystemVariables(vars, apply)
+	if err != nil {
+		return false, e.writeErrRespPacket(conn, err)
+	}
+	if !autoCommit && conn.AutoCommit() && conn.InTransaction() {
+		if err = e.execTxStmt(ctx, e.hdl, conn, "COMMIT", nil); err != nil {
+			return false, e.writeErrRespPacket(conn, err)
+		}
+	}
+	b := builder.NewOKPacket(conn.ClientCapabilityFlags(), e.getServerStatus(conn)|status)
+	for _, v := range tracked {
+		b.SessionStateInfo = append(b.SessionStateInfo, builder.NewSystemVariableState(v.Name, v.Value.String))
+	}
+	return true, conn.WritePacket(b.Build())
+}
+
+// setNextTransaction 执行没有指定作用域的 SET TRANSACTION，和 MySQL 一样事务中不能执行
+func (e *QueryExecutor) setNextTransaction(conn *connection.Conn, vars []connection.SystemVariable, status flags.SeverStatus) (bool, error) {
+	if conn.InTransaction() {
+		return false, conn.WritePacket(builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.ER_CANT_CHANGE_TX_CHARACTERISTICS).Build())
+	}
+	if err := conn.SetNextTransaction(vars); err != nil {
+		return false, e.writeErrRespPacket(conn, err)
+	}
+	return true, e.writeOKRespPacket(conn, e.getServerStatus(conn)|status, 0, 0)
+}
+
+// localSystemVariables SELECT @@max_allowed_packet 这种只读取系统变量的语句，在 dbproxy 认识全部变量的时候直接返回结果
+// 全局变量和 dbproxy 不认识的变量还是交给插件处理
+func (e *QueryExecutor) localSystemVariables(conn *connection.Conn, ctx *pcontext.Context) ([]builder.ColumnType, []any, bool) {
+	res := vparser.NewSelectVariableVisitor().Parse(ctx.ParsedQuery.Root()).(vparser.BaseVal)
+	if res.Err != nil {
+		return nil, nil, false
+	}
+	vals := res.Data.([]vparser.SelectVariableVal)
+	cols := make([]builder.ColumnType, 0, len(vals))
+	row := make([]any, 0, len(vals))
+	for _, v := range vals {
+		value, ok := conn.SystemVariable(v.Name)
+		if !ok || v.Scope != vparser.ScopeSession {
+			return nil, nil, false
+		}
+		typ := "VARCHAR"
+		if _, err := strconv.ParseUint(value.String, 10, 64); err == nil {
+			typ = "BIGINT"
+		}
+		cols = append(cols, builder.NewColumn(v.Column, typ))
+		var data []byte
+		if value.Valid {
+			data = []byte(value.String)
+		}
+		row = append(row, &data)
 	}
-	return e.handlePluginResult(result, conn, e.handleQuerySQLRows)
+	return cols, row, true
 }
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryExecutor_Exec(t *testing.T) {
+	tests := []struct {
+		name            string
+		multiStatements bool
+		query           string
+
+		wantQueries []string
+		// 客户端收到的每个报文的第一个字节
+		wantHeaders []byte
+	}{
+		{
+			name:        "单个语句",
+			query:       "UPDATE users SET name = 'Tom'",
+			wantQueries: []string{"UPDATE users SET name = 'Tom'"},
+			wantHeaders: []byte{0x00},
+		},
+		{
+			name:        "没有开启多语句",
+			query:       "UPDATE users SET name = 'Tom'; DELETE FROM users",
+			wantQueries: []string{"UPDATE users SET name = 'Tom'; DELETE FROM users"},
+			wantHeaders: []byte{0x00},
+		},
+		{
+			name:            "多个语句",
+			multiStatements: true,
+			query:           "UPDATE users SET name = 'Tom'; DELETE FROM users;",
+			wantQueries:     []string{"UPDATE users SET name = 'Tom'", "DELETE FROM users"},
+			wantHeaders:     []byte{0x00, 0x00},
+		},
+		{
+			name:            "出错之后不再执行后面的语句",
+			multiStatements: true,
+			query:           "UPDATE users SET name = 'Tom'; SELECT * FROM invalid; DELETE FROM users",
+			wantQueries:     []string{"UPDATE users SET name = 'Tom'", "SELECT * FROM invalid"},
+			wantHeaders:     []byte{0x00, 0xff},
+		},
+		{
+			name:        "语句超时",
+			query:       "UPDATE /* @proxy timeout=10ms */ users SET name = 'Tom'",
+			wantQueries: []string{"UPDATE /* @proxy timeout=10ms */ users SET name = 'Tom'"},
+			wantHeaders: []byte{0xff},
+		},
+		{
+			name:        "SQL 层面的预处理语句",
+			query:       "PREPARE stmt1 FROM 'SELECT 1'",
+			wantHeaders: []byte{0xff},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queries []string
+			hdl := plugin.HandleFunc(func(ctx *pcontext.Context) (*plugin.Result, error) {
+				queries = append(queries, ctx.Query)
+				if ctx.Query == "SELECT * FROM invalid" {
+					return nil, errors.New("mock error")
+				}
+				if _, ok := ctx.Deadline(); ok {
+					// 模拟一个执行很久的查询
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}
+				return &plugin.Result{}, nil
+			})
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := connection.NewConn(1, server, nil)
+			defer conn.Close()
+			conn.SetMultiStatements(tt.multiStatements)
+
+			headers := make(chan []byte, 1)
+			go func() {
+				headers <- readPacketHeaders(client, len(tt.wantHeaders))
+			}()
+			err := NewQueryExecutor(hdl, &BaseExecutor{}).Exec(context.Background(), conn, append([]byte{CmdQuery.Byte()}, tt.query...))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantQueries, queries)
+
+			assert.Equal(t, tt.wantHeaders, <-headers)
+		})
+	}
+}
+
+func TestQueryExecutor_CallStmt(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []*sqlmock.Rows
+		// 客户端收到的每个报文的第一个字节
+		wantHeaders []byte
+	}{
+		{
+			name: "多个结果集",
+			rows: []*sqlmock.Rows{
+				sqlmock.NewRows([]string{"id"}).AddRow("1"),
+				sqlmock.NewRows([]string{"total"}).AddRow("2"),
+			},
+			wantHeaders: []byte{
+				0x01, 0x03, 0xfe, 0x01, 0xfe, // 第一个结果集
+				0x01, 0x03, 0xfe, 0x01, 0xfe, // 第二个结果集
+				0x00, // CALL 语句本身的执行结果
+			},
+		},
+		{
+			name:        "没有结果集",
+			rows:        []*sqlmock.Rows{sqlmock.NewRows([]string{})},
+			wantHeaders: []byte{0x00},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+			mock.ExpectQuery("CALL .*").WillReturnRows(tt.rows...)
+			hdl := plugin.HandleFunc(func(ctx *pcontext.Context) (*plugin.Result, error) {
+				rows, err := db.QueryContext(ctx, ctx.Query)
+				return &plugin.Result{Rows: rows}, err
+			})
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := connection.NewConn(1, server, nil)
+			defer conn.Close()
+
+			headers := make(chan []byte, 1)
+			go func() {
+				headers <- readPacketHeaders(client, len(tt.wantHeaders))
+			}()
+			err = NewQueryExecutor(hdl, &BaseExecutor{}).Exec(context.Background(), conn, append([]byte{CmdQuery.Byte()}, "CALL get_users()"...))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantHeaders, <-headers)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+)
+
+// COM_SET_OPTION 支持的选项
+// https://dev.mysql.com/doc/dev/mysql-server/latest/mysql__com_8h.html#a64d7b0ae6ea8d2c1c9b2d6dab4ebb2ba
+const (
+	mysqlOptionMultiStatementsOn  uint16 = 0
+	mysqlOptionMultiStatementsOff uint16 = 1
+)
+
+var _ Executor = &SetOptionExecutor{}
+
+// SetOptionExecutor 负责处理 COM_SET_OPTION 命令，目前只有开启和关闭多语句这一个选项
+type SetOptionExecutor struct {
+	*BaseExecutor
+}
+
+func NewSetOptionExecutor(executor *BaseExecutor) *SetOptionExecutor {
+	return &SetOptionExecutor{
+		BaseExecutor: executor,
+	}
+}
+
+// Exec
+// COM_SET_OPTION 命令的 payload 格式在
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_set_option.html
+func (e *SetOptionExecutor) Exec(
+	ctx context.Context,
+	conn *connection.Conn,
+	payload []byte) error {
+	if len(payload) < 3 {
+		return conn.WritePacket(builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.ER_UNKNOWN_COM_ERROR).Build())
+	}
+	// int<2>	option_operation
+	switch binary.LittleEndian.Uint16(payload[1:3]) {
+	case mysqlOptionMultiStatementsOn:
+		conn.SetMultiStatements(true)
+	case mysqlOptionMultiStatementsOff:
+		conn.SetMultiStatements(false)
+	default:
+		return conn.WritePacket(builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.ER_UNKNOWN_COM_ERROR).Build())
+	}
+	// 成功的时候返回的是 EOF_Packet
+	return conn.WritePacket(builder.NewEOFPacket(conn.ClientCapabilityFlags(), e.getServerStatus(conn)).Build())
+}
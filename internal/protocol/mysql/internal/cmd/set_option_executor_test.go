@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetOptionExecutor_Exec(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  bool
+		payload []byte
+
+		wantHeader          byte
+		wantMultiStatements bool
+	}{
+		{
+			name:                "开启多语句",
+			payload:             []byte{CmdSetOption.Byte(), 0x00, 0x00},
+			wantHeader:          0xfe,
+			wantMultiStatements: true,
+		},
+		{
+			name:                "关闭多语句",
+			before:              true,
+			payload:             []byte{CmdSetOption.Byte(), 0x01, 0x00},
+			wantHeader:          0xfe,
+			wantMultiStatements: false,
+		},
+		{
+			name:                "未知选项",
+			before:              true,
+			payload:             []byte{CmdSetOption.Byte(), 0x02, 0x00},
+			wantHeader:          0xff,
+			wantMultiStatements: true,
+		},
+		{
+			name:       "缺少选项",
+			payload:    []byte{CmdSetOption.Byte()},
+			wantHeader: 0xff,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := connection.NewConn(1, server, nil)
+			defer conn.Close()
+			conn.SetMultiStatements(tt.before)
+
+			headers := make(chan []byte, 1)
+			go func() {
+				headers <- readPacketHeaders(client, 1)
+			}()
+			err := NewSetOptionExecutor(&BaseExecutor{}).Exec(context.Background(), conn, tt.payload)
+			require.NoError(t, err)
+			assert.Equal(t, []byte{tt.wantHeader}, <-headers)
+			assert.Equal(t, tt.wantMultiStatements, conn.MultiStatements())
+		})
+	}
+}
@@ -27,15 +27,18 @@ func (e *StmtCloseExecutor) Exec(
 	conn *connection.Conn,
 	payload []byte) error {
 
-	stmtId := e.parseStmtID(payload)
-	deallocatePrepareStmtSQL := e.generateDeallocatePrepareStmtSQL(stmtId)
-	pctx := &pcontext.Context{
-		Context:     ctx,
-		Query:       deallocatePrepareStmtSQL,
-		ParsedQuery: pcontext.NewParsedQuery(deallocatePrepareStmtSQL),
-		ConnID:      conn.ID(),
-		StmtID:      stmtId,
+	stmtId, err := e.parseStmtID(payload)
+	if err != nil {
+		// 没有响应，也就没办法告诉客户端出错了
+		return e.checkMalformedPacket(conn, err)
 	}
+	// COM_STMT_CLOSE 没有响应，关闭游标出错也只能忽略
+	_ = e.releaseStmt(conn.ID(), stmtId)
+	deallocatePrepareStmtSQL := e.generateDeallocatePrepareStmtSQL(stmtId)
+	pctx := NewPluginContext(ctx, conn)
+	pctx.Query = deallocatePrepareStmtSQL
+	pctx.ParsedQuery = pcontext.NewParsedQuery(deallocatePrepareStmtSQL)
+	pctx.StmtID = stmtId
 
 	// 在这里执行 que，并且写回响应
 	result, err := e.hdl.Handle(pctx)
@@ -6,8 +6,10 @@ import (
 	"log"
 
 	"github.com/ecodeclub/ekit/slice"
+	"github.com/ecodeclub/ekit/sqlx"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/parser"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
@@ -32,19 +34,52 @@ func (e *StmtExecuteExecutor) Exec(
 	conn *connection.Conn,
 	payload []byte) error {
 
-	stmtId := e.parseStmtID(payload)
-	args, err := e.parseArgs(conn.ClientCapabilityFlags(), stmtId, payload)
+	stmtId, err := e.parseStmtID(payload)
 	if err != nil {
 		return e.writeErrRespPacket(conn, err)
 	}
+	args, cursorType, err := e.parseArgs(conn.ClientCapabilityFlags(), stmtId, payload)
+	if err != nil {
+		return e.writeErrRespPacket(conn, err)
+	}
+	// 重新执行的时候，之前打开的游标就没用了
+	if err = e.closeCursor(stmtId); err != nil {
+		return e.writeErrRespPacket(conn, err)
+	}
 	executeStmtSQL := e.generateExecuteStmtSQL(stmtId)
 
-	pctx := &pcontext.Context{
-		Context:     ctx,
-		ParsedQuery: pcontext.NewParsedQuery(executeStmtSQL),
-		Args:        args,
-		ConnID:      conn.ID(),
-		StmtID:      stmtId,
+	ctx, cancel := e.withTimeout(ctx, e.loadTimeout(stmtId))
+	defer cancel()
+	// 游标中的数据在后续的 COM_STMT_FETCH 中读取，结果集不能在命令结束的时候跟着 ctx 一起关闭
+	var cctx *cursorContext
+	if cursorType.Has(packet.CursorTypeReadOnly) {
+		cctx = newCursorContext(ctx)
+		defer cctx.detach()
+		ctx = cctx
+	}
+	cursorOpened := false
+	defer func() {
+		if cctx != nil && !cursorOpened {
+			cctx.cancel()
+		}
+	}()
+	pctx := NewPluginContext(ctx, conn)
+	pctx.ParsedQuery = pcontext.NewParsedQuery(executeStmtSQL)
+	pctx.Args = args
+	pctx.StmtID = stmtId
+	// 开启事务的时候插件需要在事务的连接上设置这些变量
+	pctx.SystemVariables = conn.BackendSystemVariables()
+
+	if err = e.beginImplicitTx(ctx, e.hdl, conn); err != nil {
+		return e.writeErrRespPacket(conn, err)
+	}
+
+	// 游标中的数据在命令结束之后才会读取，不能放在只包含这个语句的事务中执行
+	if !cursorType.Has(packet.CursorTypeReadOnly) && e.needSystemVariablesTx(conn, pctx, func() string {
+		return e.loadSQLType(stmtId)
+	}) {
+		_, err = e.execInSystemVariablesTx(ctx, e.hdl, conn, pctx, e.handlePrepareSQLRows, 0)
+		return err
 	}
 
 	// 在这里执行 que，并且写回响应
@@ -55,24 +90,36 @@ func (e *StmtExecuteExecutor) Exec(
 		return e.writeErrRespPacket(conn, err)
 	}
 
+	if cursorType.Has(packet.CursorTypeReadOnly) {
+		// 客户端要求使用游标，数据通过 COM_STMT_FETCH 获取
+		return e.handlePluginResult(result, conn, func(rows sqlx.Rows, conn *connection.Conn, status flags.SeverStatus) (bool, error) {
+			cursorOpened, err = e.openCursor(stmtId, rows, cctx.cancel, conn, status)
+			return cursorOpened, err
+		})
+	}
 	return e.handlePluginResult(result, conn, e.handlePrepareSQLRows)
 }
 
-func (e *StmtExecuteExecutor) parseArgs(clientCapabilityFlags flags.CapabilityFlags, stmtID uint32, payload []byte) ([]any, error) {
+func (e *StmtExecuteExecutor) parseArgs(clientCapabilityFlags flags.CapabilityFlags, stmtID uint32, payload []byte) ([]any, packet.CursorType, error) {
 	numParams, ok := e.loadNumParams(stmtID)
 
 	log.Printf("loadNumParams stmtID = %d, numParams = %d", stmtID, numParams)
 	if !ok {
-		return nil, fmt.Errorf("failed to load num params")
+		return nil, packet.CursorTypeNoCursor, fmt.Errorf("failed to load num params")
 	}
 
+	longData, err := e.takeLongData(stmtID)
+	if err != nil {
+		return nil, packet.CursorTypeNoCursor, err
+	}
 	p := parser.NewStmtExecutePacket(clientCapabilityFlags, numParams)
+	p.SetLongData(longData)
 	if err := p.Parse(payload); err != nil {
-		return nil, err
+		return nil, packet.CursorTypeNoCursor, err
 	}
 
 	return slice.Map(p.Parameters(), func(idx int, src parser.StmtExecuteParameter) any {
 		log.Printf("get execute params[%d] = %#v\n", idx, src)
 		return src.Value
-	}), nil
+	}), p.CursorType(), nil
 }
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/parser"
+)
+
+var _ Executor = &StmtFetchExecutor{}
+
+// StmtFetchExecutor 负责处理 COM_STMT_FETCH 命令，从 COM_STMT_EXECUTE 打开的游标中读取数据
+type StmtFetchExecutor struct {
+	*BaseStmtExecutor
+}
+
+func NewStmtFetchExecutor(executor *BaseStmtExecutor) *StmtFetchExecutor {
+	return &StmtFetchExecutor{
+		BaseStmtExecutor: executor,
+	}
+}
+
+// Exec
+// COM_STMT_FETCH 命令的 payload 格式在
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_stmt_fetch.html
+// 响应是最多 num_rows 行数据加上一个 EOF_Packet，数据读完之后 EOF_Packet 会带上 SERVER_STATUS_LAST_ROW_SENT
+func (e *StmtFetchExecutor) Exec(
+	ctx context.Context,
+	conn *connection.Conn,
+	payload []byte) error {
+	p := parser.NewStmtFetchPacket()
+	if err := p.Parse(payload); err != nil {
+		return e.writeErrRespPacket(conn, err)
+	}
+	c, ok := e.loadCursor(conn.ID(), p.StatementID())
+	if !ok {
+		b := builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.NewErrStmtHasNoOpenCursor(p.StatementID()))
+		return conn.WritePacket(b.Build())
+	}
+	status := e.getServerStatus(conn) | flags.ServerStatusCursorExists
+	closed, err := c.fetch(conn, p.NumRows(), status)
+	if closed {
+		_ = e.closeCursor(p.StatementID())
+	}
+	return err
+}
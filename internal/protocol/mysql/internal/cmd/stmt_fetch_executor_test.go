@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ecodeclub/ekit/sqlx"
+	"github.com/meoying/dbproxy/internal/merger"
+	"github.com/meoying/dbproxy/internal/merger/factory"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
+	"github.com/meoying/dbproxy/internal/rows"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStmtFetchExecutor_Exec(t *testing.T) {
+	tests := []struct {
+		name string
+		// rows 插件返回的结果集，一共有三行数据
+		rows func(t *testing.T) sqlx.Rows
+	}{
+		{
+			name: "单个结果集",
+			rows: func(t *testing.T) sqlx.Rows {
+				return mockRows(t, [][]string{{"1", "Tom"}, {"2", "Jerry"}, {"3", "Spike"}})
+			},
+		},
+		{
+			name: "分库分表合并之后的结果集",
+			rows: func(t *testing.T) sqlx.Rows {
+				m, err := factory.New(
+					factory.QuerySpec{Select: []merger.ColumnInfo{{Index: 0, Name: "id"}, {Index: 1, Name: "name"}}},
+					factory.QuerySpec{Select: []merger.ColumnInfo{{Index: 0, Name: "id"}, {Index: 1, Name: "name"}}})
+				require.NoError(t, err)
+				res, err := m.Merge(context.Background(), []rows.Rows{
+					mockRows(t, [][]string{{"1", "Tom"}, {"2", "Jerry"}}),
+					mockRows(t, [][]string{{"3", "Spike"}}),
+				})
+				require.NoError(t, err)
+				return res
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := NewBaseStmtExecutor(&BaseExecutor{})
+			stmtID := base.generateStmtID(1)
+			base.storeNumParams(stmtID, "SELECT id, name FROM users")
+			hdl := plugin.HandleFunc(func(ctx *pcontext.Context) (*plugin.Result, error) {
+				return &plugin.Result{Rows: tt.rows(t)}, nil
+			})
+
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := connection.NewConn(1, server, nil)
+			defer conn.Close()
+
+			// 打开游标的时候只返回字段定义
+			headers := make(chan []byte, 1)
+			go func() {
+				headers <- readPacketHeaders(client, 4)
+			}()
+			err := NewStmtExecuteExecutor(hdl, base).Exec(context.Background(), conn, []byte{
+				CmdStmtExecute.Byte(),
+				byte(stmtID), 0x00, 0x00, 0x00, // statement_id
+				0x01,                   // flags CURSOR_TYPE_READ_ONLY
+				0x01, 0x00, 0x00, 0x00, // iteration_count
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []byte{0x02, 0x03, 0x03, 0xfe}, <-headers)
+
+			fetch := []byte{
+				CmdStmtFetch.Byte(),
+				byte(stmtID), 0x00, 0x00, 0x00, // statement_id
+				0x02, 0x00, 0x00, 0x00, // num_rows
+			}
+			executor := NewStmtFetchExecutor(base)
+			wantHeaders := [][]byte{
+				// 两行数据
+				{0x00, 0x00, 0xfe},
+				// 只剩一行数据，读完之后关闭游标
+				{0x00, 0xfe},
+				// 游标已经关闭了
+				{0xff},
+			}
+			for _, want := range wantHeaders {
+				go func() {
+					headers <- readPacketHeaders(client, len(want))
+				}()
+				err = executor.Exec(context.Background(), conn, fetch)
+				require.NoError(t, err)
+				assert.Equal(t, want, <-headers)
+			}
+		})
+	}
+}
+
+func TestStmtFetchExecutor_ExecAfterCmdTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(
+		sqlmock.NewRowsWithColumnDefinition(
+			sqlmock.NewColumn("id").OfType("VARCHAR", ""),
+			sqlmock.NewColumn("name").OfType("VARCHAR", "")).
+			AddRow("1", "Tom").AddRow("2", "Jerry"))
+	base := NewBaseStmtExecutor(&BaseExecutor{})
+	stmtID := base.generateStmtID(1)
+	base.storeNumParams(stmtID, "SELECT id, name FROM users")
+	hdl := plugin.HandleFunc(func(ctx *pcontext.Context) (*plugin.Result, error) {
+		// 插件使用命令的 ctx 执行查询
+		rs, err := db.QueryContext(ctx, "SELECT id, name FROM users")
+		if err != nil {
+			return nil, err
+		}
+		return &plugin.Result{Rows: rs}, nil
+	})
+
+	server, client := net.Pipe()
+	defer client.Close()
+	// 游标被关闭的时候只会返回一个错误包，不能一直等下去
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(3*time.Second)))
+	conn := connection.NewConn(1, server, nil)
+	defer conn.Close()
+
+	headers := make(chan []byte, 1)
+	go func() {
+		headers <- readPacketHeaders(client, 4)
+	}()
+	// 模拟 cmdTimeout，命令结束之后 ctx 也会被取消
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	err = NewStmtExecuteExecutor(hdl, base).Exec(ctx, conn, []byte{
+		CmdStmtExecute.Byte(),
+		byte(stmtID), 0x00, 0x00, 0x00, // statement_id
+		0x01,                   // flags CURSOR_TYPE_READ_ONLY
+		0x01, 0x00, 0x00, 0x00, // iteration_count
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x02, 0x03, 0x03, 0xfe}, <-headers)
+	<-ctx.Done()
+	cancel()
+
+	// 超时之后游标中的数据还可以继续读取
+	go func() {
+		headers <- readPacketHeaders(client, 3)
+	}()
+	err = NewStmtFetchExecutor(base).Exec(context.Background(), conn, []byte{
+		CmdStmtFetch.Byte(),
+		byte(stmtID), 0x00, 0x00, 0x00, // statement_id
+		0x02, 0x00, 0x00, 0x00, // num_rows
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x00, 0xfe}, <-headers)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func mockRows(t *testing.T, data [][]string) *sql.Rows {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	// 二进制协议需要知道字段的类型
+	mockRows := sqlmock.NewRowsWithColumnDefinition(
+		sqlmock.NewColumn("id").OfType("VARCHAR", ""),
+		sqlmock.NewColumn("name").OfType("VARCHAR", ""))
+	for _, row := range data {
+		mockRows.AddRow(row[0], row[1])
+	}
+	mock.ExpectQuery("SELECT .*").WillReturnRows(mockRows)
+	res, err := db.Query("SELECT id, name FROM users")
+	require.NoError(t, err)
+	return res
+}
@@ -33,7 +33,7 @@ func (e *StmtPrepareExecutor) Exec(
 	payload []byte) error {
 
 	query := e.parseQuery(payload)
-	stmtID := e.generateStmtID()
+	stmtID := e.generateStmtID(conn.ID())
 	numParams := e.storeNumParams(stmtID, query)
 
 	prepareStmtSQL := e.generatePrepareStmtSQL(stmtID, query)
@@ -41,13 +41,11 @@ func (e *StmtPrepareExecutor) Exec(
 	log.Printf("Query = %s\n", query)
 	log.Printf("PrepareStmtSQL = %s\n", prepareStmtSQL)
 
-	pctx := &pcontext.Context{
-		Context:     ctx,
-		Query:       query,
-		ParsedQuery: pcontext.NewParsedQuery(prepareStmtSQL),
-		ConnID:      conn.ID(),
-		StmtID:      stmtID,
-	}
+	pctx := NewPluginContext(ctx, conn)
+	pctx.Query = query
+	pctx.ParsedQuery = pcontext.NewParsedQuery(prepareStmtSQL)
+	pctx.StmtID = stmtID
+	pctx.SystemVariables = conn.BackendSystemVariables()
 
 	// 在这里执行 que，并且写回响应
 	result, err := e.hdl.Handle(pctx)
@@ -58,6 +56,8 @@ func (e *StmtPrepareExecutor) Exec(
 	}
 
 	conn.SetInTransaction(result.InTransactionState)
+	e.storeTimeout(stmtID, query)
+	e.storeQuery(stmtID, query)
 
 	return e.writeRespPackets(conn, e.buildRespPackets(stmtID, numParams, conn))
 }
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+)
+
+var _ Executor = &StmtResetExecutor{}
+
+// StmtResetExecutor 负责处理 COM_STMT_RESET 命令
+// 清空 COM_STMT_SEND_LONG_DATA 缓存的参数，并且关闭打开的游标
+type StmtResetExecutor struct {
+	*BaseStmtExecutor
+}
+
+func NewStmtResetExecutor(executor *BaseStmtExecutor) *StmtResetExecutor {
+	return &StmtResetExecutor{
+		BaseStmtExecutor: executor,
+	}
+}
+
+// Exec
+// COM_STMT_RESET 命令的 payload 格式在
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_stmt_reset.html
+func (e *StmtResetExecutor) Exec(
+	ctx context.Context,
+	conn *connection.Conn,
+	payload []byte) error {
+	stmtId, err := e.parseStmtID(payload)
+	if err != nil {
+		return e.writeErrRespPacket(conn, err)
+	}
+	if _, ok := e.loadNumParams(stmtId); !ok {
+		b := builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.NewErrUnknownStmtHandler(stmtId, "mysqld_stmt_reset"))
+		return conn.WritePacket(b.Build())
+	}
+	e.resetLongData(stmtId)
+	if err := e.closeCursor(stmtId); err != nil {
+		return e.writeErrRespPacket(conn, err)
+	}
+	return e.writeOKRespPacket(conn, e.getServerStatus(conn), 0, 0)
+}
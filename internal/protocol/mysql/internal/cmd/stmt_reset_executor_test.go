@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStmtResetExecutor_Exec(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    []byte
+		strictMode bool
+
+		wantHeader byte
+		wantErr    error
+	}{
+		{
+			name:       "重置",
+			payload:    []byte{CmdStmtReset.Byte(), 0x01, 0x00, 0x00, 0x00},
+			wantHeader: 0x00,
+		},
+		{
+			name:       "预处理语句不存在",
+			payload:    []byte{CmdStmtReset.Byte(), 0x02, 0x00, 0x00, 0x00},
+			wantHeader: 0xff,
+		},
+		{
+			name:       "缺少statement_id",
+			payload:    []byte{CmdStmtReset.Byte()},
+			wantHeader: 0xff,
+		},
+		{
+			name:       "严格模式下缺少statement_id",
+			payload:    []byte{CmdStmtReset.Byte()},
+			strictMode: true,
+			wantHeader: 0xff,
+			// 返回错误之后连接会被关闭
+			wantErr: errs.ErrMalformedPacket,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := NewBaseStmtExecutor(&BaseExecutor{})
+			stmtID := base.generateStmtID(1)
+			base.storeNumParams(stmtID, "UPDATE files SET meta = ? WHERE id = ?")
+			base.appendLongData(stmtID, 0, []byte("{}"), connection.DefaultMaxAllowedPacket)
+
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := connection.NewConn(1, server, nil, connection.WithStrictMode(tt.strictMode))
+			defer conn.Close()
+
+			headers := make(chan []byte, 1)
+			go func() {
+				headers <- readPacketHeaders(client, 1)
+			}()
+			err := NewStmtResetExecutor(base).Exec(context.Background(), conn, tt.payload)
+			require.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, []byte{tt.wantHeader}, <-headers)
+			_, ok := base.stmtID2LongData.Load(stmtID)
+			assert.Equal(t, tt.wantHeader == 0xff, ok)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/parser"
+)
+
+var _ Executor = &StmtSendLongDataExecutor{}
+
+// StmtSendLongDataExecutor 负责处理 COM_STMT_SEND_LONG_DATA 命令
+// 数据会缓存在 dbproxy 中，执行预处理语句的时候再作为参数传给插件
+type StmtSendLongDataExecutor struct {
+	*BaseStmtExecutor
+}
+
+func NewStmtSendLongDataExecutor(executor *BaseStmtExecutor) *StmtSendLongDataExecutor {
+	return &StmtSendLongDataExecutor{
+		BaseStmtExecutor: executor,
+	}
+}
+
+// Exec
+// COM_STMT_SEND_LONG_DATA 命令的 payload 格式在
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_stmt_send_long_data.html
+func (e *StmtSendLongDataExecutor) Exec(
+	ctx context.Context,
+	conn *connection.Conn,
+	payload []byte) error {
+	p := parser.NewStmtSendLongDataPacket()
+	if err := p.Parse(payload); err != nil {
+		// 无需返回任何响应包给客户端，也就没办法告诉客户端出错了
+		e.getLogger().Warn("解析 COM_STMT_SEND_LONG_DATA 失败", "连接", conn.ID(), "错误", err)
+		return e.checkMalformedPacket(conn, err)
+	}
+	if _, ok := e.loadNumParams(p.StatementID()); !ok {
+		e.getLogger().Warn("COM_STMT_SEND_LONG_DATA 预处理语句不存在", "连接", conn.ID(), "预处理语句", p.StatementID())
+		return nil
+	}
+	e.appendLongData(p.StatementID(), p.ParamID(), p.Data(), conn.MaxAllowedPacket())
+	return nil
+}
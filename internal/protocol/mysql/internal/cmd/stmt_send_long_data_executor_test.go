@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStmtSendLongDataExecutor_Exec(t *testing.T) {
+	tests := []struct {
+		name string
+		// chunks 通过 COM_STMT_SEND_LONG_DATA 发送的第一个参数的数据
+		chunks [][]byte
+		// reset 执行之前是否发送 COM_STMT_RESET
+		reset bool
+		// maxAllowedPacket 为 0 的时候使用默认值
+		maxAllowedPacket int
+
+		wantHeader byte
+		wantArgs   []any
+	}{
+		{
+			name:     "分多次发送",
+			chunks:   [][]byte{[]byte(`{"name":`), []byte(`"Tom"}`)},
+			wantArgs: []any{[]byte(`{"name":"Tom"}`), int64(1002)},
+		},
+		{
+			name:     "发送之后重置",
+			chunks:   [][]byte{[]byte(`{"name":"Tom"}`)},
+			reset:    true,
+			wantArgs: []any{"{}", int64(1002)},
+		},
+		{
+			name:             "拼接之后超过max_allowed_packet",
+			chunks:           [][]byte{[]byte(`{"name":`), []byte(`"Tom"}`), []byte(`{}`)},
+			maxAllowedPacket: 12,
+			wantHeader:       0xff,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := NewBaseStmtExecutor(&BaseExecutor{})
+			stmtID := base.generateStmtID(1)
+			base.storeNumParams(stmtID, "UPDATE files SET meta = ? WHERE id = ?")
+			var args []any
+			hdl := plugin.HandleFunc(func(ctx *pcontext.Context) (*plugin.Result, error) {
+				args = ctx.Args
+				return &plugin.Result{}, nil
+			})
+
+			server, client := net.Pipe()
+			defer client.Close()
+			var opts []connection.ConnOption
+			if tt.maxAllowedPacket > 0 {
+				opts = append(opts, connection.WithMaxAllowedPacket(tt.maxAllowedPacket))
+			}
+			conn := connection.NewConn(1, server, nil, opts...)
+			defer conn.Close()
+
+			// COM_STMT_SEND_LONG_DATA 没有响应
+			for _, chunk := range tt.chunks {
+				payload := append([]byte{
+					CmdStmtSendLongData.Byte(),
+					byte(stmtID), 0x00, 0x00, 0x00, // statement_id
+					0x00, 0x00, // param_id
+				}, chunk...)
+				err := NewStmtSendLongDataExecutor(base).Exec(context.Background(), conn, payload)
+				require.NoError(t, err)
+			}
+
+			headers := make(chan []byte, 1)
+			if tt.reset {
+				go func() {
+					headers <- readPacketHeaders(client, 1)
+				}()
+				err := NewStmtResetExecutor(base).Exec(context.Background(), conn, []byte{
+					CmdStmtReset.Byte(), byte(stmtID), 0x00, 0x00, 0x00,
+				})
+				require.NoError(t, err)
+				assert.Equal(t, []byte{0x00}, <-headers)
+			}
+
+			payload := []byte{
+				CmdStmtExecute.Byte(),
+				byte(stmtID), 0x00, 0x00, 0x00, // statement_id
+				0x00,                   // flags
+				0x01, 0x00, 0x00, 0x00, // iteration_count
+				0x00,       // null_bitmap
+				0x01,       // new_params_bind_flag
+				0xfe, 0x00, // params[0].Type
+				0x08, 0x00, // params[1].Type
+			}
+			if tt.reset {
+				// 重置之后客户端需要自己发送参数的值
+				payload = append(payload, 0x02, '{', '}')
+			}
+			payload = append(payload, 0xea, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+			go func() {
+				headers <- readPacketHeaders(client, 1)
+			}()
+			err := NewStmtExecuteExecutor(hdl, base).Exec(context.Background(), conn, payload)
+			require.NoError(t, err)
+			assert.Equal(t, []byte{tt.wantHeader}, <-headers)
+			assert.Equal(t, tt.wantArgs, args)
+
+			// 执行之后缓存的参数就清空了
+			_, ok := base.stmtID2LongData.Load(stmtID)
+			assert.False(t, ok)
+		})
+	}
+}
@@ -2,23 +2,38 @@ package connection
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"database/sql"
 	"fmt"
 	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/meoying/dbproxy/internal/protocol/mysql/auth"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
-	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
 )
 
 // OnCmd 返回是否处理成功
 type OnCmd func(ctx context.Context, conn *Conn, payload []byte) error
 
+// OnAuth 在鉴权成功之后、返回 OK 响应之前调用，返回 error 的时候客户端会收到对应的错误并且连接会被关闭
+type OnAuth func(conn *Conn) error
+
 // Conn 代表了 MySQL 的一个连接
 // 要参考 mysql driver 的设计与实现
 // 但是我个人觉得它的写法并不是特别优雅
 type Conn struct {
 	conn net.Conn
-	// 默认是 maxPacketSize
+	// trustedProxies 可以发送 PROXY protocol 头部的对端地址，为空的时候不读取头部
+	trustedProxies []netip.Prefix
+	// clientAddr PROXY protocol 头部中客户端真实的地址，为 nil 的时候使用 conn 的地址
+	clientAddr net.Addr
+	// maxAllowedPacket 客户端发送的单个 payload 的最大长度，拆分成多个报文的 payload 按照拼接之后的长度计算
+	// 默认是 64MB，和 MySQL 一样
 	maxAllowedPacket int
 	// 写入超时时间
 	writeTimeout time.Duration
@@ -26,24 +41,213 @@ type Conn struct {
 	id           uint32
 
 	// onCmd 处理客户端过来的命令
-	onCmd      OnCmd
+	onCmd OnCmd
+	// connectTimeout 握手和鉴权的最长时间，和 MySQL 的 connect_timeout 一样，为 0 的时候不限制
+	connectTimeout time.Duration
+	// cmdTimeout 每个命令的执行超时时间，为 0 的时候不限制
 	cmdTimeout time.Duration
-	// inTransaction 当前处于事务中
-	inTransaction bool
+	// waitTimeout 两个命令之间最长的空闲时间，超过之后关闭连接，为 0 的时候不限制
+	waitTimeout time.Duration
+	// idleInTxTimeout 处于事务中的时候两个命令之间最长的空闲时间，为 0 的时候使用 waitTimeout
+	idleInTxTimeout time.Duration
+	// onAuth 为 nil 的时候鉴权成功就可以使用连接
+	onAuth OnAuth
+	// strictMode 为 true 的时候，客户端发送了格式非法的报文会在返回错误之后关闭连接
+	strictMode bool
+	// inTransaction 当前处于事务中，优雅退出的时候会在其它 goroutine 上读取
+	inTransaction atomic.Bool
 
+	// serverVersion 握手的时候告诉客户端的版本，@@version 也返回它
+	serverVersion string
+	// serverCapabilities 握手的时候声明的能力
+	serverCapabilities flags.CapabilityFlags
+	// clientFlags 协商之后的能力，也就是客户端和服务端能力的交集
 	clientFlags  flags.CapabilityFlags
 	characterSet uint32
+
+	// tlsConfig 不为 nil 的时候，握手阶段会告诉客户端支持 SSL
+	tlsConfig *tls.Config
+	// requireSecureTransport 为 true 的时候，拒绝没有使用 TLS 的客户端
+	requireSecureTransport bool
+	// secure 表示当前连接已经切换到了 TLS
+	secure bool
+
+	// userStore 为 nil 的时候不校验用户名和密码
+	userStore auth.Store
+	// sha2Cache 缓存 caching_sha2_password 完整鉴权的结果，为 nil 的时候每次都走完整鉴权
+	sha2Cache *auth.SHA2Cache
+	// rsaKey 非 TLS 连接上 caching_sha2_password 完整鉴权的时候用于解密密码
+	rsaKey *rsa.PrivateKey
+	// authData 握手阶段发送给客户端的 scramble
+	authData []byte
+	// user 通过鉴权的用户名
+	user string
+	// schema 当前使用的逻辑库
+	schema string
+	// connAttrs 客户端在握手的时候发送的连接属性
+	connAttrs map[string]string
+	// session 插件在这个连接上保存的数据
+	session *pcontext.Session
+	// multiStatements 是否允许在一个 COM_QUERY 中发送多个语句
+	// 握手的时候由 CLIENT_MULTI_STATEMENTS 决定，之后可以通过 COM_SET_OPTION 修改
+	multiStatements bool
+
+	// cancelMu 保护 cancelCmd，KILL 是在其它连接的 goroutine 上执行的
+	cancelMu sync.Mutex
+	// cancelCmd 取消正在执行的命令，没有命令在执行的时候为 nil
+	cancelCmd context.CancelFunc
+	// closing 为 true 的时候不再执行新的命令，由 CloseIfIdle 设置
+	closing bool
+	// pending 命令执行期间探测客户端是否断开连接的时候读到的数据
+	pending []byte
+	// compress 客户端要求使用压缩协议的时候不为 nil，鉴权成功之后才生效
+	compress *compressIO
+	// sysVars 客户端通过 SET 修改过的系统变量，key 是小写的变量名，只在处理命令的 goroutine 上访问
+	sysVars map[string]sql.NullString
+	// nextTxVars SET TRANSACTION 设置的只对下一个事务生效的特性，开启事务的时候清空
+	nextTxVars map[string]string
 }
 
-func NewConn(id uint32, rc net.Conn, onCmd OnCmd) *Conn {
-	return &Conn{
+// DefaultMaxAllowedPacket max_allowed_packet 的默认值，和 MySQL 一样
+const DefaultMaxAllowedPacket = 64 << 20
+
+// DefaultConnectTimeout connect_timeout 的默认值，和 MySQL 一样
+const DefaultConnectTimeout = 10 * time.Second
+
+type ConnOption func(conn *Conn)
+
+func NewConn(id uint32, rc net.Conn, onCmd OnCmd, opts ...ConnOption) *Conn {
+	conn := &Conn{
 		conn:             rc,
-		maxAllowedPacket: packet.MaxPacketSize,
+		maxAllowedPacket: DefaultMaxAllowedPacket,
+		connectTimeout:   DefaultConnectTimeout,
 		// 后续要考虑做成可配置的
-		writeTimeout: time.Second * 3,
-		onCmd:        onCmd,
-		id:           id,
-		cmdTimeout:   time.Second * 3,
+		writeTimeout:       time.Second * 3,
+		onCmd:              onCmd,
+		id:                 id,
+		serverVersion:      DefaultServerVersion,
+		serverCapabilities: flags.DefaultServerCapabilities,
+		session:            pcontext.NewSession(),
+	}
+	for _, opt := range opts {
+		opt(conn)
+	}
+	return conn
+}
+
+// WithTLSConfig 设置 TLS 配置，设置之后客户端可以通过 SSLRequest 切换到 TLS
+func WithTLSConfig(cfg *tls.Config) ConnOption {
+	return func(conn *Conn) {
+		conn.tlsConfig = cfg
+	}
+}
+
+// WithConnectTimeout 设置握手和鉴权的最长时间，超过之后客户端会收到 ER_HANDSHAKE_ERROR 并且连接会被关闭
+// 和 MySQL 的 connect_timeout 一样，避免不发送握手响应的客户端一直占用连接
+func WithConnectTimeout(timeout time.Duration) ConnOption {
+	return func(conn *Conn) {
+		conn.connectTimeout = timeout
+	}
+}
+
+// WithCmdTimeout 设置每个命令的执行超时时间，超时之后后端正在执行的查询会被取消
+// 打开了游标的时候只限制执行语句的时间，之后通过 COM_STMT_FETCH 读取数据不受影响
+func WithCmdTimeout(timeout time.Duration) ConnOption {
+	return func(conn *Conn) {
+		conn.cmdTimeout = timeout
+	}
+}
+
+// WithMaxAllowedPacket 设置客户端发送的 payload 的最大长度，超过之后客户端会收到错误并且连接会被关闭
+func WithMaxAllowedPacket(size int) ConnOption {
+	return func(conn *Conn) {
+		conn.maxAllowedPacket = size
+	}
+}
+
+// WithWaitTimeout 设置两个命令之间最长的空闲时间，超过之后客户端会收到 ER_CLIENT_INTERACTION_TIMEOUT 并且连接会被关闭
+// 和 MySQL 的 wait_timeout 一样
+func WithWaitTimeout(timeout time.Duration) ConnOption {
+	return func(conn *Conn) {
+		conn.waitTimeout = timeout
+	}
+}
+
+// WithIdleInTransactionTimeout 设置处于事务中的时候两个命令之间最长的空闲时间，超过之后连接会被关闭
+// 事务长时间不结束会一直占用后端的连接和锁，所以一般会比 wait_timeout 短
+func WithIdleInTransactionTimeout(timeout time.Duration) ConnOption {
+	return func(conn *Conn) {
+		conn.idleInTxTimeout = timeout
+	}
+}
+
+// WithOnAuth 设置鉴权成功之后的回调，例如用来限制每个用户的连接数
+func WithOnAuth(onAuth OnAuth) ConnOption {
+	return func(conn *Conn) {
+		conn.onAuth = onAuth
+	}
+}
+
+// WithTrustedProxies 设置可以发送 PROXY protocol 头部的对端地址，一般是四层负载均衡的地址
+// 需要在 Loop 之前调用 ReadProxyHeader
+func WithTrustedProxies(prefixes []netip.Prefix) ConnOption {
+	return func(conn *Conn) {
+		conn.trustedProxies = prefixes
+	}
+}
+
+// WithStrictMode 开启严格模式，客户端发送了格式非法的报文时，返回错误响应之后关闭连接
+// 默认只返回错误响应，连接可以继续使用
+func WithStrictMode(strict bool) ConnOption {
+	return func(conn *Conn) {
+		conn.strictMode = strict
+	}
+}
+
+// WithUserStore 设置用户存储，设置之后会校验客户端的用户名和密码
+func WithUserStore(store auth.Store) ConnOption {
+	return func(conn *Conn) {
+		conn.userStore = store
+	}
+}
+
+// WithSHA2Cache 设置 caching_sha2_password 的缓存，一般整个服务端共享一个
+func WithSHA2Cache(cache *auth.SHA2Cache) ConnOption {
+	return func(conn *Conn) {
+		conn.sha2Cache = cache
+	}
+}
+
+// WithRSAKey 设置 RSA 私钥，客户端在非 TLS 连接上可以通过公钥加密的方式发送密码
+func WithRSAKey(key *rsa.PrivateKey) ConnOption {
+	return func(conn *Conn) {
+		conn.rsaKey = key
+	}
+}
+
+// WithRequireSecureTransport 拒绝没有使用 TLS 的客户端
+// 只有在设置了 TLS 配置的时候才有意义
+func WithRequireSecureTransport(require bool) ConnOption {
+	return func(conn *Conn) {
+		conn.requireSecureTransport = require
+	}
+}
+
+// WithServerVersion 设置握手的时候告诉客户端的版本，默认是 DefaultServerVersion
+// 部分驱动会根据版本决定使用哪些特性，所以要和 WithServerCapabilities 保持一致
+func WithServerVersion(version string) ConnOption {
+	return func(conn *Conn) {
+		if version != "" {
+			conn.serverVersion = version
+		}
+	}
+}
+
+// WithServerCapabilities 设置握手的时候声明的能力，默认是 flags.DefaultServerCapabilities
+// 总是会加上 flags.RequiredServerCapabilities
+func WithServerCapabilities(capabilities flags.CapabilityFlags) ConnOption {
+	return func(conn *Conn) {
+		conn.serverCapabilities = capabilities | flags.RequiredServerCapabilities
 	}
 }
 
@@ -51,29 +255,40 @@ func (mc *Conn) ID() uint32 {
 	return mc.id
 }
 
+// StrictMode 是否开启了严格模式，参考 WithStrictMode
+func (mc *Conn) StrictMode() bool {
+	return mc.strictMode
+}
+
+// MaxAllowedPacket 客户端发送的单个 payload 的最大长度，参考 WithMaxAllowedPacket
+func (mc *Conn) MaxAllowedPacket() int {
+	return mc.maxAllowedPacket
+}
+
 // Loop 完成握手、鉴权，并且开始监听客户端的数据
 // 返回错误之后，则意味着这个 Conn 已经不可用
 func (mc *Conn) Loop() error {
-	// 先建立连接
-	err := mc.startHandshake()
+	// 先建立连接并鉴权
+	err := mc.handshake()
 	if err != nil {
-		return fmt.Errorf("发送握手请求失败 %w", err)
-	}
-	// 鉴权
-	err = mc.auth()
-	if err != nil {
-		return fmt.Errorf("开始鉴权失败 %w", err)
+		return err
 	}
 	for {
 		// 开始不断接收客户端的请求
-		pkt, err1 := mc.readPacket()
+		pkt, err1 := mc.readCmdPacket()
 		if err1 != nil {
 			return fmt.Errorf("读取客户端请求失败 %w", err1)
 		}
-		// ctx, _ := context.WithTimeout(context.Background(), mc.cmdTimeout)
-		ctx := context.Background()
+		ctx, done, err1 := mc.startCmd()
+		if err1 != nil {
+			return err1
+		}
 		err1 = mc.onCmd(ctx, mc, pkt)
-		// cancel() // TODO：暂时注释，每次取消会影响事务和prepare的后续操作
+		done()
+		// 压缩协议下，响应可能还有一部分在缓冲区里
+		if err2 := mc.flush(); err1 == nil {
+			err1 = err2
+		}
 		if err1 != nil {
 			return err1
 		}
@@ -81,6 +296,8 @@ func (mc *Conn) Loop() error {
 }
 
 func (mc *Conn) Close() error {
+	// 连接关闭了，正在执行的命令也没有必要继续执行了
+	mc.KillQuery()
 	return mc.conn.Close()
 }
 
@@ -93,9 +310,86 @@ func (mc *Conn) CharacterSet() uint32 {
 }
 
 func (mc *Conn) SetInTransaction(s bool) {
-	mc.inTransaction = s
+	mc.inTransaction.Store(s)
 }
 
 func (mc *Conn) InTransaction() bool {
-	return mc.inTransaction
+	return mc.inTransaction.Load()
+}
+
+// User 通过鉴权的用户名
+func (mc *Conn) User() string {
+	return mc.user
+}
+
+// Schema 当前连接使用的逻辑库，没有选择的时候为空字符串
+func (mc *Conn) Schema() string {
+	return mc.schema
+}
+
+// ConnAttrs 客户端在握手的时候发送的连接属性，客户端没有发送的时候为 nil
+func (mc *Conn) ConnAttrs() map[string]string {
+	return mc.connAttrs
+}
+
+// Charset 客户端使用的字符集，也就是 character_set_client
+// 握手的时候由客户端指定的 collation 决定，之后可以通过 SET NAMES 修改
+func (mc *Conn) Charset() string {
+	charset, _ := mc.SystemVariable("character_set_client")
+	return charset.String
+}
+
+// Session 插件在这个连接上保存的数据，参考 pcontext.Session
+func (mc *Conn) Session() *pcontext.Session {
+	return mc.session
+}
+
+// SetSchema 切换当前连接使用的逻辑库，调用者需要先用 CanAccess 校验权限
+func (mc *Conn) SetSchema(schema string) {
+	mc.schema = schema
+}
+
+// MultiStatements 是否允许在一个 COM_QUERY 中发送多个语句
+func (mc *Conn) MultiStatements() bool {
+	return mc.multiStatements
+}
+
+func (mc *Conn) SetMultiStatements(enabled bool) {
+	mc.multiStatements = enabled
+}
+
+// CanAccess 当前用户是否有权限访问 schema
+func (mc *Conn) CanAccess(schema string) bool {
+	if mc.userStore == nil {
+		return true
+	}
+	u, ok := mc.userStore.User(mc.user)
+	return ok && u.CanAccess(schema)
+}
+
+// RemoteAddr 客户端的地址，通过负载均衡连接的时候是 PROXY protocol 头部中客户端真实的地址
+func (mc *Conn) RemoteAddr() net.Addr {
+	if mc.clientAddr != nil {
+		return mc.clientAddr
+	}
+	return mc.conn.RemoteAddr()
+}
+
+// RemoteHost 客户端的地址，不包含端口
+// 和 MySQL 一样，通过 Unix domain socket 连接的客户端是 localhost
+func (mc *Conn) RemoteHost() string {
+	if mc.RemoteAddr().Network() == "unix" {
+		return "localhost"
+	}
+	addr := mc.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// Secure 当前连接是否已经切换到了 TLS
+func (mc *Conn) Secure() bool {
+	return mc.secure
 }
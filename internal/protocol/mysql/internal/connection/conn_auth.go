@@ -0,0 +1,149 @@
+package connection
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/auth"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/parser"
+)
+
+// authenticate 校验用户名、密码以及初始数据库
+// 校验失败的时候会回写错误响应，并且返回 errs.ErrAccessDenied
+func (mc *Conn) authenticate(resp *parser.HandshakeResponse41) error {
+	if mc.userStore == nil {
+		// 没有配置用户的时候不做校验
+		return nil
+	}
+	username, host := resp.Username(), mc.RemoteHost()
+	pluginName, authResponse := resp.AuthPluginName(), resp.AuthResponse()
+	usingPassword := len(authResponse) > 0
+	if pluginName == "" {
+		// 不支持 CLIENT_PLUGIN_AUTH 的客户端只会使用 mysql_native_password
+		pluginName = auth.NativePasswordPluginName
+	}
+	if pluginName != auth.NativePasswordPluginName && pluginName != auth.CachingSha2PasswordPluginName {
+		// 客户端使用了我们不支持的鉴权插件，要求它切换到 caching_sha2_password
+		if !mc.clientFlags.Has(flags.ClientPluginAuth) {
+			return mc.denyAccess(builder.NewErrAccessDenied(username, host, usingPassword))
+		}
+		var err error
+		pluginName = auth.CachingSha2PasswordPluginName
+		authResponse, err = mc.switchAuthPlugin(pluginName)
+		if err != nil {
+			return err
+		}
+		usingPassword = len(authResponse) > 0
+	}
+
+	u, ok := mc.userStore.User(username)
+	if !ok {
+		return mc.denyAccess(builder.NewErrAccessDenied(username, host, usingPassword))
+	}
+	var passed bool
+	switch pluginName {
+	case auth.NativePasswordPluginName:
+		passed = auth.VerifyNativePassword(mc.authData, authResponse, u.Password)
+	case auth.CachingSha2PasswordPluginName:
+		var err error
+		passed, err = mc.cachingSha2Auth(u, authResponse)
+		if err != nil {
+			return err
+		}
+	}
+	if !passed {
+		return mc.denyAccess(builder.NewErrAccessDenied(username, host, usingPassword))
+	}
+	if db := resp.Database(); db != "" && !u.CanAccess(db) {
+		return mc.denyAccess(builder.NewErrDBAccessDenied(username, host, db))
+	}
+	return nil
+}
+
+// switchAuthPlugin 发送 AuthSwitchRequest，返回客户端使用新插件计算的鉴权数据
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase.html#sect_protocol_connection_phase_auth_method_mismatch
+func (mc *Conn) switchAuthPlugin(pluginName string) ([]byte, error) {
+	// 切换插件的时候使用新的 scramble
+	mc.authData = []byte(builder.AuthPluginDataGenerator()[:20])
+	err := mc.WritePacket(builder.NewAuthSwitchRequestPacket(pluginName, mc.authData).Build())
+	if err != nil {
+		return nil, err
+	}
+	return mc.readPacket()
+}
+
+// cachingSha2Auth caching_sha2_password 鉴权
+// 命中缓存的时候走快速鉴权，否则要求客户端发送密码，走完整鉴权
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_caching_sha2_authentication_exchanges.html
+func (mc *Conn) cachingSha2Auth(u auth.User, authResponse []byte) (bool, error) {
+	if len(authResponse) == 0 {
+		// 空密码客户端不会发送任何数据
+		return u.Password == "", nil
+	}
+	if mc.sha2Cache != nil {
+		if digest, ok := mc.sha2Cache.Get(u); ok {
+			if !auth.VerifyCachingSha2Password(mc.authData, authResponse, digest) {
+				return false, nil
+			}
+			err := mc.WritePacket(builder.NewAuthMoreDataPacket([]byte{auth.CachingSha2FastAuthSuccess}).Build())
+			return err == nil, err
+		}
+	}
+
+	// 完整鉴权
+	err := mc.WritePacket(builder.NewAuthMoreDataPacket([]byte{auth.CachingSha2PerformFullAuthentication}).Build())
+	if err != nil {
+		return false, err
+	}
+	payload, err := mc.readPacket()
+	if err != nil {
+		return false, err
+	}
+	var password string
+	switch {
+	case mc.secure:
+		// TLS 连接上客户端直接发送明文密码
+		password = string(bytes.TrimRight(payload, "\x00"))
+	case len(payload) == 1 && payload[0] == auth.CachingSha2RequestPublicKey && mc.rsaKey != nil:
+		password, err = mc.readEncryptedPassword()
+		if err != nil {
+			return false, err
+		}
+	default:
+		// 非 TLS 连接上不能接受明文密码
+		return false, nil
+	}
+	if !auth.VerifyPassword(password, u.Password) {
+		return false, nil
+	}
+	if mc.sha2Cache != nil {
+		mc.sha2Cache.Put(u, auth.CachingSha2Digest(password))
+	}
+	return true, nil
+}
+
+// readEncryptedPassword 将公钥发送给客户端，读取并解密客户端用公钥加密之后的密码
+func (mc *Conn) readEncryptedPassword() (string, error) {
+	pub, err := auth.PublicKeyPEM(mc.rsaKey)
+	if err != nil {
+		return "", err
+	}
+	err = mc.WritePacket(builder.NewAuthMoreDataPacket(pub).Build())
+	if err != nil {
+		return "", err
+	}
+	payload, err := mc.readPacket()
+	if err != nil {
+		return "", err
+	}
+	return auth.DecryptPassword(mc.rsaKey, mc.authData, payload)
+}
+
+// denyAccess 回写鉴权失败的错误响应
+func (mc *Conn) denyAccess(e builder.Error) error {
+	_ = mc.WritePacket(builder.NewErrPacket(mc.ClientCapabilityFlags(), e).Build())
+	return fmt.Errorf("%w: %s", errs.ErrAccessDenied, e.Msg())
+}
@@ -0,0 +1,105 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrConnClosing 连接已经被 CloseIfIdle 关闭了
+var ErrConnClosing = errors.New("连接正在关闭")
+
+// startCmd 为命令创建一个可以被 KillQuery 取消的 context
+// 命令执行期间会探测客户端是否断开了连接，断开的时候同样会取消 context，
+// 这样后端还在执行的查询也会被取消。设置了 cmdTimeout 的时候，超时之后也会取消 context。
+// 返回的 done 需要在命令执行完毕之后调用，它会取消 context。连接已经被 CloseIfIdle 关闭的时候返回 ErrConnClosing
+func (mc *Conn) startCmd() (ctx context.Context, done func(), err error) {
+	// 事务、预处理语句和游标都和连接的生命周期绑定，它们不会使用命令的 ctx，
+	// 所以命令执行完毕之后就可以取消 ctx，同时停止 cmdTimeout 的计时
+	var cancel context.CancelFunc
+	if mc.cmdTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), mc.cmdTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	mc.cancelMu.Lock()
+	if mc.closing {
+		mc.cancelMu.Unlock()
+		cancel()
+		return nil, nil, ErrConnClosing
+	}
+	mc.cancelCmd = cancel
+	mc.cancelMu.Unlock()
+
+	stop := mc.watchDisconnect(cancel)
+	return ctx, func() {
+		stop()
+		mc.cancelMu.Lock()
+		mc.cancelCmd = nil
+		mc.cancelMu.Unlock()
+		cancel()
+	}, nil
+}
+
+// watchDisconnect 在后台读取客户端的数据，读取失败说明客户端断开了连接
+// 正常情况下客户端在等待响应的时候不会发送数据，如果读到了数据，
+// 那么就是客户端提前发送了下一个命令，要留给 readPacket 读取
+func (mc *Conn) watchDisconnect(cancel context.CancelFunc) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1)
+		n, err := mc.conn.Read(buf)
+		if n > 0 {
+			mc.pending = append(mc.pending, buf[:n]...)
+		}
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cancel()
+		}
+	}()
+	return func() {
+		// 让后台的读取立刻返回
+		_ = mc.conn.SetReadDeadline(time.Now())
+		<-done
+		_ = mc.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// readFull 读满 buf，先读取 watchDisconnect 读到的数据
+func (mc *Conn) readFull(buf []byte) error {
+	n := copy(buf, mc.pending)
+	mc.pending = mc.pending[n:]
+	_, err := io.ReadFull(mc.conn, buf[n:])
+	return err
+}
+
+// KillQuery 取消连接正在执行的命令，对应 KILL QUERY 语句
+func (mc *Conn) KillQuery() {
+	mc.cancelMu.Lock()
+	defer mc.cancelMu.Unlock()
+	if mc.cancelCmd != nil {
+		mc.cancelCmd()
+	}
+}
+
+// CloseIfIdle 连接空闲的时候关闭连接，返回是否关闭了连接
+// 正在执行命令或者处于事务中的连接不是空闲的。关闭之后，即便客户端已经发送了下一个命令，这个命令也不会被执行
+func (mc *Conn) CloseIfIdle() bool {
+	mc.cancelMu.Lock()
+	if mc.cancelCmd != nil || mc.InTransaction() {
+		mc.cancelMu.Unlock()
+		return false
+	}
+	mc.closing = true
+	mc.cancelMu.Unlock()
+	_ = mc.conn.Close()
+	return true
+}
+
+// Kill 取消连接正在执行的命令并且关闭连接，对应 KILL [CONNECTION] 语句和 COM_PROCESS_KILL 命令
+func (mc *Conn) Kill() error {
+	mc.KillQuery()
+	return mc.Close()
+}
@@ -0,0 +1,234 @@
+package connection
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
+)
+
+const (
+	// compressedHeaderSize 压缩报文的头部长度
+	// int<3> 压缩之后的长度，int<1> 压缩报文的 sequence，int<3> 压缩之前的长度
+	compressedHeaderSize = 7
+	// minCompressLength 太短的数据压缩之后往往更长，所以不压缩，和 MySQL 保持一致
+	minCompressLength = 50
+	// compressFlushSize 缓冲的数据超过这个大小就压缩发送，和 MySQL 的 net_buffer_length 默认值保持一致
+	compressFlushSize = 16 * 1024
+	// defaultZstdLevel 客户端没有指定 zstd 压缩级别的时候使用的级别，和 MySQL 保持一致
+	defaultZstdLevel = 3
+)
+
+// zstdDecoder 只用于 DecodeAll，可以被所有连接共享
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(packet.MaxPacketSize))
+
+// compressor 压缩协议使用的压缩算法
+type compressor interface {
+	// compress 压缩 src，返回的数据在下一次调用之前有效
+	compress(src []byte) ([]byte, error)
+	// decompress 解压 src，n 是压缩之前的长度
+	decompress(src []byte, n int) ([]byte, error)
+}
+
+type zlibCompressor struct {
+	buf bytes.Buffer
+	w   *zlib.Writer
+}
+
+func newZlibCompressor() *zlibCompressor {
+	c := &zlibCompressor{}
+	c.w = zlib.NewWriter(&c.buf)
+	return c
+}
+
+func (c *zlibCompressor) compress(src []byte) ([]byte, error) {
+	c.buf.Reset()
+	c.w.Reset(&c.buf)
+	if _, err := c.w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := c.w.Close(); err != nil {
+		return nil, err
+	}
+	return c.buf.Bytes(), nil
+}
+
+func (c *zlibCompressor) decompress(src []byte, n int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	dst := make([]byte, n)
+	if _, err = io.ReadFull(r, dst); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+type zstdCompressor struct {
+	enc *zstd.Encoder
+	buf []byte
+}
+
+func newZstdCompressor(level int) (*zstdCompressor, error) {
+	enc, err := zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdCompressor{enc: enc}, nil
+}
+
+func (c *zstdCompressor) compress(src []byte) ([]byte, error) {
+	c.buf = c.enc.EncodeAll(src, c.buf[:0])
+	return c.buf, nil
+}
+
+func (c *zstdCompressor) decompress(src []byte, n int) ([]byte, error) {
+	dst, err := zstdDecoder.DecodeAll(src, make([]byte, 0, n))
+	if err != nil {
+		return nil, err
+	}
+	if len(dst) != n {
+		return nil, fmt.Errorf("解压之后的长度 %d 和预期的长度 %d 不一致", len(dst), n)
+	}
+	return dst, nil
+}
+
+// compressIO 压缩协议的读写状态
+// 开启压缩之后，普通报文（包含头部）被放在压缩报文中传输，一个压缩报文可以包含多个普通报文，
+// 一个普通报文也可能被拆分到多个压缩报文中
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_compression.html
+type compressIO struct {
+	alg compressor
+	// sequence 压缩报文自己的 sequence，和普通报文的 sequence 分开计算
+	sequence uint8
+	// readBuf 已经解压但是还没有读取的数据
+	readBuf []byte
+	// writeBuf 还没有发送的普通报文
+	writeBuf []byte
+}
+
+// enableCompression 鉴权成功之后，根据客户端的能力开启压缩协议
+// 客户端同时要求两种算法的时候，和 MySQL 一样优先使用 zlib
+func (mc *Conn) enableCompression(zstdLevel byte) error {
+	switch {
+	case mc.clientFlags.Has(flags.ClientCompress):
+		mc.compress = &compressIO{alg: newZlibCompressor()}
+	case mc.clientFlags.Has(flags.ClientZstdCompressionAlgorithm):
+		level := int(zstdLevel)
+		if level == 0 {
+			level = defaultZstdLevel
+		}
+		alg, err := newZstdCompressor(level)
+		if err != nil {
+			return err
+		}
+		mc.compress = &compressIO{alg: alg}
+	}
+	return nil
+}
+
+// readCompressed 从解压之后的数据中读满 buf，数据不够的时候继续读取压缩报文
+func (mc *Conn) readCompressed(buf []byte) error {
+	for len(buf) > 0 {
+		if len(mc.compress.readBuf) == 0 {
+			if err := mc.readCompressedPacket(); err != nil {
+				return err
+			}
+			continue
+		}
+		n := copy(buf, mc.compress.readBuf)
+		mc.compress.readBuf = mc.compress.readBuf[n:]
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// readCompressedPacket 读取一个压缩报文并且解压
+func (mc *Conn) readCompressedPacket() error {
+	header := make([]byte, compressedHeaderSize)
+	if err := mc.readFull(header); err != nil {
+		return err
+	}
+	compressedLen := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	uncompressedLen := int(uint32(header[4]) | uint32(header[5])<<8 | uint32(header[6])<<16)
+	// 和 MySQL 一样，不校验压缩报文的 sequence，响应接着客户端的 sequence 往下走
+	mc.compress.sequence = header[3] + 1
+	body := make([]byte, compressedLen)
+	if err := mc.readFull(body); err != nil {
+		return err
+	}
+	if uncompressedLen == 0 {
+		// 数据太短的时候，客户端不会压缩
+		mc.compress.readBuf = body
+		return nil
+	}
+	data, err := mc.compress.alg.decompress(body, uncompressedLen)
+	if err != nil {
+		return fmt.Errorf("%w，解压报文失败 %w", errs.ErrInvalidConn, err)
+	}
+	mc.compress.readBuf = data
+	return nil
+}
+
+// writeCompressed 缓冲普通报文，缓冲的数据足够多的时候才压缩发送，这样压缩率更高
+func (mc *Conn) writeCompressed(data []byte) error {
+	mc.compress.writeBuf = append(mc.compress.writeBuf, data...)
+	if len(mc.compress.writeBuf) < compressFlushSize {
+		return nil
+	}
+	return mc.flush()
+}
+
+// flush 把缓冲的报文压缩之后发送出去，没有开启压缩协议的时候什么也不做
+// 每个命令执行完毕之后都要调用，确保客户端收到了完整的响应
+func (mc *Conn) flush() error {
+	if mc.compress == nil {
+		return nil
+	}
+	data := mc.compress.writeBuf
+	for len(data) > 0 {
+		n := min(len(data), packet.MaxPacketSize)
+		if err := mc.writeCompressedPacket(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	if cap(mc.compress.writeBuf) > 4*compressFlushSize {
+		// 不要一直占用发送大报文时分配的内存
+		mc.compress.writeBuf = nil
+	} else {
+		mc.compress.writeBuf = mc.compress.writeBuf[:0]
+	}
+	return nil
+}
+
+// writeCompressedPacket 发送一个压缩报文，压缩之后没有变短的时候直接发送原始数据
+func (mc *Conn) writeCompressedPacket(payload []byte) error {
+	body, uncompressedLen := payload, 0
+	if len(payload) >= minCompressLength {
+		compressed, err := mc.compress.alg.compress(payload)
+		if err != nil {
+			return fmt.Errorf("%w，压缩报文失败 %w", errs.ErrInvalidConn, err)
+		}
+		if len(compressed) < len(payload) {
+			body, uncompressedLen = compressed, len(payload)
+		}
+	}
+	data := make([]byte, 0, compressedHeaderSize+len(body))
+	data = append(data,
+		byte(len(body)), byte(len(body)>>8), byte(len(body)>>16),
+		mc.compress.sequence,
+		byte(uncompressedLen), byte(uncompressedLen>>8), byte(uncompressedLen>>16))
+	data = append(data, body...)
+	mc.compress.sequence++
+	return mc.writeRaw(data)
+}
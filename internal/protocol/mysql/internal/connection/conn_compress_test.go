@@ -0,0 +1,178 @@
+package connection
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn_Compress(t *testing.T) {
+	// 每一行都是 1000 个相同的字符，压缩之后会短很多
+	row := bytes.Repeat([]byte{'a'}, 1000)
+	onCmd := func(ctx context.Context, conn *Conn, payload []byte) error {
+		if payload[0] == 0x03 {
+			// 把查询语句和 20 行数据写回去
+			pkt := append(make([]byte, 4), payload[1:]...)
+			if err := conn.WritePacket(pkt); err != nil {
+				return err
+			}
+			for i := 0; i < 20; i++ {
+				if err := conn.WritePacket(append(make([]byte, 4), row...)); err != nil {
+					return err
+				}
+			}
+		}
+		b := builder.NewOKPacket(conn.ClientCapabilityFlags(), flags.ServerStatusAutoCommit)
+		return conn.WritePacket(b.Build())
+	}
+	addr := startTestServerWithCmd(t, onCmd, nil)
+
+	tests := []struct {
+		name       string
+		clientFlag flags.CapabilityFlag
+		zstdLevel  byte
+		compress   func(t *testing.T, data []byte) []byte
+		decompress func(t *testing.T, data []byte) []byte
+	}{
+		{
+			name:       "zlib",
+			clientFlag: flags.ClientCompress,
+			compress: func(t *testing.T, data []byte) []byte {
+				var buf bytes.Buffer
+				w := zlib.NewWriter(&buf)
+				_, err := w.Write(data)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				return buf.Bytes()
+			},
+			decompress: func(t *testing.T, data []byte) []byte {
+				r, err := zlib.NewReader(bytes.NewReader(data))
+				require.NoError(t, err)
+				res, err := io.ReadAll(r)
+				require.NoError(t, err)
+				return res
+			},
+		},
+		{
+			name:       "zstd",
+			clientFlag: flags.ClientZstdCompressionAlgorithm,
+			zstdLevel:  5,
+			compress: func(t *testing.T, data []byte) []byte {
+				enc, err := zstd.NewWriter(nil)
+				require.NoError(t, err)
+				return enc.EncodeAll(data, nil)
+			},
+			decompress: func(t *testing.T, data []byte) []byte {
+				dec, err := zstd.NewReader(nil)
+				require.NoError(t, err)
+				defer dec.Close()
+				res, err := dec.DecodeAll(data, nil)
+				require.NoError(t, err)
+				return res
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawConn, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+			defer rawConn.Close()
+			require.NoError(t, rawConn.SetDeadline(time.Now().Add(3*time.Second)))
+
+			// 握手阶段不压缩
+			_, err = readTestPacket(rawConn)
+			require.NoError(t, err)
+			clientFlags := uint32(flags.ClientProtocol41 | tt.clientFlag)
+			resp := binary.LittleEndian.AppendUint32(nil, clientFlags)
+			resp = binary.LittleEndian.AppendUint32(resp, 1<<24)
+			resp = append(resp, 0x2d)
+			resp = append(resp, make([]byte, 23)...)
+			resp = append(resp, "root\x00\x00"...)
+			resp = append(resp, tt.zstdLevel)
+			require.NoError(t, writeTestPacket(rawConn, 1, resp))
+			ok, err := readTestPacket(rawConn)
+			require.NoError(t, err)
+			require.Equal(t, byte(0x00), ok[0])
+
+			// 太短的命令不压缩
+			ping := []byte{0x01, 0x00, 0x00, 0x00, 0x0e}
+			_, err = rawConn.Write(append([]byte{byte(len(ping)), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, ping...))
+			require.NoError(t, err)
+			r := &compressedTestReader{t: t, conn: rawConn, decompress: tt.decompress}
+			pkt, seq := r.readPacket()
+			assert.Equal(t, byte(0x00), pkt[0])
+			assert.Equal(t, byte(1), seq)
+
+			// 压缩之后的查询语句
+			query := "SELECT * FROM users WHERE name = '" + string(bytes.Repeat([]byte{'b'}, 100)) + "'"
+			cmd := append([]byte{byte(len(query) + 1), 0x00, 0x00, 0x00, 0x03}, query...)
+			body := tt.compress(t, cmd)
+			header := []byte{byte(len(body)), byte(len(body) >> 8), byte(len(body) >> 16), 0x00,
+				byte(len(cmd)), byte(len(cmd) >> 8), byte(len(cmd) >> 16)}
+			_, err = rawConn.Write(append(header, body...))
+			require.NoError(t, err)
+
+			r.compressedLen = 0
+			pkt, _ = r.readPacket()
+			assert.Equal(t, query, string(pkt))
+			for i := 0; i < 20; i++ {
+				pkt, _ = r.readPacket()
+				assert.Equal(t, row, pkt)
+			}
+			pkt, _ = r.readPacket()
+			assert.Equal(t, byte(0x00), pkt[0])
+			// 20000 多个字节的响应压缩之后很短
+			assert.Less(t, r.compressedLen, 1000)
+		})
+	}
+}
+
+// compressedTestReader 从压缩报文中读取普通报文
+type compressedTestReader struct {
+	t          *testing.T
+	conn       net.Conn
+	decompress func(t *testing.T, data []byte) []byte
+	buf        []byte
+	// compressedLen 读取到的压缩报文的总长度
+	compressedLen int
+}
+
+// readPacket 读取一个普通报文，返回 payload 和 sequence
+func (r *compressedTestReader) readPacket() ([]byte, byte) {
+	header := r.read(4)
+	n := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	return r.read(n), header[3]
+}
+
+func (r *compressedTestReader) read(n int) []byte {
+	for len(r.buf) < n {
+		header := make([]byte, 7)
+		_, err := io.ReadFull(r.conn, header)
+		require.NoError(r.t, err)
+		compressedLen := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+		uncompressedLen := int(header[4]) | int(header[5])<<8 | int(header[6])<<16
+		body := make([]byte, compressedLen)
+		_, err = io.ReadFull(r.conn, body)
+		require.NoError(r.t, err)
+		r.compressedLen += compressedHeaderSize + compressedLen
+		if uncompressedLen > 0 {
+			body = r.decompress(r.t, body)
+			require.Len(r.t, body, uncompressedLen)
+		}
+		r.buf = append(r.buf, body...)
+	}
+	res := r.buf[:n]
+	r.buf = r.buf[n:]
+	return res
+}
@@ -1,39 +1,146 @@
 package connection
 
 import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/auth"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/parser"
 )
 
+// handshake 发送握手请求并且完成鉴权，整个过程不能超过 connectTimeout
+// 超时之后和其它握手阶段的错误一样返回 ER_HANDSHAKE_ERROR
+func (mc *Conn) handshake() error {
+	if mc.connectTimeout > 0 {
+		// TLS 握手也在这个时间之内，tls.Conn 的 deadline 就是底层连接的 deadline
+		_ = mc.conn.SetReadDeadline(time.Now().Add(mc.connectTimeout))
+	}
+	err := mc.startHandshake()
+	if err != nil {
+		return fmt.Errorf("发送握手请求失败 %w", err)
+	}
+	err = mc.auth()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		b := builder.NewErrPacket(flags.CapabilityFlags(flags.ClientProtocol41), builder.ER_HANDSHAKE_ERROR)
+		if err1 := mc.WritePacket(b.Build()); err1 == nil {
+			_ = mc.flush()
+		}
+		return fmt.Errorf("握手超过了 connect_timeout %s %w", mc.connectTimeout, err)
+	}
+	if err != nil {
+		return fmt.Errorf("开始鉴权失败 %w", err)
+	}
+	// 之后由 wait_timeout 控制读取命令的时间
+	_ = mc.conn.SetReadDeadline(time.Time{})
+	return nil
+}
+
 // startHandshake
 // 在 mysql 协议中，在建立了 TCP 连接之后
 // mysql server 端发起 startHandshake
 // 而后客户端要响应 startHandshake
 func (mc *Conn) startHandshake() error {
-	b := builder.NewHandshakeV10Packet(flags.CapabilityFlags(flags.ClientPluginAuth), flags.ServerStatusAutoCommit, builder.AuthPluginDataGenerator)
+	// 后续校验密码的时候要用到 scramble，所以这里要保存下来
+	mc.authData = []byte(builder.AuthPluginDataGenerator()[:20])
+	b := builder.NewHandshakeV10Packet(mc.handshakeCapabilities(), flags.ServerStatusAutoCommit, func() string {
+		return string(mc.authData)
+	})
 	b.ProtocolVersion = packet.MinProtocolVersion
-	b.ServerVersion = "8.4.0"
+	b.ServerVersion = mc.serverVersion
 	b.ConnectionID = mc.id
-	b.AuthPluginName = "mysql_native_password"
+	b.AuthPluginName = auth.CachingSha2PasswordPluginName
 	return mc.WritePacket(b.Build())
 }
 
+// handshakeCapabilities 握手的时候声明的能力
+func (mc *Conn) handshakeCapabilities() flags.CapabilityFlags {
+	capabilities := mc.serverCapabilities
+	if mc.tlsConfig == nil {
+		// 没有配置证书的时候，不能告诉客户端我们支持 SSL
+		capabilities &^= flags.CapabilityFlags(flags.ClientSSL)
+	}
+	return capabilities
+}
+
 func (mc *Conn) auth() error {
 	payload, err := mc.readPacket()
 	if err != nil {
 		return err
 	}
-	// TODO: 这里不该默认用41解析,需要根据客户端传递的flags来判断一下
-	p := parser.HandshakeResponse41{}
+	if parser.IsSSLRequest(payload) {
+		payload, err = mc.upgradeToTLS(payload)
+		if err != nil {
+			return err
+		}
+	}
+	if mc.requireSecureTransport && !mc.secure {
+		b := builder.NewErrPacket(flags.CapabilityFlags(flags.ClientProtocol41), builder.ER_SECURE_TRANSPORT_REQUIRED)
+		_ = mc.WritePacket(b.Build())
+		return fmt.Errorf("客户端没有使用 TLS 连接")
+	}
+	// dbproxy 只支持 4.1 之后的协议，没有设置 CLIENT_PROTOCOL_41 的客户端发送的是 HandshakeResponse320
+	if len(payload) < 4 || !flags.CapabilityFlags(binary.LittleEndian.Uint16(payload)).Has(flags.ClientProtocol41) {
+		b := builder.NewErrPacket(flags.CapabilityFlags(flags.ClientProtocol41), builder.ER_HANDSHAKE_ERROR)
+		_ = mc.WritePacket(b.Build())
+		return fmt.Errorf("客户端不支持 CLIENT_PROTOCOL_41")
+	}
+	p := parser.NewHandshakeResponse41()
 	err = p.Parse(payload)
 	if err != nil {
+		// 和 MySQL 一样，握手阶段的报文有问题的时候直接关闭连接
+		b := builder.NewErrPacket(flags.CapabilityFlags(flags.ClientProtocol41), builder.ER_HANDSHAKE_ERROR)
+		_ = mc.WritePacket(b.Build())
 		return err
 	}
-	mc.clientFlags = p.ClientFlags()
+	// 后续只使用双方都支持的能力，builder 根据它决定报文的格式
+	mc.clientFlags = p.ClientFlags() & mc.handshakeCapabilities()
 	mc.characterSet = p.CharacterSet()
+	if err = mc.authenticate(p); err != nil {
+		return err
+	}
+	mc.user = p.Username()
+	mc.schema = p.Database()
+	mc.connAttrs = p.Attrs()
+	mc.multiStatements = mc.clientFlags.Has(flags.ClientMultiStatements)
+	if mc.onAuth != nil {
+		if err = mc.onAuth(mc); err != nil {
+			_ = mc.WritePacket(builder.NewErrPacket(mc.ClientCapabilityFlags(), builder.NewError(err)).Build())
+			return err
+		}
+	}
 	// 写回 OK 响应
 	b := builder.NewOKPacket(mc.ClientCapabilityFlags(), flags.ServerStatusAutoCommit)
-	return mc.WritePacket(b.Build())
+	if err = mc.WritePacket(b.Build()); err != nil {
+		return err
+	}
+	// OK 响应之后的数据都使用压缩协议
+	return mc.enableCompression(p.ZstdCompressionLevel())
+}
+
+// upgradeToTLS 处理 SSLRequest，将底层连接切换为 TLS 连接
+// 返回切换之后客户端发送过来的 HandshakeResponse41
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase.html#sect_protocol_connection_phase_initial_handshake_ssl_handshake
+func (mc *Conn) upgradeToTLS(payload []byte) ([]byte, error) {
+	if mc.tlsConfig == nil {
+		return nil, fmt.Errorf("客户端请求使用 TLS，但是服务端没有配置证书")
+	}
+	req := parser.NewSSLRequest()
+	if err := req.Parse(payload); err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Server(mc.conn, mc.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS 握手失败 %w", err)
+	}
+	mc.conn = tlsConn
+	mc.secure = true
+	// 切换之后，客户端会在 TLS 连接上重新发送完整的 HandshakeResponse41
+	return mc.readPacket()
 }
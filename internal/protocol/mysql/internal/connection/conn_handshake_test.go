@@ -0,0 +1,519 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/auth"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn_TLS(t *testing.T) {
+	err := mysql.RegisterTLSConfig("dbproxy-test", &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer mysql.DeregisterTLSConfig("dbproxy-test")
+
+	tests := []struct {
+		name    string
+		opts    []ConnOption
+		tls     string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:    "未配置证书_明文连接",
+			tls:     "false",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "未配置证书_客户端要求TLS",
+			tls:     "dbproxy-test",
+			wantErr: assert.Error,
+		},
+		{
+			name:    "配置证书_TLS连接",
+			opts:    []ConnOption{WithTLSConfig(newTestTLSConfig(t))},
+			tls:     "dbproxy-test",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "配置证书_明文连接",
+			opts:    []ConnOption{WithTLSConfig(newTestTLSConfig(t))},
+			tls:     "false",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "要求TLS_TLS连接",
+			opts:    []ConnOption{WithTLSConfig(newTestTLSConfig(t)), WithRequireSecureTransport(true)},
+			tls:     "dbproxy-test",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "要求TLS_明文连接",
+			opts:    []ConnOption{WithTLSConfig(newTestTLSConfig(t)), WithRequireSecureTransport(true)},
+			tls:     "false",
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startTestServer(t, tt.opts...)
+			db, err := sql.Open("mysql", fmt.Sprintf("root:root@tcp(%s)/test?tls=%s", addr, tt.tls))
+			require.NoError(t, err)
+			defer db.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			tt.wantErr(t, db.PingContext(ctx))
+		})
+	}
+}
+
+func TestConn_Auth(t *testing.T) {
+	err := mysql.RegisterTLSConfig("dbproxy-auth-test", &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer mysql.DeregisterTLSConfig("dbproxy-auth-test")
+
+	store := auth.NewStaticStore([]auth.User{
+		{Name: "root", Password: auth.NativePasswordHash("root")},
+		{Name: "guest"},
+		{Name: "order", Password: auth.NativePasswordHash("order"), Schemas: []string{"order_db"}},
+	})
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	// 模拟 root 之前已经完整鉴权成功过
+	cache := auth.NewSHA2Cache()
+	cache.Put(auth.User{Name: "root", Password: auth.NativePasswordHash("root")}, auth.CachingSha2Digest("root"))
+
+	tests := []struct {
+		name    string
+		opts    []ConnOption
+		dsn     string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:    "未配置用户_不校验",
+			dsn:     "anyone:anything@tcp(%s)/test",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "完整鉴权_RSA加密_密码正确",
+			opts:    []ConnOption{WithUserStore(store), WithRSAKey(key)},
+			dsn:     "root:root@tcp(%s)/test",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "完整鉴权_RSA加密_密码错误",
+			opts:    []ConnOption{WithUserStore(store), WithRSAKey(key)},
+			dsn:     "root:wrong@tcp(%s)/test",
+			wantErr: assertMySQLError(1045),
+		},
+		{
+			name:    "完整鉴权_TLS明文_密码正确",
+			opts:    []ConnOption{WithUserStore(store), WithTLSConfig(newTestTLSConfig(t))},
+			dsn:     "root:root@tcp(%s)/test?tls=dbproxy-auth-test",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "完整鉴权_TLS明文_密码错误",
+			opts:    []ConnOption{WithUserStore(store), WithTLSConfig(newTestTLSConfig(t))},
+			dsn:     "root:wrong@tcp(%s)/test?tls=dbproxy-auth-test",
+			wantErr: assertMySQLError(1045),
+		},
+		{
+			name:    "完整鉴权_既没有TLS也没有RSA密钥",
+			opts:    []ConnOption{WithUserStore(store)},
+			dsn:     "root:root@tcp(%s)/test",
+			wantErr: assert.Error,
+		},
+		{
+			name:    "快速鉴权_密码正确",
+			opts:    []ConnOption{WithUserStore(store), WithSHA2Cache(cache)},
+			dsn:     "root:root@tcp(%s)/test",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "快速鉴权_密码错误",
+			opts:    []ConnOption{WithUserStore(store), WithSHA2Cache(cache)},
+			dsn:     "root:wrong@tcp(%s)/test",
+			wantErr: assertMySQLError(1045),
+		},
+		{
+			name:    "用户不存在",
+			opts:    []ConnOption{WithUserStore(store), WithRSAKey(key)},
+			dsn:     "nobody:root@tcp(%s)/test",
+			wantErr: assertMySQLError(1045),
+		},
+		{
+			name:    "空密码",
+			opts:    []ConnOption{WithUserStore(store), WithRSAKey(key)},
+			dsn:     "guest@tcp(%s)/test",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "可以访问的逻辑库",
+			opts:    []ConnOption{WithUserStore(store), WithRSAKey(key)},
+			dsn:     "order:order@tcp(%s)/order_db",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "不能访问的逻辑库",
+			opts:    []ConnOption{WithUserStore(store), WithRSAKey(key)},
+			dsn:     "order:order@tcp(%s)/user_db",
+			wantErr: assertMySQLError(1044),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startTestServer(t, tt.opts...)
+			db, err := sql.Open("mysql", fmt.Sprintf(tt.dsn, addr))
+			require.NoError(t, err)
+			defer db.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			tt.wantErr(t, db.PingContext(ctx))
+		})
+	}
+
+	t.Run("完整鉴权之后写入缓存", func(t *testing.T) {
+		sha2Cache := auth.NewSHA2Cache()
+		addr := startTestServer(t, WithUserStore(store), WithRSAKey(key), WithSHA2Cache(sha2Cache))
+		db, err := sql.Open("mysql", fmt.Sprintf("root:root@tcp(%s)/test", addr))
+		require.NoError(t, err)
+		defer db.Close()
+		require.NoError(t, db.Ping())
+		digest, ok := sha2Cache.Get(auth.User{Name: "root", Password: auth.NativePasswordHash("root")})
+		assert.True(t, ok)
+		assert.Equal(t, auth.CachingSha2Digest("root"), digest)
+	})
+}
+
+func TestConn_AuthSwitch(t *testing.T) {
+	store := auth.NewStaticStore([]auth.User{
+		{Name: "root", Password: auth.NativePasswordHash("root")},
+	})
+	cache := auth.NewSHA2Cache()
+	cache.Put(auth.User{Name: "root", Password: auth.NativePasswordHash("root")}, auth.CachingSha2Digest("root"))
+	addr := startTestServer(t, WithUserStore(store), WithSHA2Cache(cache))
+
+	rawConn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer rawConn.Close()
+	require.NoError(t, rawConn.SetDeadline(time.Now().Add(3*time.Second)))
+
+	// 读取握手请求
+	_, err = readTestPacket(rawConn)
+	require.NoError(t, err)
+
+	// 客户端声称使用服务端不支持的 sha256_password
+	clientFlags := uint32(flags.ClientProtocol41 | flags.ClientSecureConnection | flags.ClientPluginAuth)
+	resp := binary.LittleEndian.AppendUint32(nil, clientFlags)
+	resp = binary.LittleEndian.AppendUint32(resp, 1<<24)
+	resp = append(resp, 0x2d)
+	resp = append(resp, make([]byte, 23)...)
+	resp = append(resp, "root\x00"...)
+	resp = append(resp, 0x00)
+	resp = append(resp, "sha256_password\x00"...)
+	require.NoError(t, writeTestPacket(rawConn, 1, resp))
+
+	// 服务端要求切换到 caching_sha2_password
+	switchReq, err := readTestPacket(rawConn)
+	require.NoError(t, err)
+	require.Equal(t, byte(0xfe), switchReq[0])
+	pluginName, scramble, found := bytes.Cut(switchReq[1:], []byte{0x00})
+	require.True(t, found)
+	require.Equal(t, auth.CachingSha2PasswordPluginName, string(pluginName))
+	scramble = bytes.TrimSuffix(scramble, []byte{0x00})
+	require.Len(t, scramble, 20)
+
+	// 使用新的 scramble 计算鉴权数据
+	stage1 := sha256.Sum256([]byte("root"))
+	stage2 := sha256.Sum256(stage1[:])
+	stage3 := sha256.Sum256(append(stage2[:], scramble...))
+	for i := range stage3 {
+		stage3[i] ^= stage1[i]
+	}
+	require.NoError(t, writeTestPacket(rawConn, 3, stage3[:]))
+
+	// 命中缓存，快速鉴权成功
+	moreData, err := readTestPacket(rawConn)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x01, auth.CachingSha2FastAuthSuccess}, moreData)
+	ok, err := readTestPacket(rawConn)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x00), ok[0])
+}
+
+func TestConn_BadHandshake(t *testing.T) {
+	addr := startTestServerWithCmd(t, nil, nil)
+	rawConn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer rawConn.Close()
+	require.NoError(t, rawConn.SetDeadline(time.Now().Add(3*time.Second)))
+
+	_, err = readTestPacket(rawConn)
+	require.NoError(t, err)
+	// 用户名没有结束符
+	resp := binary.LittleEndian.AppendUint32(nil, uint32(flags.ClientProtocol41))
+	resp = binary.LittleEndian.AppendUint32(resp, 1<<24)
+	resp = append(resp, 0x2d)
+	resp = append(resp, make([]byte, 23)...)
+	resp = append(resp, "root"...)
+	require.NoError(t, writeTestPacket(rawConn, 1, resp))
+
+	errPkt, err := readTestPacket(rawConn)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0xff), errPkt[0])
+	assert.Equal(t, uint16(1043), binary.LittleEndian.Uint16(errPkt[1:3]))
+	// 连接被关闭了
+	_, err = readTestPacket(rawConn)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestConn_ConnectTimeout(t *testing.T) {
+	pingCmd := func(ctx context.Context, conn *Conn, payload []byte) error {
+		b := builder.NewOKPacket(conn.ClientCapabilityFlags(), flags.ServerStatusAutoCommit)
+		return conn.WritePacket(b.Build())
+	}
+	addr := startTestServerWithCmd(t, pingCmd, nil, WithConnectTimeout(100*time.Millisecond))
+
+	t.Run("没有发送握手响应", func(t *testing.T) {
+		rawConn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer rawConn.Close()
+		require.NoError(t, rawConn.SetDeadline(time.Now().Add(3*time.Second)))
+
+		_, err = readTestPacket(rawConn)
+		require.NoError(t, err)
+		errPkt, err := readTestPacket(rawConn)
+		require.NoError(t, err)
+		assert.Equal(t, byte(0xff), errPkt[0])
+		assert.Equal(t, uint16(1043), binary.LittleEndian.Uint16(errPkt[1:3]))
+		// 连接被关闭了
+		_, err = readTestPacket(rawConn)
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("鉴权之后不受影响", func(t *testing.T) {
+		rawConn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer rawConn.Close()
+		require.NoError(t, rawConn.SetDeadline(time.Now().Add(3*time.Second)))
+		handshakeTestConn(t, rawConn)
+
+		time.Sleep(200 * time.Millisecond)
+		require.NoError(t, writeTestPacket(rawConn, 0, []byte{0x0e}))
+		ok, err := readTestPacket(rawConn)
+		require.NoError(t, err)
+		assert.Equal(t, byte(0x00), ok[0])
+	})
+}
+
+func TestConn_CapabilityNegotiation(t *testing.T) {
+	clientFlags := flags.CapabilityFlags(flags.ClientProtocol41 | flags.ClientSecureConnection | flags.ClientPluginAuth |
+		flags.ClientDeprecateEOF | flags.ClientSessionTrack | flags.ClientQueryAttributes | flags.ClientLocalFiles)
+	tests := []struct {
+		name        string
+		opts        []ConnOption
+		clientFlags flags.CapabilityFlags
+
+		wantVersion      string
+		wantCapabilities flags.CapabilityFlags
+		wantNegotiated   flags.CapabilityFlags
+		wantErrNumber    uint16
+	}{
+		{
+			name:             "默认",
+			clientFlags:      clientFlags,
+			wantVersion:      DefaultServerVersion,
+			wantCapabilities: flags.DefaultServerCapabilities &^ flags.CapabilityFlags(flags.ClientSSL),
+			wantNegotiated:   clientFlags &^ flags.CapabilityFlags(flags.ClientLocalFiles),
+		},
+		{
+			name: "配置了版本和能力",
+			opts: []ConnOption{
+				WithServerVersion("5.7.44"),
+				WithServerCapabilities(flags.CapabilityFlags(flags.ClientTransactions | flags.ClientSessionTrack)),
+			},
+			clientFlags:      clientFlags,
+			wantVersion:      "5.7.44",
+			wantCapabilities: flags.RequiredServerCapabilities | flags.CapabilityFlags(flags.ClientTransactions|flags.ClientSessionTrack),
+			wantNegotiated:   flags.RequiredServerCapabilities | flags.CapabilityFlags(flags.ClientSessionTrack),
+		},
+		{
+			name:             "客户端不支持4.1协议",
+			clientFlags:      flags.CapabilityFlags(flags.ClientSecureConnection),
+			wantVersion:      DefaultServerVersion,
+			wantCapabilities: flags.DefaultServerCapabilities &^ flags.CapabilityFlags(flags.ClientSSL),
+			wantErrNumber:    1043,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conns := make(chan *Conn, 1)
+			addr := startTestServerWithCmd(t, nil, conns, tt.opts...)
+			rawConn, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+			defer rawConn.Close()
+			require.NoError(t, rawConn.SetDeadline(time.Now().Add(3*time.Second)))
+
+			handshake, err := readTestPacket(rawConn)
+			require.NoError(t, err)
+			version, rest, found := bytes.Cut(handshake[1:], []byte{0x00})
+			require.True(t, found)
+			assert.Equal(t, tt.wantVersion, string(version))
+			// connection id + auth-plugin-data-part-1 + filler 之后是 capability_flags_1，
+			// 再跳过 character_set 和 status_flags 是 capability_flags_2
+			capabilities := flags.CapabilityFlags(binary.LittleEndian.Uint16(rest[13:15])) |
+				flags.CapabilityFlags(binary.LittleEndian.Uint16(rest[18:20]))<<16
+			assert.Equal(t, tt.wantCapabilities, capabilities)
+
+			resp := binary.LittleEndian.AppendUint32(nil, uint32(tt.clientFlags))
+			resp = binary.LittleEndian.AppendUint32(resp, 1<<24)
+			resp = append(resp, 0x2d)
+			resp = append(resp, make([]byte, 23)...)
+			resp = append(resp, "root\x00\x00"...)
+			require.NoError(t, writeTestPacket(rawConn, 1, resp))
+
+			pkt, err := readTestPacket(rawConn)
+			require.NoError(t, err)
+			if tt.wantErrNumber != 0 {
+				assert.Equal(t, byte(0xff), pkt[0])
+				assert.Equal(t, tt.wantErrNumber, binary.LittleEndian.Uint16(pkt[1:3]))
+				return
+			}
+			assert.Equal(t, byte(0x00), pkt[0])
+			conn := <-conns
+			assert.Equal(t, tt.wantNegotiated, conn.ClientCapabilityFlags())
+		})
+	}
+}
+
+func TestConn_OnAuth(t *testing.T) {
+	addr := startTestServer(t, WithOnAuth(func(conn *Conn) error {
+		return errs.ErrTooManyConnections
+	}))
+	rawConn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer rawConn.Close()
+	require.NoError(t, rawConn.SetDeadline(time.Now().Add(3*time.Second)))
+
+	_, err = readTestPacket(rawConn)
+	require.NoError(t, err)
+	resp := binary.LittleEndian.AppendUint32(nil, uint32(flags.ClientProtocol41))
+	resp = binary.LittleEndian.AppendUint32(resp, 1<<24)
+	resp = append(resp, 0x2d)
+	resp = append(resp, make([]byte, 23)...)
+	resp = append(resp, "root\x00\x00"...)
+	require.NoError(t, writeTestPacket(rawConn, 1, resp))
+
+	errPkt, err := readTestPacket(rawConn)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0xff), errPkt[0])
+	assert.Equal(t, uint16(1040), binary.LittleEndian.Uint16(errPkt[1:3]))
+	_, err = readTestPacket(rawConn)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func readTestPacket(conn net.Conn) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	body := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+	_, err := io.ReadFull(conn, body)
+	return body, err
+}
+
+func writeTestPacket(conn net.Conn, seq byte, payload []byte) error {
+	data := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}
+	_, err := conn.Write(append(data, payload...))
+	return err
+}
+
+// assertMySQLError 断言客户端收到了指定错误码的错误
+func assertMySQLError(number uint16) assert.ErrorAssertionFunc {
+	return func(t assert.TestingT, err error, i ...interface{}) bool {
+		var me *mysql.MySQLError
+		if !assert.ErrorAs(t, err, &me, i...) {
+			return false
+		}
+		return assert.Equal(t, number, me.Number, i...)
+	}
+}
+
+// startTestServer 启动一个只处理 ping 命令的服务端，返回监听的地址
+func startTestServer(t *testing.T, opts ...ConnOption) string {
+	onCmd := func(ctx context.Context, conn *Conn, payload []byte) error {
+		b := builder.NewOKPacket(conn.ClientCapabilityFlags(), flags.ServerStatusAutoCommit)
+		return conn.WritePacket(b.Build())
+	}
+	return startTestServerWithCmd(t, onCmd, nil, opts...)
+}
+
+// startTestServerWithCmd 启动一个使用 onCmd 处理命令的服务端，返回监听的地址
+// conns 不为 nil 的时候会把建立的连接发送过去
+func startTestServerWithCmd(t *testing.T, onCmd OnCmd, conns chan<- *Conn, opts ...ConnOption) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		var id uint32
+		for {
+			rawConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			id++
+			conn := NewConn(id, rawConn, onCmd, opts...)
+			if conns != nil {
+				conns <- conn
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.Loop()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// newTestTLSConfig 生成一个自签名证书
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dbproxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
@@ -0,0 +1,48 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+)
+
+// readCmdPacket 等待客户端发送下一个命令
+// 空闲时间超过限制的时候，和 MySQL 一样先告诉客户端原因，然后返回错误关闭连接。
+// 处于事务中的连接被关闭之后，事务会被回滚
+func (mc *Conn) readCmdPacket() ([]byte, error) {
+	timeout := mc.idleTimeout()
+	if timeout <= 0 {
+		return mc.readPacket()
+	}
+	_ = mc.conn.SetReadDeadline(time.Now().Add(timeout))
+	pkt, err := mc.readPacket()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		// 这个错误不是对某个命令的响应，所以 sequence 从 0 开始
+		mc.sequence = 0
+		b := builder.NewErrPacket(mc.clientFlags, builder.ER_CLIENT_INTERACTION_TIMEOUT)
+		if err1 := mc.WritePacket(b.Build()); err1 == nil {
+			_ = mc.flush()
+		}
+		return nil, fmt.Errorf("空闲时间超过 %s %w", timeout, err)
+	}
+	// 命令执行期间还要探测客户端是否断开了连接，不能带着 deadline
+	_ = mc.conn.SetReadDeadline(time.Time{})
+	return pkt, err
+}
+
+// idleTimeout 当前允许的最长空闲时间，为 0 的时候不限制
+func (mc *Conn) idleTimeout() time.Duration {
+	if mc.InTransaction() && mc.idleInTxTimeout > 0 {
+		return mc.idleInTxTimeout
+	}
+	if v, ok := mc.sysVars["wait_timeout"]; ok {
+		// 客户端通过 SET wait_timeout 修改过，校验的时候已经确认过是正整数了
+		seconds, _ := strconv.Atoi(v.String)
+		return time.Duration(seconds) * time.Second
+	}
+	return mc.waitTimeout
+}
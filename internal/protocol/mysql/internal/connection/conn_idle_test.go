@@ -0,0 +1,101 @@
+package connection
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn_IdleTimeout(t *testing.T) {
+	// COM_QUERY 开启事务，其余命令结束事务
+	onCmd := func(ctx context.Context, conn *Conn, payload []byte) error {
+		conn.SetInTransaction(payload[0] == 0x03)
+		b := builder.NewOKPacket(conn.ClientCapabilityFlags(), flags.ServerStatusAutoCommit)
+		return conn.WritePacket(b.Build())
+	}
+	tests := []struct {
+		name   string
+		opts   []ConnOption
+		inTx   bool
+		idle   time.Duration
+		closed bool
+	}{
+		{
+			name:   "空闲超时",
+			opts:   []ConnOption{WithWaitTimeout(100 * time.Millisecond)},
+			idle:   300 * time.Millisecond,
+			closed: true,
+		},
+		{
+			name: "没有超时",
+			opts: []ConnOption{WithWaitTimeout(time.Second)},
+			idle: 100 * time.Millisecond,
+		},
+		{
+			name: "不限制",
+			idle: 300 * time.Millisecond,
+		},
+		{
+			name:   "事务中空闲超时",
+			opts:   []ConnOption{WithWaitTimeout(time.Second), WithIdleInTransactionTimeout(100 * time.Millisecond)},
+			inTx:   true,
+			idle:   300 * time.Millisecond,
+			closed: true,
+		},
+		{
+			name: "不在事务中不使用事务的空闲超时",
+			opts: []ConnOption{WithWaitTimeout(time.Second), WithIdleInTransactionTimeout(100 * time.Millisecond)},
+			idle: 300 * time.Millisecond,
+		},
+		{
+			name:   "事务中使用wait_timeout",
+			opts:   []ConnOption{WithWaitTimeout(100 * time.Millisecond)},
+			inTx:   true,
+			idle:   300 * time.Millisecond,
+			closed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startTestServerWithCmd(t, onCmd, nil, tt.opts...)
+			rawConn, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+			defer rawConn.Close()
+			require.NoError(t, rawConn.SetDeadline(time.Now().Add(3*time.Second)))
+			handshakeTestConn(t, rawConn)
+
+			cmd := byte(0x0e)
+			if tt.inTx {
+				cmd = 0x03
+			}
+			require.NoError(t, writeTestPacket(rawConn, 0, []byte{cmd}))
+			ok, err := readTestPacket(rawConn)
+			require.NoError(t, err)
+			require.Equal(t, byte(0x00), ok[0])
+
+			time.Sleep(tt.idle)
+			if !tt.closed {
+				// 连接还可以继续使用
+				require.NoError(t, writeTestPacket(rawConn, 0, []byte{0x0e}))
+				ok, err = readTestPacket(rawConn)
+				require.NoError(t, err)
+				assert.Equal(t, byte(0x00), ok[0])
+				return
+			}
+			errPkt, err := readTestPacket(rawConn)
+			require.NoError(t, err)
+			assert.Equal(t, byte(0xff), errPkt[0])
+			assert.Equal(t, uint16(4031), binary.LittleEndian.Uint16(errPkt[1:3]))
+			_, err = readTestPacket(rawConn)
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package connection
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/proxyproto"
+)
+
+// proxyHeaderTimeout 等待负载均衡发送 PROXY protocol 头部的最长时间，和 MySQL 的 connect_timeout 默认值一样
+const proxyHeaderTimeout = 10 * time.Second
+
+// ReadProxyHeader 对端是可信的负载均衡的时候，读取 PROXY protocol 头部并且记录客户端真实的地址
+// 要在 Loop 之前调用。MySQL 协议是服务端先发送数据，所以没有办法探测对端有没有发送头部，
+// 可信的对端必须发送头部，其余的对端不会读取，直接使用连接本身的地址
+func (mc *Conn) ReadProxyHeader() error {
+	if !mc.trustedProxy(mc.conn.RemoteAddr()) {
+		return nil
+	}
+	_ = mc.conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	h, err := proxyproto.ReadHeader(mc.conn)
+	if err != nil {
+		return fmt.Errorf("读取 PROXY protocol 头部失败 %w", err)
+	}
+	_ = mc.conn.SetReadDeadline(time.Time{})
+	if h.SrcAddr != nil {
+		mc.clientAddr = h.SrcAddr
+	}
+	return nil
+}
+
+// trustedProxy 判断 addr 是否可以发送 PROXY protocol 头部
+func (mc *Conn) trustedProxy(addr net.Addr) bool {
+	if len(mc.trustedProxies) == 0 {
+		return false
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := ap.Addr().Unmap()
+	for _, prefix := range mc.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -5,15 +5,18 @@ import (
 
 	"github.com/meoying/dbproxy/internal/errs"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
 )
 
 // readPacket 读取一个完整报文，已经去除了头部字段，只剩下 payload 字段
+// 超过 MaxPacketSize 的 payload 会被客户端拆分成多个报文发送，这里会重新拼接起来
 func (mc *Conn) readPacket() ([]byte, error) {
 	var prevData []byte
 	for {
 		// 读取头部的四个字节，其中三个字节是长度，一个字节是 sequence
 		data := make([]byte, 4)
-		_, err := mc.conn.Read(data)
+		// TLS 等连接一次 Read 可能只返回部分数据，所以要读满
+		err := mc.read(data)
 		if err != nil {
 			return nil, fmt.Errorf("%w，读取报文头部失败 %w", errs.ErrInvalidConn, err)
 		}
@@ -23,31 +26,37 @@ func (mc *Conn) readPacket() ([]byte, error) {
 
 		// check packet sync [8 bit]
 		// 当我们收到数据的时候，有两种可能
-		// 1. 这是一个新命令，那么 sequence
-		if data[3] == 0 {
+		// 1. 这是一个新命令，那么 sequence 从 0 开始
+		if prevData == nil && data[3] == 0 {
 			mc.sequence = 0
 		} else if data[3] != mc.sequence {
-			// 2. 这是一个老命令，所以我们会预期它的 sequence 应该是我们上次发送完之后 + 1的
+			// 2. 这是一个老命令，或者是一个大报文的后续部分，所以我们会预期它的 sequence 应该是我们上次发送完之后 + 1的
+			// sequence 超过 255 之后从 0 开始，uint8 溢出刚好就是这个效果
 			_ = mc.Close()
 			return nil, errs.ErrPktSync
 		}
 		mc.sequence++
 		// packets with length 0 terminate a previous packet which is a
 		// multiple of (2^24)-1 bytes long
-		if pktLen == 0 {
-			// there was no previous packet
-			if prevData == nil {
-				return nil, fmt.Errorf("%w，当前报文长度为 0，但未读到前面报文", errs.ErrInvalidConn)
-			}
-			return prevData, nil
+		// 命令报文至少有一个字节的命令类型，所以新命令不能是空报文。
+		// 鉴权阶段客户端可能发送空的鉴权数据，那个时候 sequence 不是 0
+		if pktLen == 0 && prevData == nil && data[3] == 0 {
+			return nil, fmt.Errorf("%w，当前报文长度为 0，但未读到前面报文", errs.ErrInvalidConn)
+		}
+
+		// 在读取报文体之前校验，避免客户端声称一个很大的长度让我们分配大量内存
+		if len(prevData)+pktLen > mc.maxAllowedPacket {
+			return nil, mc.rejectLargePacket()
 		}
+
 		// read packet body [pktLen bytes]
 		body := make([]byte, pktLen)
-		_, err = mc.conn.Read(body)
+		err = mc.read(body)
 		if err != nil {
 			return nil, fmt.Errorf("%w，读取报文体失败 %w", errs.ErrInvalidConn, err)
 		}
-		// return data if this was the last packet
+		// 长度小于 MaxPacketSize 的报文是最后一个报文
+		// 长度刚好是 MaxPacketSize 整数倍的 payload 最后会跟着一个空报文
 		if pktLen < packet.MaxPacketSize {
 			// zero allocations for non-split packets
 			if prevData == nil {
@@ -58,3 +67,20 @@ func (mc *Conn) readPacket() ([]byte, error) {
 		prevData = append(prevData, body...)
 	}
 }
+
+// rejectLargePacket 和 MySQL 一样，告诉客户端报文超过了 max_allowed_packet，之后连接就不能再用了
+func (mc *Conn) rejectLargePacket() error {
+	b := builder.NewErrPacket(mc.clientFlags, builder.ER_NET_PACKET_TOO_LARGE)
+	if err := mc.WritePacket(b.Build()); err == nil {
+		_ = mc.flush()
+	}
+	return fmt.Errorf("%w，超过了 max_allowed_packet %d", errs.ErrPktTooLarge, mc.maxAllowedPacket)
+}
+
+// read 读满 buf，开启了压缩协议的时候读取的是解压之后的数据
+func (mc *Conn) read(buf []byte) error {
+	if mc.compress != nil {
+		return mc.readCompressed(buf)
+	}
+	return mc.readFull(buf)
+}
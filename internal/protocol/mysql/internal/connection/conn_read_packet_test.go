@@ -0,0 +1,120 @@
+package connection
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn_ReadPacket(t *testing.T) {
+	large := bytes.Repeat([]byte{'a'}, packet.MaxPacketSize)
+	testPacket := func(seq byte, payload []byte) []byte {
+		return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}, payload...)
+	}
+	tests := []struct {
+		name             string
+		sequence         uint8
+		maxAllowedPacket int
+		// 客户端发送的数据
+		data []byte
+
+		wantPayload  []byte
+		wantSequence uint8
+		wantErr      error
+		// 客户端收到的响应的第一个字节
+		wantResp []byte
+	}{
+		{
+			name:         "单个报文",
+			data:         testPacket(0, []byte{0x0e}),
+			wantPayload:  []byte{0x0e},
+			wantSequence: 1,
+		},
+		{
+			name:    "空命令报文",
+			data:    testPacket(0, nil),
+			wantErr: errs.ErrInvalidConn,
+		},
+		{
+			name:         "鉴权阶段的空报文",
+			sequence:     3,
+			data:         testPacket(3, nil),
+			wantPayload:  []byte{},
+			wantSequence: 4,
+		},
+		{
+			name:         "拆分成两个报文",
+			data:         append(testPacket(0, large), testPacket(1, []byte("bc"))...),
+			wantPayload:  append(bytes.Clone(large), "bc"...),
+			wantSequence: 2,
+		},
+		{
+			name:         "刚好是MaxPacketSize",
+			data:         append(testPacket(0, large), testPacket(1, nil)...),
+			wantPayload:  large,
+			wantSequence: 2,
+		},
+		{
+			name:         "sequence超过255",
+			sequence:     255,
+			data:         append(testPacket(255, large), testPacket(0, []byte("bc"))...),
+			wantPayload:  append(bytes.Clone(large), "bc"...),
+			wantSequence: 1,
+		},
+		{
+			name:    "后续报文的sequence不对",
+			data:    append(testPacket(0, large), testPacket(2, []byte("bc"))...),
+			wantErr: errs.ErrPktSync,
+		},
+		{
+			name:             "超过max_allowed_packet",
+			maxAllowedPacket: 1,
+			data:             testPacket(0, []byte("bc")),
+			wantErr:          errs.ErrPktTooLarge,
+			wantResp:         []byte{0xff},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			var opts []ConnOption
+			if tt.maxAllowedPacket > 0 {
+				opts = append(opts, WithMaxAllowedPacket(tt.maxAllowedPacket))
+			}
+			conn := NewConn(1, server, nil, opts...)
+			defer conn.Close()
+			conn.sequence = tt.sequence
+
+			resp := make(chan []byte, 1)
+			go func() {
+				// 服务端可能不读取剩下的数据，所以不能等待写入完成
+				go func() {
+					_, _ = client.Write(tt.data)
+				}()
+				if tt.wantResp == nil {
+					resp <- nil
+					return
+				}
+				pkt, _ := readTestPacket(client)
+				resp <- pkt
+			}()
+			payload, err := conn.readPacket()
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr != nil {
+				if tt.wantResp != nil {
+					assert.Equal(t, tt.wantResp, (<-resp)[:len(tt.wantResp)])
+				}
+				return
+			}
+			require.Nil(t, <-resp)
+			assert.Equal(t, tt.wantPayload, payload)
+			assert.Equal(t, tt.wantSequence, conn.sequence)
+		})
+	}
+}
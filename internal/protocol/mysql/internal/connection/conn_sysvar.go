@@ -0,0 +1,315 @@
+package connection
+
+import (
+	"database/sql"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meoying/dbproxy/internal/errs"
+)
+
+// DefaultServerVersion 默认在握手的时候告诉客户端的版本，参考 WithServerVersion
+const DefaultServerVersion = "8.4.0"
+
+// SystemVariable 系统变量的一次赋值，也用于返回变量的值
+type SystemVariable struct {
+	// Name 小写的变量名
+	Name  string
+	Value sql.NullString
+	// Default 为 true 的时候恢复成默认值，这时候忽略 Value
+	Default bool
+}
+
+// systemVariable dbproxy 认识的系统变量
+type systemVariable struct {
+	// value 默认值，和连接相关的变量由连接决定，参考 defaultSystemVariable
+	value string
+	// local 为 true 的时候只在 dbproxy 中生效，不需要设置到后端的连接上
+	// 例如字符集，dbproxy 到后端的连接固定使用 utf8mb4，数据都是透传的
+	// 事务特性也是，开启事务的时候通过 sql.TxOptions 传给后端，参考 TakeTxOptions
+	local bool
+	// readOnly 为 true 的时候客户端不能修改
+	readOnly bool
+	// boolean 为 true 的时候值只能是 ON 或者 OFF，1、0、TRUE、FALSE 会被转换成 ON 或者 OFF
+	boolean bool
+	// nullable 为 true 的时候可以设置成 NULL
+	nullable bool
+}
+
+// systemVariables 驱动和客户端工具在建立连接之后经常读取的系统变量，dbproxy 直接返回结果
+// 不在这里的变量会交给后端处理
+var systemVariables = map[string]systemVariable{
+	"version":                        {value: DefaultServerVersion, local: true, readOnly: true},
+	"version_comment":                {value: "dbproxy", local: true, readOnly: true},
+	"max_allowed_packet":             {local: true, readOnly: true},
+	"wait_timeout":                   {local: true},
+	"interactive_timeout":            {local: true},
+	"net_write_timeout":              {value: "60", local: true},
+	"net_buffer_length":              {value: "16384", local: true},
+	"autocommit":                     {value: "ON", local: true, boolean: true},
+	"character_set_client":           {local: true},
+	"character_set_connection":       {local: true},
+	"character_set_results":          {local: true, nullable: true},
+	"collation_connection":           {local: true},
+	"character_set_server":           {value: "utf8mb4", local: true},
+	"collation_server":               {value: "utf8mb4_0900_ai_ci", local: true},
+	"character_set_database":         {value: "utf8mb4", local: true},
+	"collation_database":             {value: "utf8mb4_0900_ai_ci", local: true},
+	"character_set_system":           {value: "utf8mb3", local: true, readOnly: true},
+	"init_connect":                   {value: "", local: true, readOnly: true},
+	"lower_case_table_names":         {value: "0", local: true, readOnly: true},
+	"performance_schema":             {value: "OFF", local: true, readOnly: true, boolean: true},
+	"session_track_system_variables": {value: "time_zone,autocommit,character_set_client,character_set_results,character_set_connection", local: true},
+	"transaction_isolation":          {value: "REPEATABLE-READ", local: true},
+	"transaction_read_only":          {value: "OFF", local: true, boolean: true},
+	"sql_mode":                       {value: "ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES,NO_ZERO_IN_DATE,NO_ZERO_DATE,ERROR_FOR_DIVISION_BY_ZERO,NO_ENGINE_SUBSTITUTION"},
+	"time_zone":                      {value: "SYSTEM"},
+	"system_time_zone":               {value: "UTC", local: true, readOnly: true},
+	"auto_increment_increment":       {value: "1"},
+	"sql_select_limit":               {value: "18446744073709551615"},
+	"max_execution_time":             {value: "0"},
+	"sql_safe_updates":               {value: "OFF", boolean: true},
+	"foreign_key_checks":             {value: "ON", boolean: true},
+	"unique_checks":                  {value: "ON", boolean: true},
+}
+
+// systemVariableAliases 旧版本的变量名
+var systemVariableAliases = map[string]string{
+	"tx_isolation": "transaction_isolation",
+	"tx_read_only": "transaction_read_only",
+}
+
+// collation 握手的时候客户端通过 id 指定的 collation
+type collation struct {
+	charset string
+	name    string
+}
+
+// collations 常用的 collation，key 是 id
+var collations = map[uint32]collation{
+	8:   {charset: "latin1", name: "latin1_swedish_ci"},
+	11:  {charset: "ascii", name: "ascii_general_ci"},
+	28:  {charset: "gbk", name: "gbk_chinese_ci"},
+	33:  {charset: "utf8mb3", name: "utf8mb3_general_ci"},
+	45:  {charset: "utf8mb4", name: "utf8mb4_general_ci"},
+	46:  {charset: "utf8mb4", name: "utf8mb4_bin"},
+	63:  {charset: "binary", name: "binary"},
+	83:  {charset: "utf8mb3", name: "utf8mb3_bin"},
+	224: {charset: "utf8mb4", name: "utf8mb4_unicode_ci"},
+	255: {charset: "utf8mb4", name: "utf8mb4_0900_ai_ci"},
+}
+
+// defaultCollations 字符集默认的 collation
+var defaultCollations = map[string]string{
+	"latin1":  "latin1_swedish_ci",
+	"ascii":   "ascii_general_ci",
+	"gbk":     "gbk_chinese_ci",
+	"utf8mb3": "utf8mb3_general_ci",
+	"utf8mb4": "utf8mb4_0900_ai_ci",
+	"binary":  "binary",
+}
+
+// SystemVariable 当前连接的系统变量，第二个返回值表示 dbproxy 是否认识这个变量
+// 不认识的变量需要交给后端处理
+func (mc *Conn) SystemVariable(name string) (sql.NullString, bool) {
+	name = canonicalSystemVariable(name)
+	if v, ok := mc.sysVars[name]; ok {
+		return v, true
+	}
+	v, ok := mc.defaultSystemVariable(name)
+	return sql.NullString{String: v, Valid: true}, ok
+}
+
+// defaultSystemVariable 系统变量的默认值，和连接相关的变量由连接决定
+func (mc *Conn) defaultSystemVariable(name string) (string, bool) {
+	switch name {
+	case "version":
+		if mc.serverVersion != "" {
+			return mc.serverVersion, true
+		}
+	case "max_allowed_packet":
+		return strconv.Itoa(mc.maxAllowedPacket), true
+	case "wait_timeout", "interactive_timeout":
+		if mc.waitTimeout <= 0 {
+			// 不限制的时候和 MySQL 的默认值一样
+			return "28800", true
+		}
+		return strconv.Itoa(int(mc.waitTimeout / time.Second)), true
+	case "character_set_client", "character_set_connection", "character_set_results":
+		return mc.handshakeCollation().charset, true
+	case "collation_connection":
+		return mc.handshakeCollation().name, true
+	}
+	v, ok := systemVariables[name]
+	return v.value, ok
+}
+
+// handshakeCollation 握手的时候客户端指定的 collation，不认识的时候使用 utf8mb4
+func (mc *Conn) handshakeCollation() collation {
+	if c, ok := collations[mc.characterSet]; ok {
+		return c
+	}
+	return collations[255]
+}
+
+// SetSystemVariables 执行 SET 语句，修改当前连接的系统变量
+// 所有的赋值都校验通过之后，调用 apply 把需要在后端生效的变量设置到后端，参考 BackendSystemVariables
+// 校验失败或者 apply 返回 error 的时候不会修改任何变量，apply 为 nil 的时候只修改连接上的变量
+// 返回客户端要求跟踪的变量，也就是 session_track_system_variables 中的变量，用于在 OK_Packet 中返回
+func (mc *Conn) SetSystemVariables(vars []SystemVariable, apply func(backend map[string]string) error) ([]SystemVariable, error) {
+	sysVars := maps.Clone(mc.sysVars)
+	if sysVars == nil {
+		sysVars = make(map[string]sql.NullString, len(vars))
+	}
+	for _, v := range vars {
+		name := canonicalSystemVariable(v.Name)
+		value, err := mc.checkSystemVariable(name, v)
+		if err != nil {
+			return nil, err
+		}
+		if v.Default {
+			delete(sysVars, name)
+		} else {
+			sysVars[name] = value
+		}
+		mc.syncCharset(sysVars, name, value, v.Default)
+	}
+	if apply != nil {
+		if err := apply(backendSystemVariables(sysVars)); err != nil {
+			return nil, err
+		}
+	}
+	mc.sysVars = sysVars
+	return mc.trackedSystemVariables(vars), nil
+}
+
+// checkSystemVariable 校验赋值，返回规范化之后的值
+func (mc *Conn) checkSystemVariable(name string, v SystemVariable) (sql.NullString, error) {
+	def, ok := systemVariables[name]
+	if !ok {
+		// 不认识的变量交给后端校验
+		if !v.Default && !v.Value.Valid {
+			return v.Value, errs.NewWrongValueForVarError(name, "NULL")
+		}
+		return v.Value, nil
+	}
+	if def.readOnly {
+		return v.Value, errs.NewReadOnlyVarError(name)
+	}
+	if v.Default {
+		return v.Value, nil
+	}
+	if !v.Value.Valid {
+		if def.nullable {
+			return v.Value, nil
+		}
+		return v.Value, errs.NewWrongValueForVarError(name, "NULL")
+	}
+	value := v.Value.String
+	switch {
+	case def.boolean:
+		switch strings.ToUpper(value) {
+		case "1", "ON", "TRUE":
+			value = "ON"
+		case "0", "OFF", "FALSE":
+			value = "OFF"
+		default:
+			return v.Value, errs.NewWrongValueForVarError(name, value)
+		}
+	case name == "wait_timeout" || name == "interactive_timeout":
+		if seconds, err := strconv.Atoi(value); err != nil || seconds < 1 {
+			return v.Value, errs.NewWrongValueForVarError(name, value)
+		}
+	case strings.HasPrefix(name, "character_set_") || strings.HasPrefix(name, "collation_"):
+		value = strings.ToLower(value)
+		if value == "utf8" {
+			// 和 MySQL 8.0 一样，utf8 是 utf8mb3 的别名
+			value = "utf8mb3"
+		}
+	case name == "transaction_isolation":
+		value = strings.ToUpper(value)
+		switch value {
+		case "READ-UNCOMMITTED", "READ-COMMITTED", "REPEATABLE-READ", "SERIALIZABLE":
+		default:
+			return v.Value, errs.NewWrongValueForVarError(name, v.Value.String)
+		}
+	}
+	return sql.NullString{String: value, Valid: true}, nil
+}
+
+// syncCharset character_set_connection 和 collation_connection 总是一起变化的
+func (mc *Conn) syncCharset(sysVars map[string]sql.NullString, name string, value sql.NullString, isDefault bool) {
+	switch name {
+	case "character_set_connection":
+		if isDefault {
+			delete(sysVars, "collation_connection")
+			return
+		}
+		if c, ok := defaultCollations[value.String]; ok {
+			sysVars["collation_connection"] = sql.NullString{String: c, Valid: true}
+		}
+	case "collation_connection":
+		if isDefault {
+			delete(sysVars, "character_set_connection")
+			return
+		}
+		charset, _, _ := strings.Cut(value.String, "_")
+		sysVars["character_set_connection"] = sql.NullString{String: charset, Valid: true}
+	}
+}
+
+// trackedSystemVariables 从 vars 中找出客户端要求跟踪的变量，值是修改之后的值
+func (mc *Conn) trackedSystemVariables(vars []SystemVariable) []SystemVariable {
+	tracked, _ := mc.SystemVariable("session_track_system_variables")
+	all := tracked.String == "*"
+	names := strings.Split(tracked.String, ",")
+	var res []SystemVariable
+	for _, v := range vars {
+		name := canonicalSystemVariable(v.Name)
+		if !all && !containsFold(names, name) {
+			continue
+		}
+		value, _ := mc.SystemVariable(name)
+		res = append(res, SystemVariable{Name: name, Value: value})
+	}
+	return res
+}
+
+// BackendSystemVariables 客户端修改过的、需要在后端连接上生效的系统变量
+// 后端的连接是多个客户端共用的，插件需要在使用独占的连接的时候设置，例如事务
+func (mc *Conn) BackendSystemVariables() map[string]string {
+	return backendSystemVariables(mc.sysVars)
+}
+
+func backendSystemVariables(sysVars map[string]sql.NullString) map[string]string {
+	var res map[string]string
+	for name, v := range sysVars {
+		if systemVariables[name].local {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]string, len(sysVars))
+		}
+		res[name] = v.String
+	}
+	return res
+}
+
+func canonicalSystemVariable(name string) string {
+	name = strings.ToLower(name)
+	if alias, ok := systemVariableAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(strings.TrimSpace(n), name) {
+			return true
+		}
+	}
+	return false
+}
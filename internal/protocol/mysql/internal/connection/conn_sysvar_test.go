@@ -0,0 +1,189 @@
+package connection
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn_SystemVariable(t *testing.T) {
+	mc := &Conn{maxAllowedPacket: 1024, waitTimeout: time.Minute, characterSet: 45}
+	tests := []struct {
+		name    string
+		varName string
+		want    sql.NullString
+		wantOK  bool
+	}{
+		{name: "版本", varName: "version", want: str(DefaultServerVersion), wantOK: true},
+		{name: "由连接决定", varName: "max_allowed_packet", want: str("1024"), wantOK: true},
+		{name: "空闲时间", varName: "WAIT_TIMEOUT", want: str("60"), wantOK: true},
+		{name: "握手时的字符集", varName: "character_set_client", want: str("utf8mb4"), wantOK: true},
+		{name: "握手时的collation", varName: "collation_connection", want: str("utf8mb4_general_ci"), wantOK: true},
+		{name: "别名", varName: "tx_isolation", want: str("REPEATABLE-READ"), wantOK: true},
+		{name: "不认识的变量", varName: "innodb_lock_wait_timeout", want: str(""), wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mc.SystemVariable(tt.varName)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConn_SetSystemVariables(t *testing.T) {
+	mockErr := errors.New("mock error")
+	tests := []struct {
+		name        string
+		vars        []SystemVariable
+		apply       func(backend map[string]string) error
+		wantErr     error
+		wantTracked []SystemVariable
+		wantValues  map[string]sql.NullString
+		wantBackend map[string]string
+	}{
+		{
+			name: "SET NAMES",
+			vars: []SystemVariable{
+				{Name: "character_set_client", Value: str("latin1")},
+				{Name: "character_set_connection", Value: str("latin1")},
+				{Name: "character_set_results", Value: str("latin1")},
+			},
+			wantTracked: []SystemVariable{
+				{Name: "character_set_client", Value: str("latin1")},
+				{Name: "character_set_connection", Value: str("latin1")},
+				{Name: "character_set_results", Value: str("latin1")},
+			},
+			wantValues: map[string]sql.NullString{
+				"collation_connection": str("latin1_swedish_ci"),
+			},
+		},
+		{
+			name: "修改collation",
+			vars: []SystemVariable{{Name: "collation_connection", Value: str("utf8mb4_bin")}},
+			wantValues: map[string]sql.NullString{
+				"character_set_connection": str("utf8mb4"),
+			},
+		},
+		{
+			name:        "布尔值",
+			vars:        []SystemVariable{{Name: "autocommit", Value: str("0")}, {Name: "unique_checks", Value: str("false")}},
+			wantTracked: []SystemVariable{{Name: "autocommit", Value: str("OFF")}},
+			wantValues: map[string]sql.NullString{
+				"autocommit":    str("OFF"),
+				"unique_checks": str("OFF"),
+			},
+			wantBackend: map[string]string{"unique_checks": "OFF"},
+		},
+		{
+			name: "需要在后端生效",
+			vars: []SystemVariable{
+				{Name: "time_zone", Value: str("+08:00")},
+				{Name: "tx_isolation", Value: str("read-committed")},
+				{Name: "innodb_lock_wait_timeout", Value: str("10")},
+			},
+			wantTracked: []SystemVariable{{Name: "time_zone", Value: str("+08:00")}},
+			wantValues: map[string]sql.NullString{
+				"transaction_isolation": str("READ-COMMITTED"),
+			},
+			// 事务特性通过 sql.TxOptions 传给后端
+			wantBackend: map[string]string{
+				"time_zone":                "+08:00",
+				"innodb_lock_wait_timeout": "10",
+			},
+		},
+		{
+			name: "跟踪全部变量",
+			vars: []SystemVariable{
+				{Name: "session_track_system_variables", Value: str("*")},
+				{Name: "sql_mode", Value: str("")},
+			},
+			wantTracked: []SystemVariable{
+				{Name: "session_track_system_variables", Value: str("*")},
+				{Name: "sql_mode", Value: str("")},
+			},
+			wantBackend: map[string]string{"sql_mode": ""},
+		},
+		{
+			name: "NULL",
+			vars: []SystemVariable{{Name: "character_set_results", Value: sql.NullString{}}},
+			wantTracked: []SystemVariable{
+				{Name: "character_set_results", Value: sql.NullString{}},
+			},
+		},
+		{
+			name:    "不能设置成NULL",
+			vars:    []SystemVariable{{Name: "time_zone", Value: str("+08:00")}, {Name: "sql_mode", Value: sql.NullString{}}},
+			wantErr: errs.ErrWrongValueForVar,
+		},
+		{
+			name:    "非法的值",
+			vars:    []SystemVariable{{Name: "autocommit", Value: str("2")}},
+			wantErr: errs.ErrWrongValueForVar,
+		},
+		{
+			name:    "只读",
+			vars:    []SystemVariable{{Name: "max_allowed_packet", Value: str("1")}},
+			wantErr: errs.ErrReadOnlyVar,
+		},
+		{
+			name: "后端设置失败",
+			vars: []SystemVariable{{Name: "sql_mode", Value: str("abc")}},
+			apply: func(backend map[string]string) error {
+				assert.Equal(t, map[string]string{"sql_mode": "abc"}, backend)
+				return mockErr
+			},
+			wantErr: mockErr,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := &Conn{characterSet: 255}
+			tracked, err := mc.SetSystemVariables(tt.vars, tt.apply)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				// 失败的时候不会修改任何变量
+				assert.Nil(t, mc.sysVars)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantTracked, tracked)
+			for name, want := range tt.wantValues {
+				got, _ := mc.SystemVariable(name)
+				assert.Equal(t, want, got, name)
+			}
+			assert.Equal(t, tt.wantBackend, mc.BackendSystemVariables())
+		})
+	}
+}
+
+func TestConn_SetSystemVariablesDefault(t *testing.T) {
+	mc := &Conn{characterSet: 255}
+	_, err := mc.SetSystemVariables([]SystemVariable{
+		{Name: "character_set_connection", Value: str("latin1")},
+		{Name: "wait_timeout", Value: str("10")},
+		{Name: "time_zone", Value: str("+08:00")},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, mc.idleTimeout())
+
+	_, err = mc.SetSystemVariables([]SystemVariable{
+		{Name: "character_set_connection", Default: true},
+		{Name: "wait_timeout", Default: true},
+		{Name: "time_zone", Default: true},
+	}, nil)
+	require.NoError(t, err)
+	got, _ := mc.SystemVariable("collation_connection")
+	assert.Equal(t, str("utf8mb4_0900_ai_ci"), got)
+	assert.Equal(t, time.Duration(0), mc.idleTimeout())
+	assert.Nil(t, mc.BackendSystemVariables())
+}
+
+func str(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: true}
+}
@@ -1 +1,125 @@
 package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn_Cancel(t *testing.T) {
+	// cmdErrs 记录 COM_QUERY 命令结束的原因
+	cmdErrs := make(chan error, 1)
+	onCmd := func(ctx context.Context, conn *Conn, payload []byte) error {
+		if payload[0] == 0x03 {
+			// 模拟一个执行很久的查询
+			select {
+			case <-ctx.Done():
+				cmdErrs <- ctx.Err()
+				b := builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.NewError(ctx.Err()))
+				return conn.WritePacket(b.Build())
+			case <-time.After(3 * time.Second):
+				cmdErrs <- nil
+			}
+		}
+		b := builder.NewOKPacket(conn.ClientCapabilityFlags(), flags.ServerStatusAutoCommit)
+		return conn.WritePacket(b.Build())
+	}
+
+	t.Run("KILL QUERY", func(t *testing.T) {
+		conns := make(chan *Conn, 1)
+		addr := startTestServerWithCmd(t, onCmd, conns)
+		db, err := sql.Open("mysql", fmt.Sprintf("root:root@tcp(%s)/test", addr))
+		require.NoError(t, err)
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+		require.NoError(t, db.Ping())
+		conn := <-conns
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			conn.KillQuery()
+		}()
+		_, err = db.Exec("UPDATE users SET name = 'Tom'")
+		assertMySQLError(1317)(t, err)
+		assert.ErrorIs(t, <-cmdErrs, context.Canceled)
+		// 只是取消了语句，连接还可以继续使用
+		assert.NoError(t, db.Ping())
+	})
+
+	t.Run("命令超时", func(t *testing.T) {
+		addr := startTestServerWithCmd(t, onCmd, nil, WithCmdTimeout(100*time.Millisecond))
+		db, err := sql.Open("mysql", fmt.Sprintf("root:root@tcp(%s)/test", addr))
+		require.NoError(t, err)
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		_, err = db.Exec("UPDATE users SET name = 'Tom'")
+		assertMySQLError(3024)(t, err)
+		assert.ErrorIs(t, <-cmdErrs, context.DeadlineExceeded)
+		// 每个命令都有自己的超时时间，连接还可以继续使用
+		assert.NoError(t, db.Ping())
+	})
+
+	t.Run("客户端断开连接", func(t *testing.T) {
+		addr := startTestServerWithCmd(t, onCmd, nil)
+		db, err := sql.Open("mysql", fmt.Sprintf("root:root@tcp(%s)/test", addr))
+		require.NoError(t, err)
+		defer db.Close()
+
+		// 超时之后驱动会关闭连接
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err = db.ExecContext(ctx, "UPDATE users SET name = 'Tom'")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, <-cmdErrs, context.Canceled)
+	})
+
+	t.Run("提前发送下一个命令", func(t *testing.T) {
+		pingCmd := func(ctx context.Context, conn *Conn, payload []byte) error {
+			// 给探测客户端断开连接的 goroutine 留出读取数据的时间
+			time.Sleep(100 * time.Millisecond)
+			b := builder.NewOKPacket(conn.ClientCapabilityFlags(), flags.ServerStatusAutoCommit)
+			return conn.WritePacket(b.Build())
+		}
+		addr := startTestServerWithCmd(t, pingCmd, nil)
+		rawConn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer rawConn.Close()
+		require.NoError(t, rawConn.SetDeadline(time.Now().Add(3*time.Second)))
+		handshakeTestConn(t, rawConn)
+
+		// 不等响应就发送两个 COM_PING
+		require.NoError(t, writeTestPacket(rawConn, 0, []byte{0x0e}))
+		require.NoError(t, writeTestPacket(rawConn, 0, []byte{0x0e}))
+		for i := 0; i < 2; i++ {
+			ok, err := readTestPacket(rawConn)
+			require.NoError(t, err)
+			assert.Equal(t, byte(0x00), ok[0])
+		}
+	})
+}
+
+// handshakeTestConn 使用原始的协议完成握手，服务端不能校验密码
+func handshakeTestConn(t *testing.T, rawConn net.Conn) {
+	_, err := readTestPacket(rawConn)
+	require.NoError(t, err)
+	resp := []byte{
+		0x00, 0x02, 0x00, 0x00, // client_flag CLIENT_PROTOCOL_41
+		0x00, 0x00, 0x00, 0x01, // max_packet_size
+		0x2d, // character_set
+	}
+	resp = append(resp, make([]byte, 23)...)
+	resp = append(resp, "root\x00\x00"...)
+	require.NoError(t, writeTestPacket(rawConn, 1, resp))
+	ok, err := readTestPacket(rawConn)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x00), ok[0])
+}
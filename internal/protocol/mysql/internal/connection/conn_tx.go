@@ -0,0 +1,68 @@
+package connection
+
+import (
+	"database/sql"
+	"maps"
+)
+
+// isolationLevels transaction_isolation 的值对应的隔离级别
+var isolationLevels = map[string]sql.IsolationLevel{
+	"READ-UNCOMMITTED": sql.LevelReadUncommitted,
+	"READ-COMMITTED":   sql.LevelReadCommitted,
+	"REPEATABLE-READ":  sql.LevelRepeatableRead,
+	"SERIALIZABLE":     sql.LevelSerializable,
+}
+
+// AutoCommit 客户端是否开启了 autocommit，关闭的时候执行语句之前需要隐式地开启事务
+func (mc *Conn) AutoCommit() bool {
+	v, _ := mc.SystemVariable("autocommit")
+	return v.String != "OFF"
+}
+
+// SetNextTransaction 执行没有指定作用域的 SET TRANSACTION，设置的特性只对下一个事务生效
+// 校验失败的时候不会修改任何特性
+func (mc *Conn) SetNextTransaction(vars []SystemVariable) error {
+	nextTxVars := maps.Clone(mc.nextTxVars)
+	if nextTxVars == nil {
+		nextTxVars = make(map[string]string, len(vars))
+	}
+	for _, v := range vars {
+		name := canonicalSystemVariable(v.Name)
+		value, err := mc.checkSystemVariable(name, v)
+		if err != nil {
+			return err
+		}
+		nextTxVars[name] = value.String
+	}
+	mc.nextTxVars = nextTxVars
+	return nil
+}
+
+// TakeTxOptions 开启事务时使用的事务特性，SET TRANSACTION 设置的特性优先于连接上的系统变量
+// SET TRANSACTION 设置的特性只生效一次，调用之后就会被清空。
+// 客户端没有修改过事务特性的时候返回 nil，也就是使用后端的默认值
+func (mc *Conn) TakeTxOptions() *sql.TxOptions {
+	defer func() {
+		mc.nextTxVars = nil
+	}()
+	var opts *sql.TxOptions
+	if level, ok := mc.txVariable("transaction_isolation"); ok {
+		opts = &sql.TxOptions{Isolation: isolationLevels[level]}
+	}
+	if readOnly, ok := mc.txVariable("transaction_read_only"); ok {
+		if opts == nil {
+			opts = &sql.TxOptions{}
+		}
+		opts.ReadOnly = readOnly == "ON"
+	}
+	return opts
+}
+
+// txVariable 客户端修改过的事务特性，第二个返回值为 false 表示没有修改过
+func (mc *Conn) txVariable(name string) (string, bool) {
+	if v, ok := mc.nextTxVars[name]; ok {
+		return v, true
+	}
+	v, ok := mc.sysVars[name]
+	return v.String, ok
+}
@@ -0,0 +1,82 @@
+package connection
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn_TakeTxOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		sysVars []SystemVariable
+		nextTx  []SystemVariable
+		want    *sql.TxOptions
+		// wantAfter 再次开启事务时使用的特性，SET TRANSACTION 只对一个事务生效
+		wantAfter *sql.TxOptions
+	}{
+		{
+			name: "没有修改过",
+		},
+		{
+			name:      "连接上的隔离级别",
+			sysVars:   []SystemVariable{{Name: "tx_isolation", Value: str("read-committed")}},
+			want:      &sql.TxOptions{Isolation: sql.LevelReadCommitted},
+			wantAfter: &sql.TxOptions{Isolation: sql.LevelReadCommitted},
+		},
+		{
+			name:      "连接上的只读事务",
+			sysVars:   []SystemVariable{{Name: "transaction_read_only", Value: str("1")}},
+			want:      &sql.TxOptions{ReadOnly: true},
+			wantAfter: &sql.TxOptions{ReadOnly: true},
+		},
+		{
+			name:   "下一个事务",
+			nextTx: []SystemVariable{{Name: "transaction_isolation", Value: str("SERIALIZABLE")}, {Name: "transaction_read_only", Value: str("ON")}},
+			want:   &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true},
+		},
+		{
+			name:      "下一个事务优先",
+			sysVars:   []SystemVariable{{Name: "transaction_isolation", Value: str("READ-COMMITTED")}},
+			nextTx:    []SystemVariable{{Name: "transaction_isolation", Value: str("REPEATABLE-READ")}},
+			want:      &sql.TxOptions{Isolation: sql.LevelRepeatableRead},
+			wantAfter: &sql.TxOptions{Isolation: sql.LevelReadCommitted},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := &Conn{}
+			_, err := mc.SetSystemVariables(tt.sysVars, nil)
+			require.NoError(t, err)
+			require.NoError(t, mc.SetNextTransaction(tt.nextTx))
+			assert.Equal(t, tt.want, mc.TakeTxOptions())
+			assert.Equal(t, tt.wantAfter, mc.TakeTxOptions())
+		})
+	}
+}
+
+func TestConn_SetNextTransaction(t *testing.T) {
+	mc := &Conn{}
+	require.NoError(t, mc.SetNextTransaction([]SystemVariable{{Name: "transaction_read_only", Value: str("ON")}}))
+	err := mc.SetNextTransaction([]SystemVariable{
+		{Name: "transaction_isolation", Value: str("READ-COMMITTED")},
+		{Name: "transaction_isolation", Value: str("abc")},
+	})
+	assert.ErrorIs(t, err, errs.ErrWrongValueForVar)
+	// 失败的时候不会修改之前设置的特性
+	assert.Equal(t, &sql.TxOptions{ReadOnly: true}, mc.TakeTxOptions())
+}
+
+func TestConn_AutoCommit(t *testing.T) {
+	mc := &Conn{}
+	assert.True(t, mc.AutoCommit())
+	_, err := mc.SetSystemVariables([]SystemVariable{{Name: "autocommit", Value: str("0")}}, nil)
+	require.NoError(t, err)
+	assert.False(t, mc.AutoCommit())
+	_, err = mc.SetSystemVariables([]SystemVariable{{Name: "autocommit", Default: true}}, nil)
+	require.NoError(t, err)
+	assert.True(t, mc.AutoCommit())
+}
@@ -5,21 +5,48 @@ import (
 	"time"
 
 	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
 )
 
 // WritePacket 写入一个 packet
 // https://mariadb.com/kb/en/0-packet/
 // 注意：
-// 1. WritePacket 并不会执行拆包。也就是说如果你的 data 需要多个报文来发送，那么你需要自己手动拆分
+// 1. payload 超过 MaxPacketSize 的时候，会被拆分成多个报文发送，data 中的数据会被修改
 // 2. 你需要在 data 里面预留出来四个字节的头部字段
 func (mc *Conn) WritePacket(data []byte) error {
+	pktLen := len(data) - 4
+	for {
+		size := min(pktLen, packet.MaxPacketSize)
+		// 每个报文的头部直接覆盖在上一个报文的末尾，那部分数据已经发送出去了
+		pkt, err := builder.NewSetHeader(mc.sequence, data[:4+size]).Build()
+		if err != nil {
+			return err
+		}
+		if err = mc.write(pkt); err != nil {
+			return err
+		}
+		// sequence 超过 255 之后从 0 开始
+		mc.sequence++
+		// 长度刚好是 MaxPacketSize 的报文后面必须还有一个报文，哪怕是空报文，客户端才知道数据结束了
+		if size < packet.MaxPacketSize {
+			return nil
+		}
+		pktLen -= size
+		data = data[size:]
+	}
+}
 
-	data, err := builder.NewSetHeader(mc.sequence, data).Build()
-	if err != nil {
-		return err
+// write 写入一个完整的报文，开启了压缩协议的时候会先缓存起来
+func (mc *Conn) write(data []byte) error {
+	if mc.compress != nil {
+		return mc.writeCompressed(data)
 	}
+	return mc.writeRaw(data)
+}
 
+// writeRaw 直接将数据写入底层连接
+func (mc *Conn) writeRaw(data []byte) error {
 	// 设置回写的超时时间
 	if mc.writeTimeout > 0 {
 		if err := mc.conn.SetWriteDeadline(time.Now().Add(mc.writeTimeout)); err != nil {
@@ -39,6 +66,5 @@ func (mc *Conn) WritePacket(data []byte) error {
 	if n != len(data) {
 		return fmt.Errorf("%w: 写入数据失败, 未写入足够数据，预期写入：%d，实际写入：%d", errs.ErrInvalidConn, len(data), n)
 	}
-	mc.sequence++
 	return nil
 }
@@ -2,7 +2,13 @@ package connection
 
 import (
 	"encoding/binary"
+	"io"
+	"net"
 	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestErrorPacketExample 这是一个 Error packet 的例子
@@ -25,3 +31,81 @@ func TestErrorPacketExample(t *testing.T) {
 	// no table used
 	t.Log(string(data[13:]))
 }
+
+func TestConn_WritePacket(t *testing.T) {
+	tests := []struct {
+		name       string
+		sequence   uint8
+		payloadLen int
+
+		// 客户端收到的每个报文的长度和 sequence
+		wantLens      []int
+		wantSequences []uint8
+	}{
+		{
+			name:          "单个报文",
+			payloadLen:    10,
+			wantLens:      []int{10},
+			wantSequences: []uint8{0},
+		},
+		{
+			name:          "刚好是MaxPacketSize",
+			sequence:      1,
+			payloadLen:    packet.MaxPacketSize,
+			wantLens:      []int{packet.MaxPacketSize, 0},
+			wantSequences: []uint8{1, 2},
+		},
+		{
+			name:          "拆分成三个报文并且sequence超过255",
+			sequence:      254,
+			payloadLen:    packet.MaxPacketSize*2 + 5,
+			wantLens:      []int{packet.MaxPacketSize, packet.MaxPacketSize, 5},
+			wantSequences: []uint8{254, 255, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := NewConn(1, server, nil)
+			defer conn.Close()
+			conn.sequence = tt.sequence
+
+			payload := make([]byte, tt.payloadLen)
+			for i := range payload {
+				payload[i] = byte(i)
+			}
+			type result struct {
+				lens      []int
+				sequences []uint8
+				payload   []byte
+			}
+			results := make(chan result, 1)
+			go func() {
+				var res result
+				for range tt.wantLens {
+					header := make([]byte, 4)
+					if _, err := io.ReadFull(client, header); err != nil {
+						break
+					}
+					n := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+					body := make([]byte, n)
+					if _, err := io.ReadFull(client, body); err != nil {
+						break
+					}
+					res.lens = append(res.lens, n)
+					res.sequences = append(res.sequences, header[3])
+					res.payload = append(res.payload, body...)
+				}
+				results <- res
+			}()
+			err := conn.WritePacket(append(make([]byte, 4), payload...))
+			require.NoError(t, err)
+			res := <-results
+			assert.Equal(t, tt.wantLens, res.lens)
+			assert.Equal(t, tt.wantSequences, res.sequences)
+			assert.Equal(t, payload, res.payload)
+			assert.Equal(t, tt.wantSequences[len(tt.wantSequences)-1]+1, conn.sequence)
+		})
+	}
+}
@@ -1,22 +1,91 @@
 package flags
 
+import (
+	"fmt"
+	"strings"
+)
+
 // CapabilityFlag
 // 这里我们按需定义，只把用到了的添加到这里
 // https://dev.mysql.com/doc/dev/mysql-server/latest/group__group__cs__capabilities__flags.html
 type CapabilityFlag uint64
 
 const (
+	// ClientLongPassword
+	// Use the improved version of Old Password Authentication.
+	ClientLongPassword CapabilityFlag = 1
+
+	// ClientFoundRows
+	// Send found rows instead of affected rows in EOF_Packet.
+	ClientFoundRows = 2
+
+	// ClientLongFlag
+	// Get all column flags.
+	ClientLongFlag = 4
+
+	// ClientConnectWithDB
+	// Database (schema) name can be specified on connect in Handshake Response Packet.
+	ClientConnectWithDB = 8
+
+	// ClientCompress
+	// Compression protocol supported. 使用 zlib 算法
+	ClientCompress = 32
+
+	// ClientLocalFiles
+	// Can use LOAD DATA LOCAL.
+	ClientLocalFiles = 128
+
+	// ClientIgnoreSpace
+	// Ignore spaces before '('.
+	ClientIgnoreSpace = 256
+
 	// ClientProtocol41  New 4.1 protocol
 	ClientProtocol41 CapabilityFlag = 512
 
+	// ClientInteractive
+	// This is an interactive client. 使用 interactive_timeout 作为空闲超时时间
+	ClientInteractive = 1024
+
+	// ClientSSL
+	// Use SSL encryption for the session.
+	ClientSSL = 2048
+
 	// ClientTransactions
 	// Client knows about transactions
 	ClientTransactions = 8192
 
+	// ClientSecureConnection
+	// Client supports Authentication::Native41.
+	ClientSecureConnection = 1 << 15
+
+	// ClientMultiStatements
+	// Enable/disable multi-stmt support.
+	ClientMultiStatements = 1 << 16
+
+	// ClientMultiResults
+	// Enable/disable multi-results.
+	ClientMultiResults = 1 << 17
+
+	// ClientPSMultiResults
+	// Multi-results and OUT parameters in PS-protocol.
+	ClientPSMultiResults = 1 << 18
+
 	// ClientPluginAuth
 	// Client supports plugin authentication.
 	ClientPluginAuth = 1 << 19
 
+	// ClientConnectAttrs
+	// Client supports connection attributes.
+	ClientConnectAttrs = 1 << 20
+
+	// ClientPluginAuthLenencClientData
+	// Enable authentication response packet to be larger than 255 bytes.
+	ClientPluginAuthLenencClientData = 1 << 21
+
+	// ClientCanHandleExpiredPasswords
+	// Don't close the connection for a user account with expired password.
+	ClientCanHandleExpiredPasswords = 1 << 22
+
 	// ClientSessionTrack
 	// Capable of handling server state change information
 	ClientSessionTrack = 1 << 23
@@ -29,11 +98,69 @@ const (
 	// The client can handle optional metadata information in the resultset.
 	ClientOptionalResultsetMetadata = 1 << 25
 
+	// ClientZstdCompressionAlgorithm
+	// Compression protocol extended to support zstd compression method.
+	ClientZstdCompressionAlgorithm = 1 << 26
+
 	// ClientQueryAttributes
 	// Support optional extension for query parameters into the COM_QUERY and COM_STMT_EXECUTE packets.
 	ClientQueryAttributes = 1 << 27
 )
 
+// DefaultServerCapabilities dbproxy 默认在握手的时候声明的能力，也就是 dbproxy 能够处理的全部能力
+// 客户端最终使用的是客户端和服务端能力的交集。
+// 没有声明 CLIENT_FOUND_ROWS、CLIENT_LOCAL_FILES 和 CLIENT_IGNORE_SPACE，它们需要后端连接配合，dbproxy 做不到
+const DefaultServerCapabilities = CapabilityFlags(ClientLongPassword | ClientLongFlag | ClientConnectWithDB |
+	ClientCompress | ClientProtocol41 | ClientInteractive | ClientSSL | ClientTransactions | ClientSecureConnection |
+	ClientMultiStatements | ClientMultiResults | ClientPSMultiResults | ClientPluginAuth | ClientConnectAttrs |
+	ClientPluginAuthLenencClientData | ClientSessionTrack | ClientDeprecateEOF | ClientOptionalResultsetMetadata |
+	ClientZstdCompressionAlgorithm | ClientQueryAttributes)
+
+// RequiredServerCapabilities 握手的时候总是会声明的能力，dbproxy 只支持 4.1 之后的协议和插件鉴权
+const RequiredServerCapabilities = CapabilityFlags(ClientProtocol41 | ClientSecureConnection | ClientPluginAuth)
+
+// capabilityNames 能力的名字，和 MySQL 文档中的一样，用于配置
+var capabilityNames = map[string]CapabilityFlag{
+	"CLIENT_LONG_PASSWORD":                  ClientLongPassword,
+	"CLIENT_LONG_FLAG":                      ClientLongFlag,
+	"CLIENT_CONNECT_WITH_DB":                ClientConnectWithDB,
+	"CLIENT_COMPRESS":                       ClientCompress,
+	"CLIENT_PROTOCOL_41":                    ClientProtocol41,
+	"CLIENT_INTERACTIVE":                    ClientInteractive,
+	"CLIENT_SSL":                            ClientSSL,
+	"CLIENT_TRANSACTIONS":                   ClientTransactions,
+	"CLIENT_SECURE_CONNECTION":              ClientSecureConnection,
+	"CLIENT_MULTI_STATEMENTS":               ClientMultiStatements,
+	"CLIENT_MULTI_RESULTS":                  ClientMultiResults,
+	"CLIENT_PS_MULTI_RESULTS":               ClientPSMultiResults,
+	"CLIENT_PLUGIN_AUTH":                    ClientPluginAuth,
+	"CLIENT_CONNECT_ATTRS":                  ClientConnectAttrs,
+	"CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA": ClientPluginAuthLenencClientData,
+	"CLIENT_SESSION_TRACK":                  ClientSessionTrack,
+	"CLIENT_DEPRECATE_EOF":                  ClientDeprecateEOF,
+	"CLIENT_OPTIONAL_RESULTSET_METADATA":    ClientOptionalResultsetMetadata,
+	"CLIENT_ZSTD_COMPRESSION_ALGORITHM":     ClientZstdCompressionAlgorithm,
+	"CLIENT_QUERY_ATTRIBUTES":               ClientQueryAttributes,
+}
+
+// ParseServerCapabilities 根据名字构造握手的时候声明的能力，例如 CLIENT_DEPRECATE_EOF
+// names 为空的时候返回 DefaultServerCapabilities，否则只包含 names 和 RequiredServerCapabilities。
+// 名字不区分大小写，dbproxy 不支持的能力会返回错误
+func ParseServerCapabilities(names []string) (CapabilityFlags, error) {
+	if len(names) == 0 {
+		return DefaultServerCapabilities, nil
+	}
+	res := RequiredServerCapabilities
+	for _, name := range names {
+		flag, ok := capabilityNames[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("dbproxy 不支持的能力 %s", name)
+		}
+		res |= CapabilityFlags(flag)
+	}
+	return res, nil
+}
+
 // CapabilityFlags 是客户端告诉服务端，它支持什么样的功能特性
 // 通过 connection.Conn 的 ClientCapabilityFlags 方法获取该信息
 type CapabilityFlags uint64
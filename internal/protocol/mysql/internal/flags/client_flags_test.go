@@ -0,0 +1,40 @@
+package flags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServerCapabilities(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    CapabilityFlags
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:    "默认",
+			want:    DefaultServerCapabilities,
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "总是包含必须的能力",
+			names:   []string{"CLIENT_DEPRECATE_EOF", " client_session_track "},
+			want:    RequiredServerCapabilities | CapabilityFlags(ClientDeprecateEOF|ClientSessionTrack),
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "不支持的能力",
+			names:   []string{"CLIENT_LOCAL_FILES"},
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseServerCapabilities(tt.names)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package builder
+
+// AuthMoreDataPacket 鉴权插件需要和客户端交换更多数据的时候使用
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_packets_protocol_auth_more_data.html
+type AuthMoreDataPacket struct {
+	// Data 鉴权插件的数据，例如 caching_sha2_password 的状态或者 RSA 公钥
+	Data []byte
+}
+
+func NewAuthMoreDataPacket(data []byte) *AuthMoreDataPacket {
+	return &AuthMoreDataPacket{
+		Data: data,
+	}
+}
+
+func (b *AuthMoreDataPacket) Build() []byte {
+	// 头部的四个字节保留，不需要填充
+	p := make([]byte, 4, 4+1+len(b.Data))
+
+	// int<1>	0x01	status tag
+	p = append(p, 0x01)
+
+	// string<EOF>	authentication method data	Extra authentication data beyond the initial challenge
+	p = append(p, b.Data...)
+	return p
+}
@@ -0,0 +1,30 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthMoreDataPacket_Build(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *AuthMoreDataPacket
+		want    []byte
+	}{
+		{
+			name:    "fast_auth_success",
+			builder: NewAuthMoreDataPacket([]byte{0x03}),
+			want: []byte{
+				0x02, 0x00, 0x00, 0x00, // packet header
+				0x01, // status tag
+				0x03, // authentication method data
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want[4:], tt.builder.Build()[4:])
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package builder
+
+import (
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/encoding"
+)
+
+// AuthSwitchRequestPacket 要求客户端换一个鉴权插件重新鉴权
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_packets_protocol_auth_switch_request.html
+type AuthSwitchRequestPacket struct {
+	// PluginName 要求客户端使用的鉴权插件
+	PluginName string
+	// PluginData 鉴权插件需要的数据，mysql_native_password 和 caching_sha2_password 都是 scramble
+	PluginData []byte
+}
+
+func NewAuthSwitchRequestPacket(pluginName string, pluginData []byte) *AuthSwitchRequestPacket {
+	return &AuthSwitchRequestPacket{
+		PluginName: pluginName,
+		PluginData: pluginData,
+	}
+}
+
+func (b *AuthSwitchRequestPacket) Build() []byte {
+	// 头部的四个字节保留，不需要填充
+	p := make([]byte, 4, 4+1+len(b.PluginName)+1+len(b.PluginData)+1)
+
+	// int<1>	0xFE (254)	status tag
+	p = append(p, 0xFE)
+
+	// string[NUL]	plugin name	name of the client authentication plugin to switch to
+	p = append(p, encoding.NullTerminatedString(b.PluginName)...)
+
+	// string[EOF]	plugin provided data	Initial authentication data for that client plugin
+	// 和握手的时候一样，scramble 后面跟着一个 0x00
+	p = append(p, encoding.NullTerminatedString(string(b.PluginData))...)
+	return p
+}
@@ -0,0 +1,32 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthSwitchRequestPacket_Build(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *AuthSwitchRequestPacket
+		want    []byte
+	}{
+		{
+			name:    "正常情况",
+			builder: NewAuthSwitchRequestPacket("caching_sha2_password", []byte{0x01, 0x02, 0x03}),
+			want: []byte{
+				0x1b, 0x00, 0x00, 0x00, // packet header
+				0xfe,                                                                   // status tag
+				0x63, 0x61, 0x63, 0x68, 0x69, 0x6e, 0x67, 0x5f, 0x73, 0x68, 0x61, 0x32, // plugin name
+				0x5f, 0x70, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x00,
+				0x01, 0x02, 0x03, 0x00, // plugin provided data
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want[4:], tt.builder.Build()[4:])
+		})
+	}
+}
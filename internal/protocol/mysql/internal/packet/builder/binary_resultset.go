@@ -14,10 +14,14 @@ type BinaryResultsetPacket struct {
 	rows         [][]any
 	serverStatus flags.SeverStatus
 	charset      uint32
+
+	// MetadataFollows 协商了 CLIENT_OPTIONAL_RESULTSET_METADATA 的时候才会写入，默认是 packet.ResultSetMetadataFull
+	MetadataFollows packet.ResultSetMetadata
 }
 
 func NewBinaryResultsetPacket(capabilities flags.CapabilityFlags, columnTypes []ColumnType, rows [][]any, serverStatus flags.SeverStatus, charset uint32) *BinaryResultsetPacket {
-	return &BinaryResultsetPacket{capabilities: capabilities, columnTypes: columnTypes, rows: rows, serverStatus: serverStatus, charset: charset}
+	return &BinaryResultsetPacket{capabilities: capabilities, columnTypes: columnTypes, rows: rows, serverStatus: serverStatus, charset: charset,
+		MetadataFollows: packet.ResultSetMetadataFull}
 }
 
 // Build
@@ -25,43 +29,65 @@ func NewBinaryResultsetPacket(capabilities flags.CapabilityFlags, columnTypes []
 func (b *BinaryResultsetPacket) Build() ([][]byte, error) {
 	// resultset 由四种类型的包组成（字段数量包 + 字段描述包 + eof包 + 真实数据包）
 	// 总包结构 = 字段数量包 + 字段数 * 字段描述包 + eof包 + 字段数 * 真实数据包 + eof包
+	packets := b.BuildColumns()
+	for _, row := range b.rows {
+		p, err := b.BuildRow(row)
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, p)
+	}
+	return append(packets, b.BuildEnd(nil)), nil
+}
 
-	eofPacket := NewEOFPacket(b.capabilities, b.serverStatus).Build()
-
-	var packets [][]byte
+// BuildColumns 构建结果集中行数据之前的部分，也就是字段数量包 + 字段描述包 + eof包
+// 和 BuildRow、BuildEnd 一起使用可以边读取数据边发送
+func (b *BinaryResultsetPacket) BuildColumns() [][]byte {
+	packets := make([][]byte, 0, len(b.columnTypes)+2)
 
 	p := make([]byte, 4, 20)
 
+	if b.capabilities.Has(flags.ClientOptionalResultsetMetadata) {
+		// int<1>	metadata_follows	Flag specifying if metadata are skipped or not. See enum_resultset_metadata
+		p = append(p, byte(b.MetadataFollows))
+	}
+
 	// int<lenenc>	column_count	Number of Column Definition to follow
 	p = append(p, encoding.LengthEncodeInteger(uint64(len(b.columnTypes)))...)
 	packets = append(packets, p)
 
-	// column_count x Column Definition	Field metadata
-	// one Column Definition for each field up to column_count
-	for _, c := range b.columnTypes {
-		packets = append(packets, b.buildColumnDefinitionPacket(c))
+	if !b.capabilities.Has(flags.ClientOptionalResultsetMetadata) ||
+		b.MetadataFollows == packet.ResultSetMetadataFull {
+		// column_count x Column Definition	Field metadata
+		// one Column Definition for each field up to column_count
+		for _, c := range b.columnTypes {
+			packets = append(packets, b.buildColumnDefinitionPacket(c))
+		}
 	}
-	if len(b.columnTypes) != 0 {
+	if len(b.columnTypes) != 0 && !b.capabilities.Has(flags.ClientDeprecateEOF) {
 		// EOF_Packet	End of metadata	Marker to set the end of metadata
-		packets = append(packets, eofPacket)
+		packets = append(packets, NewEOFPacket(b.capabilities, b.serverStatus).Build())
 	}
+	return packets
+}
 
-	// None or many Binary Protocol Resultset Row
-	rowBuilder := BinaryResultsetRowPacket{}
-	for _, row := range b.rows {
-		rowBuilder.values = row
-		rowBuilder.cols = b.columnTypes
-		build, err := rowBuilder.Build()
-		if err != nil {
-			return nil, err
-		}
-		packets = append(packets, build)
-	}
+// BuildRow 构建一行 Binary Protocol Resultset Row
+func (b *BinaryResultsetPacket) BuildRow(row []any) ([]byte, error) {
+	rowBuilder := BinaryResultsetRowPacket{values: row, cols: b.columnTypes}
+	return rowBuilder.Build()
+}
 
+// BuildEnd 构建结果集的结束包，err 不为 nil 的时候使用 ERR_Packet 结束
+func (b *BinaryResultsetPacket) BuildEnd(err error) []byte {
+	if err != nil {
+		return NewErrPacket(b.capabilities, NewError(err)).Build()
+	}
+	if b.capabilities.Has(flags.ClientDeprecateEOF) {
+		// OK_Packet	terminator	All the execution details
+		return NewEOFProtocol41Packet(b.capabilities, b.serverStatus).Build()
+	}
 	// EOF_Packet	terminator	end of resultset marker
-	packets = append(packets, eofPacket)
-
-	return packets, nil
+	return NewEOFPacket(b.capabilities, b.serverStatus).Build()
 }
 
 func (b *BinaryResultsetPacket) buildColumnDefinitionPacket(column ColumnType) []byte {
@@ -0,0 +1,74 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryResultsetPacket_Build(t *testing.T) {
+	columns := []ColumnType{NewColumn("id", "BIGINT")}
+	first, second := []byte("1"), []byte("2")
+	rows := [][]any{{&first}, {&second}}
+	tests := []struct {
+		name         string
+		capabilities flags.CapabilityFlags
+		// skipMetadata 客户端通过 resultset_metadata = NONE 要求不返回字段定义
+		skipMetadata bool
+		// wantPackets column_count + 列定义 + 两行数据 + 结束包，加上元数据之后的 EOF
+		wantPackets int
+		// wantColumnCount 第一个报文去掉报文头之后的内容
+		wantColumnCount []byte
+		// wantEnd 结束包
+		wantEnd []byte
+	}{
+		{
+			name:            "EOF",
+			capabilities:    flags.CapabilityFlags(flags.ClientProtocol41),
+			wantPackets:     6,
+			wantColumnCount: []byte{0x01},
+			wantEnd:         NewEOFPacket(flags.CapabilityFlags(flags.ClientProtocol41), flags.ServerStatusAutoCommit).Build(),
+		},
+		{
+			name:            "CLIENT_DEPRECATE_EOF",
+			capabilities:    flags.CapabilityFlags(flags.ClientProtocol41 | flags.ClientDeprecateEOF),
+			wantPackets:     5,
+			wantColumnCount: []byte{0x01},
+			wantEnd: NewEOFProtocol41Packet(flags.CapabilityFlags(flags.ClientProtocol41|flags.ClientDeprecateEOF),
+				flags.ServerStatusAutoCommit).Build(),
+		},
+		{
+			name:            "CLIENT_OPTIONAL_RESULTSET_METADATA",
+			capabilities:    flags.CapabilityFlags(flags.ClientProtocol41 | flags.ClientDeprecateEOF | flags.ClientOptionalResultsetMetadata),
+			wantPackets:     5,
+			wantColumnCount: []byte{byte(packet.ResultSetMetadataFull), 0x01},
+			wantEnd: NewEOFProtocol41Packet(flags.CapabilityFlags(flags.ClientProtocol41|flags.ClientDeprecateEOF|flags.ClientOptionalResultsetMetadata),
+				flags.ServerStatusAutoCommit).Build(),
+		},
+		{
+			name:            "不返回字段定义",
+			capabilities:    flags.CapabilityFlags(flags.ClientProtocol41 | flags.ClientDeprecateEOF | flags.ClientOptionalResultsetMetadata),
+			skipMetadata:    true,
+			wantPackets:     4,
+			wantColumnCount: []byte{byte(packet.ResultSetMetadataNone), 0x01},
+			wantEnd: NewEOFProtocol41Packet(flags.CapabilityFlags(flags.ClientProtocol41|flags.ClientDeprecateEOF|flags.ClientOptionalResultsetMetadata),
+				flags.ServerStatusAutoCommit).Build(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBinaryResultsetPacket(tt.capabilities, columns, rows, flags.ServerStatusAutoCommit, packet.CharSetUtf8mb4GeneralCi)
+			if tt.skipMetadata {
+				b.MetadataFollows = packet.ResultSetMetadataNone
+			}
+			packets, err := b.Build()
+			require.NoError(t, err)
+			assert.Len(t, packets, tt.wantPackets)
+			assert.Equal(t, tt.wantColumnCount, packets[0][4:])
+			assert.Equal(t, tt.wantEnd, packets[len(packets)-1])
+		})
+	}
+}
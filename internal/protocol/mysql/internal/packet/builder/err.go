@@ -1,21 +1,118 @@
 package builder
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/meoying/dbproxy/internal/errs"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
 )
 
 // 这里直接照着 MySQL 文档的命令，所以不符合 Go 的规范
 
-// ER_XAER_INVAL 不支持的参数，或者命令
 var (
+	// ER_XAER_INVAL 不支持的参数，或者命令
 	ER_XAER_INVAL = Error{
 		code:     1398,
 		sqlState: []byte("XAE05"),
 		msg:      "XAER_INVAL: Invalid arguments (or unsupported command)",
 	}
+
+	// ER_UNKNOWN_COM_ERROR 未知的命令或者命令参数
+	ER_UNKNOWN_COM_ERROR = Error{
+		code:     1047,
+		sqlState: []byte("08S01"),
+		msg:      "Unknown command",
+	}
+
+	// ER_NO_DB_ERROR 没有选择数据库
+	ER_NO_DB_ERROR = Error{
+		code:     1046,
+		sqlState: []byte("3D000"),
+		msg:      "No database selected",
+	}
+
+	// ER_QUERY_INTERRUPTED 语句被 KILL QUERY 或者客户端断开连接中断了
+	ER_QUERY_INTERRUPTED = Error{
+		code:     1317,
+		sqlState: []byte("70100"),
+		msg:      "Query execution was interrupted",
+	}
+
+	// ER_QUERY_TIMEOUT 语句执行超时了，对应 MySQL 的 max_execution_time
+	ER_QUERY_TIMEOUT = Error{
+		code:     3024,
+		sqlState: []byte("HY000"),
+		msg:      "Query execution was interrupted, maximum statement execution time exceeded",
+	}
+
+	// ER_NET_PACKET_TOO_LARGE 客户端发送的报文超过了 max_allowed_packet
+	ER_NET_PACKET_TOO_LARGE = Error{
+		code:     1153,
+		sqlState: []byte("08S01"),
+		msg:      "Got a packet bigger than 'max_allowed_packet' bytes",
+	}
+
+	// ER_MALFORMED_PACKET 客户端发送的报文格式非法
+	ER_MALFORMED_PACKET = Error{
+		code:     1835,
+		sqlState: []byte("HY000"),
+		msg:      "Malformed communication packet.",
+	}
+
+	// ER_HANDSHAKE_ERROR 握手阶段客户端发送的报文格式非法，或者超过了 connect_timeout
+	ER_HANDSHAKE_ERROR = Error{
+		code:     1043,
+		sqlState: []byte("08S01"),
+		msg:      "Bad handshake",
+	}
+
+	// ER_CON_COUNT_ERROR 连接数超过了限制
+	ER_CON_COUNT_ERROR = Error{
+		code:     1040,
+		sqlState: []byte("08004"),
+		msg:      "Too many connections",
+	}
+
+	// ER_SPECIFIC_ACCESS_DENIED_ERROR 非管理员用户通过管理端口登录
+	ER_SPECIFIC_ACCESS_DENIED_ERROR = Error{
+		code:     1227,
+		sqlState: []byte("42000"),
+		msg:      "Access denied; you need (at least one of) the SERVICE_CONNECTION_ADMIN privilege(s) for this operation",
+	}
+
+	// ER_CLIENT_INTERACTION_TIMEOUT 客户端空闲时间超过了 wait_timeout，连接被服务端关闭
+	ER_CLIENT_INTERACTION_TIMEOUT = Error{
+		code:     4031,
+		sqlState: []byte("HY000"),
+		msg:      "The client was disconnected by the server because of inactivity. See wait_timeout and interactive_timeout for configuring this behavior.",
+	}
+
+	// ER_CANT_CHANGE_TX_CHARACTERISTICS 事务中不能通过 SET TRANSACTION 修改下一个事务的特性
+	ER_CANT_CHANGE_TX_CHARACTERISTICS = Error{
+		code:     1568,
+		sqlState: []byte("25001"),
+		msg:      "Transaction characteristics can't be changed while a transaction is in progress",
+	}
+
+	// ER_SECURE_TRANSPORT_REQUIRED 要求客户端必须使用 TLS 连接
+	ER_SECURE_TRANSPORT_REQUIRED = Error{
+		code:     3159,
+		sqlState: []byte("HY000"),
+		msg:      "Connections using insecure transport are prohibited while --require_secure_transport=ON.",
+	}
+)
+
+// dbproxy 自己产生的错误使用 9000 开始的错误码，避免和 MySQL 的错误码冲突
+// 这些错误码一旦确定就不能修改，客户端可能依赖它们做重试等处理
+const (
+	// ErrCodeProxyUnsupportedSQL dbproxy 尚未支持的 SQL
+	ErrCodeProxyUnsupportedSQL uint16 = 9001
+	// ErrCodeProxyRouteFailed 无法将 SQL 路由到目标库、表或者数据源
+	ErrCodeProxyRouteFailed uint16 = 9002
 )
 
 // Error 表示服务端发生的一个错误
@@ -40,6 +137,133 @@ func NewInternalError(cause error) Error {
 	}
 }
 
+// NewError 根据 cause 构造返回给客户端的错误
+// 来自后端 MySQL 的错误会原样透传错误码、SQLSTATE 和错误信息
+// dbproxy 自身的错误使用 dbproxy 的错误码，其余的错误使用 NewInternalError
+func NewError(cause error) Error {
+	var me *mysql.MySQLError
+	switch {
+	case errors.As(cause, &me):
+		sqlState := me.SQLState[:]
+		if me.SQLState == [5]byte{} {
+			// 没有 CLIENT_PROTOCOL_41 的时候，MySQL 不会返回 SQLSTATE
+			sqlState = []byte("HY000")
+		}
+		return Error{
+			code:     me.Number,
+			sqlState: sqlState,
+			msg:      me.Message,
+		}
+	case errors.Is(cause, context.Canceled):
+		return ER_QUERY_INTERRUPTED
+	case errors.Is(cause, context.DeadlineExceeded):
+		return ER_QUERY_TIMEOUT
+	case errors.Is(cause, errs.ErrMalformedPacket):
+		return ER_MALFORMED_PACKET
+	case errors.Is(cause, errs.ErrTooManyConnections):
+		return ER_CON_COUNT_ERROR
+	case errors.Is(cause, errs.ErrAdminRequired):
+		return ER_SPECIFIC_ACCESS_DENIED_ERROR
+	case errors.Is(cause, errs.ErrWrongValueForVar):
+		return Error{
+			code:     1231,
+			sqlState: []byte("42000"),
+			msg:      cause.Error(),
+		}
+	case errors.Is(cause, errs.ErrReadOnlyVar):
+		return Error{
+			code:     1238,
+			sqlState: []byte("HY000"),
+			msg:      cause.Error(),
+		}
+	case errors.Is(cause, errs.ErrLongDataTooLarge):
+		// 和 MySQL 一样使用 ER_UNKNOWN_ERROR
+		return Error{
+			code:     1105,
+			sqlState: []byte("HY000"),
+			msg:      "Parameter of prepared statement which is set through mysql_send_long_data() is longer than 'max_allowed_packet' bytes",
+		}
+	case errors.Is(cause, errs.ErrUnknownDatabase):
+		return Error{
+			code:     1049,
+			sqlState: []byte("42000"),
+			msg:      cause.Error(),
+		}
+	case errors.Is(cause, errs.ErrUnsupportedSQL):
+		return Error{
+			code:     ErrCodeProxyUnsupportedSQL,
+			sqlState: []byte("42000"),
+			msg:      fmt.Sprintf("dbproxy: unsupported SQL: %s", cause),
+		}
+	case errors.Is(cause, errs.ErrRouteFailed):
+		return Error{
+			code:     ErrCodeProxyRouteFailed,
+			sqlState: []byte("HY000"),
+			msg:      fmt.Sprintf("dbproxy: route failed: %s", cause),
+		}
+	default:
+		return NewInternalError(cause)
+	}
+}
+
+// NewErrAccessDenied 用户名或者密码错误
+func NewErrAccessDenied(user, host string, usingPassword bool) Error {
+	using := "NO"
+	if usingPassword {
+		using = "YES"
+	}
+	return Error{
+		code:     1045,
+		sqlState: []byte("28000"),
+		msg:      fmt.Sprintf("Access denied for user '%s'@'%s' (using password: %s)", user, host, using),
+	}
+}
+
+// NewErrDBAccessDenied 用户没有权限访问该数据库
+func NewErrDBAccessDenied(user, host, db string) Error {
+	return Error{
+		code:     1044,
+		sqlState: []byte("42000"),
+		msg:      fmt.Sprintf("Access denied for user '%s'@'%s' to database '%s'", user, host, db),
+	}
+}
+
+// NewErrStmtHasNoOpenCursor 预处理语句没有打开的游标
+func NewErrStmtHasNoOpenCursor(stmtID uint32) Error {
+	return Error{
+		code:     1421,
+		sqlState: []byte("HY000"),
+		msg:      fmt.Sprintf("The statement (%d) has no open cursor.", stmtID),
+	}
+}
+
+// NewErrUnknownStmtHandler 预处理语句不存在
+func NewErrUnknownStmtHandler(stmtID uint32, cmd string) Error {
+	return Error{
+		code:     1243,
+		sqlState: []byte("HY000"),
+		msg:      fmt.Sprintf("Unknown prepared statement handler (%d) given to %s", stmtID, cmd),
+	}
+}
+
+// NewErrNoSuchThread KILL 的连接不存在
+func NewErrNoSuchThread(connID uint32) Error {
+	return Error{
+		code:     1094,
+		sqlState: []byte("HY000"),
+		msg:      fmt.Sprintf("Unknown thread id: %d", connID),
+	}
+}
+
+// NewErrKillDenied 没有权限 KILL 其他用户的连接
+func NewErrKillDenied(connID uint32) Error {
+	return Error{
+		code:     1095,
+		sqlState: []byte("HY000"),
+		msg:      fmt.Sprintf("You are not owner of thread %d", connID),
+	}
+}
+
 func (e Error) Code() uint16 {
 	return e.code
 }
@@ -1,9 +1,13 @@
 package builder
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"testing"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/meoying/dbproxy/internal/errs"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
 	"github.com/stretchr/testify/assert"
 )
@@ -36,3 +40,139 @@ func TestErrPacket_Build(t *testing.T) {
 		})
 	}
 }
+
+func TestNewError(t *testing.T) {
+	tests := []struct {
+		name  string
+		cause error
+
+		wantCode     uint16
+		wantSQLState string
+		wantMsg      string
+	}{
+		{
+			name: "MySQL错误",
+			cause: &mysql.MySQLError{
+				Number:   1062,
+				SQLState: [5]byte{'2', '3', '0', '0', '0'},
+				Message:  "Duplicate entry '1' for key 'PRIMARY'",
+			},
+			wantCode:     1062,
+			wantSQLState: "23000",
+			wantMsg:      "Duplicate entry '1' for key 'PRIMARY'",
+		},
+		{
+			name: "被包装的MySQL错误",
+			cause: fmt.Errorf("执行失败 %w", &mysql.MySQLError{
+				Number:   1213,
+				SQLState: [5]byte{'4', '0', '0', '0', '1'},
+				Message:  "Deadlock found when trying to get lock; try restarting transaction",
+			}),
+			wantCode:     1213,
+			wantSQLState: "40001",
+			wantMsg:      "Deadlock found when trying to get lock; try restarting transaction",
+		},
+		{
+			name: "没有SQLSTATE的MySQL错误",
+			cause: &mysql.MySQLError{
+				Number:  1054,
+				Message: "Unknown column 'a' in 'field list'",
+			},
+			wantCode:     1054,
+			wantSQLState: "HY000",
+			wantMsg:      "Unknown column 'a' in 'field list'",
+		},
+		{
+			name:         "不支持的SQL",
+			cause:        errs.NewUnsupportedSQLError("CreateTableStmt"),
+			wantCode:     ErrCodeProxyUnsupportedSQL,
+			wantSQLState: "42000",
+			wantMsg:      "dbproxy: unsupported SQL: CreateTableStmt",
+		},
+		{
+			name:         "路由失败",
+			cause:        fmt.Errorf("查询失败 %w", errs.NewRouteError("sharding key 未设置")),
+			wantCode:     ErrCodeProxyRouteFailed,
+			wantSQLState: "HY000",
+			wantMsg:      "dbproxy: route failed: 查询失败 sharding key 未设置",
+		},
+		{
+			name:         "语句被中断",
+			cause:        fmt.Errorf("查询失败 %w", context.Canceled),
+			wantCode:     1317,
+			wantSQLState: "70100",
+			wantMsg:      "Query execution was interrupted",
+		},
+		{
+			name:         "语句超时",
+			cause:        fmt.Errorf("查询失败 %w", context.DeadlineExceeded),
+			wantCode:     3024,
+			wantSQLState: "HY000",
+			wantMsg:      "Query execution was interrupted, maximum statement execution time exceeded",
+		},
+		{
+			name:         "报文格式非法",
+			cause:        fmt.Errorf("解析 COM_STMT_EXECUTE 失败 %w", errs.ErrMalformedPacket),
+			wantCode:     1835,
+			wantSQLState: "HY000",
+			wantMsg:      "Malformed communication packet.",
+		},
+		{
+			name:         "连接数过多",
+			cause:        fmt.Errorf("用户 root %w", errs.ErrTooManyConnections),
+			wantCode:     1040,
+			wantSQLState: "08004",
+			wantMsg:      "Too many connections",
+		},
+		{
+			name:         "需要管理员权限",
+			cause:        fmt.Errorf("用户 root %w", errs.ErrAdminRequired),
+			wantCode:     1227,
+			wantSQLState: "42000",
+			wantMsg:      "Access denied; you need (at least one of) the SERVICE_CONNECTION_ADMIN privilege(s) for this operation",
+		},
+		{
+			name:         "系统变量的值非法",
+			cause:        errs.NewWrongValueForVarError("autocommit", "abc"),
+			wantCode:     1231,
+			wantSQLState: "42000",
+			wantMsg:      "Variable 'autocommit' can't be set to the value of 'abc'",
+		},
+		{
+			name:         "只读的系统变量",
+			cause:        errs.NewReadOnlyVarError("version"),
+			wantCode:     1238,
+			wantSQLState: "HY000",
+			wantMsg:      "Variable 'version' is a read only variable",
+		},
+		{
+			name:         "预处理语句的参数过长",
+			cause:        errs.ErrLongDataTooLarge,
+			wantCode:     1105,
+			wantSQLState: "HY000",
+			wantMsg:      "Parameter of prepared statement which is set through mysql_send_long_data() is longer than 'max_allowed_packet' bytes",
+		},
+		{
+			name:         "逻辑库不存在",
+			cause:        errs.NewUnknownDatabaseError("other_db"),
+			wantCode:     1049,
+			wantSQLState: "42000",
+			wantMsg:      "Unknown database 'other_db'",
+		},
+		{
+			name:         "其他错误",
+			cause:        errors.New("mock error"),
+			wantCode:     1398,
+			wantSQLState: "HY000",
+			wantMsg:      "Internal error: mock error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewError(tt.cause)
+			assert.Equal(t, tt.wantCode, e.Code())
+			assert.Equal(t, tt.wantSQLState, string(e.SQLState()))
+			assert.Equal(t, tt.wantMsg, e.Msg())
+		})
+	}
+}
@@ -11,7 +11,7 @@ import (
 // HandshakeV10Packet 在 mysql 协议中，在建立了 TCP 连接之后
 // mysql server 端发起 Handshake
 // 而后客户端要响应 Handshake
-// TODO 支持 SSL
+// SSL 的部分由 CapabilityFlags1 中的 CLIENT_SSL 决定
 // https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase.html#sect_protocol_connection_phase_initial_handshake
 // https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_packets_protocol_handshake_v10.html
 // 可以参考 README.md 中的一个例子
@@ -31,13 +31,14 @@ type HandshakeV10Packet struct {
 	AuthPluginName       string
 }
 
+// NewHandshakeV10Packet capabilities 是服务端声明的能力，CapabilityFlags1 和 CapabilityFlags2 由它拆分得到
 func NewHandshakeV10Packet(capabilities flags.CapabilityFlags, serverStatus flags.SeverStatus, AuthPluginDataGenerator func() string) *HandshakeV10Packet {
 	return &HandshakeV10Packet{
 		capabilities:         capabilities,
 		StatusFlags:          serverStatus,
-		CapabilityFlags1:     0xFFFF,
+		CapabilityFlags1:     uint16(capabilities),
 		CharacterSet:         0xFF,
-		CapabilityFlags2:     0xDFFF,
+		CapabilityFlags2:     uint16(capabilities >> 16),
 		AuthPluginDataLength: 0x15,
 		authPluginDataFunc:   AuthPluginDataGenerator,
 	}
@@ -98,8 +99,8 @@ func (b *HandshakeV10Packet) Build() []byte {
 
 	// $length	auth-plugin-data-part-2
 	// Rest of the plugin provided data (scramble), $len=MAX(13, length of auth-plugin-data - 8)
-	// 0x00 作为结束符
-	p = append(p, encoding.LengthEncodeString(string(encoding.NullTerminatedString(authPluginData[8:])))...)
+	// 0x00 作为结束符，这部分没有长度前缀，客户端是根据 auth_plugin_data_len 来读取的
+	p = append(p, encoding.NullTerminatedString(authPluginData[8:])...)
 
 	if b.capabilities.Has(flags.ClientPluginAuth) {
 		// 	NULL	auth_plugin_name	name of the auth_method that the auth_plugin_data belongs to
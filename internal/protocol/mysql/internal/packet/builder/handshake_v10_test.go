@@ -33,7 +33,7 @@ func TestHandshakeV10Packet_Build(t *testing.T) {
 				return b
 			}(),
 			want: []byte{
-				0x33, 0x00, 0x00, 0x00, // packet header
+				0x32, 0x00, 0x00, 0x00, // packet header
 				0x0a,                               // protocol version
 				0x38, 0x2e, 0x34, 0x2e, 0x30, 0x00, // server version
 				0x01, 0x00, 0x00, 0x00, // 	thread id
@@ -45,7 +45,7 @@ func TestHandshakeV10Packet_Build(t *testing.T) {
 				0xff, 0xdf, // 	capability_flags_2
 				0x15,                                                       // auth_plugin_data_len
 				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // reserved
-				0x0d, 0x09, 0x29, 0x58, 0x17, 0x38, 0x45, 0x5d, 0x5f, 0x06, 0x31, 0x5f, 0x63, 0x00, // auth-plugin-data-part-2
+				0x09, 0x29, 0x58, 0x17, 0x38, 0x45, 0x5d, 0x5f, 0x06, 0x31, 0x5f, 0x63, 0x00, // auth-plugin-data-part-2
 				0x6d, 0x79, 0x73, 0x71, 0x6c, 0x5f, 0x6e, 0x61, 0x74, 0x69, 0x76, 0x65, 0x5f, // auth_plugin_name
 				0x70, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x00,
 			},
@@ -57,3 +57,10 @@ func TestHandshakeV10Packet_Build(t *testing.T) {
 		})
 	}
 }
+
+func TestNewHandshakeV10Packet(t *testing.T) {
+	b := NewHandshakeV10Packet(flags.CapabilityFlags(flags.ClientPluginAuth|flags.ClientProtocol41|flags.ClientDeprecateEOF),
+		flags.ServerStatusAutoCommit, AuthPluginDataGenerator)
+	assert.Equal(t, uint16(flags.ClientProtocol41), b.CapabilityFlags1)
+	assert.Equal(t, uint16((flags.ClientPluginAuth|flags.ClientDeprecateEOF)>>16), b.CapabilityFlags2)
+}
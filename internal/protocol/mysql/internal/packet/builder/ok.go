@@ -2,6 +2,7 @@ package builder
 
 import (
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/encoding"
 )
 
@@ -21,7 +22,26 @@ type OKPacket struct {
 	Warnings uint16
 	// Info 仅OK包需要设置
 	Info string
-	// SessionStateInfo
+	// SessionStateInfo 会话状态的变化，只有客户端开启了 ClientSessionTrack 才会返回
+	SessionStateInfo []SessionStateInfo
+}
+
+// SessionStateInfo 会话状态的一个变化
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_ok_packet.html
+type SessionStateInfo struct {
+	Type packet.SessionState
+	// Data 不同类型的格式不一样，例如 SESSION_TRACK_SYSTEM_VARIABLES 是变量名和变量值
+	Data []byte
+}
+
+// NewSystemVariableState 系统变量发生了变化
+// data 是 string<lenenc> 的变量名加上 string<lenenc> 的变量值
+func NewSystemVariableState(name, value string) SessionStateInfo {
+	data := encoding.LengthEncodeString(name)
+	return SessionStateInfo{
+		Type: packet.SESSION_TRACK_SYSTEM_VARIABLES,
+		Data: append(data, encoding.LengthEncodeString(value)...),
+	}
 }
 
 // NewOKPacket 构造 OK_Packet
@@ -61,6 +81,11 @@ func (b *OKPacket) Build() []byte {
 	// 头部的四个字节保留，不需要填充
 	p := make([]byte, 4, 11)
 
+	status := b.StatusFlags
+	if b.Capabilities.Has(flags.ClientSessionTrack) && len(b.SessionStateInfo) > 0 {
+		status |= flags.ServerSessionStateChanged
+	}
+
 	// int<1>  header 0x00 表示OK,0xFE 表示EOF
 	p = append(p, b.header)
 
@@ -74,7 +99,7 @@ func (b *OKPacket) Build() []byte {
 	if b.Capabilities.Has(flags.ClientProtocol41) {
 
 		// int<2>	status_flags	SERVER_STATUS_flags_enum 服务器状态
-		p = append(p, encoding.FixedLengthInteger(uint64(b.StatusFlags), 2)...)
+		p = append(p, encoding.FixedLengthInteger(uint64(status), 2)...)
 
 		// int<2>	warnings 警告数
 		p = append(p, encoding.FixedLengthInteger(uint64(b.Warnings), 2)...)
@@ -82,16 +107,22 @@ func (b *OKPacket) Build() []byte {
 	} else if b.Capabilities.Has(flags.ClientTransactions) {
 
 		// int<2>	status_flags	SERVER_STATUS_flags_enum 服务器状态
-		p = append(p, encoding.FixedLengthInteger(uint64(b.StatusFlags), 2)...)
+		p = append(p, encoding.FixedLengthInteger(uint64(status), 2)...)
 	}
 
 	if b.Capabilities.Has(flags.ClientSessionTrack) {
 		// string<lenenc>	info	human-readable status information
 		p = append(p, encoding.LengthEncodeString(b.Info)...)
 
-		if b.StatusFlags.Has(flags.ServerSessionStateChanged) {
+		if status.Has(flags.ServerSessionStateChanged) {
 			// string<lenenc>	session state info	Session State Information
-			panic("暂不支持客户端设置 ClientSessionTrack ")
+			// 每个变化是 int<1> 的类型加上 string<lenenc> 的数据
+			var info []byte
+			for _, state := range b.SessionStateInfo {
+				info = append(info, byte(state.Type))
+				info = append(info, encoding.LengthEncodeString(string(state.Data))...)
+			}
+			p = append(p, encoding.LengthEncodeString(string(info))...)
 		}
 	} else {
 		// string<EOF>	info human-readable status information
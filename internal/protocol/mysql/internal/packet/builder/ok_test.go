@@ -27,6 +27,44 @@ func TestOKPacket_Build(t *testing.T) {
 				0x00, 0x00, // warnings
 			},
 		},
+		{
+			name: "系统变量发生了变化",
+			b: func() *OKPacket {
+				b := NewOKPacket(flags.CapabilityFlags(flags.ClientProtocol41|flags.ClientSessionTrack), flags.ServerStatusAutoCommit)
+				b.SessionStateInfo = []SessionStateInfo{NewSystemVariableState("autocommit", "OFF")}
+				return b
+			}(),
+			want: []byte{
+				0x1d, 0x00, 0x00, 0x02, // packet header
+				0x00,       // OK header
+				0x00,       // affected_rows
+				0x00,       // last_insert_id
+				0x02, 0x40, // status_flags 带上了 SERVER_SESSION_STATE_CHANGED
+				0x00, 0x00, // warnings
+				0x00, // info
+				0x11, // session state info 的长度
+				0x00, // SESSION_TRACK_SYSTEM_VARIABLES
+				0x0f, // 数据的长度
+				0x0a, 'a', 'u', 't', 'o', 'c', 'o', 'm', 'm', 'i', 't',
+				0x03, 'O', 'F', 'F',
+			},
+		},
+		{
+			name: "客户端没有开启 ClientSessionTrack",
+			b: func() *OKPacket {
+				b := NewOKPacket(flags.CapabilityFlags(flags.ClientProtocol41), flags.ServerStatusAutoCommit)
+				b.SessionStateInfo = []SessionStateInfo{NewSystemVariableState("autocommit", "OFF")}
+				return b
+			}(),
+			want: []byte{
+				0x07, 0x00, 0x00, 0x02, // packet header
+				0x00,       // OK header
+				0x00,       // affected_rows
+				0x00,       // last_insert_id
+				0x02, 0x00, // status_flags
+				0x00, 0x00, // warnings
+			},
+		},
 		{
 			name: "EOF",
 			b: func() *OKPacket {
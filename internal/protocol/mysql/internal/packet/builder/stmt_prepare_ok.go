@@ -42,12 +42,13 @@ type StmtPrepareOKPacket struct {
 
 	// int<1>	metadata_follows	Flag specifying if metadata are skipped or not.
 	// 详见 resultset_metadata.go
-	// 该字段当 CLIENT_OPTIONAL_RESULTSET_METADATA 设置时才会写入
+	// 该字段当 CLIENT_OPTIONAL_RESULTSET_METADATA 设置时才会写入，默认是 packet.ResultSetMetadataFull
 	MetadataFollows packet.ResultSetMetadata
 }
 
 func NewStmtPrepareOKPacket(capabilities flags.CapabilityFlags, serverStatus flags.SeverStatus, charset uint32) *StmtPrepareOKPacket {
-	return &StmtPrepareOKPacket{capabilities: capabilities, serverStatus: serverStatus, charset: charset}
+	return &StmtPrepareOKPacket{capabilities: capabilities, serverStatus: serverStatus, charset: charset,
+		MetadataFollows: packet.ResultSetMetadataFull}
 }
 
 func (b *StmtPrepareOKPacket) Build() [][]byte {
@@ -92,7 +93,7 @@ func (b *StmtPrepareOKPacket) isClientOptionalResultsetMetadataFlagSet() bool {
 }
 
 func (b *StmtPrepareOKPacket) buildParameterDefinitionPackets() [][]byte {
-	if b.NumParams > 0 && !b.isClientOptionalResultsetMetadataFlagSet() || b.MetadataFollows == packet.ResultSetMetadataFull {
+	if b.NumParams > 0 && (!b.isClientOptionalResultsetMetadataFlagSet() || b.MetadataFollows == packet.ResultSetMetadataFull) {
 
 		params := make([]Column, 0, b.NumParams)
 		for i := uint16(0); i < b.NumParams; i++ {
@@ -105,19 +106,18 @@ func (b *StmtPrepareOKPacket) buildParameterDefinitionPackets() [][]byte {
 			packets = append(packets, b.buildColumnDefinitionPacket(p))
 		}
 
-		packets = append(packets, b.buildEOFPacket())
+		if !b.isClientDeprecateEOFFlagSet() {
+			packets = append(packets, b.buildEOFPacket())
+		}
 
 		return packets
 	}
 	return nil
 }
 
+// buildEOFPacket 参数和列的定义之后的 EOF 包
+// 和 MySQL 一样，设置了 CLIENT_DEPRECATE_EOF 的时候不发送
 func (b *StmtPrepareOKPacket) buildEOFPacket() []byte {
-	if b.isClientDeprecateEOFFlagSet() {
-		// 发送ok包表示EOF
-		return NewEOFProtocol41Packet(b.capabilities, b.serverStatus).Build()
-	}
-	// 发送EOF包
 	return NewEOFPacket(b.capabilities, b.serverStatus).Build()
 }
 
@@ -126,7 +126,7 @@ func (b *StmtPrepareOKPacket) isClientDeprecateEOFFlagSet() bool {
 }
 
 func (b *StmtPrepareOKPacket) buildColumnDefinitionPackets() [][]byte {
-	if b.NumColumns > 0 && !b.isClientOptionalResultsetMetadataFlagSet() || b.MetadataFollows == packet.ResultSetMetadataFull {
+	if b.NumColumns > 0 && (!b.isClientOptionalResultsetMetadataFlagSet() || b.MetadataFollows == packet.ResultSetMetadataFull) {
 
 		fields := make([]Column, 0, b.NumColumns)
 		for i := uint16(0); i < b.NumColumns; i++ {
@@ -138,7 +138,9 @@ func (b *StmtPrepareOKPacket) buildColumnDefinitionPackets() [][]byte {
 			packets = append(packets, b.buildColumnDefinitionPacket(f))
 		}
 
-		packets = append(packets, b.buildEOFPacket())
+		if !b.isClientDeprecateEOFFlagSet() {
+			packets = append(packets, b.buildEOFPacket())
+		}
 
 		return packets
 	}
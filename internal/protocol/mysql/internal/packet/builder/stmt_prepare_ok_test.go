@@ -44,3 +44,37 @@ func TestStmtPrepareOKPacket_Build(t *testing.T) {
 		})
 	}
 }
+
+func TestStmtPrepareOKPacket_DeprecateEOF(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities flags.CapabilityFlags
+		// wantPackets OK 包 + 参数定义 + 列定义，没有设置 CLIENT_DEPRECATE_EOF 的时候各自后面有一个 EOF 包
+		wantPackets int
+	}{
+		{
+			name:         "EOF",
+			capabilities: flags.CapabilityFlags(flags.ClientProtocol41),
+			wantPackets:  1 + 2 + 1 + 1 + 1,
+		},
+		{
+			name:         "CLIENT_DEPRECATE_EOF",
+			capabilities: flags.CapabilityFlags(flags.ClientProtocol41 | flags.ClientDeprecateEOF),
+			wantPackets:  1 + 2 + 1,
+		},
+		{
+			name:         "CLIENT_OPTIONAL_RESULTSET_METADATA",
+			capabilities: flags.CapabilityFlags(flags.ClientProtocol41 | flags.ClientDeprecateEOF | flags.ClientOptionalResultsetMetadata),
+			wantPackets:  1 + 2 + 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := builder.NewStmtPrepareOKPacket(tt.capabilities, flags.ServerStatusAutoCommit, packet.CharSetUtf8mb4GeneralCi)
+			b.StatementID = 1
+			b.NumParams = 2
+			b.NumColumns = 1
+			assert.Len(t, b.Build(), tt.wantPackets)
+		})
+	}
+}
@@ -15,12 +15,14 @@ type TextResultsetPacket struct {
 	serverStatus flags.SeverStatus
 	charset      uint32
 
+	// MetadataFollows 协商了 CLIENT_OPTIONAL_RESULTSET_METADATA 的时候才会写入，默认是 packet.ResultSetMetadataFull
 	MetadataFollows packet.ResultSetMetadata
 	Error           error
 }
 
 func NewTextResultsetPacket(capabilities flags.CapabilityFlags, columnTypes []ColumnType, rows [][]any, serverStatus flags.SeverStatus, charset uint32) *TextResultsetPacket {
-	return &TextResultsetPacket{capabilities: capabilities, columnTypes: columnTypes, rows: rows, serverStatus: serverStatus, charset: charset}
+	return &TextResultsetPacket{capabilities: capabilities, columnTypes: columnTypes, rows: rows, serverStatus: serverStatus, charset: charset,
+		MetadataFollows: packet.ResultSetMetadataFull}
 }
 
 // Build 构建 text_resultset
@@ -28,10 +30,19 @@ func NewTextResultsetPacket(capabilities flags.CapabilityFlags, columnTypes []Co
 func (b *TextResultsetPacket) Build() [][]byte {
 	// resultset 由四种类型的包组成（字段数量包 + 字段描述包 + eof包 + 真实数据包）
 	// 总包结构 = 字段数量包 + 字段数 * 字段描述包 + eof包 + 字段数 * 真实数据包 + eof包
+	packets := b.BuildColumns()
+	for _, row := range b.rows {
+		// 文本协议构造行不会出错
+		p, _ := b.BuildRow(row)
+		packets = append(packets, p)
+	}
+	return append(packets, b.BuildEnd(b.Error))
+}
 
-	eofPacket := NewEOFPacket(b.capabilities, b.serverStatus).Build()
-
-	var packets [][]byte
+// BuildColumns 构建结果集中行数据之前的部分，也就是字段数量包 + 字段描述包 + eof包
+// 和 BuildRow、BuildEnd 一起使用可以边读取数据边发送
+func (b *TextResultsetPacket) BuildColumns() [][]byte {
+	packets := make([][]byte, 0, len(b.columnTypes)+2)
 
 	p := make([]byte, 4, 20)
 
@@ -57,30 +68,31 @@ func (b *TextResultsetPacket) Build() [][]byte {
 	if !b.capabilities.Has(flags.ClientDeprecateEOF) {
 		if len(b.columnTypes) != 0 {
 			// EOF_Packet	End of metadata	Marker to set the end of metadata
-			packets = append(packets, eofPacket)
+			packets = append(packets, NewEOFPacket(b.capabilities, b.serverStatus).Build())
 		}
 	}
+	return packets
+}
 
-	// One or more Text Resultset Row
-	// The row data	each Text Resultset Row contains column_count values
-	rowBuilder := TextResultsetRowPacket{}
-	for _, row := range b.rows {
-		rowBuilder.values = row
-		packets = append(packets, rowBuilder.Build())
-	}
+// BuildRow 构建一行数据
+// The row data	each Text Resultset Row contains column_count values
+func (b *TextResultsetPacket) BuildRow(row []any) ([]byte, error) {
+	rowBuilder := TextResultsetRowPacket{values: row}
+	return rowBuilder.Build(), nil
+}
 
-	if b.Error != nil {
+// BuildEnd 构建结果集的结束包，err 不为 nil 的时候使用 ERR_Packet 结束
+func (b *TextResultsetPacket) BuildEnd(err error) []byte {
+	if err != nil {
 		// ERR_Packet	terminator	Error details
-		packets = append(packets, NewErrPacket(b.capabilities, NewInternalError(b.Error)).Build())
-	} else if b.capabilities.Has(flags.ClientDeprecateEOF) {
+		return NewErrPacket(b.capabilities, NewError(err)).Build()
+	}
+	if b.capabilities.Has(flags.ClientDeprecateEOF) {
 		// OK_Packet	terminator	All the execution details
-		newEOF := NewEOFProtocol41Packet(b.capabilities, b.serverStatus)
-		packets = append(packets, newEOF.Build())
-	} else {
-		// EOF_Packet	terminator	end of resultset marker
-		packets = append(packets, eofPacket)
+		return NewEOFProtocol41Packet(b.capabilities, b.serverStatus).Build()
 	}
-	return packets
+	// EOF_Packet	terminator	end of resultset marker
+	return NewEOFPacket(b.capabilities, b.serverStatus).Build()
 }
 
 func (b *TextResultsetPacket) buildColumnDefinitionPacket(column ColumnType) []byte {
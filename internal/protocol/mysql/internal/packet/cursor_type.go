@@ -5,6 +5,19 @@ package packet
 type CursorType byte
 
 const (
+	// CursorTypeNoCursor 不使用游标，一次性返回整个结果集
+	CursorTypeNoCursor CursorType = 0
+	// CursorTypeReadOnly 只读游标，客户端通过 COM_STMT_FETCH 分批获取数据
+	CursorTypeReadOnly CursorType = 1
+	// CursorTypeForUpdate 目前 MySQL 也不支持
+	CursorTypeForUpdate CursorType = 2
+	// CursorTypeScrollable 目前 MySQL 也不支持
+	CursorTypeScrollable CursorType = 4
 	// ParameterCountAvailable  当客户端发送参数数量即使为0也开启该选项
 	ParameterCountAvailable CursorType = 8
 )
+
+// Has 判断是否设置了 t
+func (c CursorType) Has(t CursorType) bool {
+	return c&t != 0
+}
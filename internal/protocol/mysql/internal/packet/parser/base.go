@@ -3,10 +3,15 @@ package parser
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 )
 
+// errInvalidLengthEncodedInteger int<lenenc> 的第一个字节不合法，0xFB 表示 NULL，0xFF 表示错误报文
+var errInvalidLengthEncodedInteger = errors.New("非法的 length-encoded integer")
+
+// 所有的方法在数据不够的时候都返回 io.ErrUnexpectedEOF，不会 panic
 type base struct{}
 
 // ParseLengthEncodedInteger 解析 Length-Encoded Integer
@@ -15,7 +20,7 @@ type base struct{}
 func (p *base) ParseLengthEncodedInteger(buf *bytes.Buffer) (uint64, int, error) {
 	firstByte, err := buf.ReadByte()
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, io.ErrUnexpectedEOF
 	}
 	switch {
 	case firstByte < 0xFB:
@@ -23,48 +28,34 @@ func (p *base) ParseLengthEncodedInteger(buf *bytes.Buffer) (uint64, int, error)
 		return uint64(firstByte), 1, nil
 	case firstByte == 0xFC:
 		// [251, 2^16) 编码方式 0xFC + 2-byte integer
-		var num uint16
-		if err := binary.Read(buf, binary.LittleEndian, &num); err != nil {
+		b, err := p.next(buf, 2)
+		if err != nil {
 			return 0, 0, err
 		}
-		return uint64(num), 2, nil
+		return uint64(binary.LittleEndian.Uint16(b)), 2, nil
 	case firstByte == 0xFD:
 		// [2^16, 2^24) 编码方式	0xFD + 3-byte integer
-		b := make([]byte, 3)
-		if err := binary.Read(buf, binary.LittleEndian, b); err != nil {
+		b, err := p.next(buf, 3)
+		if err != nil {
 			return 0, 0, err
 		}
-		var result uint64
-		result |= uint64(b[0])
-		result |= uint64(b[1]) << 8
-		result |= uint64(b[2]) << 16
-		return result, 3, nil
+		return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16, 3, nil
 	case firstByte == 0xFE:
-		var num uint64
 		// [2^24, 2^64)	编码方式 0xFE + 8-byte integer
-		if err := binary.Read(buf, binary.LittleEndian, &num); err != nil {
+		b, err := p.next(buf, 8)
+		if err != nil {
 			return 0, 0, err
 		}
-		return num, 8, nil
+		return binary.LittleEndian.Uint64(b), 8, nil
 	default:
-		return 0, 0, fmt.Errorf("invalid length-encoded integer first byte: %d", firstByte)
+		return 0, 0, fmt.Errorf("%w，第一个字节 %d", errInvalidLengthEncodedInteger, firstByte)
 	}
 }
 
 // ParseLengthEncodedString 解析 Length-Encoded String
 func (p *base) ParseLengthEncodedString(buf *bytes.Buffer) (string, error) {
-	strLength, _, err := p.ParseLengthEncodedInteger(buf)
-	if err != nil {
-		return "", err
-	}
-	log.Printf("StrLength = %d\n", strLength)
-
-	strBytes := make([]byte, strLength)
-	if _, err := buf.Read(strBytes); err != nil {
-		return "", err
-	}
-	log.Printf("StrBytes = %s\n", string(strBytes))
-	return string(strBytes), nil
+	strBytes, err := p.ParseVariableLengthBinary(buf)
+	return string(strBytes), err
 }
 
 // ParseVariableLengthBinary 解析 Variable-Length Binary
@@ -73,11 +64,33 @@ func (p *base) ParseVariableLengthBinary(buf *bytes.Buffer) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	// 长度是客户端发送过来的，先确认数据足够再分配内存
+	return p.readN(buf, binLength)
+}
 
-	binBytes := make([]byte, binLength)
-	if _, err := buf.Read(binBytes); err != nil {
+// ParseNullTerminatedString 解析以 0x00 结尾的字符串，返回值不包含 0x00
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_dt_strings.html#sect_protocol_basic_dt_string_null
+func (p *base) ParseNullTerminatedString(buf *bytes.Buffer) (string, error) {
+	str, err := buf.ReadString(0x00)
+	if err != nil {
+		return "", fmt.Errorf("%w，未找到字符串结束符 0x00", io.ErrUnexpectedEOF)
+	}
+	return str[:len(str)-1], nil
+}
+
+// readN 读取 n 个字节，剩余的数据不足 n 个字节的时候返回错误
+func (p *base) readN(buf *bytes.Buffer, n uint64) ([]byte, error) {
+	b, err := p.next(buf, n)
+	if err != nil {
 		return nil, err
 	}
+	return bytes.Clone(b), nil
+}
 
-	return binBytes, nil
+// next 和 readN 一样，但是返回的数据直接引用了 buf 中的数据
+func (p *base) next(buf *bytes.Buffer, n uint64) ([]byte, error) {
+	if uint64(buf.Len()) < n {
+		return nil, fmt.Errorf("%w，预期 %d 字节，剩余 %d 字节", io.ErrUnexpectedEOF, n, buf.Len())
+	}
+	return buf.Next(int(n)), nil
 }
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bytes"
+	"io"
 	"testing"
 
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/encoding"
@@ -96,6 +97,15 @@ func TestBase_ParseLengthEncodedInteger(t *testing.T) {
 			}(),
 			assertErrFunc: assert.Error,
 		},
+		{
+			name: "数据被截断",
+			buf: func() *bytes.Buffer {
+				return bytes.NewBuffer([]byte{0xfe, 0x01, 0x02})
+			}(),
+			assertErrFunc: func(t assert.TestingT, err error, i ...interface{}) bool {
+				return assert.ErrorIs(t, err, io.ErrUnexpectedEOF, i...)
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,31 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/meoying/dbproxy/internal/errs"
+)
+
+// ParseError 报文格式非法，例如数据被截断了，或者字段的值和其它字段对不上
+// 可以用 errors.Is(err, errs.ErrMalformedPacket) 判断是不是报文格式的问题，
+// 也可以用 errors.As 获取具体是哪个报文的哪个字段出了问题
+type ParseError struct {
+	// Packet 报文的名字，例如 COM_STMT_EXECUTE
+	Packet string
+	// Field 解析失败的字段
+	Field string
+	// Err 具体的原因，数据不够的时候是 io.ErrUnexpectedEOF
+	Err error
+}
+
+func newParseError(packet, field string, err error) *ParseError {
+	return &ParseError{Packet: packet, Field: field, Err: err}
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("请求格式非法: 解析 %s 的 %s 失败: %v", e.Packet, e.Field, e.Err)
+}
+
+func (e *ParseError) Unwrap() []error {
+	return []error{errs.ErrMalformedPacket, e.Err}
+}
@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+// 模糊测试保证任意的输入都不会导致 panic，并且出错的时候返回的是 ParseError
+// 运行方式 go test -run=^$ -fuzz=FuzzStmtExecutePacket_Parse ./internal/protocol/mysql/internal/packet/parser
+
+func FuzzBase(f *testing.F) {
+	f.Add([]byte{0xfa})
+	f.Add([]byte{0xfc, 0xfb, 0x00})
+	f.Add([]byte{0xfd, 0x00, 0x00, 0x01})
+	f.Add([]byte{0xfe, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00})
+	f.Add([]byte{0x05, 'h', 'e', 'l', 'l', 'o'})
+	f.Add([]byte("root\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := &base{}
+		assertBaseErr := func(err error) {
+			if err != nil {
+				assert.True(t, errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errInvalidLengthEncodedInteger), err)
+			}
+		}
+		_, _, err := p.ParseLengthEncodedInteger(bytes.NewBuffer(data))
+		assertBaseErr(err)
+		_, err = p.ParseLengthEncodedString(bytes.NewBuffer(data))
+		assertBaseErr(err)
+		_, err = p.ParseVariableLengthBinary(bytes.NewBuffer(data))
+		assertBaseErr(err)
+		_, err = p.ParseNullTerminatedString(bytes.NewBuffer(data))
+		assertBaseErr(err)
+	})
+}
+
+func FuzzHandshakeResponse41_Parse(f *testing.F) {
+	clientFlag := flags.ClientProtocol41 | flags.ClientSecureConnection | flags.ClientPluginAuthLenencClientData |
+		flags.ClientConnectWithDB | flags.ClientPluginAuth | flags.ClientConnectAttrs | flags.ClientZstdCompressionAlgorithm
+	header := []byte{
+		byte(clientFlag), byte(clientFlag >> 8), byte(clientFlag >> 16), byte(clientFlag >> 24),
+		0x00, 0x00, 0x00, 0x01,
+		0x2d,
+	}
+	header = append(header, make([]byte, 23)...)
+	f.Add(header)
+	f.Add(append(bytes.Clone(header), "root\x00\x03\x01\x02\x03dbproxy\x00mysql_native_password\x00\x0a\x04_pid\x041234\x03"...))
+	f.Add(append(bytes.Clone(header), "root\x00\xfc\xff\xff"...))
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		assertParseErr(t, NewHandshakeResponse41().Parse(payload))
+	})
+}
+
+func FuzzQueryPacket_Parse(f *testing.F) {
+	f.Add(uint32(0), []byte("\x03SELECT 1"))
+	f.Add(uint32(flags.ClientQueryAttributes), []byte("\x03\x00\x01SELECT 1"))
+	f.Add(uint32(flags.ClientQueryAttributes), append([]byte{
+		0x03, 0x02, 0x01, 0x02, 0x01,
+		0xfe, 0x00, 0x02, 'i', 'd',
+		0x06, 0x00, 0x01, 'n',
+		0x03, 'a', 'b', 'c',
+	}, "SELECT 1"...))
+	f.Fuzz(func(t *testing.T, clientFlags uint32, payload []byte) {
+		err := NewQueryPacket(flags.CapabilityFlags(clientFlags)).Parse(payload)
+		if errors.Is(err, errUnsupportedParameterType) {
+			return
+		}
+		assertParseErr(t, err)
+	})
+}
+
+func FuzzSSLRequest_Parse(f *testing.F) {
+	clientFlag := flags.ClientProtocol41 | flags.ClientSSL
+	payload := []byte{
+		byte(clientFlag), byte(clientFlag >> 8), byte(clientFlag >> 16), byte(clientFlag >> 24),
+		0x00, 0x00, 0x00, 0x01,
+		0x2d,
+	}
+	f.Add(append(payload, make([]byte, 23)...))
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		assertParseErr(t, NewSSLRequest().Parse(payload))
+	})
+}
+
+func FuzzStmtExecutePacket_Parse(f *testing.F) {
+	f.Add(uint64(0), uint32(0), []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00})
+	f.Add(uint64(1), uint32(0), []byte{
+		0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x08, 0x00,
+		0xea, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	f.Add(uint64(2), uint32(0), []byte{
+		0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x01, 0x06, 0x00, 0xfe, 0x00,
+		0x03, 'a', 'b', 'c',
+	})
+	f.Add(uint64(0), uint32(flags.ClientQueryAttributes), []byte{
+		0x17, 0x01, 0x00, 0x00, 0x00, 0x08, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x01, 0x0f, 0x00, 0x01, 'a',
+		0x01, 'b',
+	})
+	f.Fuzz(func(t *testing.T, numParams uint64, clientFlags uint32, payload []byte) {
+		// prepare 阶段得到的参数个数是服务端自己算出来的，不会太大
+		numParams %= maxStmtParameterCount + 1
+		p := NewStmtExecutePacket(flags.CapabilityFlags(clientFlags), numParams)
+		p.SetLongData(map[uint16][]byte{1: []byte("long data")})
+		err := p.Parse(payload)
+		if errors.Is(err, errUnsupportedParameterType) {
+			return
+		}
+		assertParseErr(t, err)
+	})
+}
+
+func FuzzStmtFetchPacket_Parse(f *testing.F) {
+	f.Add([]byte{0x1c, 0x02, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		assertParseErr(t, NewStmtFetchPacket().Parse(payload))
+	})
+}
+
+func FuzzStmtPreparePacket_Parse(f *testing.F) {
+	f.Add([]byte("\x16SELECT * FROM users WHERE id = ?"))
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		assertParseErr(t, NewStmtPreparePacket().Parse(payload))
+	})
+}
+
+func FuzzStmtSendLongDataPacket_Parse(f *testing.F) {
+	f.Add([]byte{0x18, 0x02, 0x00, 0x00, 0x00, 0x01, 0x00, '{', '}'})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		assertParseErr(t, NewStmtSendLongDataPacket().Parse(payload))
+	})
+}
+
+// assertParseErr 解析失败的时候必须返回 ParseError
+func assertParseErr(t *testing.T, err error) {
+	if err == nil {
+		return
+	}
+	var pe *ParseError
+	assert.ErrorAs(t, err, &pe)
+	assert.ErrorIs(t, err, errs.ErrMalformedPacket)
+}
@@ -1,36 +1,194 @@
 package parser
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
 
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
 )
 
+const handshakeResponse41PacketName = "HandshakeResponse41"
+
 // HandshakeResponse41 是来自客户端的握手响应
 // 包含了头部字段, 去掉头部4个字节, 从第5个字节(编号为4)开始为响应载荷
 // https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_packets_protocol_handshake_response.html#sect_protocol_connection_phase_packets_protocol_handshake_response41
 type HandshakeResponse41 struct {
+	*base
+
 	// int<4>	client_flag	Capabilities Flags, CLIENT_PROTOCOL_41 always set.
 	clientFlag flags.CapabilityFlags
 
 	// int<4>	max_packet_size	maximum packet size
+	maxPacketSize uint32
 
 	// int<1>	character_set	client charset a_protocol_character_set, only the lower 8-bits
 	characterSet uint32
+
+	// string<NUL>	username	login user name
+	username string
+
+	// auth_response	opaque authentication response data generated by Authentication Method
+	// 长度编码方式取决于 CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA 和 CLIENT_SECURE_CONNECTION
+	authResponse []byte
+
+	// string<NUL>	database	initial database for the connection
+	// 当 CLIENT_CONNECT_WITH_DB 设置才会解析
+	database string
+
+	// string<NUL>	client_plugin_name	the Authentication Method used by the client to generate auth-response value in this packet
+	// 当 CLIENT_PLUGIN_AUTH 设置才会解析
+	authPluginName string
+
+	// 客户端连接属性，例如 _client_name, _os 等
+	// 当 CLIENT_CONNECT_ATTRS 设置才会解析
+	attrs map[string]string
+
+	// int<1>	zstd_compression_level	compression level for zstd compression algorithm
+	// 当 CLIENT_ZSTD_COMPRESSION_ALGORITHM 设置才会解析
+	zstdCompressionLevel byte
+}
+
+func NewHandshakeResponse41() *HandshakeResponse41 {
+	return &HandshakeResponse41{base: &base{}}
 }
 
 func (h *HandshakeResponse41) Parse(payload []byte) error {
+	// client_flag + max_packet_size + character_set + filler
+	const fixedLen = 4 + 4 + 1 + 23
+	if len(payload) < fixedLen {
+		return newParseError(handshakeResponse41PacketName, "client_flag",
+			fmt.Errorf("%w，长度 %d", io.ErrUnexpectedEOF, len(payload)))
+	}
 	h.clientFlag = flags.CapabilityFlags(flags.ClientProtocol41)
-	h.clientFlag |= flags.CapabilityFlags(binary.LittleEndian.Uint32(payload[4:8]))
-	h.characterSet = uint32(payload[12])
+	h.clientFlag |= flags.CapabilityFlags(binary.LittleEndian.Uint32(payload[0:4]))
+	h.maxPacketSize = binary.LittleEndian.Uint32(payload[4:8])
+	h.characterSet = uint32(payload[8])
+
+	buf := bytes.NewBuffer(payload[fixedLen:])
+	// 部分客户端只发送固定长度的部分
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	username, err := h.ParseNullTerminatedString(buf)
+	if err != nil {
+		return newParseError(handshakeResponse41PacketName, "username", err)
+	}
+	h.username = username
+
+	h.authResponse, err = h.parseAuthResponse(buf)
+	if err != nil {
+		return newParseError(handshakeResponse41PacketName, "auth_response", err)
+	}
+
+	if h.clientFlag.Has(flags.ClientConnectWithDB) {
+		h.database, err = h.ParseNullTerminatedString(buf)
+		if err != nil {
+			return newParseError(handshakeResponse41PacketName, "database", err)
+		}
+	}
+
+	if h.clientFlag.Has(flags.ClientPluginAuth) && buf.Len() > 0 {
+		h.authPluginName, err = h.ParseNullTerminatedString(buf)
+		if err != nil {
+			return newParseError(handshakeResponse41PacketName, "client_plugin_name", err)
+		}
+	}
+
+	if h.clientFlag.Has(flags.ClientConnectAttrs) && buf.Len() > 0 {
+		h.attrs, err = h.parseAttrs(buf)
+		if err != nil {
+			return newParseError(handshakeResponse41PacketName, "connect_attrs", err)
+		}
+	}
+
+	if h.clientFlag.Has(flags.ClientZstdCompressionAlgorithm) && buf.Len() > 0 {
+		h.zstdCompressionLevel, _ = buf.ReadByte()
+	}
 	return nil
 }
 
+func (h *HandshakeResponse41) parseAuthResponse(buf *bytes.Buffer) ([]byte, error) {
+	switch {
+	case h.clientFlag.Has(flags.ClientPluginAuthLenencClientData):
+		// string<lenenc>	auth_response
+		return h.ParseVariableLengthBinary(buf)
+	case h.clientFlag.Has(flags.ClientSecureConnection):
+		// int<1>	auth_response_length
+		// string<var>	auth_response
+		n, err := buf.ReadByte()
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return h.readN(buf, uint64(n))
+	default:
+		// string<NUL>	auth_response
+		str, err := h.ParseNullTerminatedString(buf)
+		return []byte(str), err
+	}
+}
+
+func (h *HandshakeResponse41) parseAttrs(buf *bytes.Buffer) (map[string]string, error) {
+	// int<lenenc>	length of all key-values
+	n, _, err := h.ParseLengthEncodedInteger(buf)
+	if err != nil {
+		return nil, err
+	}
+	data, err := h.readN(buf, n)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make(map[string]string, 8)
+	attrBuf := bytes.NewBuffer(data)
+	for attrBuf.Len() > 0 {
+		key, err := h.ParseLengthEncodedString(attrBuf)
+		if err != nil {
+			return nil, err
+		}
+		val, err := h.ParseLengthEncodedString(attrBuf)
+		if err != nil {
+			return nil, err
+		}
+		attrs[key] = val
+	}
+	return attrs, nil
+}
+
 func (h *HandshakeResponse41) ClientFlags() flags.CapabilityFlags {
 	return h.clientFlag
 }
 
+func (h *HandshakeResponse41) MaxPacketSize() uint32 {
+	return h.maxPacketSize
+}
+
 func (h *HandshakeResponse41) CharacterSet() uint32 {
-	// 跳过4个字节的 max_packet_size
 	return h.characterSet
 }
+
+func (h *HandshakeResponse41) Username() string {
+	return h.username
+}
+
+func (h *HandshakeResponse41) AuthResponse() []byte {
+	return h.authResponse
+}
+
+func (h *HandshakeResponse41) Database() string {
+	return h.database
+}
+
+func (h *HandshakeResponse41) AuthPluginName() string {
+	return h.authPluginName
+}
+
+func (h *HandshakeResponse41) Attrs() map[string]string {
+	return h.attrs
+}
+
+// ZstdCompressionLevel 客户端要求的 zstd 压缩级别，客户端没有发送的时候为 0
+func (h *HandshakeResponse41) ZstdCompressionLevel() byte {
+	return h.zstdCompressionLevel
+}
@@ -0,0 +1,145 @@
+package parser_test
+
+import (
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshakeResponse41_Parse(t *testing.T) {
+	filler := make([]byte, 23)
+	header := func(flag flags.CapabilityFlag) []byte {
+		clientFlag := uint32(flag)
+		res := []byte{
+			byte(clientFlag), byte(clientFlag >> 8), byte(clientFlag >> 16), byte(clientFlag >> 24), // client_flag
+			0x00, 0x00, 0x00, 0x01, // max_packet_size
+			0x2d, // character_set
+		}
+		return append(res, filler...)
+	}
+	concat := func(parts ...[]byte) []byte {
+		var res []byte
+		for _, p := range parts {
+			res = append(res, p...)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name    string
+		payload []byte
+
+		wantUsername       string
+		wantAuthResponse   []byte
+		wantDatabase       string
+		wantAuthPluginName string
+		wantAttrs          map[string]string
+		wantZstdLevel      byte
+		wantErr            assert.ErrorAssertionFunc
+	}{
+		{
+			name: "auth_response使用长度编码",
+			payload: concat(
+				header(flags.ClientProtocol41|flags.ClientSecureConnection|flags.ClientPluginAuthLenencClientData|
+					flags.ClientConnectWithDB|flags.ClientPluginAuth|flags.ClientConnectAttrs),
+				[]byte("root\x00"),
+				[]byte{0x03, 0x01, 0x02, 0x03},
+				[]byte("dbproxy\x00"),
+				[]byte("mysql_native_password\x00"),
+				[]byte{0x0a, 0x04}, []byte("_pid"), []byte{0x04}, []byte("1234"),
+			),
+			wantUsername:       "root",
+			wantAuthResponse:   []byte{0x01, 0x02, 0x03},
+			wantDatabase:       "dbproxy",
+			wantAuthPluginName: "mysql_native_password",
+			wantAttrs:          map[string]string{"_pid": "1234"},
+			wantErr:            assert.NoError,
+		},
+		{
+			name: "zstd压缩级别",
+			payload: concat(
+				header(flags.ClientProtocol41|flags.ClientSecureConnection|flags.ClientPluginAuth|
+					flags.ClientCompress|flags.ClientZstdCompressionAlgorithm),
+				[]byte("root\x00"),
+				[]byte{0x02, 0x01, 0x02},
+				[]byte("caching_sha2_password\x00"),
+				[]byte{0x07},
+			),
+			wantUsername:       "root",
+			wantAuthResponse:   []byte{0x01, 0x02},
+			wantAuthPluginName: "caching_sha2_password",
+			wantZstdLevel:      7,
+			wantErr:            assert.NoError,
+		},
+		{
+			name: "auth_response使用一个字节的长度",
+			payload: concat(
+				header(flags.ClientProtocol41|flags.ClientSecureConnection|flags.ClientPluginAuth),
+				[]byte("root\x00"),
+				[]byte{0x02, 0x01, 0x02},
+				[]byte("mysql_native_password\x00"),
+			),
+			wantUsername:       "root",
+			wantAuthResponse:   []byte{0x01, 0x02},
+			wantAuthPluginName: "mysql_native_password",
+			wantErr:            assert.NoError,
+		},
+		{
+			name: "auth_response以NUL结尾",
+			payload: concat(
+				header(flags.ClientProtocol41),
+				[]byte("root\x00"),
+				[]byte("abc\x00"),
+			),
+			wantUsername:     "root",
+			wantAuthResponse: []byte("abc"),
+			wantErr:          assert.NoError,
+		},
+		{
+			name:    "只有固定长度的部分",
+			payload: header(flags.ClientProtocol41),
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "长度不够",
+			payload: []byte{0x0d, 0xa2, 0x0f, 0x00},
+			wantErr: assert.Error,
+		},
+		{
+			name: "用户名没有结束符",
+			payload: concat(
+				header(flags.ClientProtocol41),
+				[]byte("root"),
+			),
+			wantErr: assert.Error,
+		},
+		{
+			name: "auth_response长度超出",
+			payload: concat(
+				header(flags.ClientProtocol41|flags.ClientSecureConnection),
+				[]byte("root\x00"),
+				[]byte{0x14, 0x01, 0x02},
+			),
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parser.NewHandshakeResponse41()
+			err := p.Parse(tt.payload)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, uint32(45), p.CharacterSet())
+			assert.Equal(t, tt.wantUsername, p.Username())
+			assert.Equal(t, tt.wantAuthResponse, p.AuthResponse())
+			assert.Equal(t, tt.wantDatabase, p.Database())
+			assert.Equal(t, tt.wantAuthPluginName, p.AuthPluginName())
+			assert.Equal(t, tt.wantAttrs, p.Attrs())
+			assert.Equal(t, tt.wantZstdLevel, p.ZstdCompressionLevel())
+		})
+	}
+}
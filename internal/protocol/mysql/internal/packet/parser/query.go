@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+)
+
+const queryPacketName = "COM_QUERY"
+
+// QueryPacket 用于解析客户端发送的 COM_QUERY 包
+// 客户端和服务端都支持 CLIENT_QUERY_ATTRIBUTES 的时候，查询语句前面是查询属性，格式和 COM_STMT_EXECUTE 的参数一样
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_query.html
+type QueryPacket struct {
+	*base
+
+	// clientCapabilityFlags 握手时协商的 flags，从 connection.Conn 中获取
+	// 不属于 COM_QUERY 包
+	clientCapabilityFlags flags.CapabilityFlags
+
+	// 以下是 COM_QUERY 包的各个字段
+
+	// int<1>	command	0x03: COM_QUERY
+	command byte
+
+	// int<lenenc>	parameter_count	Number of parameters
+	// int<lenenc>	parameter_set_count	Number of parameter sets. Currently always 1
+	// binary<var>	null_bitmap	NULL bitmap, length= (paramater_count + 7) / 8
+	// int<1>	new_params_bind_flag	Always 1. Malformed packet error if not 1
+	// 以及每个属性的类型、名字和值，当 CLIENT_QUERY_ATTRIBUTES 设置才会解析
+	attributes []StmtExecuteParameter
+
+	// string<EOF>	query	the text of the SQL query to execute
+	query string
+}
+
+func NewQueryPacket(clientCapabilityFlags flags.CapabilityFlags) *QueryPacket {
+	return &QueryPacket{
+		base:                  &base{},
+		clientCapabilityFlags: clientCapabilityFlags,
+	}
+}
+
+func (p *QueryPacket) Parse(payload []byte) error {
+	buf := bytes.NewBuffer(payload)
+	command, err := buf.ReadByte()
+	if err != nil {
+		return newParseError(queryPacketName, "command", io.ErrUnexpectedEOF)
+	}
+	if command != 0x03 {
+		return newParseError(queryPacketName, "command", fmt.Errorf("%x 不是 COM_QUERY", command))
+	}
+	p.command = command
+	if p.clientCapabilityFlags.Has(flags.ClientQueryAttributes) {
+		if err = p.parseAttributes(buf); err != nil {
+			return err
+		}
+	}
+	p.query = buf.String()
+	return nil
+}
+
+func (p *QueryPacket) parseAttributes(buf *bytes.Buffer) error {
+	count, _, err := p.ParseLengthEncodedInteger(buf)
+	if err != nil {
+		return newParseError(queryPacketName, "parameter_count", err)
+	}
+	if count > maxStmtParameterCount {
+		return newParseError(queryPacketName, "parameter_count",
+			fmt.Errorf("参数个数 %d 超过上限 %d", count, maxStmtParameterCount))
+	}
+	setCount, _, err := p.ParseLengthEncodedInteger(buf)
+	if err != nil {
+		return newParseError(queryPacketName, "parameter_set_count", err)
+	}
+	if setCount != 1 {
+		return newParseError(queryPacketName, "parameter_set_count", fmt.Errorf("%d 不是 1", setCount))
+	}
+	if count == 0 {
+		return nil
+	}
+	nullBitmap, err := p.readN(buf, (count+7)/8)
+	if err != nil {
+		return newParseError(queryPacketName, "null_bitmap", err)
+	}
+	bindFlag, err := buf.ReadByte()
+	if err != nil {
+		return newParseError(queryPacketName, "new_params_bind_flag", io.ErrUnexpectedEOF)
+	}
+	if bindFlag != 1 {
+		return newParseError(queryPacketName, "new_params_bind_flag", fmt.Errorf("%d 不是 1", bindFlag))
+	}
+	params := &StmtExecutePacket{
+		base:                  p.base,
+		name:                  queryPacketName,
+		clientCapabilityFlags: p.clientCapabilityFlags,
+		parameterCount:        count,
+		nullBitmap:            nullBitmap,
+		newParamsBindFlag:     bindFlag,
+	}
+	if err = params.parseParameters(buf); err != nil {
+		return err
+	}
+	p.attributes = params.Parameters()
+	return nil
+}
+
+// Query 查询语句
+func (p *QueryPacket) Query() string {
+	return p.query
+}
+
+// Attributes 客户端通过 CLIENT_QUERY_ATTRIBUTES 发送的查询属性，Name 是属性名
+func (p *QueryPacket) Attributes() []StmtExecuteParameter {
+	return p.attributes
+}
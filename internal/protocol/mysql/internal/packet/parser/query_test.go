@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryPacket_Parse(t *testing.T) {
+	queryAttributes := flags.CapabilityFlags(flags.ClientProtocol41 | flags.ClientQueryAttributes)
+	tests := []struct {
+		name        string
+		capabilites flags.CapabilityFlags
+		payload     []byte
+
+		wantQuery      string
+		wantAttributes []StmtExecuteParameter
+		wantErr        error
+	}{
+		{
+			name:        "没有查询属性",
+			capabilites: flags.CapabilityFlags(flags.ClientProtocol41),
+			payload:     append([]byte{0x03}, "SELECT 1"...),
+			wantQuery:   "SELECT 1",
+		},
+		{
+			name:        "查询属性为空",
+			capabilites: queryAttributes,
+			payload:     append([]byte{0x03, 0x00, 0x01}, "SELECT 1"...),
+			wantQuery:   "SELECT 1",
+		},
+		{
+			name:        "有查询属性",
+			capabilites: queryAttributes,
+			payload: append([]byte{
+				0x03,       // command
+				0x02,       // parameter_count
+				0x01,       // parameter_set_count
+				0x02,       // null_bitmap，第二个属性是 NULL
+				0x01,       // new_params_bind_flag
+				0xfe, 0x00, // 第一个属性的类型
+				0x02, 'i', 'd', // 第一个属性的名字
+				0x06, 0x00, // 第二个属性的类型
+				0x01, 'n', // 第二个属性的名字
+				0x03, 'a', 'b', 'c', // 第一个属性的值
+			}, "SELECT 1"...),
+			wantQuery: "SELECT 1",
+			wantAttributes: []StmtExecuteParameter{
+				{Type: packet.MySQLTypeString, Name: "id", Value: "abc"},
+				{Type: packet.MySQLTypeNULL, Name: "n"},
+			},
+		},
+		{
+			name:        "命令不对",
+			capabilites: queryAttributes,
+			payload:     []byte{0x16, 0x00, 0x01},
+			wantErr:     errs.ErrMalformedPacket,
+		},
+		{
+			name:        "parameter_set_count不对",
+			capabilites: queryAttributes,
+			payload:     append([]byte{0x03, 0x00, 0x02}, "SELECT 1"...),
+			wantErr:     errs.ErrMalformedPacket,
+		},
+		{
+			name:        "属性被截断",
+			capabilites: queryAttributes,
+			payload:     []byte{0x03, 0x01, 0x01, 0x00, 0x01, 0xfe, 0x00},
+			wantErr:     errs.ErrMalformedPacket,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewQueryPacket(tt.capabilites)
+			err := p.Parse(tt.payload)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.wantQuery, p.Query())
+			assert.Equal(t, tt.wantAttributes, p.Attributes())
+		})
+	}
+}
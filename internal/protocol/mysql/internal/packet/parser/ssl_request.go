@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+)
+
+// SSLRequestPacketLength SSLRequest 报文的载荷长度是固定的
+// 4 字节 client_flag + 4 字节 max_packet_size + 1 字节 character_set + 23 字节 filler
+const SSLRequestPacketLength = 32
+
+// SSLRequest 是客户端在握手阶段要求切换到 TLS 时发送的报文
+// 它相当于 HandshakeResponse41 的前 32 个字节，不包含用户名、密码等信息
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_packets_protocol_ssl_request.html
+type SSLRequest struct {
+	// int<4>	client_flag	Capabilities Flags, CLIENT_SSL always set
+	clientFlag flags.CapabilityFlags
+	// int<4>	max_packet_size	maximum packet size
+	maxPacketSize uint32
+	// int<1>	character_set	client charset a_protocol_character_set, only the lower 8-bits
+	characterSet uint32
+}
+
+func NewSSLRequest() *SSLRequest {
+	return &SSLRequest{}
+}
+
+// IsSSLRequest 判断握手阶段收到的报文是不是 SSLRequest
+// SSLRequest 和 HandshakeResponse41 的区别在于前者长度固定为 32 且设置了 CLIENT_SSL
+func IsSSLRequest(payload []byte) bool {
+	if len(payload) != SSLRequestPacketLength {
+		return false
+	}
+	return flags.CapabilityFlags(binary.LittleEndian.Uint32(payload[:4])).Has(flags.ClientSSL)
+}
+
+func (r *SSLRequest) Parse(payload []byte) error {
+	if len(payload) != SSLRequestPacketLength {
+		return newParseError("SSLRequest", "client_flag", fmt.Errorf("长度 %d 不是 %d", len(payload), SSLRequestPacketLength))
+	}
+	r.clientFlag = flags.CapabilityFlags(binary.LittleEndian.Uint32(payload[:4]))
+	if !r.clientFlag.Has(flags.ClientSSL) {
+		return newParseError("SSLRequest", "client_flag", errors.New("未设置 CLIENT_SSL"))
+	}
+	r.maxPacketSize = binary.LittleEndian.Uint32(payload[4:8])
+	r.characterSet = uint32(payload[8])
+	return nil
+}
+
+func (r *SSLRequest) ClientFlags() flags.CapabilityFlags {
+	return r.clientFlag
+}
+
+func (r *SSLRequest) MaxPacketSize() uint32 {
+	return r.maxPacketSize
+}
+
+func (r *SSLRequest) CharacterSet() uint32 {
+	return r.characterSet
+}
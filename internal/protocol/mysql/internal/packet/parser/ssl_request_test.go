@@ -0,0 +1,65 @@
+package parser_test
+
+import (
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSLRequest_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+
+		wantIsSSLRequest bool
+		wantClientFlags  flags.CapabilityFlags
+		wantCharset      uint32
+		wantErr          assert.ErrorAssertionFunc
+	}{
+		{
+			name: "正常情况",
+			payload: []byte{
+				0x0d, 0xaa, 0x0f, 0x00, // client_flag
+				0x00, 0x00, 0x00, 0x00, // max_packet_size
+				0x2d,                                                                   // character_set
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // filler
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			wantIsSSLRequest: true,
+			wantClientFlags:  flags.CapabilityFlags(0x0faa0d),
+			wantCharset:      45,
+			wantErr:          assert.NoError,
+		},
+		{
+			name: "没有设置CLIENT_SSL",
+			payload: []byte{
+				0x0d, 0xa2, 0x0f, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+				0x2d,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			wantErr: assert.Error,
+		},
+		{
+			name:    "长度不对",
+			payload: []byte{0x0d, 0xaa, 0x0f, 0x00},
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantIsSSLRequest, parser.IsSSLRequest(tt.payload))
+			p := parser.NewSSLRequest()
+			err := p.Parse(tt.payload)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.wantClientFlags, p.ClientFlags())
+			assert.Equal(t, tt.wantCharset, p.CharacterSet())
+		})
+	}
+}
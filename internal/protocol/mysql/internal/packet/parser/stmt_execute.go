@@ -3,18 +3,31 @@ package parser
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
 )
 
+const (
+	stmtExecutePacketName = "COM_STMT_EXECUTE"
+	// maxStmtParameterCount 一条预处理语句最多只能有 65535 个参数，和 MySQL 保持一致
+	maxStmtParameterCount = 65535
+)
+
+// errUnsupportedParameterType 参数的类型还不支持，报文本身是合法的
+var errUnsupportedParameterType = errors.New("不支持的参数类型")
+
 // StmtExecutePacket 用于解析客户端发送的 COM_STMT_EXECUTE 包
 // https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_stmt_execute.html
 type StmtExecutePacket struct {
 	*base
 
+	// name 报文的名字，用于错误信息。COM_QUERY 中的查询属性和参数的格式一样，也复用这里的解析逻辑
+	name string
+
 	// clientCapabilityFlags 客户端与服务端建立连接、握手时传递的参数,从 connection.Conn 中获取
 	// 不属于 COM_STMT_EXECUTE 包
 	clientCapabilityFlags flags.CapabilityFlags
@@ -53,6 +66,10 @@ type StmtExecutePacket struct {
 	// binary<var>	parameter_values	value of each parameter
 	// parameters 当 newParamsBindFlag != 0 才会解析
 	parameters []StmtExecuteParameter
+
+	// longData 之前通过 COM_STMT_SEND_LONG_DATA 发送的参数，key 是参数的下标
+	// 不属于 COM_STMT_EXECUTE 包，这些参数在 parameter_values 中没有对应的值
+	longData map[uint16][]byte
 }
 
 type StmtExecuteParameter struct {
@@ -67,50 +84,52 @@ type StmtExecuteParameter struct {
 func NewStmtExecutePacket(clientCapabilityFlags flags.CapabilityFlags, numParams uint64) *StmtExecutePacket {
 	return &StmtExecutePacket{
 		base:                  &base{},
+		name:                  stmtExecutePacketName,
 		clientCapabilityFlags: clientCapabilityFlags,
 		numParams:             numParams,
 	}
 }
 
+// SetLongData 设置通过 COM_STMT_SEND_LONG_DATA 发送的参数，需要在 Parse 之前调用
+func (p *StmtExecutePacket) SetLongData(longData map[uint16][]byte) {
+	p.longData = longData
+}
+
 func (p *StmtExecutePacket) Parse(payload []byte) error {
-	log.Printf("pay = %#v\n", payload)
 	buf := bytes.NewBuffer(payload)
 
 	// 解析 Command
-	if err := binary.Read(buf, binary.LittleEndian, &p.status); err != nil {
-		return fmt.Errorf("error reading Command: %v", err)
+	status, err := buf.ReadByte()
+	if err != nil {
+		return newParseError(stmtExecutePacketName, "command", io.ErrUnexpectedEOF)
 	}
 
 	// Command 验证
-	if p.status != 0x17 {
-		return fmt.Errorf("invalid Command: %x", p.status)
-	}
-
-	// 解析 StatementID
-	if err := binary.Read(buf, binary.LittleEndian, &p.statementID); err != nil {
-		return fmt.Errorf("error reading StatementID: %v", err)
-	}
-
-	// 解析 Flags
-	if err := binary.Read(buf, binary.LittleEndian, &p.flags); err != nil {
-		return fmt.Errorf("error reading Flags: %v", err)
+	if status != 0x17 {
+		return newParseError(stmtExecutePacketName, "command", fmt.Errorf("%x 不是 COM_STMT_EXECUTE", status))
 	}
+	p.status = status
 
-	// 解析 IterationCount
-	if err := binary.Read(buf, binary.LittleEndian, &p.iterationCount); err != nil {
-		return fmt.Errorf("error reading IterationCount: %v", err)
+	// statement_id + flags + iteration_count
+	header, err := p.next(buf, 4+1+4)
+	if err != nil {
+		return newParseError(stmtExecutePacketName, "statement_id", err)
 	}
+	p.statementID = binary.LittleEndian.Uint32(header[0:4])
+	p.flags = header[4]
+	p.iterationCount = binary.LittleEndian.Uint32(header[5:9])
 
 	// 判断并解析参数数量
-	var err error
-
 	if p.numParams > 0 || (p.isClientQueryAttributesFlagOn() && (packet.CursorType(p.flags)&packet.ParameterCountAvailable) != 0) {
 
 		if p.isClientQueryAttributesFlagOn() {
-			log.Printf("isClientQueryAttributesFlagOn = %#v\n", p.clientCapabilityFlags)
 			p.parameterCount, _, err = p.ParseLengthEncodedInteger(buf)
 			if err != nil {
-				return fmt.Errorf("error reading ParameterCount: %v", err)
+				return newParseError(stmtExecutePacketName, "parameter_count", err)
+			}
+			if p.parameterCount > maxStmtParameterCount {
+				return newParseError(stmtExecutePacketName, "parameter_count",
+					fmt.Errorf("参数个数 %d 超过上限 %d", p.parameterCount, maxStmtParameterCount))
 			}
 		} else {
 			p.parameterCount = p.numParams
@@ -118,25 +137,18 @@ func (p *StmtExecutePacket) Parse(payload []byte) error {
 
 		// 如果 ParameterCount 大于 0
 		if p.parameterCount > 0 {
-
-			nullBitmapLen := (p.parameterCount + 7) / 8
-			p.nullBitmap = make([]byte, nullBitmapLen)
-			if _, err := buf.Read(p.nullBitmap); err != nil {
-				return fmt.Errorf("error reading NullBitmap: %v", err)
+			p.nullBitmap, err = p.readN(buf, (p.parameterCount+7)/8)
+			if err != nil {
+				return newParseError(stmtExecutePacketName, "null_bitmap", err)
 			}
 
-			log.Printf("req.nullBitmap = %#v\n", p.nullBitmap)
-
-			log.Printf("buf = %#v\n", buf.Bytes())
-			if err := binary.Read(buf, binary.LittleEndian, &p.newParamsBindFlag); err != nil {
-				return fmt.Errorf("error reading NewParamsBindFlag: %v", err)
+			p.newParamsBindFlag, err = buf.ReadByte()
+			if err != nil {
+				return newParseError(stmtExecutePacketName, "new_params_bind_flag", io.ErrUnexpectedEOF)
 			}
 
-			log.Printf("NewParamsBindFlag = %#v\n", p.newParamsBindFlag)
-
-			err1 := p.parseParameters(buf)
-			if err1 != nil {
-				return err1
+			if err = p.parseParameters(buf); err != nil {
+				return err
 			}
 		}
 	}
@@ -144,6 +156,11 @@ func (p *StmtExecutePacket) Parse(payload []byte) error {
 	return nil
 }
 
+// CursorType 客户端要求的游标类型
+func (p *StmtExecutePacket) CursorType() packet.CursorType {
+	return packet.CursorType(p.flags)
+}
+
 func (p *StmtExecutePacket) isClientQueryAttributesFlagOn() bool {
 	return p.clientCapabilityFlags.Has(flags.ClientQueryAttributes)
 }
@@ -156,102 +173,126 @@ func (p *StmtExecutePacket) parseParameters(buf *bytes.Buffer) error {
 
 	p.parameters = make([]StmtExecuteParameter, p.parameterCount)
 
-	err2 := p.parseParametersType(buf)
+	err2 := p.parseParametersTypeAndName(buf)
 	if err2 != nil {
 		return err2
 	}
 
-	err3 := p.parseParametersName(buf)
+	err3 := p.parseParametersValue(buf)
 	if err3 != nil {
 		return err3
 	}
-
-	err4 := p.parseParametersValue(buf)
-	if err4 != nil {
-		return err4
-	}
 	return nil
 }
 
-func (p *StmtExecutePacket) parseParametersType(buf *bytes.Buffer) error {
-	if p.isNewParamsBindFlagOn() {
-		for i := uint64(0); i < p.parameterCount; i++ {
-			if err := binary.Read(buf, binary.LittleEndian, &p.parameters[i].Type); err != nil {
-				return fmt.Errorf("解析参数[%d]的类型失败: %v", i, err)
-			}
-		}
+// parseParametersTypeAndName 每个参数的类型后面紧跟着参数的名字，名字只有在 CLIENT_QUERY_ATTRIBUTES 设置的时候才有
+func (p *StmtExecutePacket) parseParametersTypeAndName(buf *bytes.Buffer) error {
+	if !p.isNewParamsBindFlagOn() {
+		return nil
 	}
-	return nil
-}
-
-func (p *StmtExecutePacket) parseParametersName(buf *bytes.Buffer) error {
-	if p.isNewParamsBindFlagOn() && p.isClientQueryAttributesFlagOn() {
-		for i := uint64(0); i < p.parameterCount; i++ {
-			name, err := p.ParseLengthEncodedString(buf)
-			if err != nil {
-				return fmt.Errorf("解析参数[%d]的名称失败: %v", i, err)
-			}
-			p.parameters[i].Name = name
+	for i := uint64(0); i < p.parameterCount; i++ {
+		b, err := p.next(buf, 2)
+		if err != nil {
+			return newParseError(p.name, fmt.Sprintf("参数[%d]的类型", i), err)
+		}
+		p.parameters[i].Type = packet.MySQLType(binary.LittleEndian.Uint16(b))
+		if !p.isClientQueryAttributesFlagOn() {
+			continue
 		}
+		name, err := p.ParseLengthEncodedString(buf)
+		if err != nil {
+			return newParseError(p.name, fmt.Sprintf("参数[%d]的名称", i), err)
+		}
+		p.parameters[i].Name = name
 	}
 	return nil
 }
 
 func (p *StmtExecutePacket) parseParametersValue(buf *bytes.Buffer) error {
 	for i := uint64(0); i < p.parameterCount; i++ {
+		if data, ok := p.longData[uint16(i)]; ok {
+			// 客户端不会再发送这个参数的值
+			p.parameters[i].Value = data
+			continue
+		}
+		if p.isNull(i) {
+			// NULL 参数在 parameter_values 中没有对应的数据
+			continue
+		}
 		value, err := p.parseParameterValue(buf, p.parameters[i].Type)
+		if errors.Is(err, errUnsupportedParameterType) {
+			return fmt.Errorf("解析参数[%d]的数值失败: %w", i, err)
+		}
 		if err != nil {
-			return fmt.Errorf("解析参数[%d]的数值失败: %v", i, err)
+			return newParseError(p.name, fmt.Sprintf("参数[%d]的数值", i), err)
 		}
 		p.parameters[i].Value = value
 	}
 	return nil
 }
 
+// isNull 根据 null_bitmap 判断第 i 个参数是不是 NULL
+func (p *StmtExecutePacket) isNull(i uint64) bool {
+	return p.nullBitmap[i/8]&(1<<(i%8)) != 0
+}
+
 // parseParameterValue 根据字段的类型来读取对应的字段值
 func (p *StmtExecutePacket) parseParameterValue(buf *bytes.Buffer, fieldType packet.MySQLType) (any, error) {
 	switch fieldType {
 	case packet.MySQLTypeLongLong:
 		var value int64
-		if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
-			return nil, fmt.Errorf("error reading LONGLONG value: %v", err)
+		if err := p.readFixed(buf, &value); err != nil {
+			return nil, err
 		}
 		return value, nil
 	case packet.MySQLTypeLong:
 		var value int32
-		if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
-			return nil, fmt.Errorf("error reading LONG value: %v", err)
+		if err := p.readFixed(buf, &value); err != nil {
+			return nil, err
 		}
 		return value, nil
 	case packet.MySQLTypeShort:
 		var value int16
-		if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
-			return nil, fmt.Errorf("error reading SHORT value: %v", err)
+		if err := p.readFixed(buf, &value); err != nil {
+			return nil, err
 		}
 		return value, nil
 	case packet.MySQLTypeTiny:
 		var value int8
-		if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
-			return nil, fmt.Errorf("error reading TINY value: %v", err)
+		if err := p.readFixed(buf, &value); err != nil {
+			return nil, err
 		}
 		return value, nil
 	case packet.MySQLTypeFloat:
 		var value float32
-		if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
-			return nil, fmt.Errorf("error reading FLOAT value: %v", err)
+		if err := p.readFixed(buf, &value); err != nil {
+			return nil, err
 		}
 		return value, nil
 	case packet.MySQLTypeDouble:
 		var value float64
-		if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
-			return nil, fmt.Errorf("error reading DOUBLE value: %v", err)
+		if err := p.readFixed(buf, &value); err != nil {
+			return nil, err
 		}
 		return value, nil
-	case packet.MySQLTypeString, packet.MySQLTypeVarchar, packet.MySQLTypeVarString, packet.MySQLTypeDecimal:
+	case packet.MySQLTypeString, packet.MySQLTypeVarchar, packet.MySQLTypeVarString, packet.MySQLTypeDecimal,
+		packet.MySQLTypeNewDecimal, packet.MySQLTypeJSON:
 		return p.ParseLengthEncodedString(buf)
+	case packet.MySQLTypeTinyBlob, packet.MySQLTypeMediumBlob, packet.MySQLTypeLongBlob, packet.MySQLTypeBlob:
+		return p.ParseVariableLengthBinary(buf)
+	case packet.MySQLTypeNULL:
+		return nil, nil
 	default:
-		return nil, fmt.Errorf("支持的的参数类型: %d", fieldType)
+		return nil, fmt.Errorf("%w: %d", errUnsupportedParameterType, fieldType)
+	}
+}
+
+// readFixed 读取定长的数值，数据不够的时候返回 io.ErrUnexpectedEOF
+func (p *StmtExecutePacket) readFixed(buf *bytes.Buffer, value any) error {
+	if n := binary.Size(value); buf.Len() < n {
+		return fmt.Errorf("%w，预期 %d 字节，剩余 %d 字节", io.ErrUnexpectedEOF, n, buf.Len())
 	}
+	return binary.Read(buf, binary.LittleEndian, value)
 }
 
 func (p *StmtExecutePacket) Parameters() []StmtExecuteParameter {
@@ -3,6 +3,7 @@ package parser
 import (
 	"testing"
 
+	"github.com/meoying/dbproxy/internal/errs"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/encoding"
@@ -15,6 +16,7 @@ func TestStmtExecutePacket_Parse(t *testing.T) {
 		payload               []byte
 		numParams             uint64
 		clientCapabilityFlags flags.CapabilityFlags
+		longData              map[uint16][]byte
 		expected              *StmtExecutePacket
 		errAssertFunc         assert.ErrorAssertionFunc
 	}{
@@ -216,6 +218,86 @@ func TestStmtExecutePacket_Parse(t *testing.T) {
 			}(),
 			errAssertFunc: assert.NoError,
 		},
+		{
+			name: "NULL参数",
+			payload: []byte{
+				0x17,                   // command
+				0x01, 0x00, 0x00, 0x00, // statement_id
+				0x00,                   // flags
+				0x01, 0x00, 0x00, 0x00, // iteration_count
+				0x01,       // null_bitmap 第一个参数是 NULL
+				0x01,       // new_params_bind_flag
+				0x08, 0x00, // params[0].Type
+				0x08, 0x00, // params[1].Type
+				// 只有 params[1].Value
+				0x16, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			numParams: 2,
+			expected: func() *StmtExecutePacket {
+				p := NewStmtExecutePacket(0, 2)
+				p.status = 0x17
+				p.statementID = 1
+				p.iterationCount = 1
+				p.parameterCount = 2
+				p.nullBitmap = []byte{0x01}
+				p.newParamsBindFlag = 0x01
+				p.parameters = []StmtExecuteParameter{
+					{Type: packet.MySQLTypeLongLong},
+					{Type: packet.MySQLTypeLongLong, Value: int64(22)},
+				}
+				return p
+			}(),
+			errAssertFunc: assert.NoError,
+		},
+		{
+			name: "参数值被截断",
+			payload: []byte{
+				0x17,                   // command
+				0x01, 0x00, 0x00, 0x00, // statement_id
+				0x00,                   // flags
+				0x01, 0x00, 0x00, 0x00, // iteration_count
+				0x00,       // null_bitmap
+				0x01,       // new_params_bind_flag
+				0x08, 0x00, // parameter_type
+				0xea, 0x03, // Value 只有 2 个字节
+			},
+			numParams: 1,
+			errAssertFunc: func(t assert.TestingT, err error, i ...interface{}) bool {
+				return assert.ErrorIs(t, err, errs.ErrMalformedPacket, i...)
+			},
+		},
+		{
+			name: "字符串长度超过报文长度",
+			payload: []byte{
+				0x17,                   // command
+				0x01, 0x00, 0x00, 0x00, // statement_id
+				0x00,                   // flags
+				0x01, 0x00, 0x00, 0x00, // iteration_count
+				0x00,       // null_bitmap
+				0x01,       // new_params_bind_flag
+				0xfb, 0x00, // parameter_type MYSQL_TYPE_LONG_BLOB
+				0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, // 长度
+				0x01,
+			},
+			numParams: 1,
+			errAssertFunc: func(t assert.TestingT, err error, i ...interface{}) bool {
+				return assert.ErrorIs(t, err, errs.ErrMalformedPacket, i...)
+			},
+		},
+		{
+			name: "参数个数过多",
+			payload: []byte{
+				0x17,                   // command
+				0x01, 0x00, 0x00, 0x00, // statement_id
+				0x08,                   // flags PARAMETER_COUNT_AVAILABLE
+				0x01, 0x00, 0x00, 0x00, // iteration_count
+				0xfe, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, // parameter_count
+			},
+			clientCapabilityFlags: flags.ClientQueryAttributes,
+			errAssertFunc: func(t assert.TestingT, err error, i ...interface{}) bool {
+				return assert.ErrorIs(t, err, errs.ErrMalformedPacket, i...)
+			},
+		},
 		{
 			name:          "命令非法",
 			payload:       []byte{0x18, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}, // Command is not 0x17
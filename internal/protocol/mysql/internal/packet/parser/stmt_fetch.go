@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// stmtFetchPacketLength COM_STMT_FETCH 报文的载荷长度是固定的
+// 1 字节 command + 4 字节 statement_id + 4 字节 num_rows
+const stmtFetchPacketLength = 9
+
+// StmtFetchPacket 用于解析客户端发送的 COM_STMT_FETCH 包
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_stmt_fetch.html
+type StmtFetchPacket struct {
+	// int<4>	stmt_id	ID of the prepared statement to close
+	statementID uint32
+	// int<4>	num_rows	max number of rows to return
+	numRows uint32
+}
+
+func NewStmtFetchPacket() *StmtFetchPacket {
+	return &StmtFetchPacket{}
+}
+
+func (p *StmtFetchPacket) Parse(payload []byte) error {
+	if len(payload) != stmtFetchPacketLength {
+		return newParseError("COM_STMT_FETCH", "statement_id", fmt.Errorf("长度 %d 不是 %d", len(payload), stmtFetchPacketLength))
+	}
+	// int<1>	status	[0x1C] COM_STMT_FETCH
+	if payload[0] != 0x1c {
+		return newParseError("COM_STMT_FETCH", "command", fmt.Errorf("%x 不是 COM_STMT_FETCH", payload[0]))
+	}
+	p.statementID = binary.LittleEndian.Uint32(payload[1:5])
+	p.numRows = binary.LittleEndian.Uint32(payload[5:9])
+	return nil
+}
+
+func (p *StmtFetchPacket) StatementID() uint32 {
+	return p.statementID
+}
+
+func (p *StmtFetchPacket) NumRows() uint32 {
+	return p.numRows
+}
@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStmtFetchPacket_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+
+		wantStatementID uint32
+		wantNumRows     uint32
+		wantErr         assert.ErrorAssertionFunc
+	}{
+		{
+			name: "正常情况",
+			payload: []byte{
+				0x1c,                   // status
+				0x02, 0x00, 0x00, 0x00, // statement_id
+				0x0a, 0x00, 0x00, 0x00, // num_rows
+			},
+			wantStatementID: 2,
+			wantNumRows:     10,
+			wantErr:         assert.NoError,
+		},
+		{
+			name: "命令不对",
+			payload: []byte{
+				0x17,
+				0x02, 0x00, 0x00, 0x00,
+				0x0a, 0x00, 0x00, 0x00,
+			},
+			wantErr: assert.Error,
+		},
+		{
+			name:    "长度不对",
+			payload: []byte{0x1c, 0x02, 0x00, 0x00, 0x00},
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewStmtFetchPacket()
+			err := p.Parse(tt.payload)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.wantStatementID, p.StatementID())
+			assert.Equal(t, tt.wantNumRows, p.NumRows())
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package parser
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+)
 
 // StmtPreparePacket 用于解析客户端发送的 COM_STMT_PREPARE 包
 // https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_stmt_prepare.html
@@ -17,10 +20,10 @@ func NewStmtPreparePacket() *StmtPreparePacket {
 
 func (p *StmtPreparePacket) Parse(payload []byte) error {
 	if len(payload) < 1 {
-		return fmt.Errorf("请求格式非法: PrepareStmt")
+		return newParseError("COM_STMT_PREPARE", "command", io.ErrUnexpectedEOF)
 	}
 	if payload[0] != 0x16 {
-		return fmt.Errorf("命令非法: %d", payload[0])
+		return newParseError("COM_STMT_PREPARE", "command", fmt.Errorf("%x 不是 COM_STMT_PREPARE", payload[0]))
 	}
 	p.command = payload[0]
 	p.query = string(payload[1:])
@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// stmtSendLongDataHeaderLength COM_STMT_SEND_LONG_DATA 报文中数据之前部分的长度
+// 1 字节 command + 4 字节 statement_id + 2 字节 param_id
+const stmtSendLongDataHeaderLength = 7
+
+// StmtSendLongDataPacket 用于解析客户端发送的 COM_STMT_SEND_LONG_DATA 包
+// 客户端可以多次发送同一个参数的数据，服务端需要将它们拼接起来
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_stmt_send_long_data.html
+type StmtSendLongDataPacket struct {
+	// int<4>	statement_id	ID of the statement
+	statementID uint32
+	// int<2>	param_id	The parameter to supply data to
+	paramID uint16
+	// binary<var>	data	The actual payload to send
+	data []byte
+}
+
+func NewStmtSendLongDataPacket() *StmtSendLongDataPacket {
+	return &StmtSendLongDataPacket{}
+}
+
+func (p *StmtSendLongDataPacket) Parse(payload []byte) error {
+	if len(payload) < stmtSendLongDataHeaderLength {
+		return newParseError("COM_STMT_SEND_LONG_DATA", "param_id", fmt.Errorf("%w，长度 %d", io.ErrUnexpectedEOF, len(payload)))
+	}
+	// int<1>	status	[0x18] COM_STMT_SEND_LONG_DATA
+	if payload[0] != 0x18 {
+		return newParseError("COM_STMT_SEND_LONG_DATA", "command", fmt.Errorf("%x 不是 COM_STMT_SEND_LONG_DATA", payload[0]))
+	}
+	p.statementID = binary.LittleEndian.Uint32(payload[1:5])
+	p.paramID = binary.LittleEndian.Uint16(payload[5:7])
+	p.data = payload[stmtSendLongDataHeaderLength:]
+	return nil
+}
+
+func (p *StmtSendLongDataPacket) StatementID() uint32 {
+	return p.statementID
+}
+
+func (p *StmtSendLongDataPacket) ParamID() uint16 {
+	return p.paramID
+}
+
+// Data 数据直接引用了 payload，调用者需要自己复制
+func (p *StmtSendLongDataPacket) Data() []byte {
+	return p.data
+}
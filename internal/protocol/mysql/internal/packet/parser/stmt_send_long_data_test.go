@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStmtSendLongDataPacket_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+
+		wantStatementID uint32
+		wantParamID     uint16
+		wantData        []byte
+		wantErr         assert.ErrorAssertionFunc
+	}{
+		{
+			name: "正常情况",
+			payload: []byte{
+				0x18,                   // status
+				0x02, 0x00, 0x00, 0x00, // statement_id
+				0x01, 0x00, // param_id
+				'{', '}', // data
+			},
+			wantStatementID: 2,
+			wantParamID:     1,
+			wantData:        []byte("{}"),
+			wantErr:         assert.NoError,
+		},
+		{
+			name: "没有数据",
+			payload: []byte{
+				0x18,
+				0x02, 0x00, 0x00, 0x00,
+				0x01, 0x00,
+			},
+			wantStatementID: 2,
+			wantParamID:     1,
+			wantData:        []byte{},
+			wantErr:         assert.NoError,
+		},
+		{
+			name: "命令不对",
+			payload: []byte{
+				0x17,
+				0x02, 0x00, 0x00, 0x00,
+				0x01, 0x00,
+			},
+			wantErr: assert.Error,
+		},
+		{
+			name:    "长度不对",
+			payload: []byte{0x18, 0x02, 0x00, 0x00, 0x00},
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewStmtSendLongDataPacket()
+			err := p.Parse(tt.payload)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.wantStatementID, p.StatementID())
+			assert.Equal(t, tt.wantParamID, p.ParamID())
+			assert.Equal(t, tt.wantData, p.Data())
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package pcontext
 
 import (
+	"time"
+
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast/parser"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/visitor/vparser"
@@ -13,13 +15,18 @@ type ParsedQuery struct {
 	typeName string
 	// TODO: 在这里把 Hint 放好，在解析 Root 的地方就解析出来放好（这可以认为是一个统一的机制）
 	hints ast.Hints
+	// err 语法错误，这时候语句的类型是 vparser.UnKnownSQLStmt
+	err error
 }
 
+// NewParsedQuery 解析 query，有语法错误的时候不会失败，
+// 语句会被当成 dbproxy 不认识的语句交给插件，例如原样转发给后端，由后端返回错误
 func NewParsedQuery(query string) ParsedQuery {
 	ctx := ast.Parse(query)
 	return ParsedQuery{
 		root:  ctx.Root,
 		hints: ctx.Hints,
+		err:   ctx.Err,
 	}
 }
 
@@ -29,7 +36,11 @@ func (q *ParsedQuery) Root() parser.IRootContext {
 
 func (q *ParsedQuery) Type() string {
 	if q.typeName == "" {
-		q.typeName = vparser.NewCheckVisitor().Visit(q.root).(string)
+		if q.err != nil {
+			q.typeName = vparser.UnKnownSQLStmt
+		} else {
+			q.typeName = vparser.NewCheckVisitor().Visit(q.root).(string)
+		}
 	}
 	return q.typeName
 }
@@ -45,6 +56,20 @@ func (q *ParsedQuery) UseMaster() bool {
 	return useMaster == "true"
 }
 
+// Timeout 通过 /* @proxy timeout=500ms */ 指定的语句超时时间，格式参考 time.ParseDuration
+// 没有指定或者格式不对的时候返回 0
+func (q *ParsedQuery) Timeout() time.Duration {
+	val, err := q.Hints()["timeout"].String()
+	if err != nil || val == "" {
+		return 0
+	}
+	timeout, err := time.ParseDuration(val)
+	if err != nil || timeout < 0 {
+		return 0
+	}
+	return timeout
+}
+
 // FirstDML 第一个 DML 语句，也就是增删改查语句。
 // 我们会认为必然有一个语句，参考 parser 里面的定义，你就能理解。
 func (q *ParsedQuery) FirstDML() *parser.DmlStatementContext {
@@ -59,7 +84,6 @@ func (q *ParsedQuery) SqlStatement() any {
 }
 
 func (q *ParsedQuery) FirstStatement() *parser.SqlStatementContext {
-	sqlStmts := q.root.GetChildren()[0]
-	sqlStmt := sqlStmts.GetChildren()[0]
-	return sqlStmt.(*parser.SqlStatementContext)
+	sqlStmt, _ := ast.FirstStatement(q.root)
+	return sqlStmt
 }
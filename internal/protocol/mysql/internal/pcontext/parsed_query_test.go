@@ -0,0 +1,92 @@
+package pcontext
+
+import (
+	"testing"
+	"time"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/visitor/vparser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsedQuery_Timeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+
+		wantTimeout time.Duration
+	}{
+		{
+			name:        "没有hint",
+			query:       "SELECT * FROM users",
+			wantTimeout: 0,
+		},
+		{
+			name:        "指定了超时时间",
+			query:       "SELECT /* @proxy useMaster=true;timeout=500ms */ * FROM users",
+			wantTimeout: 500 * time.Millisecond,
+		},
+		{
+			name:        "格式不对",
+			query:       "UPDATE /* @proxy timeout=500 */ users SET name = 'Tom'",
+			wantTimeout: 0,
+		},
+		{
+			name:        "负数",
+			query:       "DELETE /* @proxy timeout=-1s */ FROM users",
+			wantTimeout: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewParsedQuery(tt.query)
+			assert.Equal(t, tt.wantTimeout, q.Timeout())
+		})
+	}
+}
+
+func TestParsedQuery_Type(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+
+		wantType string
+	}{
+		{
+			name:     "SELECT",
+			query:    "SELECT /* @proxy useMaster=true */ * FROM users",
+			wantType: vparser.SelectStmt,
+		},
+		{
+			name:     "语法错误",
+			query:    "SELECT 1 FROM",
+			wantType: vparser.UnKnownSQLStmt,
+		},
+		{
+			name:     "无法识别的语句",
+			query:    "SELEC 1",
+			wantType: vparser.UnKnownSQLStmt,
+		},
+		{
+			name:     "空语句",
+			query:    "",
+			wantType: vparser.UnKnownSQLStmt,
+		},
+		{
+			name:     "只有分号",
+			query:    ";",
+			wantType: vparser.UnKnownSQLStmt,
+		},
+		{
+			name:     "dbproxy不需要理解的语句",
+			query:    "CHECK TABLE users",
+			wantType: vparser.UnKnownSQLStmt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewParsedQuery(tt.query)
+			assert.Equal(t, tt.wantType, q.Type())
+			assert.NotNil(t, q.Hints())
+		})
+	}
+}
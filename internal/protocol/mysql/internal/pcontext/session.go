@@ -0,0 +1,37 @@
+package pcontext
+
+import (
+	"github.com/ecodeclub/ekit/syncx"
+)
+
+// Session 一个客户端连接上的键值对，插件可以用它在同一个连接的多个命令之间保存数据
+// 例如鉴权之后查询到的租户信息。连接关闭之后就丢弃了。
+// 插件可能在其它 goroutine 上读写，所以是并发安全的。
+// 多个插件共用同一个 Session，key 最好带上插件的名字作为前缀，避免冲突
+type Session struct {
+	values syncx.Map[string, any]
+}
+
+func NewSession() *Session {
+	return &Session{}
+}
+
+// Get 读取 key 对应的值，第二个返回值表示 key 是否存在
+func (s *Session) Get(key string) (any, bool) {
+	return s.values.Load(key)
+}
+
+// Set 设置 key 对应的值，已经存在的时候覆盖
+func (s *Session) Set(key string, val any) {
+	s.values.Store(key, val)
+}
+
+// Delete 删除 key，key 不存在的时候什么也不做
+func (s *Session) Delete(key string) {
+	s.values.Delete(key)
+}
+
+// Range 遍历全部的键值对，f 返回 false 的时候停止遍历
+func (s *Session) Range(f func(key string, val any) bool) {
+	s.values.Range(f)
+}
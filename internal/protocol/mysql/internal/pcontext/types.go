@@ -2,6 +2,8 @@ package pcontext
 
 import (
 	"context"
+	"database/sql"
+	"net"
 )
 
 type Context struct {
@@ -14,4 +16,26 @@ type Context struct {
 	ConnID uint32
 	// 当前Query语句需要在该Stmt上执行
 	StmtID uint32
+	// User 当前连接通过鉴权的用户名
+	User string
+	// ClientAddr 客户端的地址，通过负载均衡连接并且开启了 PROXY protocol 的时候是客户端真实的地址
+	ClientAddr net.Addr
+	// Schema 当前连接使用的逻辑库，通过握手、COM_INIT_DB 或者 USE 语句设置
+	// 没有选择的时候为空字符串
+	Schema string
+	// ConnAttrs 客户端在握手的时候发送的连接属性，例如 _client_name、program_name
+	// 客户端不支持 CLIENT_CONNECT_ATTRS 的时候为 nil，插件不能修改
+	ConnAttrs map[string]string
+	// Charset 客户端使用的字符集，也就是 character_set_client，例如 utf8mb4
+	// 握手的时候协商，之后可以通过 SET NAMES 修改
+	Charset string
+	// Session 当前连接上的键值对，同一个连接的所有命令拿到的是同一个 Session
+	// 通过 database/sql 驱动直接使用插件的时候为 nil
+	Session *Session
+	// SystemVariables 客户端通过 SET 修改过的、需要在后端连接上生效的系统变量，key 是小写的变量名
+	// 字符集、autocommit 等变量由 dbproxy 自己处理，不在这里面
+	SystemVariables map[string]string
+	// TxOptions 开启事务时使用的隔离级别和只读特性，为 nil 的时候使用后端的默认值
+	// 只有开启事务的语句会设置，包括 autocommit 关闭的时候隐式开启的事务
+	TxOptions *sql.TxOptions
 }
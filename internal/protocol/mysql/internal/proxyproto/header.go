@@ -0,0 +1,168 @@
+// Package proxyproto 解析 HAProxy 的 PROXY protocol 头部
+// 四层负载均衡在转发连接的时候，会在连接的最开始发送这个头部，告诉后端客户端真实的地址。
+// 协议的格式在 https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/meoying/dbproxy/internal/errs"
+)
+
+const (
+	// v1MaxLength v1 头部的最大长度，包含结尾的 \r\n
+	v1MaxLength = 107
+	// v2HeaderLength v2 头部固定部分的长度，后面是变长的地址和 TLV
+	v2HeaderLength = 16
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// Header 解析之后的头部
+type Header struct {
+	// Version 1 或者 2
+	Version int
+	// SrcAddr 客户端真实的地址，为 nil 的时候表示负载均衡没有提供，
+	// 例如 v1 的 UNKNOWN 和 v2 的 LOCAL 命令，这时候应该使用连接本身的地址
+	SrcAddr net.Addr
+	// DstAddr 客户端连接的负载均衡的地址，和 SrcAddr 同时为 nil 或者不为 nil
+	DstAddr net.Addr
+}
+
+// ReadHeader 从 r 中读取一个完整的头部
+// 只会读取头部本身的数据，不会多读，所以之后可以继续在 r 上读取 MySQL 的报文
+func ReadHeader(r io.Reader) (*Header, error) {
+	prefix := make([]byte, len(v1Prefix))
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(prefix, v1Prefix) {
+		return readV1(r)
+	}
+	if bytes.Equal(prefix, v2Signature[:len(prefix)]) {
+		return readV2(r)
+	}
+	return nil, fmt.Errorf("%w，未知的前缀 %q", errs.ErrInvalidProxyHeader, prefix)
+}
+
+// readV1 解析文本格式的头部，例如 PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readV1(r io.Reader) (*Header, error) {
+	// 没有办法预先知道长度，只能一个字节一个字节读，避免读到后面的数据
+	line := make([]byte, 0, v1MaxLength-len(v1Prefix))
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == cap(line) {
+			return nil, fmt.Errorf("%w，v1 头部超过了 %d 字节", errs.ErrInvalidProxyHeader, v1MaxLength)
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.EOF {
+				// 已经读到了前缀，再读不到数据就是头部不完整
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	h := &Header{Version: 1}
+	switch fields[0] {
+	case "UNKNOWN":
+		// 后面的内容都要忽略
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("%w，v1 不支持的协议 %q", errs.ErrInvalidProxyHeader, fields[0])
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w，v1 头部格式错误 %q", errs.ErrInvalidProxyHeader, line)
+	}
+	src, err := parseV1Addr(fields[0], fields[1], fields[3])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[0], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	h.SrcAddr, h.DstAddr = src, dst
+	return h, nil
+}
+
+func parseV1Addr(proto, ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || (proto == "TCP4") != (addr.To4() != nil) {
+		return nil, fmt.Errorf("%w，v1 非法的 %s 地址 %q", errs.ErrInvalidProxyHeader, proto, ip)
+	}
+	// 端口不能有前导 0，也不能有正负号
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || strconv.FormatUint(p, 10) != port {
+		return nil, fmt.Errorf("%w，v1 非法的端口 %q", errs.ErrInvalidProxyHeader, port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+// readV2 解析二进制格式的头部
+// 12 字节签名 + 1 字节版本和命令 + 1 字节地址族和协议 + 2 字节长度，后面是地址和 TLV
+func readV2(r io.Reader) (*Header, error) {
+	buf := make([]byte, v2HeaderLength)
+	copy(buf, v2Signature)
+	if _, err := io.ReadFull(r, buf[len(v1Prefix):]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(buf[:len(v2Signature)], v2Signature) {
+		return nil, fmt.Errorf("%w，v2 签名错误", errs.ErrInvalidProxyHeader)
+	}
+	verCmd, fam := buf[12], buf[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w，v2 不支持的版本 %d", errs.ErrInvalidProxyHeader, verCmd>>4)
+	}
+	// 地址和 TLV 都要读出来，否则会当成 MySQL 的报文
+	body := make([]byte, binary.BigEndian.Uint16(buf[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	h := &Header{Version: 2}
+	switch verCmd & 0x0f {
+	case 0x00:
+		// LOCAL 是负载均衡自己建立的连接，例如健康检查
+		return h, nil
+	case 0x01:
+		// PROXY
+	default:
+		return nil, fmt.Errorf("%w，v2 不支持的命令 %d", errs.ErrInvalidProxyHeader, verCmd&0x0f)
+	}
+	var ipLen int
+	switch fam >> 4 {
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	default:
+		// UNSPEC 和 UNIX 地址族都没有可以使用的客户端地址
+		return h, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, fmt.Errorf("%w，v2 地址长度不足", errs.ErrInvalidProxyHeader)
+	}
+	srcIP := net.IP(bytes.Clone(body[:ipLen]))
+	dstIP := net.IP(bytes.Clone(body[ipLen : 2*ipLen]))
+	srcPort := int(binary.BigEndian.Uint16(body[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(body[2*ipLen+2:]))
+	if fam&0x0f == 0x2 {
+		h.SrcAddr = &net.UDPAddr{IP: srcIP, Port: srcPort}
+		h.DstAddr = &net.UDPAddr{IP: dstIP, Port: dstPort}
+		return h, nil
+	}
+	h.SrcAddr = &net.TCPAddr{IP: srcIP, Port: srcPort}
+	h.DstAddr = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	return h, nil
+}
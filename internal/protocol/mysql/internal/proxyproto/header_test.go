@@ -0,0 +1,160 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *Header
+		wantErr error
+	}{
+		{
+			name: "v1 TCP4",
+			data: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 3306\r\n"),
+			want: &Header{
+				Version: 1,
+				SrcAddr: &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324},
+				DstAddr: &net.TCPAddr{IP: net.ParseIP("192.168.0.11"), Port: 3306},
+			},
+		},
+		{
+			name: "v1 TCP6",
+			data: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 3306\r\n"),
+			want: &Header{
+				Version: 1,
+				SrcAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324},
+				DstAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 3306},
+			},
+		},
+		{
+			name: "v1 UNKNOWN",
+			data: []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"),
+			want: &Header{Version: 1},
+		},
+		{
+			name:    "v1 地址和协议不一致",
+			data:    []byte("PROXY TCP4 2001:db8::1 192.168.0.11 56324 3306\r\n"),
+			wantErr: errs.ErrInvalidProxyHeader,
+		},
+		{
+			name:    "v1 非法端口",
+			data:    []byte("PROXY TCP4 192.168.0.1 192.168.0.11 056324 3306\r\n"),
+			wantErr: errs.ErrInvalidProxyHeader,
+		},
+		{
+			name:    "v1 字段个数错误",
+			data:    []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n"),
+			wantErr: errs.ErrInvalidProxyHeader,
+		},
+		{
+			name:    "v1 不支持的协议",
+			data:    []byte("PROXY UDP4 192.168.0.1 192.168.0.11 56324 3306\r\n"),
+			wantErr: errs.ErrInvalidProxyHeader,
+		},
+		{
+			name:    "v1 超长",
+			data:    append([]byte("PROXY UNKNOWN "), bytes.Repeat([]byte("a"), 200)...),
+			wantErr: errs.ErrInvalidProxyHeader,
+		},
+		{
+			name:    "v1 截断",
+			data:    []byte("PROXY TCP4 192.168.0.1"),
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name: "v2 TCP4",
+			data: v2Header(0x21, 0x11, []byte{192, 168, 0, 1, 192, 168, 0, 11, 0xdc, 0x04, 0x0c, 0xea}),
+			want: &Header{
+				Version: 2,
+				SrcAddr: &net.TCPAddr{IP: net.IP{192, 168, 0, 1}, Port: 56324},
+				DstAddr: &net.TCPAddr{IP: net.IP{192, 168, 0, 11}, Port: 3306},
+			},
+		},
+		{
+			name: "v2 TCP6 带 TLV",
+			data: v2Header(0x21, 0x21, append(append(append(
+				net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...),
+				0xdc, 0x04, 0x0c, 0xea),
+				// PP2_TYPE_AUTHORITY
+				0x02, 0x00, 0x03, 'a', 'b', 'c')),
+			want: &Header{
+				Version: 2,
+				SrcAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324},
+				DstAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 3306},
+			},
+		},
+		{
+			name: "v2 LOCAL",
+			data: v2Header(0x20, 0x00, nil),
+			want: &Header{Version: 2},
+		},
+		{
+			name: "v2 UNIX",
+			data: v2Header(0x21, 0x31, make([]byte, 216)),
+			want: &Header{Version: 2},
+		},
+		{
+			name:    "v2 不支持的版本",
+			data:    v2Header(0x11, 0x11, make([]byte, 12)),
+			wantErr: errs.ErrInvalidProxyHeader,
+		},
+		{
+			name:    "v2 不支持的命令",
+			data:    v2Header(0x22, 0x11, make([]byte, 12)),
+			wantErr: errs.ErrInvalidProxyHeader,
+		},
+		{
+			name:    "v2 地址长度不足",
+			data:    v2Header(0x21, 0x11, make([]byte, 8)),
+			wantErr: errs.ErrInvalidProxyHeader,
+		},
+		{
+			name:    "v2 签名错误",
+			data:    append([]byte("\r\n\r\n\x00\r\nQUIT\r"), 0x21, 0x11, 0x00, 0x00),
+			wantErr: errs.ErrInvalidProxyHeader,
+		},
+		{
+			name:    "v2 截断",
+			data:    v2Header(0x21, 0x11, make([]byte, 12))[:20],
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "没有头部",
+			data:    []byte{0x20, 0x00, 0x00, 0x01, 0x85, 0xa6},
+			wantErr: errs.ErrInvalidProxyHeader,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 头部后面紧跟着客户端的数据，不能被读取
+			r := bytes.NewReader(append(bytes.Clone(tt.data), "rest"...))
+			h, err := ReadHeader(r)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, h)
+			rest, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, "rest", string(rest))
+		})
+	}
+}
+
+func v2Header(verCmd, fam byte, body []byte) []byte {
+	buf := append(bytes.Clone(v2Signature), verCmd, fam)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(body)))
+	return append(buf, body...)
+}
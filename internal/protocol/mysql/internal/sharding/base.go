@@ -4,30 +4,38 @@ import (
 	"context"
 	"database/sql"
 	"sync"
+	"time"
 
 	"github.com/meoying/dbproxy/internal/datasource"
 	"github.com/meoying/dbproxy/internal/sharding"
 	"go.uber.org/multierr"
 )
 
-// 几个dml语句共有的逻辑执行逻辑
-func exec(ctx context.Context, db datasource.DataSource, qs []sharding.Query) sharding.Result {
+// 几个dml语句共有的逻辑执行逻辑，同时返回每一个分片上的执行情况
+func exec(ctx context.Context, db datasource.DataSource, qs []sharding.Query) (sharding.Result, []ShardSummary) {
 	errList := make([]error, len(qs))
 	resList := make([]sql.Result, len(qs))
+	shards := make([]ShardSummary, len(qs))
 	var wg sync.WaitGroup
 	locker := &sync.RWMutex{}
 	wg.Add(len(qs))
 	for idx, q := range qs {
 		go func(idx int, q sharding.Query) {
 			defer wg.Done()
+			start := time.Now()
 			res, er := db.Exec(ctx, q)
+			shard := ShardSummary{Query: q, Elapsed: time.Since(start), Err: er}
+			if er == nil {
+				shard.RowsAffected, _ = res.RowsAffected()
+			}
 			locker.Lock()
 			errList[idx] = er
 			resList[idx] = res
+			shards[idx] = shard
 			locker.Unlock()
 		}(idx, q)
 	}
 	wg.Wait()
 	shardingRes := sharding.NewResult(resList, multierr.Combine(errList...))
-	return shardingRes
+	return shardingRes, shards
 }
@@ -0,0 +1,72 @@
+package sharding
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/meoying/dbproxy/internal/datasource"
+	"github.com/meoying/dbproxy/internal/sharding"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCancel 取消 context 之后，所有分片上正在执行的语句都要被取消
+func TestCancel(t *testing.T) {
+	qs := []sharding.Query{
+		{SQL: "UPDATE `order_db_0`.`order_tab_0` SET `content` = '1'", DB: "order_db_0", Datasource: "0.db.cluster.company.com:3306"},
+		{SQL: "UPDATE `order_db_1`.`order_tab_0` SET `content` = '1'", DB: "order_db_1", Datasource: "0.db.cluster.company.com:3306"},
+		{SQL: "UPDATE `order_db_2`.`order_tab_0` SET `content` = '1'", DB: "order_db_2", Datasource: "0.db.cluster.company.com:3306"},
+	}
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, ds datasource.DataSource) error
+	}{
+		{
+			name: "exec",
+			run: func(ctx context.Context, ds datasource.DataSource) error {
+				res, _ := exec(ctx, ds, qs)
+				return res.Err()
+			},
+		},
+		{
+			name: "queryMulti",
+			run: func(ctx context.Context, ds datasource.DataSource) error {
+				_, _, err := (&SelectHandler{db: ds}).queryMulti(ctx, qs)
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &blockingDataSource{}
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				cancel()
+			}()
+			err := tt.run(ctx, ds)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.Equal(t, int32(len(qs)), ds.canceled.Load())
+		})
+	}
+}
+
+// blockingDataSource 执行的语句一直阻塞到 ctx 被取消
+type blockingDataSource struct {
+	datasource.DataSource
+	canceled atomic.Int32
+}
+
+func (b *blockingDataSource) Query(ctx context.Context, query datasource.Query) (*sql.Rows, error) {
+	<-ctx.Done()
+	b.canceled.Add(1)
+	return nil, ctx.Err()
+}
+
+func (b *blockingDataSource) Exec(ctx context.Context, query datasource.Query) (sql.Result, error) {
+	<-ctx.Done()
+	b.canceled.Add(1)
+	return nil, ctx.Err()
+}
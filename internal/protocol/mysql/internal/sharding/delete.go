@@ -63,8 +63,9 @@ func (d *DeleteHandler) QueryOrExec(ctx context.Context) (*Result, error) {
 	if err != nil {
 		return nil, err
 	}
-	res := exec(ctx, d.db, qs)
+	res, shards := exec(ctx, d.db, qs)
 	return &Result{
-		Result: res,
+		Result:  res,
+		Summary: &Summary{Shards: shards},
 	}, nil
 }
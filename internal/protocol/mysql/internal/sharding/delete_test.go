@@ -397,6 +397,13 @@ func (d *DeleteHandlerSuite) TestDeleteHandler_Exec() {
 			affectRows, err := res.Result.RowsAffected()
 			require.NoError(t, err)
 			assert.Equal(t, tc.wantAffectedRows, affectRows)
+			// 每一个分片上影响的行数加起来就是总的行数
+			var shardAffectRows int64
+			for _, shard := range res.Summary.Shards {
+				assert.NoError(t, shard.Err)
+				shardAffectRows += shard.RowsAffected
+			}
+			assert.Equal(t, tc.wantAffectedRows, shardAffectRows)
 		})
 	}
 
@@ -1,14 +1,15 @@
 package sharding
 
 import (
-	"errors"
 	"fmt"
+
+	"github.com/meoying/dbproxy/internal/errs"
 )
 
-var ErrUnKnowSql = errors.New("未知的sql")
+var ErrUnKnowSql = errs.NewUnsupportedSQLError("未知的sql")
 
 func NewErrUnKnowSelectCol(col string) error {
-	return fmt.Errorf("select列表中未找到列 %s", col)
+	return errs.NewUnsupportedSQLError(fmt.Sprintf("select列表中未找到列 %s", col))
 }
 
-var ErrInsertShardingKeyNotFound = errors.New(" insert语句中未包含sharding key")
+var ErrInsertShardingKeyNotFound = errs.NewRouteError(" insert语句中未包含sharding key")
@@ -5,16 +5,16 @@ import (
 
 	"github.com/ecodeclub/ekit/mapx"
 	"github.com/meoying/dbproxy/internal/datasource"
+	"github.com/meoying/dbproxy/internal/errs"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast/parser"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
 	vbuilder "github.com/meoying/dbproxy/internal/protocol/mysql/internal/visitor/builder"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/visitor/vparser"
 	"github.com/meoying/dbproxy/internal/sharding"
 	"github.com/meoying/dbproxy/internal/sharding/operator"
-	"github.com/pkg/errors"
 )
 
-var ErrInsertFindingDst = errors.New(" 一行数据只能插入一个表")
+var ErrInsertFindingDst = errs.NewRouteError(" 一行数据只能插入一个表")
 
 type InsertHandler struct {
 	insertVal vparser.InsertVal
@@ -28,9 +28,10 @@ func (i *InsertHandler) QueryOrExec(ctx context.Context) (*Result, error) {
 	if err != nil {
 		return nil, err
 	}
-	res := exec(ctx, i.db, qs)
+	res, shards := exec(ctx, i.db, qs)
 	return &Result{
-		Result: res,
+		Result:  res,
+		Summary: &Summary{Shards: shards},
 	}, res.Err()
 }
 
@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ecodeclub/ekit/list"
 	"github.com/ecodeclub/ekit/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/meoying/dbproxy/internal/datasource"
+	"github.com/meoying/dbproxy/internal/errs"
 	"github.com/meoying/dbproxy/internal/merger"
 	"github.com/meoying/dbproxy/internal/merger/factory"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
@@ -17,14 +19,13 @@ import (
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/visitor/vparser"
 	"github.com/meoying/dbproxy/internal/query"
 	"github.com/meoying/dbproxy/internal/sharding"
-	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 )
 
-var ErrUnsupportedTooComplexQuery = errors.New("暂未支持太复杂的查询")
+var ErrUnsupportedTooComplexQuery = errs.NewUnsupportedSQLError("暂未支持太复杂的查询")
 
 func NewUnsupportedOperatorError(op string) error {
-	return fmt.Errorf("不支持的 operator %v", op)
+	return errs.NewUnsupportedSQLError(fmt.Sprintf("不支持的 operator %v", op))
 }
 
 type SelectHandler struct {
@@ -81,7 +82,7 @@ func (s *SelectHandler) QueryOrExec(ctx context.Context) (*Result, error) {
 	if err != nil {
 		return nil, err
 	}
-	rowsList, err := s.queryMulti(ctx, qs)
+	rowsList, shards, err := s.queryMulti(ctx, qs)
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +91,8 @@ func (s *SelectHandler) QueryOrExec(ctx context.Context) (*Result, error) {
 		return nil, err
 	}
 	return &Result{
-		Rows: rows,
+		Rows:    rows,
+		Summary: &Summary{Shards: shards},
 	}, nil
 }
 
@@ -289,20 +291,26 @@ func (s *SelectHandler) findCol(name string) (merger.ColumnInfo, error) {
 
 }
 
-func (s *SelectHandler) queryMulti(ctx context.Context, qs []sharding.Query) (list.List[sqlx.Rows], error) {
+// queryMulti 同时返回每一个分片上的执行情况，Elapsed 是拿到结果集之前的耗时
+func (s *SelectHandler) queryMulti(ctx context.Context, qs []sharding.Query) (list.List[sqlx.Rows], []ShardSummary, error) {
 	res := &list.ConcurrentList[sqlx.Rows]{
 		List: list.NewArrayList[sqlx.Rows](len(qs)),
 	}
+	shards := make([]ShardSummary, len(qs))
 	var eg errgroup.Group
-	for _, query := range qs {
-		q := query
+	for idx, query := range qs {
+		idx, q := idx, query
 		eg.Go(func() error {
+			start := time.Now()
 			rs, err := s.db.Query(ctx, q)
+			// 每个 goroutine 只写自己的下标，不需要加锁
+			shards[idx] = ShardSummary{Query: q, Elapsed: time.Since(start), Err: err}
 			if err == nil {
 				return res.Append(rs)
 			}
 			return err
 		})
 	}
-	return res, eg.Wait()
+	err := eg.Wait()
+	return res, shards, err
 }
@@ -3,6 +3,7 @@ package sharding
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/ecodeclub/ekit/sqlx"
 	"github.com/meoying/dbproxy/internal/datasource"
@@ -30,4 +31,44 @@ type Result struct {
 	Result sql.Result
 	// StmtID 会被传递过去客户端
 	StmtID uint32
+
+	// Summary 执行的统计信息，为 nil 的时候网关不会统计
+	Summary *Summary
+}
+
+// Summary 语句执行的统计信息
+// 分片上的执行情况由 handler 填充，其余字段在结果写回客户端之后由网关填充
+type Summary struct {
+	// RowsReturned 写回客户端的行数，使用游标的时候是客户端实际读取的行数
+	RowsReturned int64
+	RowsAffected int64
+	LastInsertID int64
+	// Err 写回结果集的过程中遇到的错误，例如读取下一行失败
+	Err error
+	// Shards 每一个分片上的执行情况，只有分库分表的语句才有
+	Shards []ShardSummary
+
+	onComplete []func(s *Summary)
+}
+
+// OnComplete 注册结果写回客户端之后的回调，按照注册的顺序调用
+// 结果集在关闭之后才算写回完成，例如使用游标的时候要等到游标关闭
+func (s *Summary) OnComplete(fn func(s *Summary)) {
+	s.onComplete = append(s.onComplete, fn)
+}
+
+// Complete 网关在结果写回客户端之后调用
+func (s *Summary) Complete() {
+	for _, fn := range s.onComplete {
+		fn(s)
+	}
+}
+
+// ShardSummary 语句在一个分片上的执行情况
+type ShardSummary struct {
+	Query   sharding.Query
+	Elapsed time.Duration
+	// RowsAffected 只有 INSERT、UPDATE 和 DELETE 语句才有
+	RowsAffected int64
+	Err          error
 }
@@ -63,8 +63,9 @@ func (u *UpdateHandler) QueryOrExec(ctx context.Context) (*Result, error) {
 	if err != nil {
 		return nil, err
 	}
-	res := exec(ctx, u.db, qs)
+	res, shards := exec(ctx, u.db, qs)
 	return &Result{
-		Result: res,
+		Result:  res,
+		Summary: &Summary{Shards: shards},
 	}, nil
 }
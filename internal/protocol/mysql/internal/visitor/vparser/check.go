@@ -2,6 +2,7 @@ package vparser
 
 import (
 	"github.com/antlr4-go/antlr/v4"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast/parser"
 )
 
@@ -16,6 +17,12 @@ const (
 	PrepareStmt           = "prepareStmt"
 	ExecutePrepareStmt    = "executePrepareStmt"
 	DeallocatePrepareStmt = "deallocatePrepareStmt"
+	UseStmt               = "use"
+	KillStmt              = "kill"
+	SetStmt               = "set"
+	ShowStmt              = "show"
+	DescribeStmt          = "describe"
+	CallStmt              = "call"
 	UnKnownSQLStmt        = "未知的SQL语句"
 )
 
@@ -39,9 +46,11 @@ func (c *CheckVisitor) Visit(tree antlr.ParseTree) any {
 }
 
 func (c *CheckVisitor) VisitRoot(ctx *parser.RootContext) any {
-	sqlStmts := ctx.GetChildren()[0]
-	sqlStmt := sqlStmts.GetChildren()[0]
-	return c.VisitSqlStatement(sqlStmt.(*parser.SqlStatementContext))
+	sqlStmt, ok := ast.FirstStatement(ctx)
+	if !ok {
+		return UnKnownSQLStmt
+	}
+	return c.VisitSqlStatement(sqlStmt)
 }
 
 func (c *CheckVisitor) VisitSqlStatement(ctx *parser.SqlStatementContext) any {
@@ -52,6 +61,10 @@ func (c *CheckVisitor) VisitSqlStatement(ctx *parser.SqlStatementContext) any {
 		return c.VisitTransactionStatement(ctx.TransactionStatement().(*parser.TransactionStatementContext))
 	case ctx.PreparedStatement() != nil:
 		return c.VisitPreparedStatement(ctx.PreparedStatement().(*parser.PreparedStatementContext))
+	case ctx.UtilityStatement() != nil:
+		return c.VisitUtilityStatement(ctx.UtilityStatement().(*parser.UtilityStatementContext))
+	case ctx.AdministrationStatement() != nil:
+		return c.VisitAdministrationStatement(ctx.AdministrationStatement().(*parser.AdministrationStatementContext))
 	default:
 		return UnKnownSQLStmt
 	}
@@ -67,6 +80,8 @@ func (c *CheckVisitor) VisitDmlStatement(ctx *parser.DmlStatementContext) any {
 		return UpdateStmt
 	case ctx.DeleteStatement() != nil:
 		return DeleteStmt
+	case ctx.CallStatement() != nil:
+		return CallStmt
 	default:
 		return UnKnownSQLStmt
 	}
@@ -74,7 +89,7 @@ func (c *CheckVisitor) VisitDmlStatement(ctx *parser.DmlStatementContext) any {
 
 func (c *CheckVisitor) VisitTransactionStatement(ctx *parser.TransactionStatementContext) any {
 	switch ctx.GetChildren()[0].(type) {
-	case *parser.StartTransactionContext:
+	case *parser.StartTransactionContext, *parser.BeginWorkContext:
 		return StartTransactionStmt
 	case *parser.CommitWorkContext:
 		return CommitStmt
@@ -97,3 +112,28 @@ func (c *CheckVisitor) VisitPreparedStatement(ctx *parser.PreparedStatementConte
 		return UnKnownSQLStmt
 	}
 }
+
+func (c *CheckVisitor) VisitUtilityStatement(ctx *parser.UtilityStatementContext) any {
+	switch {
+	case ctx.UseStatement() != nil:
+		return UseStmt
+	case ctx.SimpleDescribeStatement() != nil, ctx.FullDescribeStatement() != nil:
+		// DESCRIBE、DESC 和 EXPLAIN 都是这一类
+		return DescribeStmt
+	default:
+		return UnKnownSQLStmt
+	}
+}
+
+func (c *CheckVisitor) VisitAdministrationStatement(ctx *parser.AdministrationStatementContext) any {
+	switch {
+	case ctx.KillStatement() != nil:
+		return KillStmt
+	case ctx.SetStatement() != nil:
+		return SetStmt
+	case ctx.ShowStatement() != nil:
+		return ShowStmt
+	default:
+		return UnKnownSQLStmt
+	}
+}
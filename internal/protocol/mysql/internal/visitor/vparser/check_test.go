@@ -39,6 +39,11 @@ func TestVisitor_Check(t *testing.T) {
 			sql:      "START TRANSACTION;",
 			wantName: StartTransactionStmt,
 		},
+		{
+			name:     "BEGIN语句",
+			sql:      "BEGIN;",
+			wantName: StartTransactionStmt,
+		},
 		{
 			name:     "提交事务语句",
 			sql:      "COMMIT;",
@@ -64,6 +69,41 @@ func TestVisitor_Check(t *testing.T) {
 			sql:      "DEALLOCATE PREPARE stmt1;",
 			wantName: DeallocatePrepareStmt,
 		},
+		{
+			name:     "USE语句",
+			sql:      "USE order_db;",
+			wantName: UseStmt,
+		},
+		{
+			name:     "KILL语句",
+			sql:      "KILL QUERY 12;",
+			wantName: KillStmt,
+		},
+		{
+			name:     "SET语句",
+			sql:      "SET NAMES utf8mb4;",
+			wantName: SetStmt,
+		},
+		{
+			name:     "SHOW语句",
+			sql:      "SHOW TABLES",
+			wantName: ShowStmt,
+		},
+		{
+			name:     "DESCRIBE语句",
+			sql:      "DESC users",
+			wantName: DescribeStmt,
+		},
+		{
+			name:     "EXPLAIN语句",
+			sql:      "EXPLAIN SELECT * FROM users WHERE id = 1",
+			wantName: DescribeStmt,
+		},
+		{
+			name:     "CALL语句",
+			sql:      "CALL get_users(1, @total)",
+			wantName: CallStmt,
+		},
 		{
 			name:     "未知支持的SQL语句",
 			sql:      "ALTER TABLE employees ADD COLUMN birthdate DATE;",
@@ -9,4 +9,6 @@ var (
 	errQueryInvalid             = errors.New("当前查询错误")
 	errUnsupportedOrderByClause = errors.New("未支持的OrderBy语句")
 	errUnsupportedGroupByClause = errors.New("未支持的GroupBy语句")
+	errUnsupportedSetStmt       = errors.New("未支持的SET语句")
+	errUnsupportedSetValue      = errors.New("SET语句中变量的值只支持常量")
 )
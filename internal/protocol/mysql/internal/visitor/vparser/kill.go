@@ -0,0 +1,73 @@
+package vparser
+
+import (
+	"strconv"
+
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast/parser"
+)
+
+// KillVal KILL [CONNECTION | QUERY] processlist_id 语句的解析结果
+type KillVal struct {
+	// ConnID 要终止的连接
+	ConnID uint32
+	// Query 为 true 的时候只终止连接正在执行的语句，否则终止整个连接
+	Query bool
+}
+
+// KillVisitor 解析 KILL 语句，Data 是 KillVal
+type KillVisitor struct {
+	*BaseVisitor
+}
+
+func NewKillVisitor() SqlParser {
+	return &KillVisitor{
+		BaseVisitor: &BaseVisitor{},
+	}
+}
+
+func (k *KillVisitor) Parse(ctx antlr.ParseTree) any {
+	return k.Visit(ctx)
+}
+
+func (k *KillVisitor) Visit(tree antlr.ParseTree) any {
+	ctx := tree.(*parser.RootContext)
+	return k.VisitRoot(ctx)
+}
+
+func (k *KillVisitor) Name() string {
+	return "KillVisitor"
+}
+
+func (k *KillVisitor) VisitRoot(ctx *parser.RootContext) any {
+	sqlStmts := ctx.GetChildren()[0]
+	sqlStmt := sqlStmts.GetChildren()[0]
+	return k.VisitSqlStatement(sqlStmt.(*parser.SqlStatementContext))
+}
+
+func (k *KillVisitor) VisitSqlStatement(ctx *parser.SqlStatementContext) any {
+	adminStmt, ok := ctx.AdministrationStatement().(*parser.AdministrationStatementContext)
+	if !ok {
+		return BaseVal{
+			Err: errStmtMatch,
+		}
+	}
+	killStmt, ok := adminStmt.KillStatement().(*parser.KillStatementContext)
+	if !ok {
+		return BaseVal{
+			Err: errStmtMatch,
+		}
+	}
+	id, err := strconv.ParseUint(killStmt.Expression().GetText(), 10, 32)
+	if err != nil {
+		return BaseVal{
+			Err: errQueryInvalid,
+		}
+	}
+	return BaseVal{
+		Data: KillVal{
+			ConnID: uint32(id),
+			Query:  killStmt.QUERY() != nil,
+		},
+	}
+}
@@ -0,0 +1,54 @@
+package vparser
+
+import (
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKillVisitor(t *testing.T) {
+	testcases := []struct {
+		name    string
+		sql     string
+		wantVal KillVal
+		wantErr error
+	}{
+		{
+			name:    "KILL",
+			sql:     "KILL 12;",
+			wantVal: KillVal{ConnID: 12},
+		},
+		{
+			name:    "KILL CONNECTION",
+			sql:     "KILL CONNECTION 12",
+			wantVal: KillVal{ConnID: 12},
+		},
+		{
+			name:    "KILL QUERY",
+			sql:     "kill query 12",
+			wantVal: KillVal{ConnID: 12, Query: true},
+		},
+		{
+			name:    "连接ID不是数字",
+			sql:     "KILL QUERY @id",
+			wantErr: errQueryInvalid,
+		},
+		{
+			name:    "不是KILL语句",
+			sql:     "SELECT * FROM t1;",
+			wantErr: errStmtMatch,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := ast.Parse(tc.sql).Root
+			res := NewKillVisitor().Parse(root).(BaseVal)
+			assert.Equal(t, tc.wantErr, res.Err)
+			if res.Err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantVal, res.Data)
+		})
+	}
+}
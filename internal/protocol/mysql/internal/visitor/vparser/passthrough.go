@@ -0,0 +1,73 @@
+package vparser
+
+import (
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast/parser"
+)
+
+// PassthroughVal dbproxy 不需要理解、原样转发给后端的语句的特征
+type PassthroughVal struct {
+	// SessionState 为 true 的时候语句会在后端的连接上留下会话级别的状态，例如 LOCK TABLES、CREATE TEMPORARY TABLE。
+	// 后端的连接是多个客户端共用的，即便是在事务中执行，事务结束之后这些状态也会留在连接池的连接上
+	SessionState bool
+	// NoResultset 为 true 的时候语句一定不会返回结果集，例如 DDL，可以通过 Exec 拿到影响的行数。
+	// 其余语句可能返回结果集，例如 CHECK TABLE、HELP、WITH ... SELECT、TABLE t，要通过 Query 执行
+	NoResultset bool
+}
+
+// PassthroughVisitor 解析原样转发的语句，Data 是 PassthroughVal
+// 语法错误的语句也可以解析，这时候 Data 是零值
+type PassthroughVisitor struct {
+	*BaseVisitor
+}
+
+func NewPassthroughVisitor() SqlParser {
+	return &PassthroughVisitor{
+		BaseVisitor: &BaseVisitor{},
+	}
+}
+
+func (p *PassthroughVisitor) Parse(ctx antlr.ParseTree) any {
+	return p.Visit(ctx)
+}
+
+func (p *PassthroughVisitor) Visit(tree antlr.ParseTree) any {
+	ctx := tree.(*parser.RootContext)
+	return p.VisitRoot(ctx)
+}
+
+func (p *PassthroughVisitor) Name() string {
+	return "PassthroughVisitor"
+}
+
+func (p *PassthroughVisitor) VisitRoot(ctx *parser.RootContext) any {
+	sqlStmt, ok := ast.FirstStatement(ctx)
+	if !ok {
+		return BaseVal{Data: PassthroughVal{}}
+	}
+	return p.VisitSqlStatement(sqlStmt)
+}
+
+func (p *PassthroughVisitor) VisitSqlStatement(ctx *parser.SqlStatementContext) any {
+	var val PassthroughVal
+	switch {
+	case ctx.DdlStatement() != nil:
+		val.NoResultset = true
+		val.SessionState = p.isCreateTemporaryTable(ctx.DdlStatement())
+	case ctx.DmlStatement() != nil:
+		dml := ctx.DmlStatement()
+		val.NoResultset = dml.ReplaceStatement() != nil || dml.LoadDataStatement() != nil ||
+			dml.LoadXmlStatement() != nil || dml.DoStatement() != nil
+	case ctx.TransactionStatement() != nil:
+		val.NoResultset = true
+		val.SessionState = ctx.TransactionStatement().LockTables() != nil
+	}
+	return BaseVal{Data: val}
+}
+
+// isCreateTemporaryTable CREATE TEMPORARY TABLE 的三种写法都有 TEMPORARY
+func (p *PassthroughVisitor) isCreateTemporaryTable(ctx parser.IDdlStatementContext) bool {
+	createTable, ok := ctx.CreateTable().(interface{ TEMPORARY() antlr.TerminalNode })
+	return ok && createTable.TEMPORARY() != nil
+}
@@ -0,0 +1,63 @@
+package vparser
+
+import (
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassthroughVisitor(t *testing.T) {
+	testcases := []struct {
+		name    string
+		sql     string
+		wantVal PassthroughVal
+	}{
+		{
+			name:    "DDL",
+			sql:     "CREATE TABLE t1 (id INT)",
+			wantVal: PassthroughVal{NoResultset: true},
+		},
+		{
+			name:    "创建临时表",
+			sql:     "CREATE TEMPORARY TABLE t1 (id INT)",
+			wantVal: PassthroughVal{NoResultset: true, SessionState: true},
+		},
+		{
+			name:    "通过查询创建临时表",
+			sql:     "CREATE TEMPORARY TABLE t2 SELECT * FROM t1",
+			wantVal: PassthroughVal{NoResultset: true, SessionState: true},
+		},
+		{
+			name:    "锁表",
+			sql:     "LOCK TABLES t1 READ",
+			wantVal: PassthroughVal{NoResultset: true, SessionState: true},
+		},
+		{
+			name:    "REPLACE",
+			sql:     "REPLACE INTO t1 (id) VALUES (1)",
+			wantVal: PassthroughVal{NoResultset: true},
+		},
+		{
+			name: "CHECK TABLE",
+			sql:  "CHECK TABLE t1",
+		},
+		{
+			name: "WITH SELECT",
+			sql:  "WITH cte AS (SELECT 1) SELECT * FROM cte",
+		},
+		{
+			name: "语法错误",
+			sql:  "SELECT 1 FROM",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := ast.Parse(tc.sql).Root
+			res := NewPassthroughVisitor().Parse(root).(BaseVal)
+			require.NoError(t, res.Err)
+			assert.Equal(t, tc.wantVal, res.Data)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package vparser
+
+import (
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast/parser"
+)
+
+// SelectVariableVal SELECT 语句中读取的一个系统变量
+type SelectVariableVal struct {
+	// Scope 是 ScopeSession 或者 ScopeGlobal
+	Scope string
+	// Name 小写的变量名
+	Name string
+	// Column 结果集中的列名，有别名的时候是别名，否则和 MySQL 一样是原本的写法，例如 @@session.autocommit
+	Column string
+}
+
+// SelectVariableVisitor 解析只读取系统变量的 SELECT 语句，例如 SELECT @@max_allowed_packet
+// 驱动在建立连接之后经常会执行这种语句，dbproxy 可以直接返回结果。
+// Data 是 []SelectVariableVal，语句中有 FROM、WHERE 或者其它表达式的时候返回错误
+type SelectVariableVisitor struct {
+	*BaseVisitor
+}
+
+func NewSelectVariableVisitor() SqlParser {
+	return &SelectVariableVisitor{
+		BaseVisitor: &BaseVisitor{},
+	}
+}
+
+func (s *SelectVariableVisitor) Parse(ctx antlr.ParseTree) any {
+	return s.Visit(ctx)
+}
+
+func (s *SelectVariableVisitor) Visit(tree antlr.ParseTree) any {
+	ctx := tree.(*parser.RootContext)
+	return s.VisitRoot(ctx)
+}
+
+func (s *SelectVariableVisitor) Name() string {
+	return "SelectVariableVisitor"
+}
+
+func (s *SelectVariableVisitor) VisitRoot(ctx *parser.RootContext) any {
+	sqlStmts := ctx.GetChildren()[0]
+	sqlStmt := sqlStmts.GetChildren()[0]
+	return s.VisitSqlStatement(sqlStmt.(*parser.SqlStatementContext))
+}
+
+func (s *SelectVariableVisitor) VisitSqlStatement(ctx *parser.SqlStatementContext) any {
+	dmlStmt, ok := ctx.DmlStatement().(*parser.DmlStatementContext)
+	if !ok {
+		return BaseVal{Err: errStmtMatch}
+	}
+	simpleSelect, ok := dmlStmt.SelectStatement().(*parser.SimpleSelectContext)
+	if !ok || simpleSelect.LockClause() != nil {
+		return BaseVal{Err: errStmtMatch}
+	}
+	query := simpleSelect.QuerySpecification().(*parser.QuerySpecificationContext)
+	// LIMIT 对只有一行的结果没有影响，mysql 客户端会执行 SELECT @@version_comment LIMIT 1
+	if len(query.AllSelectSpec()) > 0 || query.SelectIntoExpression() != nil ||
+		query.GroupByClause() != nil || query.HavingClause() != nil ||
+		query.WindowClause() != nil || query.OrderByClause() != nil {
+		return BaseVal{Err: errStmtMatch}
+	}
+	if from, ok := query.FromClause().(*parser.FromClauseContext); ok && from.GetChildCount() > 0 {
+		return BaseVal{Err: errStmtMatch}
+	}
+	elements := query.SelectElements().(*parser.SelectElementsContext)
+	if elements.GetStar() != nil {
+		return BaseVal{Err: errStmtMatch}
+	}
+	vals := make([]SelectVariableVal, 0, len(elements.AllSelectElement()))
+	for _, element := range elements.AllSelectElement() {
+		val, ok := s.visitSelectElement(element)
+		if !ok {
+			return BaseVal{Err: errStmtMatch}
+		}
+		vals = append(vals, val)
+	}
+	return BaseVal{Data: vals}
+}
+
+func (s *SelectVariableVisitor) visitSelectElement(element parser.ISelectElementContext) (SelectVariableVal, bool) {
+	expr, ok := element.(*parser.SelectExpressionElementContext)
+	if !ok || expr.LOCAL_ID() != nil {
+		return SelectVariableVal{}, false
+	}
+	predicate, ok := expr.Expression().(*parser.PredicateExpressionContext)
+	if !ok {
+		return SelectVariableVal{}, false
+	}
+	atomPredicate, ok := predicate.Predicate().(*parser.ExpressionAtomPredicateContext)
+	if !ok {
+		return SelectVariableVal{}, false
+	}
+	atom, ok := atomPredicate.ExpressionAtom().(*parser.MysqlVariableExpressionAtomContext)
+	if !ok {
+		return SelectVariableVal{}, false
+	}
+	id := atom.MysqlVariable().GLOBAL_ID()
+	if id == nil {
+		// 用户变量要交给后端处理
+		return SelectVariableVal{}, false
+	}
+	scope, name := ParseSystemVariable(id.GetText())
+	column := id.GetText()
+	if expr.Uid() != nil {
+		column = unquoteString(s.RemoveQuote(expr.Uid().GetText()))
+	}
+	return SelectVariableVal{Scope: scope, Name: name, Column: column}, true
+}
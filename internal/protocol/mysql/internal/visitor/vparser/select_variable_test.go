@@ -0,0 +1,63 @@
+package vparser
+
+import (
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectVariableVisitor(t *testing.T) {
+	testcases := []struct {
+		name     string
+		sql      string
+		wantVals []SelectVariableVal
+		wantErr  error
+	}{
+		{
+			name:     "单个变量",
+			sql:      "SELECT @@max_allowed_packet",
+			wantVals: []SelectVariableVal{{Scope: ScopeSession, Name: "max_allowed_packet", Column: "@@max_allowed_packet"}},
+		},
+		{
+			name: "作用域和别名",
+			sql:  "select @@session.transaction_isolation AS iso, @@GLOBAL.Version_Comment, @@local.autocommit `ac` limit 1",
+			wantVals: []SelectVariableVal{
+				{Scope: ScopeSession, Name: "transaction_isolation", Column: "iso"},
+				{Scope: ScopeGlobal, Name: "version_comment", Column: "@@GLOBAL.Version_Comment"},
+				{Scope: ScopeSession, Name: "autocommit", Column: "ac"},
+			},
+		},
+		{
+			name:    "有FROM",
+			sql:     "SELECT @@autocommit FROM t1",
+			wantErr: errStmtMatch,
+		},
+		{
+			name:    "有其它表达式",
+			sql:     "SELECT @@autocommit, 1",
+			wantErr: errStmtMatch,
+		},
+		{
+			name:    "用户变量",
+			sql:     "SELECT @a",
+			wantErr: errStmtMatch,
+		},
+		{
+			name:    "不是SELECT语句",
+			sql:     "SET autocommit = 1",
+			wantErr: errStmtMatch,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := ast.Parse(tc.sql).Root
+			res := NewSelectVariableVisitor().Parse(root).(BaseVal)
+			assert.Equal(t, tc.wantErr, res.Err)
+			if res.Err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantVals, res.Data)
+		})
+	}
+}
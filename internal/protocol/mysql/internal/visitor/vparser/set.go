@@ -0,0 +1,294 @@
+package vparser
+
+import (
+	"strings"
+
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast/parser"
+)
+
+const (
+	// ScopeSession 当前连接的系统变量，SET 语句没有指定作用域的时候也是这个
+	ScopeSession = "session"
+	// ScopeGlobal 全局的系统变量
+	ScopeGlobal = "global"
+	// ScopeUser 用户变量，也就是 @var
+	ScopeUser = "user"
+	// ScopeNextTransaction 只对下一个事务生效，只有 SET TRANSACTION 会使用
+	ScopeNextTransaction = "transaction"
+)
+
+// SetVal SET 语句中的一个赋值，一个 SET 语句可以有多个赋值
+// SET NAMES 等语句会被展开成对应的系统变量，例如 SET NAMES utf8mb4 会展开成
+// character_set_client、character_set_connection 和 character_set_results 三个赋值
+type SetVal struct {
+	Scope string
+	// Name 变量名，系统变量统一使用小写
+	Name string
+	// Value 变量的值，字符串会去掉引号
+	Value string
+	// Default 为 true 的时候表示恢复成默认值，例如 SET time_zone = DEFAULT
+	Default bool
+	// Null 为 true 的时候表示值是 NULL，例如 SET character_set_results = NULL
+	Null bool
+}
+
+// SetVisitor 解析 SET 语句，Data 是 []SetVal
+// 只支持常量、标识符、ON、DEFAULT 作为变量的值，其余的表达式需要执行之后才知道结果
+type SetVisitor struct {
+	*BaseVisitor
+}
+
+func NewSetVisitor() SqlParser {
+	return &SetVisitor{
+		BaseVisitor: &BaseVisitor{},
+	}
+}
+
+func (s *SetVisitor) Parse(ctx antlr.ParseTree) any {
+	return s.Visit(ctx)
+}
+
+func (s *SetVisitor) Visit(tree antlr.ParseTree) any {
+	ctx := tree.(*parser.RootContext)
+	return s.VisitRoot(ctx)
+}
+
+func (s *SetVisitor) Name() string {
+	return "SetVisitor"
+}
+
+func (s *SetVisitor) VisitRoot(ctx *parser.RootContext) any {
+	sqlStmts := ctx.GetChildren()[0]
+	sqlStmt := sqlStmts.GetChildren()[0]
+	return s.VisitSqlStatement(sqlStmt.(*parser.SqlStatementContext))
+}
+
+func (s *SetVisitor) VisitSqlStatement(ctx *parser.SqlStatementContext) any {
+	adminStmt, ok := ctx.AdministrationStatement().(*parser.AdministrationStatementContext)
+	if !ok || adminStmt.SetStatement() == nil {
+		return BaseVal{
+			Err: errStmtMatch,
+		}
+	}
+	var (
+		vals []SetVal
+		err  error
+	)
+	switch v := adminStmt.SetStatement().(type) {
+	case *parser.SetVariableContext:
+		vals, err = s.visitSetVariable(v)
+	case *parser.SetNamesContext:
+		vals = s.visitSetNames(v)
+	case *parser.SetCharsetContext:
+		vals = s.visitSetCharset(v)
+	case *parser.SetTransactionContext:
+		vals = s.visitSetTransaction(v.SetTransactionStatement().(*parser.SetTransactionStatementContext))
+	case *parser.SetAutocommitContext:
+		stmt := v.SetAutocommitStatement().(*parser.SetAutocommitStatementContext)
+		vals = []SetVal{{Scope: ScopeSession, Name: "autocommit", Value: stmt.GetAutocommitValue().GetText()}}
+	default:
+		err = errUnsupportedSetStmt
+	}
+	return BaseVal{
+		Data: vals,
+		Err:  err,
+	}
+}
+
+func (s *SetVisitor) visitSetVariable(ctx *parser.SetVariableContext) ([]SetVal, error) {
+	clauses := ctx.AllVariableClause()
+	vals := make([]SetVal, 0, len(clauses))
+	// ON 不是表达式，按照顺序和前面的变量对应起来
+	for _, child := range ctx.GetChildren() {
+		switch c := child.(type) {
+		case *parser.VariableClauseContext:
+			vals = append(vals, s.visitVariableClause(c))
+		case antlr.TerminalNode:
+			if c.GetSymbol().GetTokenType() == parser.MySqlParserON {
+				vals[len(vals)-1].Value = "ON"
+			}
+		case parser.IExpressionContext:
+			if err := s.visitSetValue(c, &vals[len(vals)-1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return vals, nil
+}
+
+// visitVariableClause 解析变量名和作用域，支持 @var、@@var、@@session.var、SESSION var 等写法
+func (s *SetVisitor) visitVariableClause(ctx *parser.VariableClauseContext) SetVal {
+	if id := ctx.LOCAL_ID(); id != nil {
+		return SetVal{Scope: ScopeUser, Name: strings.TrimPrefix(id.GetText(), "@")}
+	}
+	if id := ctx.GLOBAL_ID(); id != nil {
+		scope, name := ParseSystemVariable(id.GetText())
+		return SetVal{Scope: scope, Name: name}
+	}
+	scope := ScopeSession
+	if ctx.GLOBAL() != nil {
+		scope = ScopeGlobal
+	}
+	return SetVal{Scope: scope, Name: strings.ToLower(s.RemoveQuote(ctx.Uid().GetText()))}
+}
+
+func (s *SetVisitor) visitSetValue(ctx parser.IExpressionContext, val *SetVal) error {
+	predicate, ok := ctx.(*parser.PredicateExpressionContext)
+	if !ok {
+		return errUnsupportedSetValue
+	}
+	atomPredicate, ok := predicate.Predicate().(*parser.ExpressionAtomPredicateContext)
+	if !ok {
+		return errUnsupportedSetValue
+	}
+	switch atom := atomPredicate.ExpressionAtom().(type) {
+	case *parser.ConstantExpressionAtomContext:
+		constant := atom.Constant().(*parser.ConstantContext)
+		switch {
+		case constant.GetNullLiteral() != nil:
+			val.Null = true
+		case constant.StringLiteral() != nil:
+			val.Value = unquoteString(constant.GetText())
+		default:
+			val.Value = constant.GetText()
+		}
+	case *parser.FullColumnNameExpressionAtomContext:
+		// 例如 SET time_zone = SYSTEM，这里是一个标识符
+		v := s.RemoveQuote(atom.GetText())
+		if strings.EqualFold(v, "DEFAULT") {
+			val.Default = true
+			return nil
+		}
+		val.Value = v
+	default:
+		return errUnsupportedSetValue
+	}
+	return nil
+}
+
+// visitSetNames SET NAMES charset [COLLATE collation] | DEFAULT
+func (s *SetVisitor) visitSetNames(ctx *parser.SetNamesContext) []SetVal {
+	vals := make([]SetVal, 0, 4)
+	for _, name := range []string{"character_set_client", "character_set_connection", "character_set_results"} {
+		val := SetVal{Scope: ScopeSession, Name: name}
+		if ctx.CharsetName() == nil {
+			val.Default = true
+		} else {
+			val.Value = s.charsetName(ctx.CharsetName())
+		}
+		vals = append(vals, val)
+	}
+	if ctx.CollationName() != nil {
+		vals = append(vals, SetVal{
+			Scope: ScopeSession,
+			Name:  "collation_connection",
+			Value: strings.ToLower(unquoteString(s.RemoveQuote(ctx.CollationName().GetText()))),
+		})
+	}
+	return vals
+}
+
+// visitSetCharset SET CHARACTER SET charset | DEFAULT
+// 和 SET NAMES 不同，character_set_connection 会使用库的字符集，也就是默认值
+func (s *SetVisitor) visitSetCharset(ctx *parser.SetCharsetContext) []SetVal {
+	vals := make([]SetVal, 0, 3)
+	for _, name := range []string{"character_set_client", "character_set_results"} {
+		val := SetVal{Scope: ScopeSession, Name: name}
+		if ctx.CharsetName() == nil {
+			val.Default = true
+		} else {
+			val.Value = s.charsetName(ctx.CharsetName())
+		}
+		vals = append(vals, val)
+	}
+	return append(vals, SetVal{Scope: ScopeSession, Name: "character_set_connection", Default: true})
+}
+
+func (s *SetVisitor) charsetName(ctx parser.ICharsetNameContext) string {
+	return strings.ToLower(unquoteString(s.RemoveQuote(ctx.GetText())))
+}
+
+// visitSetTransaction SET [GLOBAL | SESSION] TRANSACTION ISOLATION LEVEL ... | READ WRITE | READ ONLY
+// 没有指定作用域的时候只对下一个事务生效
+func (s *SetVisitor) visitSetTransaction(ctx *parser.SetTransactionStatementContext) []SetVal {
+	scope := ScopeNextTransaction
+	switch {
+	case ctx.GLOBAL() != nil:
+		scope = ScopeGlobal
+	case ctx.SESSION() != nil:
+		scope = ScopeSession
+	}
+	opts := ctx.AllTransactionOption()
+	vals := make([]SetVal, 0, len(opts))
+	for _, o := range opts {
+		opt := o.(*parser.TransactionOptionContext)
+		switch {
+		case opt.TransactionLevel() != nil:
+			// READ COMMITTED 对应的变量值是 READ-COMMITTED
+			level := opt.TransactionLevel().(*parser.TransactionLevelContext)
+			words := make([]string, 0, 2)
+			for _, child := range level.GetChildren() {
+				words = append(words, strings.ToUpper(child.(antlr.TerminalNode).GetText()))
+			}
+			vals = append(vals, SetVal{Scope: scope, Name: "transaction_isolation", Value: strings.Join(words, "-")})
+		case opt.ONLY() != nil:
+			vals = append(vals, SetVal{Scope: scope, Name: "transaction_read_only", Value: "ON"})
+		default:
+			vals = append(vals, SetVal{Scope: scope, Name: "transaction_read_only", Value: "OFF"})
+		}
+	}
+	return vals
+}
+
+// ParseSystemVariable 解析 @@var、@@session.var、@@global.var 这种写法，返回作用域和小写的变量名
+// 和 MySQL 一样，@@var 和 @@local.var 都表示当前连接的变量
+func ParseSystemVariable(text string) (scope, name string) {
+	name = strings.ToLower(strings.TrimPrefix(text, "@@"))
+	scope = ScopeSession
+	if prefix, rest, ok := strings.Cut(name, "."); ok {
+		switch prefix {
+		case "global":
+			scope, name = ScopeGlobal, rest
+		case "session", "local":
+			name = rest
+		}
+	}
+	return scope, strings.Trim(name, "`")
+}
+
+// unquoteString 去掉字符串两边的引号，并且处理 ” 和反斜杠转义
+func unquoteString(s string) string {
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] {
+		return s
+	}
+	quote := s[0]
+	s = s[1 : len(s)-1]
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case '0':
+				sb.WriteByte(0)
+			default:
+				sb.WriteByte(s[i])
+			}
+		case c == quote && i+1 < len(s) && s[i+1] == quote:
+			i++
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,108 @@
+package vparser
+
+import (
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetVisitor(t *testing.T) {
+	testcases := []struct {
+		name     string
+		sql      string
+		wantVals []SetVal
+		wantErr  error
+	}{
+		{
+			name: "SET NAMES",
+			sql:  "SET NAMES utf8mb4",
+			wantVals: []SetVal{
+				{Scope: ScopeSession, Name: "character_set_client", Value: "utf8mb4"},
+				{Scope: ScopeSession, Name: "character_set_connection", Value: "utf8mb4"},
+				{Scope: ScopeSession, Name: "character_set_results", Value: "utf8mb4"},
+			},
+		},
+		{
+			name: "SET NAMES COLLATE",
+			sql:  "SET NAMES 'utf8mb4' COLLATE 'utf8mb4_bin'",
+			wantVals: []SetVal{
+				{Scope: ScopeSession, Name: "character_set_client", Value: "utf8mb4"},
+				{Scope: ScopeSession, Name: "character_set_connection", Value: "utf8mb4"},
+				{Scope: ScopeSession, Name: "character_set_results", Value: "utf8mb4"},
+				{Scope: ScopeSession, Name: "collation_connection", Value: "utf8mb4_bin"},
+			},
+		},
+		{
+			name: "SET CHARACTER SET",
+			sql:  "SET CHARACTER SET utf8",
+			wantVals: []SetVal{
+				{Scope: ScopeSession, Name: "character_set_client", Value: "utf8"},
+				{Scope: ScopeSession, Name: "character_set_results", Value: "utf8"},
+				{Scope: ScopeSession, Name: "character_set_connection", Default: true},
+			},
+		},
+		{
+			name:     "SET autocommit",
+			sql:      "SET autocommit=0",
+			wantVals: []SetVal{{Scope: ScopeSession, Name: "autocommit", Value: "0"}},
+		},
+		{
+			name: "多个变量",
+			sql:  "SET SESSION sql_mode='STRICT_TRANS_TABLES', @@session.Time_Zone = \"+08:00\", x = ON, y = -1, z = DEFAULT, character_set_results = NULL",
+			wantVals: []SetVal{
+				{Scope: ScopeSession, Name: "sql_mode", Value: "STRICT_TRANS_TABLES"},
+				{Scope: ScopeSession, Name: "time_zone", Value: "+08:00"},
+				{Scope: ScopeSession, Name: "x", Value: "ON"},
+				{Scope: ScopeSession, Name: "y", Value: "-1"},
+				{Scope: ScopeSession, Name: "z", Default: true},
+				{Scope: ScopeSession, Name: "character_set_results", Null: true},
+			},
+		},
+		{
+			name: "全局变量和用户变量",
+			sql:  "SET GLOBAL a = 1, @@global.b = 'x''y', @c = 2",
+			wantVals: []SetVal{
+				{Scope: ScopeGlobal, Name: "a", Value: "1"},
+				{Scope: ScopeGlobal, Name: "b", Value: "x'y"},
+				{Scope: ScopeUser, Name: "c", Value: "2"},
+			},
+		},
+		{
+			name: "SET TRANSACTION",
+			sql:  "SET TRANSACTION ISOLATION LEVEL READ COMMITTED, READ ONLY",
+			wantVals: []SetVal{
+				{Scope: ScopeNextTransaction, Name: "transaction_isolation", Value: "READ-COMMITTED"},
+				{Scope: ScopeNextTransaction, Name: "transaction_read_only", Value: "ON"},
+			},
+		},
+		{
+			name: "SET SESSION TRANSACTION",
+			sql:  "SET SESSION TRANSACTION ISOLATION LEVEL serializable",
+			wantVals: []SetVal{
+				{Scope: ScopeSession, Name: "transaction_isolation", Value: "SERIALIZABLE"},
+			},
+		},
+		{
+			name:    "变量的值是表达式",
+			sql:     "SET sql_mode = CONCAT(@@sql_mode, ',STRICT_TRANS_TABLES')",
+			wantErr: errUnsupportedSetValue,
+		},
+		{
+			name:    "不是SET语句",
+			sql:     "SELECT * FROM t1;",
+			wantErr: errStmtMatch,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := ast.Parse(tc.sql).Root
+			res := NewSetVisitor().Parse(root).(BaseVal)
+			assert.Equal(t, tc.wantErr, res.Err)
+			if res.Err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantVals, res.Data)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package vparser
+
+import (
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast/parser"
+)
+
+// StartTransactionVal START TRANSACTION 语句中指定的事务特性，都为 false 的时候使用连接上的设置
+type StartTransactionVal struct {
+	// ReadOnly START TRANSACTION READ ONLY
+	ReadOnly bool
+	// ReadWrite START TRANSACTION READ WRITE
+	ReadWrite bool
+}
+
+// StartTransactionVisitor 解析 START TRANSACTION 和 BEGIN 语句，Data 是 StartTransactionVal
+type StartTransactionVisitor struct {
+	*BaseVisitor
+}
+
+func NewStartTransactionVisitor() SqlParser {
+	return &StartTransactionVisitor{
+		BaseVisitor: &BaseVisitor{},
+	}
+}
+
+func (s *StartTransactionVisitor) Parse(ctx antlr.ParseTree) any {
+	return s.Visit(ctx)
+}
+
+func (s *StartTransactionVisitor) Visit(tree antlr.ParseTree) any {
+	ctx := tree.(*parser.RootContext)
+	return s.VisitRoot(ctx)
+}
+
+func (s *StartTransactionVisitor) Name() string {
+	return "StartTransactionVisitor"
+}
+
+func (s *StartTransactionVisitor) VisitRoot(ctx *parser.RootContext) any {
+	sqlStmts := ctx.GetChildren()[0]
+	sqlStmt := sqlStmts.GetChildren()[0]
+	return s.VisitSqlStatement(sqlStmt.(*parser.SqlStatementContext))
+}
+
+func (s *StartTransactionVisitor) VisitSqlStatement(ctx *parser.SqlStatementContext) any {
+	txStmt, ok := ctx.TransactionStatement().(*parser.TransactionStatementContext)
+	if !ok {
+		return BaseVal{
+			Err: errStmtMatch,
+		}
+	}
+	var val StartTransactionVal
+	switch stmt := txStmt.GetChildren()[0].(type) {
+	case *parser.BeginWorkContext:
+	case *parser.StartTransactionContext:
+		for _, m := range stmt.AllTransactionMode() {
+			mode := m.(*parser.TransactionModeContext)
+			switch {
+			case mode.ONLY() != nil:
+				val.ReadOnly = true
+			case mode.WRITE() != nil:
+				val.ReadWrite = true
+			}
+		}
+	default:
+		return BaseVal{
+			Err: errStmtMatch,
+		}
+	}
+	return BaseVal{
+		Data: val,
+	}
+}
@@ -0,0 +1,52 @@
+package vparser
+
+import (
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartTransactionVisitor(t *testing.T) {
+	testcases := []struct {
+		name    string
+		sql     string
+		wantVal StartTransactionVal
+		wantErr error
+	}{
+		{
+			name: "START TRANSACTION",
+			sql:  "START TRANSACTION;",
+		},
+		{
+			name: "BEGIN",
+			sql:  "BEGIN",
+		},
+		{
+			name:    "只读事务",
+			sql:     "START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY",
+			wantVal: StartTransactionVal{ReadOnly: true},
+		},
+		{
+			name:    "读写事务",
+			sql:     "start transaction read write",
+			wantVal: StartTransactionVal{ReadWrite: true},
+		},
+		{
+			name:    "不是开启事务的语句",
+			sql:     "COMMIT",
+			wantErr: errStmtMatch,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := ast.Parse(tc.sql).Root
+			res := NewStartTransactionVisitor().Parse(root).(BaseVal)
+			assert.Equal(t, tc.wantErr, res.Err)
+			if res.Err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantVal, res.Data)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package vparser
+
+import (
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast/parser"
+)
+
+// UseVisitor 解析 USE 语句，Data 是要切换的库名
+type UseVisitor struct {
+	*BaseVisitor
+}
+
+func NewUseVisitor() SqlParser {
+	return &UseVisitor{
+		BaseVisitor: &BaseVisitor{},
+	}
+}
+
+func (u *UseVisitor) Parse(ctx antlr.ParseTree) any {
+	return u.Visit(ctx)
+}
+
+func (u *UseVisitor) Visit(tree antlr.ParseTree) any {
+	ctx := tree.(*parser.RootContext)
+	return u.VisitRoot(ctx)
+}
+
+func (u *UseVisitor) Name() string {
+	return "UseVisitor"
+}
+
+func (u *UseVisitor) VisitRoot(ctx *parser.RootContext) any {
+	sqlStmts := ctx.GetChildren()[0]
+	sqlStmt := sqlStmts.GetChildren()[0]
+	return u.VisitSqlStatement(sqlStmt.(*parser.SqlStatementContext))
+}
+
+func (u *UseVisitor) VisitSqlStatement(ctx *parser.SqlStatementContext) any {
+	utilityStmt, ok := ctx.UtilityStatement().(*parser.UtilityStatementContext)
+	if !ok {
+		return BaseVal{
+			Err: errStmtMatch,
+		}
+	}
+	useStmt, ok := utilityStmt.UseStatement().(*parser.UseStatementContext)
+	if !ok {
+		return BaseVal{
+			Err: errStmtMatch,
+		}
+	}
+	return BaseVal{
+		Data: u.RemoveQuote(useStmt.Uid().GetText()),
+	}
+}
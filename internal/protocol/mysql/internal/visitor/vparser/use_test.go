@@ -0,0 +1,44 @@
+package vparser
+
+import (
+	"testing"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUseVisitor(t *testing.T) {
+	testcases := []struct {
+		name       string
+		sql        string
+		wantSchema string
+		wantErr    error
+	}{
+		{
+			name:       "USE语句",
+			sql:        "USE order_db;",
+			wantSchema: "order_db",
+		},
+		{
+			name:       "库名带反引号",
+			sql:        "use `order_db`",
+			wantSchema: "order_db",
+		},
+		{
+			name:    "不是USE语句",
+			sql:     "SELECT * FROM t1;",
+			wantErr: errStmtMatch,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := ast.Parse(tc.sql).Root
+			res := NewUseVisitor().Parse(root).(BaseVal)
+			assert.Equal(t, tc.wantErr, res.Err)
+			if res.Err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantSchema, res.Data)
+		})
+	}
+}
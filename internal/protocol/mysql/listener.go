@@ -0,0 +1,78 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
+)
+
+// ListenerConfig 服务端监听的一个地址
+type ListenerConfig struct {
+	// Network tcp 或者 unix
+	Network string `json:"network" yaml:"network"`
+	// Addr 监听的地址，unix 的时候是 socket 文件的路径
+	Addr string `json:"addr" yaml:"addr"`
+	// Admin 为 true 的时候只允许管理员登录，并且不受连接数的限制
+	// 必须通过 ServerWithUserStore 配置用户，否则 Start 会返回错误
+	Admin bool `json:"admin" yaml:"admin"`
+	// ServerVersion 握手的时候告诉客户端的版本，为空的时候是 connection.DefaultServerVersion
+	// 部分驱动会根据版本判断服务端支持的特性，修改的时候要和 Capabilities 保持一致
+	ServerVersion string `json:"serverVersion" yaml:"serverVersion"`
+	// Capabilities 握手的时候声明的能力，例如 CLIENT_DEPRECATE_EOF，为空的时候是 dbproxy 支持的全部能力
+	// CLIENT_PROTOCOL_41、CLIENT_SECURE_CONNECTION 和 CLIENT_PLUGIN_AUTH 总是会声明
+	Capabilities []string `json:"capabilities" yaml:"capabilities"`
+}
+
+// connOptions 这个地址上的连接使用的配置
+func (cfg ListenerConfig) connOptions() ([]connection.ConnOption, error) {
+	capabilities, err := flags.ParseServerCapabilities(cfg.Capabilities)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s 的配置错误 %w", cfg.Network, cfg.Addr, err)
+	}
+	return []connection.ConnOption{
+		connection.WithServerVersion(cfg.ServerVersion),
+		connection.WithServerCapabilities(capabilities),
+	}, nil
+}
+
+func listen(cfg ListenerConfig) (net.Listener, error) {
+	switch cfg.Network {
+	case "tcp", "tcp4", "tcp6":
+	case "unix":
+		if err := removeStaleSocket(cfg.Addr); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("不支持的网络类型 %q", cfg.Network)
+	}
+	l, err := net.Listen(cfg.Network, cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("监听 %s %s 失败 %w", cfg.Network, cfg.Addr, err)
+	}
+	return l, nil
+}
+
+// removeStaleSocket 进程异常退出的时候 socket 文件不会被删除，导致重启之后无法监听
+// 没有进程在上面监听的时候删除它，其余类型的文件不会删除
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s 已经存在，并且不是 socket 文件", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s 上已经有进程在监听了", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,69 @@
+package mysql
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveStaleSocket(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  func(t *testing.T, path string)
+		wantErr bool
+		// exist 调用之后文件是否还存在
+		exist bool
+	}{
+		{
+			name:   "文件不存在",
+			before: func(t *testing.T, path string) {},
+		},
+		{
+			name: "进程异常退出留下的文件",
+			before: func(t *testing.T, path string) {
+				l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+				require.NoError(t, err)
+				l.SetUnlinkOnClose(false)
+				require.NoError(t, l.Close())
+			},
+		},
+		{
+			name: "有进程在监听",
+			before: func(t *testing.T, path string) {
+				l, err := net.Listen("unix", path)
+				require.NoError(t, err)
+				t.Cleanup(func() {
+					_ = l.Close()
+				})
+			},
+			wantErr: true,
+			exist:   true,
+		},
+		{
+			name: "不是 socket 文件",
+			before: func(t *testing.T, path string) {
+				require.NoError(t, os.WriteFile(path, []byte("data"), 0o600))
+			},
+			wantErr: true,
+			exist:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dbproxy.sock")
+			tt.before(t, path)
+			err := removeStaleSocket(path)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			_, err = os.Stat(path)
+			assert.Equal(t, tt.exist, err == nil)
+		})
+	}
+}
@@ -11,11 +11,15 @@ import (
 	"github.com/meoying/dbproxy/internal/datasource/cluster"
 	"github.com/meoying/dbproxy/internal/datasource/masterslave"
 	logdriver "github.com/meoying/dbproxy/internal/protocol/mysql/driver/log"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin/internal/handler"
 )
 
 var _ plugin.Plugin = &Plugin{}
+var _ plugin.ConnHook = &Plugin{}
+var _ plugin.SchemaChecker = &Plugin{}
+var _ plugin.SystemVariablesApplier = &Plugin{}
 
 type Plugin struct {
 	hdl *handler.ForwardHandler
@@ -54,3 +58,22 @@ func openDB(dsn string) (*sql.DB, error) {
 func (p *Plugin) Join(next plugin.Handler) plugin.Handler {
 	return p.hdl
 }
+
+func (p *Plugin) OnConnect(ctx *pcontext.Context) error {
+	return p.hdl.OnConnect(ctx)
+}
+
+// OnDisconnect 回滚客户端没有结束的事务，释放这个连接在 handler 中的资源
+func (p *Plugin) OnDisconnect(ctx *pcontext.Context) error {
+	return p.hdl.OnDisconnect(ctx)
+}
+
+// AppliesSystemVariables 开启事务的时候会在事务的连接上设置客户端修改过的系统变量
+func (p *Plugin) AppliesSystemVariables() bool {
+	return true
+}
+
+// HasSchema 后端只有配置中的一个库，其它的库都不能切换过去
+func (p *Plugin) HasSchema(schema string) bool {
+	return p.hdl.HasSchema(schema)
+}
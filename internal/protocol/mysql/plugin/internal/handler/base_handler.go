@@ -52,8 +52,10 @@ func (h *baseHandler) isInTransaction(connID uint32) bool {
 }
 
 // handleStartTransactionStmt 处理开启事务语句
+// 事务和连接的生命周期绑定，不能因为开启事务的命令执行完毕或者超时而被回滚
+// 隔离级别和只读特性由 ctx.TxOptions 指定，只读事务在主从模式下可以使用从库
 func (h *baseHandler) handleStartTransactionStmt(ctx *pcontext.Context) (*plugin.Result, error) {
-	tx, err := h.ds.BeginTx(h.newTxCtx(ctx), nil)
+	tx, err := h.ds.BeginTx(h.newTxCtx(context.WithoutCancel(ctx)), ctx.TxOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -87,6 +89,20 @@ func (h *baseHandler) handleRollbackStmt(ctx *pcontext.Context) (*plugin.Result,
 	return &plugin.Result{}, err
 }
 
+// OnConnect 参考 plugin.ConnHook，建立连接的时候不需要做什么
+func (h *baseHandler) OnConnect(ctx *pcontext.Context) error {
+	return nil
+}
+
+// OnDisconnect 参考 plugin.ConnHook，回滚客户端断开连接的时候没有结束的事务
+func (h *baseHandler) OnDisconnect(ctx *pcontext.Context) error {
+	tx, ok := h.connID2Tx.LoadAndDelete(ctx.ConnID)
+	if !ok {
+		return nil
+	}
+	return tx.Rollback()
+}
+
 func (h *baseHandler) getStmtPreparer(ctx *pcontext.Context) datasource.StmtPreparer {
 	if tx := h.getTxByConnID(ctx.ConnID); tx != nil {
 		return tx
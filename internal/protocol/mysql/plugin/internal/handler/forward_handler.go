@@ -1,10 +1,14 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/ecodeclub/ekit/sqlx"
 	"github.com/ecodeclub/ekit/syncx"
@@ -12,18 +16,34 @@ import (
 	"github.com/meoying/dbproxy/internal/datasource"
 	"github.com/meoying/dbproxy/internal/datasource/masterslave"
 	"github.com/meoying/dbproxy/internal/datasource/transaction"
+	"github.com/meoying/dbproxy/internal/errs"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/visitor/vparser"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
+	"go.uber.org/multierr"
 )
 
 // ForwardHandler 什么也不做，就是转发请求
-// 一般用于测试环境
+// 一般用于测试环境。dbproxy 不需要理解的语句会原样转发给后端，例如 SHOW、DDL、CALL、CHECK TABLE
 type ForwardHandler struct {
 	*baseHandler
 	stmtID2Stmt       syncx.Map[uint32, datasource.Stmt]
 	stmtID2PrepareCtx syncx.Map[uint32, *pcontext.Context]
-	config            forward.Config
+	// connID2StmtIDs 每个连接上没有关闭的预处理语句，连接断开的时候只需要关闭这些。
+	// 同一个连接上的命令是串行执行的，所以里面的 map 不需要加锁
+	connID2StmtIDs syncx.Map[uint32, map[uint32]struct{}]
+	// connID2SysVars 事务的连接上设置过的系统变量
+	connID2SysVars syncx.Map[uint32, *txSystemVariables]
+	config         forward.Config
+}
+
+// txSystemVariables 事务的连接上设置过的系统变量
+// 事务结束之后连接会回到连接池中被其它客户端复用，所以结束之前要恢复成原本的值
+type txSystemVariables struct {
+	// applied 已经设置到连接上的值
+	applied map[string]string
+	// origin 第一次设置之前连接上原本的值
+	origin map[string]sql.NullString
 }
 
 func NewForwardHandler(ds datasource.DataSource, config forward.Config) *ForwardHandler {
@@ -45,14 +65,60 @@ func (h *ForwardHandler) Handle(ctx *pcontext.Context) (*plugin.Result, error) {
 	case vparser.DeallocatePrepareStmt:
 		return h.handleDeallocatePrepareStmt(ctx)
 	case vparser.StartTransactionStmt:
-		return h.handleStartTransactionStmt(ctx)
+		return h.handleBeginStmt(ctx)
 	case vparser.CommitStmt:
-		return h.handleCommitStmt(ctx)
+		err := h.restoreSystemVariables(ctx)
+		res, err1 := h.handleCommitStmt(ctx)
+		return res, multierr.Append(err1, err)
 	case vparser.RollbackStmt:
-		return h.handleRollbackStmt(ctx)
+		err := h.restoreSystemVariables(ctx)
+		res, err1 := h.handleRollbackStmt(ctx)
+		return res, multierr.Append(err1, err)
+	case vparser.SetStmt:
+		// 只有处于事务中的时候才会收到 SET 语句，其余时候由 dbproxy 记录在连接上
+		return &plugin.Result{InTransactionState: h.isInTransaction(ctx.ConnID)}, h.applySystemVariables(ctx)
+	default:
+		return h.handlePassthroughStmt(ctx, sqlTypeName)
+	}
+}
+
+// handlePassthroughStmt 原样转发 dbproxy 不需要理解的语句，包括解析失败的语句
+// SHOW、DESCRIBE、EXPLAIN 和 CALL 会返回结果集，其中存储过程可能返回多个结果集；存储过程可能修改数据，所以和写操作一样使用主库。
+// DDL 这种一定不会返回结果集的语句只返回执行结果；其余语句可能返回结果集，例如 CHECK TABLE、HELP，
+// 也可能修改数据，例如 OPTIMIZE TABLE，所以使用主库，没有结果集的时候由 dbproxy 返回 OK 报文。
+// 后端的连接是多个客户端共用的，所以不支持在连接上留下会话状态的语句，例如 LOCK TABLES、CREATE TEMPORARY TABLE
+func (h *ForwardHandler) handlePassthroughStmt(ctx *pcontext.Context, sqlTypeName string) (*plugin.Result, error) {
+	query := datasource.Query{
+		SQL:  ctx.Query,
+		Args: ctx.Args,
+		DB:   h.dbName(ctx),
+	}
+	var rows sqlx.Rows
+	var res sql.Result
+	var err error
+	switch sqlTypeName {
+	case vparser.ShowStmt, vparser.DescribeStmt, vparser.CallStmt:
+		if sqlTypeName == vparser.CallStmt || ctx.ParsedQuery.UseMaster() {
+			ctx.Context = masterslave.UseMaster(ctx.Context)
+		}
+		rows, err = h.getDatasource(ctx).Query(ctx.Context, query)
 	default:
-		return nil, fmt.Errorf("%w", errors.New(sqlTypeName))
+		val := vparser.NewPassthroughVisitor().Parse(ctx.ParsedQuery.Root()).(vparser.BaseVal).Data.(vparser.PassthroughVal)
+		if val.SessionState {
+			return nil, errs.NewUnsupportedSQLError(fmt.Sprintf("后端连接是共用的，不支持会在连接上留下会话状态的语句: %s", ctx.Query))
+		}
+		if val.NoResultset {
+			res, err = h.getDatasource(ctx).Exec(ctx, query)
+		} else {
+			ctx.Context = masterslave.UseMaster(ctx.Context)
+			rows, err = h.getDatasource(ctx).Query(ctx.Context, query)
+		}
 	}
+	return &plugin.Result{
+		Rows:               rows,
+		Result:             res,
+		InTransactionState: h.isInTransaction(ctx.ConnID),
+	}, err
 }
 
 // handleCRUDStmt 处理Select、Insert、Update、Delete操作
@@ -70,14 +136,14 @@ func (h *ForwardHandler) handleCRUDStmt(ctx *pcontext.Context, sqlTypeName strin
 			Args: ctx.Args,
 			// TODO: 如果时多主, 多从该如何选择db
 			// TODO: DB字段和DataSource字段的区别?
-			DB: h.config.DBName,
+			DB: h.dbName(ctx),
 		})
 	} else {
 		res, err = h.getDatasource(ctx).Exec(ctx, datasource.Query{
 			SQL:  ctx.Query,
 			Args: ctx.Args,
 			// TODO: 写操作默认走主库?
-			DB: h.config.DBName,
+			DB: h.dbName(ctx),
 		})
 	}
 	return &plugin.Result{
@@ -87,24 +153,39 @@ func (h *ForwardHandler) handleCRUDStmt(ctx *pcontext.Context, sqlTypeName strin
 	}, err
 }
 
+// dbName 数据源中只有配置的库，客户端选择的逻辑库在 USE 的时候已经由 HasSchema 校验过了
+func (h *ForwardHandler) dbName(ctx *pcontext.Context) string {
+	return h.config.DBName
+}
+
+// HasSchema 参考 plugin.SchemaChecker，只能使用配置的库
+func (h *ForwardHandler) HasSchema(schema string) bool {
+	return schema == h.config.DBName
+}
+
 func (h *ForwardHandler) handlePrepareStmt(ctx *pcontext.Context) (*plugin.Result, error) {
 	stmt, err := h.getStmtPreparer(ctx).Prepare(ctx, datasource.Query{
 		SQL: ctx.Query,
-		DB:  h.config.DBName,
+		DB:  h.dbName(ctx),
 	})
 	if err != nil {
 		return nil, err
 	}
 	h.stmtID2Stmt.Store(ctx.StmtID, stmt)
-	h.stmtID2PrepareCtx.Store(ctx.StmtID, &pcontext.Context{
-		Context: ctx.Context,
-		// SELECT * FROM order where `user_id` = ?;
-		// SELECT * FROM order where `user_id` = '?';
-		ParsedQuery: pcontext.NewParsedQuery(h.convertQuery(ctx.Query)),
-		Query:       ctx.Query,
-		ConnID:      ctx.ConnID,
-		StmtID:      ctx.StmtID,
-	})
+	stmtIDs, ok := h.connID2StmtIDs.Load(ctx.ConnID)
+	if !ok {
+		stmtIDs = make(map[uint32]struct{})
+		h.connID2StmtIDs.Store(ctx.ConnID, stmtIDs)
+	}
+	stmtIDs[ctx.StmtID] = struct{}{}
+	// 保留 COM_STMT_PREPARE 命令的上下文中和连接相关的全部字段
+	prepareCtx := *ctx
+	// 预处理语句和连接的生命周期绑定，执行的时候使用的是 COM_STMT_EXECUTE 命令的 ctx
+	prepareCtx.Context = context.WithoutCancel(ctx.Context)
+	// SELECT * FROM order where `user_id` = ?;
+	// SELECT * FROM order where `user_id` = '?';
+	prepareCtx.ParsedQuery = pcontext.NewParsedQuery(h.convertQuery(ctx.Query))
+	h.stmtID2PrepareCtx.Store(ctx.StmtID, &prepareCtx)
 	return &plugin.Result{
 		InTransactionState: h.isInTransaction(ctx.ConnID),
 		StmtID:             ctx.StmtID,
@@ -136,20 +217,26 @@ func (h *ForwardHandler) handleExecutePrepareStmt(ctx *pcontext.Context) (*plugi
 		return nil, err
 	}
 	log.Printf("handleExecutePrepareStmt: type = %#v, query = %#v, args = %#v", c.ParsedQuery.Type(), c.Query, ctx.Args)
+	// 预处理语句可能是在事务之外准备的，事务中执行的时候要使用事务的连接，
+	// 例如 autocommit 的时候为了让系统变量生效而开启的只包含这个语句的事务
+	var executor datasource.Executor = stmt
+	if tx := h.getTxByConnID(ctx.ConnID); tx != nil {
+		executor = tx
+	}
 	var result sql.Result
 	var rows sqlx.Rows
 	switch c.ParsedQuery.Type() {
-	case vparser.SelectStmt:
-		rows, err = stmt.Query(ctx.Context, datasource.Query{
+	case vparser.SelectStmt, vparser.ShowStmt, vparser.DescribeStmt, vparser.CallStmt:
+		rows, err = executor.Query(ctx.Context, datasource.Query{
 			SQL:  c.Query,
 			Args: ctx.Args,
-			DB:   h.config.DBName,
+			DB:   h.dbName(ctx),
 		})
-	case vparser.InsertStmt, vparser.UpdateStmt, vparser.DeleteStmt:
-		result, err = stmt.Exec(ctx.Context, datasource.Query{
+	default:
+		result, err = executor.Exec(ctx.Context, datasource.Query{
 			SQL:  c.Query,
 			Args: ctx.Args,
-			DB:   h.config.DBName,
+			DB:   h.dbName(ctx),
 		})
 	}
 	log.Printf("handleExecutePrepareStmt: result : %#v, rows : %#v\n", result, rows)
@@ -169,7 +256,182 @@ func (h *ForwardHandler) handleDeallocatePrepareStmt(ctx *pcontext.Context) (*pl
 	err = stmt.Close()
 	h.stmtID2Stmt.Delete(ctx.StmtID)
 	h.stmtID2PrepareCtx.Delete(ctx.StmtID)
+	if stmtIDs, ok := h.connID2StmtIDs.Load(ctx.ConnID); ok {
+		delete(stmtIDs, ctx.StmtID)
+		if len(stmtIDs) == 0 {
+			h.connID2StmtIDs.Delete(ctx.ConnID)
+		}
+	}
 	return &plugin.Result{
 		InTransactionState: h.isInTransaction(ctx.ConnID),
 	}, err
 }
+
+// OnDisconnect 参考 plugin.ConnHook，关闭客户端没有关闭的预处理语句，
+// 恢复事务的连接上的系统变量之后回滚没有结束的事务
+func (h *ForwardHandler) OnDisconnect(ctx *pcontext.Context) error {
+	var err error
+	stmtIDs, _ := h.connID2StmtIDs.LoadAndDelete(ctx.ConnID)
+	for stmtID := range stmtIDs {
+		h.stmtID2PrepareCtx.Delete(stmtID)
+		if stmt, ok := h.stmtID2Stmt.LoadAndDelete(stmtID); ok {
+			err = multierr.Append(err, stmt.Close())
+		}
+	}
+	err = multierr.Append(err, h.restoreSystemVariables(ctx))
+	return multierr.Append(err, h.baseHandler.OnDisconnect(ctx))
+}
+
+// handleBeginStmt 开启事务，并且在事务的连接上设置客户端修改过的系统变量
+func (h *ForwardHandler) handleBeginStmt(ctx *pcontext.Context) (*plugin.Result, error) {
+	res, err := h.handleStartTransactionStmt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = h.applySystemVariables(ctx); err != nil {
+		_ = h.restoreSystemVariables(ctx)
+		_, _ = h.handleRollbackStmt(ctx)
+		return nil, err
+	}
+	return res, nil
+}
+
+// applySystemVariables 把 ctx.SystemVariables 设置到事务的连接上，不在事务中的时候什么也不做
+// 客户端恢复成默认值的变量，在连接上恢复成原本的值
+func (h *ForwardHandler) applySystemVariables(ctx *pcontext.Context) error {
+	tx := h.getTxByConnID(ctx.ConnID)
+	if tx == nil {
+		return nil
+	}
+	vars, ok := h.connID2SysVars.Load(ctx.ConnID)
+	if !ok {
+		vars = &txSystemVariables{origin: map[string]sql.NullString{}}
+	}
+	var names, unknown []string
+	for name, val := range ctx.SystemVariables {
+		if applied, ok := vars.applied[name]; !ok || applied != val {
+			names = append(names, name)
+		}
+	}
+	for name := range vars.applied {
+		if _, ok := ctx.SystemVariables[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if _, ok := vars.origin[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		origin, err := h.querySystemVariables(ctx, tx, unknown)
+		if err != nil {
+			return err
+		}
+		maps.Copy(vars.origin, origin)
+		h.connID2SysVars.Store(ctx.ConnID, vars)
+	}
+	values := make([]sql.NullString, 0, len(names))
+	for _, name := range names {
+		if val, ok := ctx.SystemVariables[name]; ok {
+			values = append(values, sql.NullString{String: val, Valid: true})
+		} else {
+			values = append(values, vars.origin[name])
+		}
+	}
+	if err := h.setSystemVariables(ctx, tx, names, values); err != nil {
+		return err
+	}
+	vars.applied = maps.Clone(ctx.SystemVariables)
+	return nil
+}
+
+// restoreSystemVariables 在事务结束之前把连接上的系统变量恢复成原本的值
+func (h *ForwardHandler) restoreSystemVariables(ctx *pcontext.Context) error {
+	vars, ok := h.connID2SysVars.LoadAndDelete(ctx.ConnID)
+	tx := h.getTxByConnID(ctx.ConnID)
+	if !ok || tx == nil {
+		return nil
+	}
+	names := make([]string, 0, len(vars.origin))
+	for name := range vars.origin {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	values := make([]sql.NullString, 0, len(names))
+	for _, name := range names {
+		values = append(values, vars.origin[name])
+	}
+	return h.setSystemVariables(ctx, tx, names, values)
+}
+
+func (h *ForwardHandler) querySystemVariables(ctx *pcontext.Context, tx datasource.DataSource, names []string) (map[string]sql.NullString, error) {
+	var sb strings.Builder
+	sb.WriteString("SELECT ")
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("@@SESSION.")
+		sb.WriteString(quoteIdentifier(name))
+	}
+	rows, err := tx.Query(ctx.Context, datasource.Query{SQL: sb.String(), DB: h.dbName(ctx)})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make([]sql.NullString, len(names))
+	dest := make([]any, len(names))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if !rows.Next() {
+		return nil, multierr.Append(rows.Err(), sql.ErrNoRows)
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	res := make(map[string]sql.NullString, len(names))
+	for i, name := range names {
+		res[name] = values[i]
+	}
+	return res, nil
+}
+
+func (h *ForwardHandler) setSystemVariables(ctx *pcontext.Context, tx datasource.DataSource, names []string, values []sql.NullString) error {
+	if len(names) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	sb.WriteString("SET SESSION ")
+	args := make([]any, 0, len(names))
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(quoteIdentifier(name))
+		sb.WriteString(" = ?")
+		args = append(args, systemVariableArg(values[i]))
+	}
+	_, err := tx.Exec(context.WithoutCancel(ctx.Context), datasource.Query{SQL: sb.String(), Args: args, DB: h.dbName(ctx)})
+	return err
+}
+
+// systemVariableArg 整数类型的变量不能使用字符串设置，例如 auto_increment_increment
+func systemVariableArg(val sql.NullString) any {
+	if !val.Valid {
+		return nil
+	}
+	if i, err := strconv.ParseInt(val.String, 10, 64); err == nil {
+		return i
+	}
+	return val.String
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
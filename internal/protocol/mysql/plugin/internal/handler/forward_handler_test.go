@@ -0,0 +1,288 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/meoying/dbproxy/config/mysql/plugins/forward"
+	"github.com/meoying/dbproxy/internal/datasource/single"
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/visitor/vparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardHandler_SystemVariables(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	h := NewForwardHandler(single.NewDB(db), forward.Config{})
+	newCtx := func(query string, vars map[string]string) *pcontext.Context {
+		return &pcontext.Context{
+			Context:         context.Background(),
+			Query:           query,
+			ParsedQuery:     pcontext.NewParsedQuery(query),
+			ConnID:          1,
+			SystemVariables: vars,
+		}
+	}
+
+	// 开启事务的时候先记录原本的值，再设置成客户端修改过的值
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT @@SESSION.`sql_mode`, @@SESSION.`time_zone`").
+		WillReturnRows(sqlmock.NewRows([]string{"a", "b"}).AddRow("STRICT_TRANS_TABLES", "SYSTEM"))
+	mock.ExpectExec("SET SESSION `sql_mode` = ?, `time_zone` = ?").
+		WithArgs("", "+08:00").WillReturnResult(sqlmock.NewResult(0, 0))
+	res, err := h.Handle(newCtx("START TRANSACTION", map[string]string{"time_zone": "+08:00", "sql_mode": ""}))
+	require.NoError(t, err)
+	assert.True(t, res.InTransactionState)
+
+	// 事务中的 SET 只需要设置发生了变化的变量，恢复成默认值的变量要恢复成原本的值
+	mock.ExpectQuery("SELECT @@SESSION.`auto_increment_increment`").
+		WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("1"))
+	mock.ExpectExec("SET SESSION `auto_increment_increment` = ?, `sql_mode` = ?").
+		WithArgs(int64(2), "STRICT_TRANS_TABLES").WillReturnResult(sqlmock.NewResult(0, 0))
+	res, err = h.Handle(newCtx("SET auto_increment_increment = 2, sql_mode = DEFAULT",
+		map[string]string{"time_zone": "+08:00", "auto_increment_increment": "2"}))
+	require.NoError(t, err)
+	assert.True(t, res.InTransactionState)
+
+	// 事务结束之前恢复全部变量，连接回到连接池之后不会影响其它客户端
+	mock.ExpectExec("SET SESSION `auto_increment_increment` = ?, `sql_mode` = ?, `time_zone` = ?").
+		WithArgs(int64(1), "STRICT_TRANS_TABLES", "SYSTEM").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	res, err = h.Handle(newCtx("COMMIT", map[string]string{"time_zone": "+08:00", "auto_increment_increment": "2"}))
+	require.NoError(t, err)
+	assert.False(t, res.InTransactionState)
+
+	// 没有修改过变量的事务不需要额外的语句
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	_, err = h.Handle(newCtx("START TRANSACTION", nil))
+	require.NoError(t, err)
+	_, err = h.Handle(newCtx("ROLLBACK", nil))
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestForwardHandler_Passthrough(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		mock     func(mock sqlmock.Sqlmock)
+		wantRows bool
+		wantErr  error
+	}{
+		{
+			name:  "SHOW",
+			query: "SHOW TABLES",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SHOW TABLES").WillReturnRows(sqlmock.NewRows([]string{"Tables_in_test"}).AddRow("users"))
+			},
+			wantRows: true,
+		},
+		{
+			name:  "EXPLAIN",
+			query: "EXPLAIN SELECT * FROM users",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("EXPLAIN SELECT * FROM users").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+			},
+			wantRows: true,
+		},
+		{
+			name:  "CALL",
+			query: "CALL get_users(1)",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("CALL get_users(1)").WillReturnRows(
+					sqlmock.NewRows([]string{"id"}).AddRow("1"),
+					sqlmock.NewRows([]string{"total"}).AddRow("1"))
+			},
+			wantRows: true,
+		},
+		{
+			name:  "DDL",
+			query: "CREATE TABLE users (id BIGINT PRIMARY KEY)",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("CREATE TABLE users (id BIGINT PRIMARY KEY)").WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+		},
+		{
+			name:  "语法错误",
+			query: "SELECT 1 FROM",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT 1 FROM").WillReturnError(&mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax"})
+			},
+			wantErr: &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax"},
+		},
+		{
+			name:  "CHECK TABLE",
+			query: "CHECK TABLE users",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("CHECK TABLE users").WillReturnRows(
+					sqlmock.NewRows([]string{"Table", "Op", "Msg_type", "Msg_text"}).AddRow("test.users", "check", "status", "OK"))
+			},
+			wantRows: true,
+		},
+		{
+			name:  "WITH SELECT",
+			query: "WITH cte AS (SELECT 1) SELECT * FROM cte",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("WITH cte AS (SELECT 1) SELECT * FROM cte").WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow("1"))
+			},
+			wantRows: true,
+		},
+		{
+			name:    "LOCK TABLES",
+			query:   "LOCK TABLES users READ",
+			mock:    func(mock sqlmock.Sqlmock) {},
+			wantErr: errs.NewUnsupportedSQLError("后端连接是共用的，不支持会在连接上留下会话状态的语句: LOCK TABLES users READ"),
+		},
+		{
+			name:    "创建临时表",
+			query:   "CREATE TEMPORARY TABLE tmp_users (id BIGINT)",
+			mock:    func(mock sqlmock.Sqlmock) {},
+			wantErr: errs.NewUnsupportedSQLError("后端连接是共用的，不支持会在连接上留下会话状态的语句: CREATE TEMPORARY TABLE tmp_users (id BIGINT)"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.NoError(t, err)
+			defer db.Close()
+			tt.mock(mock)
+			h := NewForwardHandler(single.NewDB(db), forward.Config{})
+			res, err := h.Handle(&pcontext.Context{
+				Context:     context.Background(),
+				Query:       tt.query,
+				ParsedQuery: pcontext.NewParsedQuery(tt.query),
+				ConnID:      1,
+			})
+			assert.Equal(t, tt.wantErr, err)
+			if tt.wantErr != nil {
+				require.NoError(t, mock.ExpectationsWereMet())
+				return
+			}
+			if tt.wantRows {
+				require.NotNil(t, res.Rows)
+				require.NoError(t, res.Rows.Close())
+			} else {
+				assert.NotNil(t, res.Result)
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestForwardHandler_OnDisconnect(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	h := NewForwardHandler(single.NewDB(db), forward.Config{})
+	vars := map[string]string{"time_zone": "+08:00"}
+	prepareCtx := func(connID, stmtID uint32, query string) *pcontext.Context {
+		return &pcontext.Context{
+			Context:     context.Background(),
+			Query:       query,
+			ParsedQuery: pcontext.NewParsedQuery(fmt.Sprintf("PREPARE stmt%d FROM '%s'", stmtID, query)),
+			ConnID:      connID,
+			StmtID:      stmtID,
+		}
+	}
+	// 连接断开的时候只有和连接相关的字段
+	connCtx := func(connID uint32) *pcontext.Context {
+		return &pcontext.Context{Context: context.Background(), ConnID: connID, SystemVariables: vars}
+	}
+
+	// 连接 1 在事务中修改了系统变量，并且打开了一个预处理语句
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT @@SESSION.`time_zone`").
+		WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow("SYSTEM"))
+	mock.ExpectExec("SET SESSION `time_zone` = ?").
+		WithArgs("+08:00").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("SELECT * FROM users WHERE id = ?").WillBeClosed()
+	_, err = h.Handle(&pcontext.Context{
+		Context:         context.Background(),
+		Query:           "START TRANSACTION",
+		ParsedQuery:     pcontext.NewParsedQuery("START TRANSACTION"),
+		ConnID:          1,
+		SystemVariables: vars,
+	})
+	require.NoError(t, err)
+	_, err = h.Handle(prepareCtx(1, 1, "SELECT * FROM users WHERE id = ?"))
+	require.NoError(t, err)
+	// 连接 2 的预处理语句不受影响
+	mock.ExpectPrepare("SELECT * FROM orders WHERE id = ?")
+	_, err = h.Handle(prepareCtx(2, 2, "SELECT * FROM orders WHERE id = ?"))
+	require.NoError(t, err)
+
+	// 断开之后关闭预处理语句，恢复系统变量并且回滚事务
+	mock.ExpectExec("SET SESSION `time_zone` = ?").
+		WithArgs("SYSTEM").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	require.NoError(t, h.OnDisconnect(connCtx(1)))
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.False(t, h.isInTransaction(1))
+	_, ok := h.stmtID2Stmt.Load(1)
+	assert.False(t, ok)
+	_, ok = h.stmtID2PrepareCtx.Load(1)
+	assert.False(t, ok)
+	_, ok = h.connID2StmtIDs.Load(1)
+	assert.False(t, ok)
+	_, ok = h.stmtID2Stmt.Load(2)
+	assert.True(t, ok)
+	stmtIDs, _ := h.connID2StmtIDs.Load(2)
+	assert.Equal(t, map[uint32]struct{}{2: {}}, stmtIDs)
+	// 客户端关闭之后连接上没有需要释放的预处理语句了
+	_, err = h.Handle(&pcontext.Context{
+		Context:     context.Background(),
+		Query:       "DEALLOCATE PREPARE stmt2",
+		ParsedQuery: pcontext.NewParsedQuery("DEALLOCATE PREPARE stmt2"),
+		ConnID:      2,
+		StmtID:      2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	_, ok = h.connID2StmtIDs.Load(2)
+	assert.False(t, ok)
+
+	// 没有事务和预处理语句的连接什么也不做
+	require.NoError(t, h.OnDisconnect(connCtx(3)))
+}
+
+func TestForwardHandler_PrepareContext(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	h := NewForwardHandler(single.NewDB(db), forward.Config{})
+	mock.ExpectPrepare("SELECT * FROM users WHERE id = ?")
+	session := pcontext.NewSession()
+	_, err = h.Handle(&pcontext.Context{
+		Context:     context.Background(),
+		Query:       "SELECT * FROM users WHERE id = ?",
+		ParsedQuery: pcontext.NewParsedQuery("PREPARE stmt1 FROM 'SELECT * FROM users WHERE id = ?'"),
+		ConnID:      1,
+		StmtID:      1,
+		User:        "root",
+		Schema:      "test",
+		ConnAttrs:   map[string]string{"_client_name": "libmysql"},
+		Charset:     "utf8mb4",
+		Session:     session,
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// 执行预处理语句的时候使用的上下文保留了连接相关的全部字段
+	c, err := h.getPrepareContextByStmtID(1)
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?", c.Query)
+	assert.Equal(t, "root", c.User)
+	assert.Equal(t, "test", c.Schema)
+	assert.Equal(t, map[string]string{"_client_name": "libmysql"}, c.ConnAttrs)
+	assert.Equal(t, "utf8mb4", c.Charset)
+	assert.Same(t, session, c.Session)
+	assert.Equal(t, vparser.SelectStmt, c.ParsedQuery.Type())
+}
@@ -1,13 +1,13 @@
 package handler
 
 import (
-	"errors"
 	"fmt"
 
 	"github.com/meoying/dbproxy/internal/datasource/masterslave"
 
 	"github.com/meoying/dbproxy/internal/datasource"
 	"github.com/meoying/dbproxy/internal/datasource/transaction"
+	"github.com/meoying/dbproxy/internal/errs"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
 	shardinghandler "github.com/meoying/dbproxy/internal/protocol/mysql/internal/sharding"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/visitor/vparser"
@@ -53,8 +53,11 @@ func (h *ShardingHandler) Handle(ctx *pcontext.Context) (*plugin.Result, error)
 		return h.handleCommitStmt(ctx)
 	case vparser.RollbackStmt:
 		return h.handleRollbackStmt(ctx)
+	case vparser.SetStmt:
+		// 分库分表的事务在执行语句的时候才会在各个库上开启，系统变量只记录在客户端的连接上
+		return &plugin.Result{InTransactionState: h.isInTransaction(ctx.ConnID)}, nil
 	default:
-		return nil, fmt.Errorf("尚未支持的SQL特性: %w", errors.New(sqlTypeName))
+		return nil, errs.NewUnsupportedSQLError(fmt.Sprintf("尚未支持的SQL特性: %s", sqlTypeName))
 	}
 }
 
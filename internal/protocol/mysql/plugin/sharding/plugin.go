@@ -5,10 +5,13 @@ import (
 
 	shardingconfig "github.com/meoying/dbproxy/config/mysql/plugins/sharding"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/configbuilder"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin/internal/handler"
 )
 
+var _ plugin.ConnHook = &Plugin{}
+
 type Plugin struct {
 	hdl *handler.ShardingHandler
 }
@@ -41,3 +44,12 @@ func (p *Plugin) Init(cfg []byte) error {
 func (p *Plugin) Join(next plugin.Handler) plugin.Handler {
 	return p.hdl
 }
+
+func (p *Plugin) OnConnect(ctx *pcontext.Context) error {
+	return p.hdl.OnConnect(ctx)
+}
+
+// OnDisconnect 回滚客户端没有结束的事务，释放这个连接在 handler 中的资源
+func (p *Plugin) OnDisconnect(ctx *pcontext.Context) error {
+	return p.hdl.OnDisconnect(ctx)
+}
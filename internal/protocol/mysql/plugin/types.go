@@ -3,6 +3,7 @@ package plugin
 import (
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/sharding"
+	"github.com/meoying/dbproxy/internal/rows"
 )
 
 // Plugin 代表的是插件
@@ -19,6 +20,34 @@ type Plugin interface {
 	Join(next Handler) Handler
 }
 
+// ConnHook 插件可以选择实现，用于感知客户端连接的建立和断开
+// Server 会对每一个实现了它的插件调用，ctx 中只有和连接相关的字段，例如 ConnID、User、Session
+type ConnHook interface {
+	// OnConnect 客户端鉴权成功之后调用，按照插件的顺序执行
+	// 返回 error 的时候拒绝这个连接，错误会返回给客户端
+	OnConnect(ctx *pcontext.Context) error
+	// OnDisconnect 客户端断开连接之后调用，按照和 OnConnect 相反的顺序执行
+	// 插件需要在这里回滚没有结束的事务，释放和连接相关的资源。
+	// 只有 OnConnect 执行成功的插件才会被调用，返回的 error 只会记录在日志中
+	OnDisconnect(ctx *pcontext.Context) error
+}
+
+// SchemaChecker 插件可以选择实现，用于校验客户端选择的逻辑库
+// 客户端在握手、USE 语句或者 COM_INIT_DB 中选择逻辑库的时候，Server 会对每一个实现了它的插件调用，
+// 任何一个插件不认识这个逻辑库都会给客户端返回 ER_BAD_DB_ERROR
+type SchemaChecker interface {
+	// HasSchema 插件能否处理逻辑库 schema 上的语句
+	HasSchema(schema string) bool
+}
+
+// SystemVariablesApplier 插件可以选择实现，表示插件开启事务的时候会把 pcontext.Context 中的 SystemVariables
+// 设置到事务的后端连接上。autocommit 的时候，只有某个插件会设置这些变量，
+// Server 才会把客户端修改过变量之后的增删改查语句放在只包含它自己的事务中执行，让这些变量对语句生效
+type SystemVariablesApplier interface {
+	// AppliesSystemVariables 返回 false 的时候和没有实现这个接口一样
+	AppliesSystemVariables() bool
+}
+
 type HandleFunc func(ctx *pcontext.Context) (*Result, error)
 
 func (h HandleFunc) Handle(ctx *pcontext.Context) (*Result, error) {
@@ -31,3 +60,27 @@ type Handler interface {
 }
 
 type Result sharding.Result
+
+// Summary 参考 sharding.Summary
+type Summary = sharding.Summary
+
+// ShardSummary 参考 sharding.ShardSummary
+type ShardSummary = sharding.ShardSummary
+
+// Intercept 结果集写回客户端的时候每一行都会按照顺序经过 interceptors 处理，例如脱敏
+// 没有结果集的时候什么也不做
+func (r *Result) Intercept(interceptors ...rows.Interceptor) {
+	if r.Rows == nil {
+		return
+	}
+	r.Rows = rows.Intercept(r.Rows, interceptors...)
+}
+
+// OnComplete 注册结果写回客户端之后的回调，fn 可以读到最终的统计信息，例如审计、统计
+// handler 没有填充 Summary 的时候会创建一个，网关会统计返回的行数和影响的行数
+func (r *Result) OnComplete(fn func(s *Summary)) {
+	if r.Summary == nil {
+		r.Summary = &Summary{}
+	}
+	r.Summary.OnComplete(fn)
+}
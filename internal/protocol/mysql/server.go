@@ -2,14 +2,24 @@ package mysql
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/meoying/dbproxy/internal/errs"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/auth"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/cmd"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/flags"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/packet/builder"
 	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
 
@@ -17,14 +27,60 @@ import (
 	"github.com/hashicorp/go-multierror"
 )
 
+const (
+	// shutdownPollInterval 优雅退出的时候检查连接是否空闲的间隔
+	shutdownPollInterval = 50 * time.Millisecond
+	// rollbackTimeout 连接断开之后插件回滚事务、释放资源的超时时间
+	rollbackTimeout = 5 * time.Second
+)
+
 type Server struct {
-	addr     string
-	logger   *slog.Logger
-	mu       sync.Mutex
-	listener net.Listener
+	// listenerConfigs 监听的全部地址
+	listenerConfigs []ListenerConfig
+	logger          *slog.Logger
+	mu              sync.Mutex
+	listeners       []net.Listener
+	// nextConnID 所有地址上的连接共享 ID
+	nextConnID atomic.Uint32
 
 	conns     syncx.Map[uint32, *connection.Conn]
 	executors map[byte]cmd.Executor
+	// stmtExecutor 连接断开的时候用来释放预处理语句的状态，例如关闭游标
+	stmtExecutor *cmd.BaseStmtExecutor
+	// connHooks 实现了 plugin.ConnHook 的插件，按照插件的顺序
+	connHooks []plugin.ConnHook
+	// schemaCheckers 实现了 plugin.SchemaChecker 的插件
+	schemaCheckers []plugin.SchemaChecker
+	// connWg 等待所有连接的 goroutine 退出，Add 和 closed 的检查都要持有 mu
+	connWg sync.WaitGroup
+
+	// tlsConfig 不为 nil 的时候允许客户端使用 TLS 连接
+	tlsConfig *tls.Config
+	// requireSecureTransport 拒绝没有使用 TLS 的客户端
+	requireSecureTransport bool
+	// userStore 为 nil 的时候不校验客户端的用户名和密码
+	userStore auth.Store
+	// sha2Cache 所有连接共享的 caching_sha2_password 缓存
+	sha2Cache *auth.SHA2Cache
+	// rsaKey 客户端在非 TLS 连接上使用 caching_sha2_password 的时候用来加密密码
+	// 没有设置的时候会在启动的时候自动生成一个
+	rsaKey *rsa.PrivateKey
+	// connectTimeout 握手和鉴权的最长时间，为 0 的时候不限制
+	connectTimeout time.Duration
+	// cmdTimeout 每个命令的执行超时时间，为 0 的时候不限制
+	cmdTimeout time.Duration
+	// maxAllowedPacket 客户端发送的 payload 的最大长度
+	maxAllowedPacket int
+	// strictMode 客户端发送了格式非法的报文时是否关闭连接
+	strictMode bool
+	// trustedProxies 可以发送 PROXY protocol 头部的负载均衡的地址，为空的时候不解析头部
+	trustedProxies []netip.Prefix
+	// limiter 限制连接的数量
+	limiter connLimiter
+	// waitTimeout 连接空闲的超时时间，为 0 的时候不限制
+	waitTimeout time.Duration
+	// idleInTxTimeout 连接在事务中空闲的超时时间，为 0 的时候使用 waitTimeout
+	idleInTxTimeout time.Duration
 
 	// 关闭
 	closeOnce sync.Once
@@ -34,68 +90,397 @@ type Server struct {
 // NewServer
 // 插件机制，需要进一步考虑细化
 // 这里默认 plugin 已经完成了初始化
-func NewServer(addr string, plugins []plugin.Plugin) *Server {
+// addr 是监听的 TCP 地址，为空的时候只监听 ServerWithListeners 设置的地址
+func NewServer(addr string, plugins []plugin.Plugin, opts ...ServerOption) *Server {
 	var hdl plugin.Handler
 	for i := len(plugins) - 1; i >= 0; i-- {
 		hdl = plugins[i].Join(hdl)
 	}
+	var connHooks []plugin.ConnHook
+	var schemaCheckers []plugin.SchemaChecker
+	// systemVariablesTx 插件会在事务的连接上设置客户端修改过的系统变量
+	var systemVariablesTx bool
+	for _, p := range plugins {
+		if hook, ok := p.(plugin.ConnHook); ok {
+			connHooks = append(connHooks, hook)
+		}
+		if checker, ok := p.(plugin.SchemaChecker); ok {
+			schemaCheckers = append(schemaCheckers, checker)
+		}
+		if applier, ok := p.(plugin.SystemVariablesApplier); ok && applier.AppliesSystemVariables() {
+			systemVariablesTx = true
+		}
+	}
+
+	s := &Server{
+		logger:         slog.Default(),
+		sha2Cache:      auth.NewSHA2Cache(),
+		connHooks:      connHooks,
+		schemaCheckers: schemaCheckers,
 
-	baseExecutor := &cmd.BaseExecutor{}
+		maxAllowedPacket: connection.DefaultMaxAllowedPacket,
+		connectTimeout:   connection.DefaultConnectTimeout,
+	}
+	baseExecutor := cmd.NewBaseExecutor(s.logger, s.kill, s.hasSchema, systemVariablesTx)
 	baseStmtExecutor := cmd.NewBaseStmtExecutor(baseExecutor)
+	s.stmtExecutor = baseStmtExecutor
+	s.executors = map[byte]cmd.Executor{
+		cmd.CmdPing.Byte():             &cmd.PingExecutor{},
+		cmd.CmdProcessKill.Byte():      cmd.NewProcessKillExecutor(baseExecutor),
+		cmd.CmdInitDB.Byte():           cmd.NewInitDBExecutor(baseExecutor),
+		cmd.CmdSetOption.Byte():        cmd.NewSetOptionExecutor(baseExecutor),
+		cmd.CmdQuery.Byte():            cmd.NewQueryExecutor(hdl, baseExecutor),
+		cmd.CmdStmtPrepare.Byte():      cmd.NewStmtPrepareExecutor(hdl, baseStmtExecutor),
+		cmd.CmdStmtExecute.Byte():      cmd.NewStmtExecuteExecutor(hdl, baseStmtExecutor),
+		cmd.CmdStmtSendLongData.Byte(): cmd.NewStmtSendLongDataExecutor(baseStmtExecutor),
+		cmd.CmdStmtClose.Byte():        cmd.NewStmtCloseExecutor(hdl, baseStmtExecutor),
+		cmd.CmdStmtReset.Byte():        cmd.NewStmtResetExecutor(baseStmtExecutor),
+		cmd.CmdStmtFetch.Byte():        cmd.NewStmtFetchExecutor(baseStmtExecutor),
+	}
+	if addr != "" {
+		s.listenerConfigs = append(s.listenerConfigs, ListenerConfig{Network: "tcp", Addr: addr})
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type ServerOption func(s *Server)
+
+// ServerWithListeners 在 NewServer 的 addr 之外监听更多的地址，例如 Unix domain socket 或者管理端口
+func ServerWithListeners(cfgs ...ListenerConfig) ServerOption {
+	return func(s *Server) {
+		s.listenerConfigs = append(s.listenerConfigs, cfgs...)
+	}
+}
+
+// ServerWithTLSConfig 设置 TLS 配置，客户端可以通过 SSLRequest 切换到 TLS 连接
+func ServerWithTLSConfig(cfg *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
 
-	return &Server{
-		logger: slog.Default(),
-		addr:   addr,
-		executors: map[byte]cmd.Executor{
-			cmd.CmdPing.Byte():        &cmd.PingExecutor{},
-			cmd.CmdQuery.Byte():       cmd.NewQueryExecutor(hdl, baseExecutor),
-			cmd.CmdStmtPrepare.Byte(): cmd.NewStmtPrepareExecutor(hdl, baseStmtExecutor),
-			cmd.CmdStmtExecute.Byte(): cmd.NewStmtExecuteExecutor(hdl, baseStmtExecutor),
-			cmd.CmdStmtClose.Byte():   cmd.NewStmtCloseExecutor(hdl, baseStmtExecutor),
-		},
+// ServerWithUserStore 设置用户存储，客户端需要使用其中的用户名和密码登录
+func ServerWithUserStore(store auth.Store) ServerOption {
+	return func(s *Server) {
+		s.userStore = store
 	}
 }
 
+// ServerWithRSAKey 设置 RSA 私钥，客户端可以在非 TLS 连接上用对应的公钥加密密码
+func ServerWithRSAKey(key *rsa.PrivateKey) ServerOption {
+	return func(s *Server) {
+		s.rsaKey = key
+	}
+}
+
+// ServerWithRequireSecureTransport 拒绝没有使用 TLS 的客户端
+func ServerWithRequireSecureTransport(require bool) ServerOption {
+	return func(s *Server) {
+		s.requireSecureTransport = require
+	}
+}
+
+// ServerWithConnectTimeout 设置握手和鉴权的最长时间，和 MySQL 的 connect_timeout 一样，默认是 10s，为 0 的时候不限制
+// 超时之后客户端会收到 ER_HANDSHAKE_ERROR 错误，并且连接会被关闭
+func ServerWithConnectTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.connectTimeout = timeout
+	}
+}
+
+// ServerWithCmdTimeout 设置每个命令的执行超时时间，为 0 的时候不限制
+// 单个语句还可以通过 /* @proxy timeout=500ms */ 设置更短的超时时间
+func ServerWithCmdTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.cmdTimeout = timeout
+	}
+}
+
+// ServerWithMaxAllowedPacket 设置客户端发送的 payload 的最大长度，默认是 64MB
+// 超过之后客户端会收到 ER_NET_PACKET_TOO_LARGE 错误，并且连接会被关闭
+func ServerWithMaxAllowedPacket(size int) ServerOption {
+	return func(s *Server) {
+		s.maxAllowedPacket = size
+	}
+}
+
+// ServerWithStrictMode 开启严格模式，客户端发送了格式非法的报文时，
+// 返回 ER_MALFORMED_PACKET 之后关闭连接。默认只返回错误，连接可以继续使用
+func ServerWithStrictMode(strict bool) ServerOption {
+	return func(s *Server) {
+		s.strictMode = strict
+	}
+}
+
+// ServerWithProxyProtocol 允许 trusted 中的地址在连接的最开始发送 HAProxy 的 PROXY protocol 头部，支持 v1 和 v2
+// 这些地址必须发送头部，头部中客户端真实的地址会用于鉴权、连接数限制，并且会传递给插件。
+// 其余地址的连接不会解析头部
+func ServerWithProxyProtocol(trusted ...netip.Prefix) ServerOption {
+	return func(s *Server) {
+		s.trustedProxies = trusted
+	}
+}
+
+// ServerWithMaxConnections 设置连接总数的上限，为 0 的时候不限制
+// 超过之后新的连接会收到 ER_CON_COUNT_ERROR 错误
+func ServerWithMaxConnections(n int) ServerOption {
+	return func(s *Server) {
+		s.limiter.maxConns = n
+	}
+}
+
+// ServerWithMaxUserConnections 设置每个用户的连接数上限，为 0 的时候不限制
+func ServerWithMaxUserConnections(n int) ServerOption {
+	return func(s *Server) {
+		s.limiter.maxUserConns = n
+	}
+}
+
+// ServerWithMaxHostConnections 设置每个客户端地址的连接数上限，为 0 的时候不限制
+func ServerWithMaxHostConnections(n int) ServerOption {
+	return func(s *Server) {
+		s.limiter.maxHostConns = n
+	}
+}
+
+// ServerWithWaitTimeout 设置连接空闲的超时时间，和 MySQL 的 wait_timeout 一样，为 0 的时候不限制
+// 超时之后客户端会收到 ER_CLIENT_INTERACTION_TIMEOUT 错误，并且连接会被关闭
+func ServerWithWaitTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.waitTimeout = timeout
+	}
+}
+
+// ServerWithIdleInTransactionTimeout 设置连接在事务中空闲的超时时间，为 0 的时候使用 wait_timeout
+// 超时之后关闭连接并且回滚事务，避免长时间持有锁
+func ServerWithIdleInTransactionTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.idleInTxTimeout = timeout
+	}
+}
+
+// Start 监听所有的地址，并且开始接收连接
+// 任意一个地址监听或者接收连接失败的时候，会关闭其余的地址并返回错误。
+// 调用 Close 或者 Shutdown 之后返回 nil
 func (s *Server) Start() error {
-	listener, err := net.Listen("tcp", s.addr)
-	if err != nil {
-		return err
+	if s.userStore != nil && s.rsaKey == nil {
+		// 和 MySQL 一样，没有配置的时候自动生成
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return err
+		}
+		s.rsaKey = key
+	}
+	if len(s.listenerConfigs) == 0 {
+		return errors.New("没有配置监听的地址")
+	}
+	for _, cfg := range s.listenerConfigs {
+		// 不校验用户的时候没有办法区分管理员，管理端口会变成一个不受连接数限制的普通端口
+		if cfg.Admin && s.userStore == nil {
+			return fmt.Errorf("%s %s 是管理端口，必须配置用户", cfg.Network, cfg.Addr)
+		}
+	}
+	listeners := make([]net.Listener, 0, len(s.listenerConfigs))
+	connOpts := make([][]connection.ConnOption, 0, len(s.listenerConfigs))
+	for _, cfg := range s.listenerConfigs {
+		opts, err := cfg.connOptions()
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return err
+		}
+		connOpts = append(connOpts, opts)
+		l, err := listen(cfg)
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
 	}
 	s.mu.Lock()
-	s.listener = listener
+	if s.closed.Load() {
+		s.mu.Unlock()
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+		return nil
+	}
+	s.listeners = listeners
 	s.mu.Unlock()
-	var id uint32 = 1
+
+	errCh := make(chan error, len(listeners))
+	for i, l := range listeners {
+		go func() {
+			errCh <- s.serve(l, s.listenerConfigs[i], connOpts[i])
+		}()
+	}
+	var err *multierror.Error
+	for range listeners {
+		if err1 := <-errCh; err1 != nil {
+			err = multierror.Append(err, err1)
+			// 其余的地址也不再接收连接
+			_ = s.closeListener()
+		}
+	}
+	return err.ErrorOrNil()
+}
+
+// serve 在 l 上接收连接，所有地址上的连接共享同一个插件链和连接集合
+func (s *Server) serve(l net.Listener, cfg ListenerConfig, connOpts []connection.ConnOption) error {
+	// tempDelay 和 net/http 一样，Accept 临时出错的时候等待一段时间再重试
+	var tempDelay time.Duration
 	for {
-		rawConn, err1 := listener.Accept()
-		if err1 != nil {
-			var opErr *net.OpError
-			if errors.As(err, &opErr) && opErr.Temporary() {
-				continue
-			}
+		rawConn, err := l.Accept()
+		if err != nil {
 			if s.closed.Load() {
-				// 忽略因为listener.Close()导致到err1
+				// 忽略因为listener.Close()导致到err
 				return nil
 			}
-			return err1
+			if isTemporary(err) {
+				// 例如文件描述符耗尽，立刻重试只会一直出错
+				tempDelay = min(max(2*tempDelay, 5*time.Millisecond), time.Second)
+				s.logger.Warn("接收连接出错，稍后重试", "地址", cfg.Addr, "错误", err, "等待", tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			return fmt.Errorf("在 %s %s 上接收连接失败 %w", cfg.Network, cfg.Addr, err)
 		}
-		conn := connection.NewConn(id, rawConn, s.omCmd)
-		s.conns.Store(id, conn)
-		id++
-		go func() {
-			// 关闭
-			defer func() {
-				s.conns.Delete(conn.ID())
-				_ = conn.Close()
-			}()
-			err2 := conn.Loop()
-			if err2 != nil {
-				s.logger.Error("退出命令处理循环出错", "错误", err2)
+		tempDelay = 0
+		s.serveConn(rawConn, cfg.Admin, connOpts...)
+	}
+}
+
+// serveConn 在新的 goroutine 上处理连接
+// admin 为 true 的时候只允许管理员登录，并且和 MySQL 的 admin_address 一样不受连接数的限制，
+// 这样连接数满了的时候管理员依旧可以登录处理问题
+// opts 是监听的地址上单独的配置，例如握手的时候告诉客户端的版本
+func (s *Server) serveConn(rawConn net.Conn, admin bool, opts ...connection.ConnOption) {
+	id := s.nextConnID.Add(1)
+	// userAcquired 表示鉴权之后占用了用户的连接数
+	var userAcquired bool
+	// connectedHooks OnConnect 执行成功的插件，断开连接的时候要调用它们的 OnDisconnect
+	var connectedHooks []plugin.ConnHook
+	opts = append([]connection.ConnOption{
+		connection.WithTrustedProxies(s.trustedProxies),
+		connection.WithTLSConfig(s.tlsConfig),
+		connection.WithRequireSecureTransport(s.requireSecureTransport),
+		connection.WithUserStore(s.userStore),
+		connection.WithSHA2Cache(s.sha2Cache),
+		connection.WithRSAKey(s.rsaKey),
+		connection.WithConnectTimeout(s.connectTimeout),
+		connection.WithCmdTimeout(s.cmdTimeout),
+		connection.WithMaxAllowedPacket(s.maxAllowedPacket),
+		connection.WithStrictMode(s.strictMode),
+		connection.WithWaitTimeout(s.waitTimeout),
+		connection.WithIdleInTransactionTimeout(s.idleInTxTimeout),
+		connection.WithOnAuth(func(conn *connection.Conn) error {
+			// 握手的时候选择的逻辑库，和 USE 一样需要校验
+			if schema := conn.Schema(); schema != "" && !s.hasSchema(schema) {
+				return errs.NewUnknownDatabaseError(schema)
+			}
+			if admin {
+				if err := s.checkAdmin(conn.User()); err != nil {
+					return err
+				}
+			} else {
+				if !s.limiter.acquireUser(conn.User()) {
+					return fmt.Errorf("用户 %s %w", conn.User(), errs.ErrTooManyConnections)
+				}
+				userAcquired = true
+			}
+			var err error
+			connectedHooks, err = s.onConnect(conn)
+			return err
+		}),
+	}, opts...)
+	conn := connection.NewConn(id, rawConn, s.omCmd, opts...)
+	s.mu.Lock()
+	if s.closed.Load() {
+		// Accept 之后服务端开始退出了
+		s.mu.Unlock()
+		_ = rawConn.Close()
+		return
+	}
+	s.conns.Store(id, conn)
+	s.connWg.Add(1)
+	s.mu.Unlock()
+	go func() {
+		defer s.connWg.Done()
+		// hostAcquired 表示占用了客户端地址的连接数
+		var hostAcquired bool
+		// 关闭
+		defer func() {
+			// 不能因为一个连接上的问题导致整个服务端崩溃
+			if r := recover(); r != nil {
+				s.logger.Error("处理连接 panic", "连接", conn.ID(), "原因", r, "调用栈", string(debug.Stack()))
+			}
+			_ = conn.Close()
+			// 游标可能占用着事务的连接，所以要在插件回滚事务之前关闭
+			s.releaseStmts(conn)
+			s.onDisconnect(conn, connectedHooks)
+			s.conns.Delete(conn.ID())
+			if userAcquired {
+				s.limiter.releaseUser(conn.User())
+			}
+			if hostAcquired {
+				s.limiter.release(conn.RemoteHost())
 			}
 		}()
+		// 读取 PROXY protocol 头部之后才知道客户端真实的地址，之后才能按照地址限制连接数
+		if err := conn.ReadProxyHeader(); err != nil {
+			s.logger.Warn("关闭连接", "连接", conn.ID(), "对端", rawConn.RemoteAddr().String(), "错误", err)
+			return
+		}
+		if !admin {
+			if !s.limiter.acquire(conn.RemoteHost()) {
+				s.rejectConn(conn)
+				return
+			}
+			hostAcquired = true
+		}
+		err := conn.Loop()
+		if err != nil && !s.closed.Load() {
+			s.logger.Error("退出命令处理循环出错", "错误", err)
+		}
+	}()
+}
+
+// checkAdmin 管理端口只允许管理员登录，没有配置用户的时候拒绝所有用户
+func (s *Server) checkAdmin(user string) error {
+	if s.userStore != nil {
+		if u, ok := s.userStore.User(user); ok && u.Admin {
+			return nil
+		}
 	}
+	return fmt.Errorf("用户 %s 不能通过管理端口登录 %w", user, errs.ErrAdminRequired)
+}
+
+// rejectConn 连接数超过限制的时候，和 MySQL 一样不发送握手请求，直接返回错误
+func (s *Server) rejectConn(conn *connection.Conn) {
+	b := builder.NewErrPacket(flags.CapabilityFlags(flags.ClientProtocol41), builder.ER_CON_COUNT_ERROR)
+	_ = conn.WritePacket(b.Build())
+}
+
+// isTemporary 判断 Accept 返回的错误是否可以重试
+func isTemporary(err error) bool {
+	var tmp interface{ Temporary() bool }
+	return errors.As(err, &tmp) && tmp.Temporary()
 }
 
 func (s *Server) omCmd(ctx context.Context, conn *connection.Conn, payload []byte) error {
+	if len(payload) == 0 {
+		b := builder.NewErrPacket(conn.ClientCapabilityFlags(), builder.ER_MALFORMED_PACKET)
+		if err := conn.WritePacket(b.Build()); err != nil || !conn.StrictMode() {
+			return err
+		}
+		return fmt.Errorf("%w，命令为空", errs.ErrMalformedPacket)
+	}
 	// 第一个字节是命令
 	exec, ok := s.executors[payload[0]]
 	if ok {
@@ -107,23 +492,137 @@ func (s *Server) omCmd(ctx context.Context, conn *connection.Conn, payload []byt
 	return err
 }
 
+// kill 终止连接 connID 正在执行的命令，query 为 false 的时候同时关闭连接
+// 和 MySQL 一样，用户只能终止自己的连接
+func (s *Server) kill(user string, connID uint32, query bool) error {
+	conn, ok := s.conns.Load(connID)
+	if !ok {
+		return cmd.ErrUnknownConn
+	}
+	if conn.User() != user {
+		return cmd.ErrKillDenied
+	}
+	if query {
+		conn.KillQuery()
+		return nil
+	}
+	return conn.Kill()
+}
+
+// releaseStmts 释放连接上没有关闭的预处理语句的状态
+func (s *Server) releaseStmts(conn *connection.Conn) {
+	if err := s.stmtExecutor.ReleaseConn(conn.ID()); err != nil {
+		s.logger.Error("连接断开之后关闭游标失败", "连接", conn.ID(), "错误", err)
+	}
+}
+
+// hasSchema 所有实现了 plugin.SchemaChecker 的插件都认识 schema 的时候才能切换过去
+func (s *Server) hasSchema(schema string) bool {
+	for _, checker := range s.schemaCheckers {
+		if !checker.HasSchema(schema) {
+			return false
+		}
+	}
+	return true
+}
+
+// onConnect 按照插件的顺序调用 OnConnect，某个插件返回 error 的时候不再调用后面的插件
+// 返回 OnConnect 执行成功的插件
+func (s *Server) onConnect(conn *connection.Conn) ([]plugin.ConnHook, error) {
+	ctx := cmd.NewPluginContext(context.Background(), conn)
+	for i, hook := range s.connHooks {
+		if err := hook.OnConnect(ctx); err != nil {
+			return s.connHooks[:i], err
+		}
+	}
+	return s.connHooks, nil
+}
+
+// onDisconnect 按照和 OnConnect 相反的顺序调用 OnDisconnect
+func (s *Server) onDisconnect(conn *connection.Conn, hooks []plugin.ConnHook) {
+	if len(hooks) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	pctx := cmd.NewPluginContext(ctx, conn)
+	for i := len(hooks) - 1; i >= 0; i-- {
+		func() {
+			// 已经在关闭连接的 defer 中了，一个插件 panic 不能影响其它插件释放资源
+			defer func() {
+				if r := recover(); r != nil {
+					s.logger.Error("插件释放连接资源 panic", "连接", conn.ID(), "原因", r, "调用栈", string(debug.Stack()))
+				}
+			}()
+			if err := hooks[i].OnDisconnect(pctx); err != nil {
+				s.logger.Error("连接断开之后插件释放资源失败", "连接", conn.ID(), "错误", err)
+			}
+		}()
+	}
+}
+
+// Close 立刻关闭服务端，正在执行的命令会被取消，没有结束的事务会被回滚
 // Close 不需要设计成幂等的，因为调用者不存在误用的可能
 func (s *Server) Close() error {
+	err := s.closeListener()
+	s.conns.Range(func(key uint32, value *connection.Conn) bool {
+		err = multierror.Append(err, value.Close())
+		return true
+	})
+	return err.ErrorOrNil()
+}
+
+// Shutdown 优雅退出，不再接收新的连接，并且等待已有的连接处理完毕：
+//   - 空闲的连接会被立刻关闭
+//   - 正在执行的命令会继续执行，执行完毕之后关闭连接
+//   - 处于事务中的连接可以继续执行命令，直到提交或者回滚事务之后关闭连接
+//
+// 所有连接都关闭之后返回 nil。ctx 过期的时候，剩下的连接会被强制关闭，
+// 没有结束的事务会通过插件的 OnDisconnect 回滚，等待回滚完毕之后返回 ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.closeListener()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			s.connWg.Wait()
+			return err.ErrorOrNil()
+		}
+		select {
+		case <-ctx.Done():
+			s.conns.Range(func(key uint32, value *connection.Conn) bool {
+				_ = value.Close()
+				return true
+			})
+			s.connWg.Wait()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns 关闭所有空闲的连接，返回是否所有连接都已经关闭了
+func (s *Server) closeIdleConns() bool {
+	allClosed := true
+	s.conns.Range(func(key uint32, value *connection.Conn) bool {
+		if !value.CloseIfIdle() {
+			allClosed = false
+		}
+		return true
+	})
+	return allClosed
+}
+
+// closeListener 不再接收新的连接
+func (s *Server) closeListener() *multierror.Error {
 	var err *multierror.Error
 	s.closeOnce.Do(func() {
-		s.closed.Store(true)
-
 		s.mu.Lock()
-		if s.listener != nil {
-			err = multierror.Append(err, s.listener.Close())
+		defer s.mu.Unlock()
+		s.closed.Store(true)
+		for _, l := range s.listeners {
+			err = multierror.Append(err, l.Close())
 		}
-		s.mu.Unlock()
-
-		// 目前只是关闭了 value，但是并没有删除掉对应的键值对
-		s.conns.Range(func(key uint32, value *connection.Conn) bool {
-			err = multierror.Append(err, value.Close())
-			return true
-		})
 	})
-	return err.ErrorOrNil()
+	return err
 }
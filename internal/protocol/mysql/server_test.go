@@ -0,0 +1,883 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ecodeclub/ekit/sqlx"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/auth"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/connection"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/internal/pcontext"
+	"github.com/meoying/dbproxy/internal/protocol/mysql/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Shutdown(t *testing.T) {
+	t.Run("关闭空闲连接", func(t *testing.T) {
+		s, addr, _ := startTestServer(t)
+		conn := newTestClientConn(t, addr)
+		require.NoError(t, conn.PingContext(context.Background()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		require.NoError(t, s.Shutdown(ctx))
+		assert.Zero(t, countConns(s))
+		// 不再接收新的连接
+		_, err := net.Dial("tcp", addr)
+		assert.Error(t, err)
+	})
+
+	t.Run("等待事务结束", func(t *testing.T) {
+		s, addr, hdl := startTestServer(t)
+		conn := newTestClientConn(t, addr)
+		_, err := conn.ExecContext(context.Background(), "BEGIN")
+		require.NoError(t, err)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Shutdown(context.Background())
+		}()
+		time.Sleep(3 * shutdownPollInterval)
+		// 事务中的连接可以继续执行命令
+		_, err = conn.ExecContext(context.Background(), "UPDATE users SET name = 'Tom'")
+		require.NoError(t, err)
+		select {
+		case <-done:
+			t.Fatal("事务还没有结束的时候就退出了")
+		default:
+		}
+		_, err = conn.ExecContext(context.Background(), "COMMIT")
+		require.NoError(t, err)
+		require.NoError(t, <-done)
+		assert.Equal(t, []string{"BEGIN", "UPDATE users SET name = 'Tom'", "COMMIT"}, hdl.Queries())
+	})
+
+	t.Run("超时之后回滚事务", func(t *testing.T) {
+		s, addr, hdl := startTestServer(t)
+		conn := newTestClientConn(t, addr)
+		_, err := conn.ExecContext(context.Background(), "BEGIN")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*shutdownPollInterval)
+		defer cancel()
+		assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+		assert.Zero(t, countConns(s))
+		assert.Equal(t, []string{"BEGIN"}, hdl.Queries())
+		assert.Equal(t, 1, hdl.DisconnectRollbacks())
+	})
+}
+
+func TestServer_ConnLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ServerOption
+	}{
+		{
+			name: "连接总数",
+			opts: []ServerOption{ServerWithMaxConnections(1)},
+		},
+		{
+			name: "每个用户的连接数",
+			opts: []ServerOption{ServerWithMaxUserConnections(1)},
+		},
+		{
+			name: "每个客户端地址的连接数",
+			opts: []ServerOption{ServerWithMaxHostConnections(1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, addr, _ := startTestServer(t, tt.opts...)
+			dsn := fmt.Sprintf("root@tcp(%s)/", addr)
+			first, err := sql.Open("mysql", dsn)
+			require.NoError(t, err)
+			require.NoError(t, first.PingContext(context.Background()))
+
+			db, err := sql.Open("mysql", dsn)
+			require.NoError(t, err)
+			defer db.Close()
+			err = db.PingContext(context.Background())
+			var myErr *mysqldriver.MySQLError
+			require.ErrorAs(t, err, &myErr)
+			assert.Equal(t, uint16(1040), myErr.Number)
+
+			// 关闭之后可以建立新的连接
+			require.NoError(t, first.Close())
+			require.Eventually(t, func() bool {
+				return countConns(s) == 0
+			}, time.Second, 10*time.Millisecond)
+			assert.NoError(t, db.PingContext(context.Background()))
+		})
+	}
+}
+
+func TestServer_IdleInTransactionTimeout(t *testing.T) {
+	_, addr, hdl := startTestServer(t, ServerWithIdleInTransactionTimeout(100*time.Millisecond))
+	conn := newTestClientConn(t, addr)
+	_, err := conn.ExecContext(context.Background(), "BEGIN")
+	require.NoError(t, err)
+	// 空闲超时之后断开连接并且回滚事务
+	require.Eventually(t, func() bool {
+		return hdl.DisconnectRollbacks() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"BEGIN"}, hdl.Queries())
+}
+
+func TestServer_DisconnectInTransaction(t *testing.T) {
+	_, addr, hdl := startTestServer(t)
+	db, err := sql.Open("mysql", fmt.Sprintf("root@tcp(%s)/", addr))
+	require.NoError(t, err)
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	_, err = conn.ExecContext(context.Background(), "BEGIN")
+	require.NoError(t, err)
+	_, err = conn.ExecContext(context.Background(), "UPDATE users SET name = 'Tom'")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.NoError(t, db.Close())
+
+	// 客户端断开之后由插件的 OnDisconnect 回滚事务，插件不会收到客户端没有发送过的 ROLLBACK
+	require.Eventually(t, func() bool {
+		return hdl.DisconnectRollbacks() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"BEGIN", "UPDATE users SET name = 'Tom'"}, hdl.Queries())
+}
+
+func TestServer_SystemVariables(t *testing.T) {
+	_, addr, hdl := startTestServer(t, ServerWithMaxAllowedPacket(1<<20))
+	conn := newTestClientConn(t, addr)
+	ctx := context.Background()
+	queryRow := func(query string) []sql.NullString {
+		rows, err := conn.QueryContext(ctx, query)
+		require.NoError(t, err)
+		defer rows.Close()
+		cols, err := rows.Columns()
+		require.NoError(t, err)
+		require.True(t, rows.Next())
+		values := make([]sql.NullString, len(cols))
+		dest := make([]any, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		require.NoError(t, rows.Scan(dest...))
+		return values
+	}
+	str := func(s string) sql.NullString {
+		return sql.NullString{String: s, Valid: true}
+	}
+
+	// 驱动建立连接之后读取的变量由 dbproxy 直接返回
+	assert.Equal(t, []sql.NullString{str("1048576")}, queryRow("SELECT @@max_allowed_packet"))
+	assert.Equal(t, []sql.NullString{str("dbproxy")}, queryRow("select @@version_comment limit 1"))
+
+	_, err := conn.ExecContext(ctx, "SET NAMES latin1")
+	require.NoError(t, err)
+	_, err = conn.ExecContext(ctx, "SET autocommit = 0, character_set_results = NULL, @@session.time_zone = '+08:00'")
+	require.NoError(t, err)
+	assert.Equal(t, []sql.NullString{str("latin1"), str("latin1_swedish_ci"), str("OFF"), {}, str("+08:00")},
+		queryRow("SELECT @@character_set_client, @@session.collation_connection, @@autocommit, @@character_set_results, @@time_zone"))
+
+	var mysqlErr *mysqldriver.MySQLError
+	_, err = conn.ExecContext(ctx, "SET GLOBAL time_zone = '+08:00'")
+	require.ErrorAs(t, err, &mysqlErr)
+	assert.Equal(t, uint16(9001), mysqlErr.Number)
+	_, err = conn.ExecContext(ctx, "SET @@max_allowed_packet = 1")
+	require.ErrorAs(t, err, &mysqlErr)
+	assert.Equal(t, uint16(1238), mysqlErr.Number)
+	_, err = conn.ExecContext(ctx, "SET autocommit = 2")
+	require.ErrorAs(t, err, &mysqlErr)
+	assert.Equal(t, uint16(1231), mysqlErr.Number)
+
+	// 没有开启事务的时候都不需要交给插件处理
+	assert.Empty(t, hdl.Queries())
+
+	// 开启事务的时候插件可以拿到需要在后端生效的变量，事务中的 SET 也要交给插件
+	_, err = conn.ExecContext(ctx, "BEGIN")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"time_zone": "+08:00"}, hdl.SystemVariables())
+	_, err = conn.ExecContext(ctx, "SET sql_mode = ''")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"time_zone": "+08:00", "sql_mode": ""}, hdl.SystemVariables())
+	// 不认识的变量交给后端
+	_, err = conn.QueryContext(ctx, "SELECT @@innodb_lock_wait_timeout")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"BEGIN", "SET sql_mode = ''", "SELECT @@innodb_lock_wait_timeout"}, hdl.Queries())
+}
+
+func TestServer_SystemVariablesAutoCommit(t *testing.T) {
+	_, addr, hdl := startTestServer(t)
+	conn := newTestClientConn(t, addr)
+	ctx := context.Background()
+	exec := func(query string) {
+		_, err := conn.ExecContext(ctx, query)
+		require.NoError(t, err)
+	}
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.ExpectQuery("SELECT NOW()").WillReturnRows(sqlmock.NewRows([]string{"now"}).AddRow("2024-01-01 08:00:00"))
+	backendRows, err := db.Query("SELECT NOW()")
+	require.NoError(t, err)
+	hdl.SetRows("SELECT NOW()", backendRows)
+
+	// autocommit 的时候，修改过变量之后的语句在只包含它自己的事务中执行，插件开启事务的时候设置变量
+	exec("SET time_zone = '+08:00'")
+	var now string
+	require.NoError(t, conn.QueryRowContext(ctx, "SELECT NOW()").Scan(&now))
+	assert.Equal(t, "2024-01-01 08:00:00", now)
+	exec("UPDATE users SET name = 'Tom'")
+	assert.Equal(t, map[string]string{"time_zone": "+08:00"}, hdl.SystemVariables())
+	// DDL 会隐式提交事务，不能放在事务中
+	exec("CREATE TABLE orders (id BIGINT)")
+	// 对客户端来说没有处于事务中，所以可以修改下一个事务的特性
+	exec("SET TRANSACTION READ ONLY")
+	// 恢复成默认值之后不再需要事务
+	exec("SET time_zone = DEFAULT")
+	exec("DELETE FROM users")
+
+	assert.Equal(t, []string{
+		"START TRANSACTION", "SELECT NOW()", "COMMIT",
+		"START TRANSACTION", "UPDATE users SET name = 'Tom'", "COMMIT",
+		"CREATE TABLE orders (id BIGINT)",
+		"DELETE FROM users",
+	}, hdl.Queries())
+}
+
+func TestServer_SystemVariablesPreparedStmt(t *testing.T) {
+	_, addr, hdl := startTestServer(t)
+	conn := newTestClientConn(t, addr)
+	ctx := context.Background()
+	_, err := conn.ExecContext(ctx, "SET time_zone = '+08:00'")
+	require.NoError(t, err)
+
+	// 有参数的时候驱动使用预处理语句，执行的时候也要在只包含它自己的事务中设置变量
+	_, err = conn.ExecContext(ctx, "UPDATE users SET name = ?", "Tom")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"time_zone": "+08:00"}, hdl.SystemVariables())
+	queries := hdl.Queries()
+	require.GreaterOrEqual(t, len(queries), 4)
+	stmt := strings.TrimPrefix(queries[2], "EXECUTE ")
+	assert.Equal(t, []string{
+		"UPDATE users SET name = ?", "START TRANSACTION", "EXECUTE " + stmt, "COMMIT",
+	}, queries[:4])
+}
+
+func TestServer_SystemVariablesNotApplied(t *testing.T) {
+	hdl := &testHandler{}
+	s := NewServer("127.0.0.1:0", []plugin.Plugin{&testPlugin{hdl: hdl, ignoreSystemVariables: true}})
+	go func() {
+		_ = s.Start()
+	}()
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	conn := newTestClientConn(t, listenerAddr(t, s, 0))
+	ctx := context.Background()
+	_, err := conn.ExecContext(ctx, "SET time_zone = '+08:00'")
+	require.NoError(t, err)
+
+	// 插件不会设置变量的时候，例如分库分表，不需要额外的事务
+	_, err = conn.ExecContext(ctx, "UPDATE users SET name = 'Tom'")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"UPDATE users SET name = 'Tom'"}, hdl.Queries())
+}
+
+func TestServer_AutoCommit(t *testing.T) {
+	_, addr, hdl := startTestServer(t)
+	conn := newTestClientConn(t, addr)
+	ctx := context.Background()
+	exec := func(query string) {
+		_, err := conn.ExecContext(ctx, query)
+		require.NoError(t, err)
+	}
+
+	// 关闭 autocommit 之后，第一个语句会隐式地开启事务，并且使用 SET TRANSACTION 设置的特性
+	exec("SET autocommit = 0")
+	exec("SET TRANSACTION ISOLATION LEVEL READ COMMITTED, READ ONLY")
+	exec("SELECT 1")
+	assert.Equal(t, &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: true}, hdl.TxOptions())
+	// 事务中不能修改下一个事务的特性
+	var mysqlErr *mysqldriver.MySQLError
+	_, err := conn.ExecContext(ctx, "SET TRANSACTION READ WRITE")
+	require.ErrorAs(t, err, &mysqlErr)
+	assert.Equal(t, uint16(1568), mysqlErr.Number)
+	exec("UPDATE users SET name = 'a'")
+	exec("COMMIT")
+
+	// SET TRANSACTION 只对一个事务生效，连接上的隔离级别对后面的事务都生效
+	exec("SET SESSION TRANSACTION ISOLATION LEVEL SERIALIZABLE")
+	exec("DELETE FROM users")
+	assert.Equal(t, &sql.TxOptions{Isolation: sql.LevelSerializable}, hdl.TxOptions())
+
+	// 重新开启 autocommit 的时候提交事务，之后的语句不会再开启事务
+	exec("SET autocommit = 1")
+	exec("SELECT 2")
+	exec("START TRANSACTION READ ONLY")
+	assert.Equal(t, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true}, hdl.TxOptions())
+	exec("ROLLBACK")
+
+	assert.Equal(t, []string{
+		"START TRANSACTION", "SELECT 1", "UPDATE users SET name = 'a'", "COMMIT",
+		"START TRANSACTION", "DELETE FROM users", "SET autocommit = 1", "COMMIT",
+		"SELECT 2", "START TRANSACTION READ ONLY", "ROLLBACK",
+	}, hdl.Queries())
+}
+
+func TestServer_MultipleResultSets(t *testing.T) {
+	_, addr, hdl := startTestServer(t)
+	conn := newTestClientConn(t, addr)
+	ctx := context.Background()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.ExpectQuery("CALL get_users()").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name"}).AddRow("1", "Tom").AddRow("2", "Jerry"),
+		sqlmock.NewRows([]string{"total"}).AddRow("2"))
+	backendRows, err := db.Query("CALL get_users()")
+	require.NoError(t, err)
+	hdl.SetRows("CALL get_users()", backendRows)
+
+	rows, err := conn.QueryContext(ctx, "CALL get_users()")
+	require.NoError(t, err)
+	var names []string
+	for rows.Next() {
+		var id int
+		var name string
+		require.NoError(t, rows.Scan(&id, &name))
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Tom", "Jerry"}, names)
+	require.True(t, rows.NextResultSet())
+	require.True(t, rows.Next())
+	var total int
+	require.NoError(t, rows.Scan(&total))
+	assert.Equal(t, 2, total)
+	assert.False(t, rows.Next())
+	// CALL 语句本身的 OK_Packet 不是结果集
+	assert.False(t, rows.NextResultSet())
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+
+	// 全部响应都被客户端读取了，连接可以继续使用
+	_, err = conn.ExecContext(ctx, "CREATE TABLE users (id BIGINT)")
+	require.NoError(t, err)
+}
+
+func TestServer_ProxyProtocol(t *testing.T) {
+	// proxyDSN 注册一个先发送 PROXY protocol 头部再开始 MySQL 协议的 dialer，模拟负载均衡
+	proxyDSN := func(addr, name, header string) string {
+		mysqldriver.RegisterDialContext(name, func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			if header != "" {
+				_, err = conn.Write([]byte(header))
+			}
+			return conn, err
+		})
+		return fmt.Sprintf("root@%s(%s)/", name, addr)
+	}
+	trusted := netip.MustParsePrefix("127.0.0.0/8")
+
+	t.Run("使用客户端真实的地址", func(t *testing.T) {
+		_, addr, hdl := startTestServer(t, ServerWithProxyProtocol(trusted))
+		db, err := sql.Open("mysql", proxyDSN(addr, "proxy-v1", "PROXY TCP4 192.168.0.1 192.168.0.11 56324 3306\r\n"))
+		require.NoError(t, err)
+		defer db.Close()
+		_, err = db.ExecContext(context.Background(), "UPDATE users SET name = 'Tom'")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"192.168.0.1:56324"}, hdl.ClientAddrs())
+	})
+
+	t.Run("按照真实的地址限制连接数", func(t *testing.T) {
+		_, addr, _ := startTestServer(t, ServerWithProxyProtocol(trusted), ServerWithMaxHostConnections(1))
+		hosts := []struct {
+			header  string
+			wantErr bool
+		}{
+			{header: "PROXY TCP4 192.168.0.1 192.168.0.11 56324 3306\r\n"},
+			{header: "PROXY TCP4 192.168.0.2 192.168.0.11 56324 3306\r\n"},
+			{header: "PROXY TCP4 192.168.0.1 192.168.0.11 56325 3306\r\n", wantErr: true},
+		}
+		for i, h := range hosts {
+			db, err := sql.Open("mysql", proxyDSN(addr, fmt.Sprintf("proxy-limit-%d", i), h.header))
+			require.NoError(t, err)
+			defer db.Close()
+			err = db.PingContext(context.Background())
+			if !h.wantErr {
+				require.NoError(t, err)
+				continue
+			}
+			var myErr *mysqldriver.MySQLError
+			require.ErrorAs(t, err, &myErr)
+			assert.Equal(t, uint16(1040), myErr.Number)
+		}
+	})
+
+	t.Run("可信的地址没有发送头部", func(t *testing.T) {
+		_, addr, _ := startTestServer(t, ServerWithProxyProtocol(trusted))
+		db, err := sql.Open("mysql", proxyDSN(addr, "proxy-invalid", "GET / HTTP/1.1\r\n"))
+		require.NoError(t, err)
+		defer db.Close()
+		assert.Error(t, db.PingContext(context.Background()))
+	})
+
+	t.Run("不可信的地址不读取头部", func(t *testing.T) {
+		_, addr, hdl := startTestServer(t, ServerWithProxyProtocol(netip.MustParsePrefix("10.0.0.0/8")))
+		conn := newTestClientConn(t, addr)
+		_, err := conn.ExecContext(context.Background(), "UPDATE users SET name = 'Tom'")
+		require.NoError(t, err)
+		require.Len(t, hdl.ClientAddrs(), 1)
+		host, _, err := net.SplitHostPort(hdl.ClientAddrs()[0])
+		require.NoError(t, err)
+		assert.Equal(t, "127.0.0.1", host)
+	})
+}
+
+func TestServer_Listeners(t *testing.T) {
+	t.Run("Unix domain socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dbproxy.sock")
+		s, _, hdl := startTestServer(t, ServerWithListeners(ListenerConfig{Network: "unix", Addr: path}))
+		assert.Equal(t, path, listenerAddr(t, s, 1))
+		db, err := sql.Open("mysql", fmt.Sprintf("root@unix(%s)/", path))
+		require.NoError(t, err)
+		defer db.Close()
+		_, err = db.ExecContext(context.Background(), "UPDATE users SET name = 'Tom'")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"UPDATE users SET name = 'Tom'"}, hdl.Queries())
+	})
+
+	t.Run("管理端口", func(t *testing.T) {
+		store := auth.NewStaticStore([]auth.User{{Name: "root", Admin: true}, {Name: "alice"}})
+		s, addr, _ := startTestServer(t,
+			ServerWithUserStore(store),
+			ServerWithMaxConnections(1),
+			ServerWithListeners(ListenerConfig{Network: "tcp", Addr: "127.0.0.1:0", Admin: true}))
+		adminAddr := listenerAddr(t, s, 1)
+		ping := func(user, addr string) error {
+			db, err := sql.Open("mysql", fmt.Sprintf("%s@tcp(%s)/", user, addr))
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				_ = db.Close()
+			})
+			return db.PingContext(context.Background())
+		}
+		assertErrCode := func(err error, code uint16) {
+			var myErr *mysqldriver.MySQLError
+			require.ErrorAs(t, err, &myErr)
+			assert.Equal(t, code, myErr.Number)
+		}
+		require.NoError(t, ping("alice", addr))
+		assertErrCode(ping("root", addr), 1040)
+		// 管理端口不受连接数的限制
+		require.NoError(t, ping("root", adminAddr))
+		// 只有管理员可以通过管理端口登录
+		assertErrCode(ping("alice", adminAddr), 1227)
+	})
+
+	t.Run("版本和能力", func(t *testing.T) {
+		s, _, _ := startTestServer(t, ServerWithListeners(ListenerConfig{
+			Network:       "tcp",
+			Addr:          "127.0.0.1:0",
+			ServerVersion: "5.7.44-dbproxy",
+			Capabilities:  []string{"CLIENT_LONG_PASSWORD", "client_transactions", "CLIENT_MULTI_RESULTS"},
+		}))
+		db, err := sql.Open("mysql", fmt.Sprintf("root@tcp(%s)/", listenerAddr(t, s, 1)))
+		require.NoError(t, err)
+		defer db.Close()
+		var version string
+		require.NoError(t, db.QueryRowContext(context.Background(), "SELECT @@version").Scan(&version))
+		assert.Equal(t, "5.7.44-dbproxy", version)
+	})
+
+	t.Run("管理端口没有配置用户", func(t *testing.T) {
+		s := NewServer("127.0.0.1:0", nil, ServerWithListeners(ListenerConfig{Network: "tcp", Addr: "127.0.0.1:0", Admin: true}))
+		assert.Error(t, s.Start())
+	})
+
+	t.Run("监听失败", func(t *testing.T) {
+		s := NewServer("127.0.0.1:0", nil, ServerWithListeners(ListenerConfig{Network: "udp", Addr: "127.0.0.1:0"}))
+		assert.Error(t, s.Start())
+	})
+
+	t.Run("不支持的能力", func(t *testing.T) {
+		s := NewServer("127.0.0.1:0", nil, ServerWithListeners(ListenerConfig{
+			Network:      "tcp",
+			Addr:         "127.0.0.1:0",
+			Capabilities: []string{"CLIENT_LOCAL_FILES"},
+		}))
+		assert.Error(t, s.Start())
+	})
+}
+
+func TestServer_SessionMetadata(t *testing.T) {
+	_, addr, hdl := startTestServer(t)
+	db, err := sql.Open("mysql",
+		fmt.Sprintf("root@tcp(%s)/order_db?collation=latin1_swedish_ci&connectionAttributes=program_name:dbproxy-test", addr))
+	require.NoError(t, err)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		_, err = conn.ExecContext(context.Background(), "UPDATE users SET name = 'Tom'")
+		require.NoError(t, err)
+	}
+
+	ctx := hdl.LastContext()
+	assert.Equal(t, "root", ctx.User)
+	assert.Equal(t, "order_db", ctx.Schema)
+	assert.Equal(t, "dbproxy-test", ctx.ConnAttrs["program_name"])
+	assert.Equal(t, "latin1", ctx.Charset)
+	// 同一个连接上的语句共用一个 Session
+	cnt, ok := ctx.Session.Get("test.count")
+	require.True(t, ok)
+	assert.Equal(t, 2, cnt)
+
+	// 修改字符集之后插件拿到的也跟着变化
+	_, err = conn.ExecContext(context.Background(), "SET NAMES utf8mb4")
+	require.NoError(t, err)
+	_, err = conn.ExecContext(context.Background(), "UPDATE users SET name = 'Tom'")
+	require.NoError(t, err)
+	assert.Equal(t, "utf8mb4", hdl.LastContext().Charset)
+}
+
+func TestServer_ConnHook(t *testing.T) {
+	hdl := &testHandler{}
+	hook := &hookPlugin{}
+	s := NewServer("127.0.0.1:0", []plugin.Plugin{hook, &testPlugin{hdl: hdl}})
+	go func() {
+		_ = s.Start()
+	}()
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	addr := listenerAddr(t, s, 0)
+
+	db, err := sql.Open("mysql", fmt.Sprintf("root@tcp(%s)/", addr))
+	require.NoError(t, err)
+	_, err = db.ExecContext(context.Background(), "UPDATE users SET name = 'Tom'")
+	require.NoError(t, err)
+	// OnConnect 中保存的数据在处理语句的时候可以读到
+	tenant, _ := hdl.LastContext().Session.Get("tenant")
+	assert.Equal(t, "tenant-root", tenant)
+	require.NoError(t, db.Close())
+	assert.Eventually(t, func() bool {
+		return len(hook.Events()) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"connect root", "disconnect root"}, hook.Events())
+
+	// OnConnect 返回 error 的时候拒绝连接，也不会调用 OnDisconnect
+	db, err = sql.Open("mysql", fmt.Sprintf("blocked@tcp(%s)/", addr))
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Error(t, db.PingContext(context.Background()))
+	assert.Eventually(t, func() bool {
+		return countConns(s) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"connect root", "disconnect root"}, hook.Events())
+}
+
+func TestServer_UseSchema(t *testing.T) {
+	hdl := &testHandler{}
+	s := NewServer("127.0.0.1:0", []plugin.Plugin{&schemaPlugin{schema: "order_db"}, &testPlugin{hdl: hdl}})
+	go func() {
+		_ = s.Start()
+	}()
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	addr := listenerAddr(t, s, 0)
+	ctx := context.Background()
+	conn := newTestClientConn(t, addr)
+
+	_, err := conn.ExecContext(ctx, "USE order_db")
+	require.NoError(t, err)
+	_, err = conn.ExecContext(ctx, "UPDATE users SET name = 'Tom'")
+	require.NoError(t, err)
+	assert.Equal(t, "order_db", hdl.LastContext().Schema)
+
+	// 插件不认识的逻辑库不能切换过去，连接还在使用原本的逻辑库
+	var mysqlErr *mysqldriver.MySQLError
+	_, err = conn.ExecContext(ctx, "USE other_db")
+	require.ErrorAs(t, err, &mysqlErr)
+	assert.Equal(t, uint16(1049), mysqlErr.Number)
+	_, err = conn.ExecContext(ctx, "UPDATE users SET name = 'Jerry'")
+	require.NoError(t, err)
+	assert.Equal(t, "order_db", hdl.LastContext().Schema)
+
+	// 握手的时候选择的逻辑库也要校验
+	db, err := sql.Open("mysql", fmt.Sprintf("root@tcp(%s)/other_db", addr))
+	require.NoError(t, err)
+	defer db.Close()
+	err = db.PingContext(ctx)
+	require.ErrorAs(t, err, &mysqlErr)
+	assert.Equal(t, uint16(1049), mysqlErr.Number)
+}
+
+func countConns(s *Server) int {
+	cnt := 0
+	s.conns.Range(func(key uint32, value *connection.Conn) bool {
+		cnt++
+		return true
+	})
+	return cnt
+}
+
+// testHandler 记录收到的语句，BEGIN 开启事务，COMMIT 和 ROLLBACK 结束事务
+type testHandler struct {
+	mu      sync.Mutex
+	queries []string
+	// clientAddrs 每个语句对应的客户端地址
+	clientAddrs []string
+	// systemVariables 最后一个语句的 SystemVariables
+	systemVariables map[string]string
+	// txOptions 最后一个开启事务的语句的 TxOptions
+	txOptions *sql.TxOptions
+	// rows 语句返回的结果集，key 是语句
+	rows map[string]sqlx.Rows
+	// lastCtx 最后一个语句的上下文
+	lastCtx *pcontext.Context
+	inTx    bool
+	// disconnectRollbacks 连接断开的时候在 OnDisconnect 中回滚的事务数
+	disconnectRollbacks int
+}
+
+func (h *testHandler) Handle(ctx *pcontext.Context) (*plugin.Result, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	query := ctx.Query
+	if query == "" && ctx.StmtID != 0 {
+		// COM_STMT_EXECUTE 只有预处理语句的 ID
+		query = fmt.Sprintf("EXECUTE stmt%d", ctx.StmtID)
+	}
+	h.queries = append(h.queries, query)
+	h.clientAddrs = append(h.clientAddrs, ctx.ClientAddr.String())
+	h.systemVariables = ctx.SystemVariables
+	h.lastCtx = ctx
+	if ctx.Session != nil {
+		// 记录这个连接上执行了多少个语句
+		cnt, _ := ctx.Session.Get("test.count")
+		n, _ := cnt.(int)
+		ctx.Session.Set("test.count", n+1)
+	}
+	switch {
+	case ctx.Query == "BEGIN" || strings.HasPrefix(ctx.Query, "START TRANSACTION"):
+		h.txOptions = ctx.TxOptions
+		h.inTx = true
+	case ctx.Query == "COMMIT" || ctx.Query == "ROLLBACK":
+		h.inTx = false
+	}
+	return &plugin.Result{Rows: h.rows[ctx.Query], InTransactionState: h.inTx}, nil
+}
+
+func (h *testHandler) OnConnect(ctx *pcontext.Context) error {
+	return nil
+}
+
+// OnDisconnect 和真实的插件一样，回滚没有结束的事务
+func (h *testHandler) OnDisconnect(ctx *pcontext.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.inTx {
+		h.inTx = false
+		h.disconnectRollbacks++
+	}
+	return nil
+}
+
+func (h *testHandler) DisconnectRollbacks() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.disconnectRollbacks
+}
+
+// SetRows 设置语句 query 返回的结果集
+func (h *testHandler) SetRows(query string, rows sqlx.Rows) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.rows == nil {
+		h.rows = make(map[string]sqlx.Rows)
+	}
+	h.rows[query] = rows
+}
+
+func (h *testHandler) LastContext() *pcontext.Context {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastCtx
+}
+
+func (h *testHandler) Queries() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.queries
+}
+
+func (h *testHandler) SystemVariables() map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.systemVariables
+}
+
+func (h *testHandler) TxOptions() *sql.TxOptions {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.txOptions
+}
+
+func (h *testHandler) ClientAddrs() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.clientAddrs
+}
+
+type testPlugin struct {
+	hdl *testHandler
+	// ignoreSystemVariables 插件不会在事务的连接上设置系统变量
+	ignoreSystemVariables bool
+}
+
+func (p *testPlugin) Name() string {
+	return "test"
+}
+
+func (p *testPlugin) Init(cfg []byte) error {
+	return nil
+}
+
+func (p *testPlugin) Join(next plugin.Handler) plugin.Handler {
+	return p.hdl
+}
+
+func (p *testPlugin) AppliesSystemVariables() bool {
+	return !p.ignoreSystemVariables
+}
+
+func (p *testPlugin) OnConnect(ctx *pcontext.Context) error {
+	return p.hdl.OnConnect(ctx)
+}
+
+func (p *testPlugin) OnDisconnect(ctx *pcontext.Context) error {
+	return p.hdl.OnDisconnect(ctx)
+}
+
+// hookPlugin 记录连接的建立和断开，拒绝用户 blocked 的连接
+type hookPlugin struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (p *hookPlugin) Name() string {
+	return "hook"
+}
+
+func (p *hookPlugin) Init(cfg []byte) error {
+	return nil
+}
+
+func (p *hookPlugin) Join(next plugin.Handler) plugin.Handler {
+	return next
+}
+
+func (p *hookPlugin) OnConnect(ctx *pcontext.Context) error {
+	if ctx.User == "blocked" {
+		return errors.New("用户被禁止登录")
+	}
+	ctx.Session.Set("tenant", "tenant-"+ctx.User)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, "connect "+ctx.User)
+	return nil
+}
+
+func (p *hookPlugin) OnDisconnect(ctx *pcontext.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, "disconnect "+ctx.User)
+	return nil
+}
+
+func (p *hookPlugin) Events() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.events)
+}
+
+// schemaPlugin 只认识逻辑库 schema
+type schemaPlugin struct {
+	schema string
+}
+
+func (p *schemaPlugin) Name() string {
+	return "schema"
+}
+
+func (p *schemaPlugin) Init(cfg []byte) error {
+	return nil
+}
+
+func (p *schemaPlugin) Join(next plugin.Handler) plugin.Handler {
+	return next
+}
+
+func (p *schemaPlugin) HasSchema(schema string) bool {
+	return schema == p.schema
+}
+
+// startTestServer 启动服务端，返回监听的地址
+func startTestServer(t *testing.T, opts ...ServerOption) (*Server, string, *testHandler) {
+	hdl := &testHandler{}
+	s := NewServer("127.0.0.1:0", []plugin.Plugin{&testPlugin{hdl: hdl}}, opts...)
+	go func() {
+		_ = s.Start()
+	}()
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s, listenerAddr(t, s, 0), hdl
+}
+
+// listenerAddr 等待服务端开始监听，返回第 i 个地址
+func listenerAddr(t *testing.T, s *Server, i int) string {
+	var addr string
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.listeners) <= i {
+			return false
+		}
+		addr = s.listeners[i].Addr().String()
+		return true
+	}, time.Second, 10*time.Millisecond)
+	return addr
+}
+
+// newTestClientConn 建立一个客户端连接，事务中的语句必须在同一个连接上执行
+func newTestClientConn(t *testing.T, addr string) *sql.Conn {
+	db, err := sql.Open("mysql", fmt.Sprintf("root@tcp(%s)/", addr))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	return conn
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rows
+
+import (
+	"database/sql"
+)
+
+// Interceptor 在读取每一行数据的时候对它进行处理，例如脱敏、审计
+type Interceptor interface {
+	// Intercept cols 是当前结果集的字段定义，row 是读取到的一行数据，
+	// 元素是驱动返回的值，例如 []byte、int64、float64、time.Time 或者 nil。
+	// 可以直接修改 row 中的元素，修改之后的值会赋值给 Scan 的参数。
+	// 返回 error 的时候 Scan 返回这个 error
+	Intercept(cols []*sql.ColumnType, row []any) error
+}
+
+type InterceptorFunc func(cols []*sql.ColumnType, row []any) error
+
+func (f InterceptorFunc) Intercept(cols []*sql.ColumnType, row []any) error {
+	return f(cols, row)
+}
+
+var _ Rows = (*interceptedRows)(nil)
+
+// Intercept 返回的 Rows 在 Scan 的时候先从 rs 中读取一行，
+// 按照顺序经过 interceptors 处理之后再赋值给 Scan 的参数
+func Intercept(rs Rows, interceptors ...Interceptor) Rows {
+	if len(interceptors) == 0 {
+		return rs
+	}
+	return &interceptedRows{
+		Rows:         rs,
+		interceptors: interceptors,
+	}
+}
+
+// interceptedRows 非线程安全实现
+type interceptedRows struct {
+	Rows
+	interceptors []Interceptor
+	// cols 当前结果集的字段定义，切换结果集之后重新获取
+	cols []*sql.ColumnType
+	// row 和 dest 每一行都复用
+	row  []any
+	dest []any
+}
+
+func (r *interceptedRows) NextResultSet() bool {
+	r.cols = nil
+	return r.Rows.NextResultSet()
+}
+
+func (r *interceptedRows) Scan(dest ...any) error {
+	if r.cols == nil {
+		cols, err := r.Rows.ColumnTypes()
+		if err != nil {
+			return err
+		}
+		r.cols = cols
+	}
+	if len(r.row) != len(dest) {
+		r.row = make([]any, len(dest))
+		r.dest = make([]any, len(dest))
+		for i := range r.row {
+			r.dest[i] = &r.row[i]
+		}
+	}
+	if err := r.Rows.Scan(r.dest...); err != nil {
+		return err
+	}
+	for _, interceptor := range r.interceptors {
+		if err := interceptor.Intercept(r.cols, r.row); err != nil {
+			return err
+		}
+	}
+	for i, dst := range dest {
+		if err := ConvertAssign(dst, r.row[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rows
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntercept(t *testing.T) {
+	mask := InterceptorFunc(func(cols []*sql.ColumnType, row []any) error {
+		if phone, ok := row[1].([]byte); ok && len(phone) > 7 {
+			row[1] = append(append([]byte{}, phone[:3]...), "****"...)
+		}
+		return nil
+	})
+	testCases := []struct {
+		name         string
+		interceptors []Interceptor
+
+		wantRows [][]string
+		wantErr  error
+	}{
+		{
+			name:     "没有拦截器",
+			wantRows: [][]string{{"1", "13800001111"}, {"2", "139"}},
+		},
+		{
+			name:         "修改数据",
+			interceptors: []Interceptor{mask},
+			wantRows:     [][]string{{"1", "138****"}, {"2", "139"}},
+		},
+		{
+			name: "按照顺序执行",
+			interceptors: []Interceptor{mask, InterceptorFunc(func(cols []*sql.ColumnType, row []any) error {
+				row[0] = "id-" + string(row[0].([]byte))
+				return nil
+			})},
+			wantRows: [][]string{{"id-1", "138****"}, {"id-2", "139"}},
+		},
+		{
+			name: "返回错误",
+			interceptors: []Interceptor{InterceptorFunc(func(cols []*sql.ColumnType, row []any) error {
+				return errors.New("mock error")
+			})},
+			wantErr: errors.New("mock error"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rs := Intercept(NewDataRows([][]any{
+				{[]byte("1"), []byte("13800001111")},
+				{[]byte("2"), []byte("139")},
+			}, []string{"id", "phone"}, nil), tc.interceptors...)
+			var got [][]string
+			for rs.Next() {
+				var id, phone string
+				err := rs.Scan(&id, &phone)
+				assert.Equal(t, tc.wantErr, err)
+				if err != nil {
+					return
+				}
+				got = append(got, []string{id, phone})
+			}
+			require.NoError(t, rs.Close())
+			assert.Equal(t, tc.wantRows, got)
+		})
+	}
+}
@@ -1,12 +1,13 @@
 package errs
 
 import (
-	"errors"
 	"fmt"
+
+	proxyerrs "github.com/meoying/dbproxy/internal/errs"
 )
 
-var ErrMissingShardingKey = errors.New("sharding key 未设置")
+var ErrMissingShardingKey = proxyerrs.NewRouteError("sharding key 未设置")
 
 func NewUnsupportedOperatorError(op string) error {
-	return fmt.Errorf("不支持的操作符 %v", op)
+	return proxyerrs.NewUnsupportedSQLError(fmt.Sprintf("不支持的操作符 %v", op))
 }